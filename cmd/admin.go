@@ -0,0 +1,75 @@
+package main
+
+import (
+	"company-service/config"
+	"context"
+	"fmt"
+	"time"
+)
+
+// migrate creates or updates the schema and the default user without starting the server
+func migrate(args []string) error {
+	flags := newFlagSet("migrate")
+	conf, err := loadAdmin(flags, args)
+	if err != nil {
+		return err
+	}
+	if err = noPositional(flags); err != nil {
+		return err
+	}
+	db, err := openDatabase(conf, true)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "Database migrated")
+	return db.Close()
+}
+
+// configCheck validates the configuration, which config.Load already does, and with --ping
+// checks that the database and the broker are reachable
+func configCheck(args []string) error {
+	flags := newFlagSet("config check")
+	ping := flags.Bool("ping", false, "also check that the database and Kafka are reachable")
+	conf, err := loadAdmin(flags, args)
+	if err != nil {
+		return err
+	}
+	if err = noPositional(flags); err != nil {
+		return err
+	}
+	if *ping {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		db, err := openDatabase(conf, false)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		producer, err := openProducer(conf)
+		if err != nil {
+			return err
+		}
+		defer producer.Close()
+		if err = db.Ping(ctx); err != nil {
+			return fmt.Errorf("database: %w", err)
+		}
+		if err = producer.Ping(ctx); err != nil {
+			return fmt.Errorf("kafka: %w", err)
+		}
+	}
+	fmt.Fprintln(stdout, "Configuration is valid")
+	return nil
+}
+
+// configPrint shows the effective configuration with the source of every value, secrets redacted
+func configPrint(args []string) error {
+	flags := newFlagSet("config print")
+	conf, err := config.Load(flags, args)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err = noPositional(flags); err != nil {
+		return err
+	}
+	return conf.Print(stdout)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"company-service/database"
+	"company-service/kafka"
+	"company-service/models"
+	"company-service/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// pageSize is the number of companies read from the database at once by export and replay
+const pageSize = 500
+
+// companyGet prints a company as indented JSON
+func companyGet(args []string) error {
+	flags := newFlagSet("company get")
+	conf, err := loadAdmin(flags, args)
+	if err != nil {
+		return err
+	}
+	id, err := positional(flags, "the company ID")
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(conf, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	company, err := db.GetCompany(context.Background(), id)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(company)
+}
+
+// companyExport writes every company as a line of JSON, the format read by import
+func companyExport(args []string) error {
+	flags := newFlagSet("company export")
+	output := flags.String("output", "-", "file to write, - for stdout")
+	conf, err := loadAdmin(flags, args)
+	if err != nil {
+		return err
+	}
+	if err = noPositional(flags); err != nil {
+		return err
+	}
+
+	db, err := openDatabase(conf, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	w := stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	count := 0
+	err = eachCompany(context.Background(), db, func(company *models.Company) error {
+		count++
+		return encoder.Encode(company)
+	})
+	if err != nil {
+		return err
+	}
+	if err = buffered.Flush(); err != nil {
+		return err
+	}
+	slog.Info("Companies exported", slog.Int("count", count))
+	return nil
+}
+
+// companyImport creates the companies of a JSON lines file and produces their company_created events.
+// The companies whose name or ID is taken are skipped, the import fails when any line is invalid.
+func companyImport(args []string) error {
+	flags := newFlagSet("company import")
+	noEvents := flags.Bool("no-events", false, "do not produce the company_created events")
+	conf, err := loadAdmin(flags, args)
+	if err != nil {
+		return err
+	}
+	path, err := positional(flags, "the file to import, - for stdin")
+	if err != nil {
+		return err
+	}
+
+	r := stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	db, err := openDatabase(conf, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var producer kafka.Producer
+	if !*noEvents {
+		if producer, err = openProducer(conf); err != nil {
+			return err
+		}
+		// Close waits for the deliveries of the produced events
+		defer producer.Close()
+	}
+
+	ctx := context.Background()
+	created, skipped, failed := 0, 0, 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var company models.Company
+		if err := json.Unmarshal(scanner.Bytes(), &company); err != nil {
+			slog.Error("Invalid company", slog.Int("line", line), slog.Any("error", err))
+			failed++
+			continue
+		}
+		if err := utils.ValidateCompanyInput(&company); err != nil {
+			slog.Error("Invalid company", slog.Int("line", line), slog.Any("error", err))
+			failed++
+			continue
+		}
+		// Keep the ID of an exported company, so that importing an export preserves it
+		if company.ID == uuid.Nil {
+			company.ID = utils.GenerateUUID()
+		}
+		company.DeletedAt = nil
+
+		err := db.CreateCompany(ctx, &company)
+		if errors.Is(err, database.ErrDuplicateName) || errors.Is(err, database.ErrDuplicateID) {
+			slog.Warn("Company already exists, skipped", slog.Int("line", line), slog.Any("company", company), slog.Any("error", err))
+			skipped++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		created++
+		if producer != nil {
+			if err := producer.ProduceEvent(ctx, kafka.NewEvent("company_created", &company)); err != nil {
+				slog.Error("Kafka publish failed", slog.Int("line", line), slog.Any("error", err))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Imported %d companies, skipped %d existing ones, %d invalid lines\n", created, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d invalid lines", failed)
+	}
+	return nil
+}
+
+// eachCompany calls fn for every company in ID order, reading them page by page
+func eachCompany(ctx context.Context, db database.Database, fn func(company *models.Company) error) error {
+	after := ""
+	for {
+		page, err := db.ListCompanies(ctx, after, pageSize)
+		if err != nil {
+			return err
+		}
+		for i := range page {
+			if err := fn(&page[i]); err != nil {
+				return err
+			}
+		}
+		if len(page) < pageSize {
+			return nil
+		}
+		after = page[len(page)-1].ID.String()
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"company-service/kafka"
+	"company-service/models"
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// eventsReplay produces an event for every company, or only for --id, so the consumers can
+// rebuild their state from the current records
+func eventsReplay(args []string) error {
+	flags := newFlagSet("events replay")
+	id := flags.String("id", "", "only replay the company with this ID")
+	eventType := flags.String("event-type", "company_updated", "type of the produced events, company_created or company_updated")
+	conf, err := loadAdmin(flags, args)
+	if err != nil {
+		return err
+	}
+	if err = noPositional(flags); err != nil {
+		return err
+	}
+	if *eventType != "company_created" && *eventType != "company_updated" {
+		return fmt.Errorf("unsupported event type %q", *eventType)
+	}
+
+	db, err := openDatabase(conf, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	producer, err := openProducer(conf)
+	if err != nil {
+		return err
+	}
+	// Close waits for the deliveries of the produced events
+	defer producer.Close()
+
+	ctx := context.Background()
+	count := 0
+	produce := func(company *models.Company) error {
+		if err := producer.ProduceEvent(ctx, kafka.NewEvent(*eventType, company)); err != nil {
+			return fmt.Errorf("company %s: %w", company.ID, err)
+		}
+		count++
+		return nil
+	}
+	if *id != "" {
+		company, err := db.GetCompany(ctx, *id)
+		if err != nil {
+			return err
+		}
+		err = produce(company)
+	} else {
+		err = eachCompany(ctx, db, produce)
+	}
+	slog.Info("Events replayed", slog.String("event_type", *eventType), slog.Int("count", count))
+	return err
+}
@@ -1,89 +1,119 @@
 package main
 
 import (
-	"company-service/config"
-	"company-service/controllers"
-	"company-service/database"
-	"company-service/kafka"
-	"company-service/middleware"
-	"context"
 	"errors"
-	"github.com/gorilla/mux"
-	"log"
-	"net/http"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"strings"
 )
 
+// command is a subcommand of the binary, named by one or two words, e.g. "user create"
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	// Assigned in init because the help command lists the commands
+	commands = []command{
+		{"serve", "", "Start the HTTP API, the default without a command", serve},
+		{"migrate", "", "Create or update the database schema and the default user", migrate},
+		{"user create", "<username>", "Create a user, the password is read from stdin", userCreate},
+		{"user reset-password", "<username>", "Replace the password of a user, read from stdin", userResetPassword},
+		{"user disable", "<username>", "Disable a user, or enable it again with --enable", userDisable},
+		{"company get", "<id>", "Print a company as JSON", companyGet},
+		{"company export", "", "Write every company as a line of JSON", companyExport},
+		{"company import", "<file>", "Create the companies of a JSON lines file, - for stdin", companyImport},
+		{"events replay", "", "Produce an event for every company, or the one given by --id", eventsReplay},
+		{"config check", "", "Validate the configuration, and reach the dependencies with --ping", configCheck},
+		{"config print", "", "Print the effective configuration with the source of each value", configPrint},
+		{"help", "", "List the commands", help},
+	}
+}
+
 func main() {
-	// Load the configuration from .env file.
-	conf, err := config.LoadConfig()
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+	cmd, args, ok := findCommand(os.Args[1:])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", strings.Join(os.Args[1:], " "))
+		usage(os.Stderr)
+		os.Exit(2)
 	}
-	db, err := database.InitDB(conf)
-	if err != nil {
-		log.Fatalf("Failed to connect to database")
+	if err := cmd.run(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fatal("Command "+cmd.name+" failed", err)
 	}
+}
 
-	kafkaProducer, err := kafka.NewKafkaProducer(conf.KafkaURL, conf.KafkaTopic)
-	if err != nil {
-		log.Fatalf("Failed to connect to Kafka")
+// findCommand returns the command named by the first words of args and the remaining arguments.
+// Without a command, or when the first argument is a flag, the server is started.
+func findCommand(args []string) (command, []string, bool) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return commands[0], args, true
 	}
-	var dbInterface database.Database = db
-	var kafkaProducerInterface kafka.Producer = kafkaProducer
-
-	// Setting up graceful shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-
-	defer func() {
-		err = dbInterface.Close()
-		if err != nil {
-			log.Fatalf("Failed to close database connection")
+	if len(args) >= 2 {
+		for _, cmd := range commands {
+			if cmd.name == args[0]+" "+args[1] {
+				return cmd, args[2:], true
+			}
 		}
-		kafkaProducerInterface.Close()
-	}()
-
-	newApp := controllers.App{DB: dbInterface, KafkaProducer: kafkaProducerInterface, Config: conf}
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd, args[1:], true
+		}
+	}
+	return command{}, nil, false
+}
 
-	//Router and endpoint setup code
-	router := mux.NewRouter()
+// newFlagSet returns the flag set of a command, config.Load adds the flags of the settings to it
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("company-service "+name, flag.ContinueOnError)
+}
 
-	apiRouter := router.PathPrefix("/api").Subrouter()
-	// Public routes: Login
-	apiRouter.HandleFunc("/login", newApp.Login).Methods("POST")
-	apiRouter.HandleFunc("/companies", middleware.JwtMiddleware(newApp.CreateCompany, conf)).Methods("POST")
-	apiRouter.HandleFunc("/companies/{id}", newApp.GetCompany).Methods("GET")
-	apiRouter.HandleFunc("/companies/{id}", middleware.JwtMiddleware(newApp.UpdateCompany, conf)).Methods("PATCH")
-	apiRouter.HandleFunc("/companies/{id}", middleware.JwtMiddleware(newApp.DeleteCompany, conf)).Methods("DELETE")
+// positional returns the only positional argument left after the flags
+func positional(flags *flag.FlagSet, what string) (string, error) {
+	if flags.NArg() != 1 {
+		return "", fmt.Errorf("expected exactly one argument: %s", what)
+	}
+	return flags.Arg(0), nil
+}
 
-	// Create an HTTP server with a graceful shutdown capability
-	server := &http.Server{
-		Addr:    ":" + conf.APIPort,
-		Handler: router,
+// noPositional fails when arguments are left after the flags
+func noPositional(flags *flag.FlagSet) error {
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
 	}
-	// Start the server in a goroutine
-	go func() {
-		log.Println("Start company service API on port", conf.APIPort)
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatalf("Failed to start server: %v", err)
-		}
-	}()
+	return nil
+}
 
-	// Block until termination signal is received
-	<-quit
+func help(args []string) error {
+	usage(stdout)
+	return nil
+}
 
-	// Graceful shutdown
-	log.Println("Shutting down server...")
-	// Give a timeout for the server shutdown (optional)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server Shutdown failed: %v", err)
+// usage lists the commands, the flags of the settings are shown by "<command> --help"
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: company-service [command] [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-32s %s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.summary)
 	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Every command accepts the configuration flags, see company-service <command> --help")
+}
 
-	log.Println("Server gracefully stopped")
+// fatal logs the error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.Any("error", err))
+	os.Exit(1)
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"company-service/config"
+	"company-service/database"
+	"company-service/kafka"
+	"company-service/models"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sharedProducer is handed to every command, they all close it so Close does nothing
+type sharedProducer struct {
+	kafka.Producer
+}
+
+func (sharedProducer) Close() {}
+
+// testCommands runs the commands against an in-memory database and producer shared by all of them
+type testCommands struct {
+	t        *testing.T
+	db       database.Database
+	producer *kafka.MemoryProducer
+}
+
+func newTestCommands(t *testing.T) *testCommands {
+	db, err := database.NewMemoryDatabase(&config.Config{User: "admin", Password: "admin-password", NameNormalization: "nfkc,casefold,whitespace"})
+	require.NoError(t, err)
+	c := &testCommands{t: t, db: db, producer: kafka.NewMemoryProducer()}
+
+	previousStdin, previousStdout, previousDatabase, previousProducer := stdin, stdout, openDatabase, openProducer
+	t.Cleanup(func() {
+		stdin, stdout, openDatabase, openProducer = previousStdin, previousStdout, previousDatabase, previousProducer
+	})
+	openDatabase = func(*config.Config, bool) (database.Database, error) { return c.db, nil }
+	openProducer = func(*config.Config) (kafka.Producer, error) { return sharedProducer{c.producer}, nil }
+	return c
+}
+
+// run runs the command of the arguments with the input on stdin and returns its stdout
+func (c *testCommands) run(input string, args ...string) (string, error) {
+	cmd, rest, ok := findCommand(args)
+	require.True(c.t, ok, args)
+	var output bytes.Buffer
+	stdin, stdout = strings.NewReader(input), &output
+	err := cmd.run(append([]string{"--in-memory", "--log.level=error"}, rest...))
+	return output.String(), err
+}
+
+// seed creates the companies of the names
+func (c *testCommands) seed(names ...string) []*models.Company {
+	var companies []*models.Company
+	for _, name := range names {
+		company := &models.Company{ID: uuid.New(), Name: name, Employees: 5, Registered: true, Type: "Corporations"}
+		require.NoError(c.t, c.db.CreateCompany(context.Background(), company))
+		companies = append(companies, company)
+	}
+	return companies
+}
+
+func TestUserCommands(t *testing.T) {
+	c := newTestCommands(t)
+	ctx := context.Background()
+
+	output, err := c.run("first-password\n", "user", "create", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "User alice created\n", output)
+	user, err := c.db.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("first-password")))
+
+	output, err = c.run("second-password", "user", "reset-password", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "User alice password reset\n", output)
+	user, err = c.db.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("second-password")))
+
+	output, err = c.run("", "user", "disable", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "User alice disabled\n", output)
+	user, err = c.db.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.True(t, user.Disabled)
+	_, err = c.run("", "user", "disable", "--enable", "alice")
+	require.NoError(t, err)
+	user, err = c.db.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.False(t, user.Disabled)
+
+	_, err = c.run("\n", "user", "create", "bob")
+	assert.EqualError(t, err, "the password read from stdin is empty")
+	_, err = c.run("password\n", "user", "reset-password", "bob")
+	assert.Error(t, err)
+	_, err = c.run("password\n", "user", "create")
+	assert.EqualError(t, err, "expected exactly one argument: the username")
+}
+
+func TestCompanyCommands(t *testing.T) {
+	c := newTestCommands(t)
+	acme := c.seed("Acme")[0]
+
+	output, err := c.run("", "company", "get", acme.ID.String())
+	require.NoError(t, err)
+	var got models.Company
+	require.NoError(t, json.Unmarshal([]byte(output), &got))
+	assert.Equal(t, "Acme", got.Name)
+	_, err = c.run("", "company", "get", uuid.NewString())
+	assert.Error(t, err)
+
+	// The ID of an exported company is kept, a taken name is skipped
+	globex := uuid.NewString()
+	input := `{"id":"` + globex + `","name":"Globex","employees":10,"registered":true,"type":"Corporations"}` + "\n\n" +
+		`{"name":"Initech","employees":3,"registered":true,"type":"Corporations"}` + "\n" +
+		`{"name":"ACME","employees":1,"registered":true,"type":"Corporations"}` + "\n"
+	output, err = c.run(input, "company", "import", "-")
+	require.NoError(t, err)
+	assert.Equal(t, "Imported 2 companies, skipped 1 existing ones, 0 invalid lines\n", output)
+	_, err = c.db.GetCompany(context.Background(), globex)
+	assert.NoError(t, err)
+	events := c.producer.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, "company_created", events[0].EventType)
+	assert.Equal(t, globex, events[0].Company.ID.String())
+
+	// The export is read back by the import, from a file
+	path := filepath.Join(t.TempDir(), "companies.jsonl")
+	_, err = c.run("", "company", "export", "--output", path)
+	require.NoError(t, err)
+	exported, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(exported)), "\n"), 3)
+	output, err = c.run("", "company", "import", "--no-events", path)
+	require.NoError(t, err)
+	assert.Equal(t, "Imported 0 companies, skipped 3 existing ones, 0 invalid lines\n", output)
+	assert.Len(t, c.producer.Events(), 2)
+
+	output, err = c.run(`{"name":"Hooli","employees":0}`+"\nnot json\n", "company", "import", "-")
+	assert.EqualError(t, err, "2 invalid lines")
+	assert.Equal(t, "Imported 0 companies, skipped 0 existing ones, 2 invalid lines\n", output)
+}
+
+func TestEventsReplay(t *testing.T) {
+	c := newTestCommands(t)
+	companies := c.seed("Acme", "Globex", "Initech")
+
+	_, err := c.run("", "events", "replay")
+	require.NoError(t, err)
+	events := c.producer.Events()
+	require.Len(t, events, 3)
+	for _, event := range events {
+		assert.Equal(t, "company_updated", event.EventType)
+	}
+
+	_, err = c.run("", "events", "replay", "--id", companies[1].ID.String(), "--event-type", "company_created")
+	require.NoError(t, err)
+	events = c.producer.Events()
+	require.Len(t, events, 4)
+	assert.Equal(t, "company_created", events[3].EventType)
+	assert.Equal(t, "Globex", events[3].Company.Name)
+
+	_, err = c.run("", "events", "replay", "--event-type", "company_deleted")
+	assert.EqualError(t, err, `unsupported event type "company_deleted"`)
+	_, err = c.run("", "events", "replay", "--id", uuid.NewString())
+	assert.Error(t, err)
+	assert.Len(t, c.producer.Events(), 4)
+}
+
+func TestConfigCheck(t *testing.T) {
+	c := newTestCommands(t)
+
+	output, err := c.run("", "config", "check")
+	require.NoError(t, err)
+	assert.Equal(t, "Configuration is valid\n", output)
+	output, err = c.run("", "config", "check", "--ping")
+	require.NoError(t, err)
+	assert.Equal(t, "Configuration is valid\n", output)
+
+	output, err = c.run("", "config", "check", "--log.format", "xml")
+	assert.ErrorContains(t, err, `log.format must be json or text, got "xml"`)
+	assert.Empty(t, output)
+	_, err = c.run("", "config", "check", "unexpected")
+	assert.EqualError(t, err, "unexpected arguments: unexpected")
+}
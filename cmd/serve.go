@@ -0,0 +1,232 @@
+package main
+
+import (
+	"company-service/config"
+	"company-service/controllers"
+	"company-service/database"
+	"company-service/grpcapi"
+	"company-service/health"
+	"company-service/kafka"
+	"company-service/metrics"
+	"company-service/tlsconfig"
+	"company-service/tracing"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve runs the HTTP and gRPC APIs until SIGINT or SIGTERM
+func serve(args []string) error {
+	flags := newFlagSet("serve")
+	// Load the configuration from the file, the environment and the flags
+	conf, err := config.Load(flags, args)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err = noPositional(flags); err != nil {
+		return err
+	}
+	if err = setupLogging(conf, os.Stdout); err != nil {
+		return err
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), conf)
+	if err != nil {
+		return fmt.Errorf("failed to configure tracing: %w", err)
+	}
+	defer func() {
+		// Flush the spans that are still buffered
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush traces", slog.Any("error", err))
+		}
+	}()
+
+	if conf.InMemory {
+		slog.Warn("Running in in-memory mode, data is lost on shutdown")
+	}
+	// The server migrates the schema and creates the default user on start
+	dbInterface, err := openDatabase(conf, true)
+	if err != nil {
+		return err
+	}
+	kafkaProducerInterface, err := openProducer(conf)
+	if err != nil {
+		dbInterface.Close()
+		return err
+	}
+	dbSystem := conf.DBDriver
+	if conf.InMemory {
+		dbSystem = "memory"
+	}
+
+	// Record the timings of the database calls and the outcome of the Kafka deliveries
+	appMetrics := metrics.New()
+	if queue, ok := kafkaProducerInterface.(interface{ QueueLength() int }); ok {
+		appMetrics.RegisterQueueLength(queue.QueueLength)
+	}
+	dbInterface = metrics.NewDatabase(dbInterface, appMetrics)
+	// Trace the calls that reach the database, cache hits do not create a span
+	dbInterface = tracing.NewDatabase(dbInterface, dbSystem)
+	kafkaProducerInterface = metrics.NewProducer(kafkaProducerInterface, appMetrics)
+
+	// The cache follows the events of this instance as they are produced, and those of the other
+	// instances as they are consumed
+	var invalidator kafka.Invalidator
+	if conf.CacheSize > 0 {
+		// Serve GetCompany from memory, every produced event invalidates its company
+		cache := database.NewCachedDatabase(dbInterface, database.CacheOptions{
+			Size:        conf.CacheSize,
+			TTL:         conf.CacheTTL,
+			NegativeTTL: conf.CacheNegativeTTL,
+		})
+		dbInterface = cache
+		appMetrics.RegisterCache(cache)
+		kafkaProducerInterface = kafka.NewInvalidatingProducer(kafkaProducerInterface, cache)
+		invalidator = cache
+	}
+	// The gRPC watch streams receive the events read back from the topic, those of every instance
+	// and of the admin commands. In memory mode they receive the events produced by this instance.
+	broadcaster := kafka.NewBroadcaster()
+	stopConsumer := func() {}
+	if conf.InMemory {
+		kafkaProducerInterface = kafka.NewBroadcastProducer(kafkaProducerInterface, broadcaster)
+	} else if stopConsumer, err = consumeEvents(conf, broadcaster, invalidator, appMetrics); err != nil {
+		dbInterface.Close()
+		kafkaProducerInterface.Close()
+		return err
+	}
+
+	// Setting up graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	defer func() {
+		err := dbInterface.Close()
+		if err != nil {
+			slog.Error("Failed to close database connection", slog.Any("error", err))
+		}
+		kafkaProducerInterface.Close()
+	}()
+	defer stopConsumer()
+
+	newApp := controllers.NewApp(dbInterface, kafkaProducerInterface, conf)
+
+	//Router and endpoint setup code
+	router := newApp.Router()
+	router.Use(appMetrics.Middleware)
+	router.Handle("/metrics", appMetrics.Handler()).Methods("GET")
+
+	// Liveness only needs the process, readiness probes the database and the broker
+	checker := health.NewChecker(conf.HealthTimeout, conf.HealthCacheTTL,
+		health.Check{Name: "database", Probe: dbInterface.Ping},
+		health.Check{Name: "kafka", Probe: kafkaProducerInterface.Ping},
+	)
+	router.HandleFunc("/healthz", checker.Liveness).Methods("GET")
+	router.HandleFunc("/readyz", checker.Readiness).Methods("GET")
+
+	// Every request context derives from baseCtx, cancelling it aborts the in-flight work
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	// Create an HTTP server with a graceful shutdown capability
+	tlsConfig, err := tlsconfig.New(conf)
+	if err != nil {
+		return fmt.Errorf("failed to configure TLS: %w", err)
+	}
+	server := &http.Server{
+		Addr:              ":" + conf.APIPort,
+		Handler:           router,
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       conf.ReadTimeout,
+		ReadHeaderTimeout: conf.ReadHeaderTimeout,
+		WriteTimeout:      conf.WriteTimeout,
+		IdleTimeout:       conf.IdleTimeout,
+	}
+	var grpcListener net.Listener
+	if conf.GRPCPort != "" {
+		if grpcListener, err = net.Listen("tcp", ":"+conf.GRPCPort); err != nil {
+			return fmt.Errorf("failed to listen on the gRPC port: %w", err)
+		}
+	}
+
+	// The jobs runner starts once nothing can fail before the graceful shutdown, which stops it
+	if err := newApp.Jobs.Start(context.Background()); err != nil {
+		if grpcListener != nil {
+			grpcListener.Close()
+		}
+		return fmt.Errorf("failed to start the job runner: %w", err)
+	}
+
+	// The gRPC server shares the app, and the TLS configuration, of the HTTP server
+	var grpcServer *grpcapi.Server
+	if grpcListener != nil {
+		grpcServer = grpcapi.NewServer(newApp, broadcaster, appMetrics, tlsConfig)
+		go func() {
+			slog.Info("Start company service gRPC API", slog.String("port", conf.GRPCPort), slog.Bool("tls", tlsConfig != nil))
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				fatal("Failed to start gRPC server", err)
+			}
+		}()
+	}
+
+	// Start the server in a goroutine
+	go func() {
+		slog.Info("Start company service API", slog.String("port", conf.APIPort), slog.Bool("tls", tlsConfig != nil))
+		var err error
+		if tlsConfig != nil {
+			// The certificate comes from TLSConfig.GetCertificate
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Failed to start server", err)
+		}
+	}()
+
+	// Block until termination signal is received
+	<-quit
+
+	// Graceful shutdown: report not ready first so the load balancer stops sending new requests
+	slog.Info("Shutting down server...", slog.Duration("delay", conf.ShutdownDelay))
+	checker.Shutdown()
+	time.Sleep(conf.ShutdownDelay)
+	// Give a timeout for the server shutdown (optional)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// The gRPC calls are drained at the same time, its watch streams are ended right away
+	grpcStopped := make(chan error, 1)
+	if grpcServer != nil {
+		go func() { grpcStopped <- grpcServer.Shutdown(ctx) }()
+	} else {
+		grpcStopped <- nil
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		// The requests still running did not finish in time, cancel their database and Kafka calls
+		slog.Warn("Server Shutdown timed out, cancelling in-flight requests", slog.Any("error", err))
+		cancelBase()
+		server.Close()
+	}
+	if err := <-grpcStopped; err != nil {
+		slog.Warn("gRPC server Shutdown timed out, cancelling in-flight calls", slog.Any("error", err))
+	}
+	// The running jobs are interrupted and queued again, before the database is closed
+	jobsCtx, cancelJobs := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelJobs()
+	if err := newApp.Jobs.Shutdown(jobsCtx); err != nil {
+		slog.Warn("Jobs did not stop in time", slog.Any("error", err))
+	}
+
+	slog.Info("Server gracefully stopped")
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"company-service/config"
+	"company-service/models"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// userCreate creates a user with the password read from the first line of stdin
+func userCreate(args []string) error {
+	flags := newFlagSet("user create")
+	conf, err := loadAdmin(flags, args)
+	if err != nil {
+		return err
+	}
+	username, err := positional(flags, "the username")
+	if err != nil {
+		return err
+	}
+	hash, err := readPassword(stdin)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(conf, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err = db.CreateUser(context.Background(), &models.User{Username: username, Password: hash}); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "User %s created\n", username)
+	return nil
+}
+
+// userResetPassword replaces the password of a user with the one read from stdin
+func userResetPassword(args []string) error {
+	flags := newFlagSet("user reset-password")
+	conf, err := loadAdmin(flags, args)
+	if err != nil {
+		return err
+	}
+	username, err := positional(flags, "the username")
+	if err != nil {
+		return err
+	}
+	hash, err := readPassword(stdin)
+	if err != nil {
+		return err
+	}
+	return updateUser(conf, username, map[string]interface{}{"password": hash}, "password reset")
+}
+
+// userDisable prevents a user from logging in, --enable lifts it
+func userDisable(args []string) error {
+	flags := newFlagSet("user disable")
+	enable := flags.Bool("enable", false, "enable the user again")
+	conf, err := loadAdmin(flags, args)
+	if err != nil {
+		return err
+	}
+	username, err := positional(flags, "the username")
+	if err != nil {
+		return err
+	}
+	done := "disabled"
+	if *enable {
+		done = "enabled"
+	}
+	return updateUser(conf, username, map[string]interface{}{"disabled": !*enable}, done)
+}
+
+func updateUser(conf *config.Config, username string, fields map[string]interface{}, done string) error {
+	db, err := openDatabase(conf, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err = db.UpdateUser(context.Background(), username, fields); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "User %s %s\n", username, done)
+	return nil
+}
+
+// readPassword reads the password from the first line of r and returns its bcrypt hash
+func readPassword(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("could not read the password: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("the password read from stdin is empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("could not hash the password: %w", err)
+	}
+	return string(hash), nil
+}
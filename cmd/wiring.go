@@ -0,0 +1,113 @@
+package main
+
+import (
+	"company-service/config"
+	"company-service/database"
+	"company-service/kafka"
+	"company-service/logging"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// setupLogging installs the default logger, the admin commands log to stderr to keep stdout for their output
+func setupLogging(conf *config.Config, w io.Writer) error {
+	level, err := logging.ParseLevel(conf.LogLevel)
+	if err != nil {
+		return fmt.Errorf("failed to configure logging: %w", err)
+	}
+	slog.SetDefault(logging.New(w, level, conf.LogFormat))
+	return nil
+}
+
+// The streams of the admin commands and the opening of their dependencies, the tests replace
+// them to run the commands against an in-memory database and producer they share
+var (
+	stdin        io.Reader = os.Stdin
+	stdout       io.Writer = os.Stdout
+	openDatabase           = newDatabase
+	openProducer           = newProducer
+)
+
+// newDatabase opens the database selected by the configuration. With migrate, the schema is
+// created or updated and the default user is created, as the server does on start.
+func newDatabase(conf *config.Config, migrate bool) (database.Database, error) {
+	if conf.InMemory {
+		db, err := database.NewMemoryDatabase(conf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create in-memory database: %w", err)
+		}
+		return db, nil
+	}
+	open := database.OpenDB
+	if migrate {
+		open = database.InitDB
+	}
+	db, err := open(conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the database: %w", err)
+	}
+	return db, nil
+}
+
+// newProducer connects to the broker selected by the configuration
+func newProducer(conf *config.Config) (kafka.Producer, error) {
+	if conf.InMemory {
+		return kafka.NewMemoryProducer(), nil
+	}
+	producer, err := kafka.NewKafkaProducer(conf.KafkaURL, conf.KafkaTopic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kafka: %w", err)
+	}
+	return producer, nil
+}
+
+// consumeEvents broadcasts the events of the topic until the returned function is called. When
+// there is an invalidator, the company of every event is invalidated first, so that the cache
+// drops the changes made by the other instances and by the admin commands. The lag of the
+// consumer is reported to the observer.
+func consumeEvents(conf *config.Config, broadcaster *kafka.Broadcaster, invalidator kafka.Invalidator, observer kafka.LagObserver) (func(), error) {
+	consumer, err := kafka.NewBroadcastConsumer(conf.KafkaURL, conf.KafkaGroupId, conf.KafkaTopic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kafka: %w", err)
+	}
+	consumer.SetLagObserver(observer)
+	ctx, cancel := context.WithCancel(context.Background())
+	consumed := make(chan kafka.EventMessage, 64)
+	go func() {
+		consumer.ConsumeEvents(ctx, conf.KafkaTopic, consumed)
+		close(consumed)
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range consumed {
+			if invalidator != nil {
+				kafka.InvalidateEvent(invalidator, &event)
+			}
+			broadcaster.Broadcast(&event)
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+		if err := consumer.Close(); err != nil {
+			slog.Error("Failed to close the Kafka consumer", slog.Any("error", err))
+		}
+	}, nil
+}
+
+// loadAdmin loads the configuration of an admin command and installs its logger
+func loadAdmin(flags *flag.FlagSet, args []string) (*config.Config, error) {
+	conf, err := config.Load(flags, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err = setupLogging(conf, os.Stderr); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
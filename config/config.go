@@ -1,13 +1,37 @@
 package config
 
 import (
+	"company-service/logging"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
+// Config holds the settings of the service. They are layered, each source overriding the
+// previous ones: the defaults, the configuration file, the environment and the command line flags.
 type Config struct {
+	// Environment is "development" or "production", production refuses the default credentials
+	Environment string
+	// InMemory keeps companies and events in memory instead of using the database and Kafka
+	InMemory bool
+
+	DBDriver     string
+	DBPath       string
+	DBSSLMode    string
 	DBUser       string
 	DBPassword   string
 	DBName       string
@@ -20,44 +44,412 @@ type Config struct {
 	KafkaTopic   string
 	User         string
 	Password     string
+
+	// UserCacheTTL is how long the user of a token is remembered, zero reads it on every request
+	UserCacheTTL time.Duration
+
+	// GRPCPort is the port of the gRPC server, empty disables it
+	GRPCPort string
+
+	// LogLevel is the minimum level written to the logs: debug, info, warn or error
+	LogLevel string
+	// LogFormat is "json" (default) or "text"
+	LogFormat string
+
+	// NameNormalization is the comma separated policy used to compare company names,
+	// made of "nfkc", "casefold" and "whitespace"
+	NameNormalization string
+
+	// CacheSize is the number of companies kept by the GetCompany cache, zero disables the cache
+	CacheSize int
+	// CacheTTL is how long a company stays in the cache
+	CacheTTL time.Duration
+	// CacheNegativeTTL is how long a missing company is remembered, zero disables negative caching
+	CacheNegativeTTL time.Duration
+
+	// KafkaProduceTimeout bounds how long a request waits for room in the queue of the producer
+	KafkaProduceTimeout time.Duration
+	// RequestTimeout is the default time budget of a request, zero disables it
+	RequestTimeout time.Duration
+	// RouteTimeouts overrides RequestTimeout per route, keyed by "METHOD /path/template"
+	RouteTimeouts map[string]time.Duration
+
+	// ReadTimeout bounds reading a whole request, ReadHeaderTimeout its headers only
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	// WriteTimeout bounds the time from the end of the request headers to the end of the response
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection waits for the next request
+	IdleTimeout time.Duration
+	// MaxBodyBytes is the default maximum size of a request body, zero disables the limit
+	MaxBodyBytes int64
+	// RouteMaxBodyBytes overrides MaxBodyBytes per route, keyed by "METHOD /path/template"
+	RouteMaxBodyBytes map[string]int64
+
+	// TLSCertFile and TLSKeyFile enable HTTPS, they are reloaded when they change on disk
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSClientCAFile enables mutual TLS with client certificates signed by these CAs
+	TLSClientCAFile string
+	// TLSClientAuth is "optional" (default, callers without a certificate are accepted) or "require"
+	TLSClientAuth string
+	// TLSReloadInterval is how often the certificate files are checked for changes
+	TLSReloadInterval time.Duration
+
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header sent over HTTPS, zero disables it
+	HSTSMaxAge time.Duration
+	// ContentSecurityPolicy is the value of the Content-Security-Policy header
+	ContentSecurityPolicy string
+
+	// CORSAllowedOrigins are the origins allowed to call the API from a browser, "*" allows any origin
+	CORSAllowedOrigins []string
+	// CORSAllowCredentials lets the allowed origins send the cookies, it is ignored for "*"
+	CORSAllowCredentials bool
+	// CORSMaxAge is how long browsers cache a preflight response
+	CORSMaxAge time.Duration
+	// CookieSameSite is the SameSite attribute of the login cookies
+	CookieSameSite http.SameSite
+	// CSRFProtection requires the X-CSRF-Token header on the mutating requests authenticated by cookie
+	CSRFProtection bool
+
+	// RateLimit is the default quota of a client per route, a zero RateLimit disables rate limiting
+	RateLimit RateLimit
+	// RouteRateLimits overrides RateLimit per route, keyed by "METHOD /path/template"
+	RouteRateLimits map[string]RateLimit
+	// TrustedProxies are the networks whose X-Forwarded-For header is trusted to find the client IP
+	TrustedProxies []netip.Prefix
+	// RateLimitAPIKeys are the X-API-Key values that get their own quota instead of the client IP's
+	RateLimitAPIKeys []string
+
+	// HealthTimeout bounds the dependency checks of the readiness endpoint
+	HealthTimeout time.Duration
+	// HealthCacheTTL is how long a readiness report is reused
+	HealthCacheTTL time.Duration
+	// ShutdownDelay is how long the instance reports not ready before it stops accepting connections
+	ShutdownDelay time.Duration
+
+	// BatchMaxOperations bounds the operations of a POST /api/companies/batch request
+	BatchMaxOperations int
+
+	// GraphQLMaxDepth bounds the nesting of the fields of a GraphQL query
+	GraphQLMaxDepth int
+	// GraphQLMaxComplexity bounds the cost of a GraphQL query: every field costs 1, and a list
+	// field multiplies the cost of its selection by its first argument
+	GraphQLMaxComplexity int
+
+	// ValidateResponses checks every response against the OpenAPI document, a mismatch becomes a 500.
+	// It buffers the responses, it is meant for the tests.
+	ValidateResponses bool
+
+	// JobWorkers is the number of background jobs run at the same time
+	JobWorkers int
+	// JobQueueSize is the number of jobs that can wait for a worker, new jobs are refused beyond it
+	JobQueueSize int
+	// JobsDir is the scratch directory of the files written by the jobs, e.g. the exports, which
+	// are then stored in the database
+	JobsDir string
+
+	// TracingExporter is where spans are sent: "none" (default), "otlp", "stdout" or "file"
+	TracingExporter string
+	// TracingFile is the file written by the "file" exporter
+	TracingFile string
+	// TracingSampleRatio is the fraction of new traces that are recorded, between 0 and 1
+	TracingSampleRatio float64
+
+	// values are the effective settings and where they come from, for Print
+	values []resolvedValue
+}
+
+// RateLimit allows Requests per Period on average, with bursts of up to Burst requests
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+const (
+	// DefaultKafkaProduceTimeout is used when KafkaProduceTimeout is not set
+	DefaultKafkaProduceTimeout = 5 * time.Second
+	// DefaultBatchMaxOperations is used when BatchMaxOperations is not set
+	DefaultBatchMaxOperations = 100
+	// DefaultGraphQLMaxDepth is used when GraphQLMaxDepth is not set
+	DefaultGraphQLMaxDepth = 8
+	// DefaultGraphQLMaxComplexity is used when GraphQLMaxComplexity is not set
+	DefaultGraphQLMaxComplexity = 20000
+
+	// Development defaults, refused in production
+	DefaultDBUser      = "user1"
+	DefaultDBPassword  = "test1"
+	DefaultAPIUser     = "user2"
+	DefaultAPIPassword = "test2"
+	DefaultJWTSecret   = "secretTest"
+
+	// MinJWTSecretLength is the minimum length of the JWT secret in production
+	MinJWTSecretLength = 32
+)
+
+// resolvedValue is the effective value of a setting
+type resolvedValue struct {
+	key    string
+	value  string
+	source string
+	secret bool
 }
 
-// LoadConfig loads the configuration from the environment variables
+// LoadConfig loads the configuration from the environment, without flags
 func LoadConfig() (*Config, error) {
-	err := godotenv.Load()
-	if err != nil {
+	return Load(nil, nil)
+}
+
+// Load loads the configuration from the file given by --config or CONFIG_FILE (YAML, or TOML
+// with a .toml extension), the environment and the command line arguments, then validates it.
+// Every setting has an environment variable, and a variant suffixed with _FILE naming a file that
+// holds the value, meant for secrets mounted by the orchestrator. The .env file of the working
+// directory is loaded into the environment when it exists.
+//
+// The flags of the settings are added to the given flag set, which may define its own flags,
+// and the positional arguments are left in flags.Args(). Without a flag set, positional
+// arguments are refused.
+func Load(flags *flag.FlagSet, args []string) (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return nil, fmt.Errorf("error loading .env file: %w", err)
 	}
 
-	// Return a new Config struct with defaults set if env variables are missing
-	return &Config{
-		// Database configuration
-		DBUser:     getEnv("DB_USER", "user1"),
-		DBPassword: getEnv("DB_PASSWORD", "test1"),
-		DBName:     getEnv("DB_NAME", "Companies"),
-		DBHost:     getEnv("DB_HOST", "127.0.0.1"),
-		DBPort:     getEnv("DB_PORT", "3306"),
+	conf := &Config{}
+	all := settings(conf)
+	positional := flags != nil
+	if flags == nil {
+		flags = flag.NewFlagSet("company-service", flag.ContinueOnError)
+	}
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file")
+	flagValues := make(map[string]string)
+	for _, s := range all {
+		key := s.key
+		store := func(value string) error {
+			flagValues[key] = value
+			return nil
+		}
+		if s.boolean {
+			flags.BoolFunc(FlagName(key), s.usage, store)
+		} else {
+			flags.Func(FlagName(key), s.usage, store)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if !positional && flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
 
-		// API Configuration
-		APIPort:  getEnv("API_PORT", "8080"),
-		User:     getEnv("API_USER", "user2"),
-		Password: getEnv("API_PASSWORD", "test2"),
+	fileValues := make(map[string]string)
+	if *configFile != "" {
+		var err error
+		if fileValues, err = readFile(*configFile, all); err != nil {
+			return nil, err
+		}
+	}
 
-		// JWT Configuration
-		JWTSecret: getEnv("JWT_SECRET", "secretTest"),
+	var errs []error
+	for _, s := range all {
+		value, source, err := resolve(s, fileValues, flagValues)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err = s.set(strings.TrimSpace(value)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s from %s: %w", s.key, source, err))
+			continue
+		}
+		conf.values = append(conf.values, resolvedValue{key: s.key, value: value, source: source, secret: s.secret})
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// FlagName returns the command line flag of a setting key, e.g. "--rate-limit.default"
+func FlagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+// resolve returns the value of the setting from the source with the highest priority
+func resolve(s setting, fileValues, flagValues map[string]string) (string, string, error) {
+	if value, ok := flagValues[s.key]; ok {
+		return value, "flag --" + FlagName(s.key), nil
+	}
+	value, inEnv := os.LookupEnv(s.env)
+	if path, ok := os.LookupEnv(s.env + "_FILE"); ok {
+		if inEnv {
+			return "", "", fmt.Errorf("both %s and %s_FILE are set", s.env, s.env)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid %s_FILE: %w", s.env, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), "env " + s.env + "_FILE", nil
+	}
+	// A variable set to the empty string is set, e.g. GRPC_PORT= disables the gRPC server
+	if inEnv {
+		return value, "env " + s.env, nil
+	}
+	if value, ok := fileValues[s.key]; ok {
+		return value, "config file", nil
+	}
+	return s.def, "default", nil
+}
 
-		// Kafka Configuration
-		KafkaURL:     getEnv("KAFKA_URL", "localhost:9092"),
-		KafkaTopic:   getEnv("KAFKA_TOPIC", "company_events"),
-		KafkaGroupId: getEnv("KAFKA_GROUP_ID", "company_events_group"),
-	}, nil
+// readFile reads a YAML or TOML configuration file into the string values of the settings.
+// Lists are joined with commas and the route maps become "route=value" lists, the same
+// formats as the environment variables. Unknown keys are refused to catch typos.
+func readFile(path string, all []setting) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the configuration file: %w", err)
+	}
+	document := make(map[string]interface{})
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(content, &document)
+	} else {
+		err = yaml.Unmarshal(content, &document)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the configuration file %s: %w", path, err)
+	}
+
+	known := make(map[string]bool, len(all))
+	for _, s := range all {
+		known[s.key] = true
+	}
+	values := make(map[string]string)
+	var errs []error
+	var walk func(prefix string, node map[string]interface{})
+	walk = func(prefix string, node map[string]interface{}) {
+		for name, value := range node {
+			key := prefix + name
+			if known[key] {
+				values[key] = fileValue(value)
+				continue
+			}
+			if child, ok := value.(map[string]interface{}); ok {
+				walk(key+".", child)
+				continue
+			}
+			errs = append(errs, fmt.Errorf("unknown setting %q in %s", key, path))
+		}
+	}
+	walk("", document)
+	return values, errors.Join(errs...)
+}
+
+// fileValue formats a value of the configuration file like the environment variable would
+func fileValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		entries := make([]string, len(v))
+		for i, entry := range v {
+			entries[i] = fileValue(entry)
+		}
+		return strings.Join(entries, ",")
+	case map[string]interface{}:
+		entries := make([]string, 0, len(v))
+		for route, entry := range v {
+			entries = append(entries, route+"="+fileValue(entry))
+		}
+		sort.Strings(entries)
+		return strings.Join(entries, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Validate checks the settings that are valid on their own but not together or not for the environment
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(oneOf(c.Environment, "development", "production"), "environment must be development or production, got %q", c.Environment)
+	check(oneOf(c.DBDriver, "mysql", "postgres", "sqlite"), "database.driver must be mysql, postgres or sqlite, got %q", c.DBDriver)
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "log.level must be debug, info, warn or error, got %q", c.LogLevel)
+	check(oneOf(c.LogFormat, "json", "text"), "log.format must be json or text, got %q", c.LogFormat)
+	check(c.GRPCPort == "" || c.GRPCPort != c.APIPort, "grpc.port must differ from api.port")
+	check(c.CacheSize >= 0, "cache.size cannot be negative")
+	check(c.TLSCertFile == "" == (c.TLSKeyFile == ""), "tls.cert_file and tls.key_file must be set together")
+	check(c.TLSClientCAFile == "" || c.TLSCertFile != "", "tls.client_ca_file requires tls.cert_file and tls.key_file")
+	check(oneOf(c.TLSClientAuth, "optional", "require"), "tls.client_auth must be optional or require, got %q", c.TLSClientAuth)
+	check(c.BatchMaxOperations >= 1, "batch.max_operations must be at least 1")
+	check(c.GraphQLMaxDepth >= 1, "graphql.max_depth must be at least 1")
+	check(c.GraphQLMaxComplexity >= 1, "graphql.max_complexity must be at least 1")
+	check(c.JobWorkers >= 1, "jobs.workers must be at least 1")
+	check(c.JobQueueSize >= 1, "jobs.queue_size must be at least 1")
+	check(oneOf(c.TracingExporter, "none", "otlp", "stdout", "file"), "tracing.exporter must be none, otlp, stdout or file, got %q", c.TracingExporter)
+	check(c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	if c.Environment == "production" {
+		check(c.JWTSecret != DefaultJWTSecret && len(c.JWTSecret) >= MinJWTSecretLength, "jwt.secret must be changed and at least %d characters long in production", MinJWTSecretLength)
+		check(c.Password != DefaultAPIPassword, "api.password must be changed in production")
+		check(c.DBDriver == "sqlite" || c.DBPassword != DefaultDBPassword, "database.password must be changed in production")
+		check(!c.InMemory, "in_memory cannot be used in production")
+	}
+	return errors.Join(errs...)
+}
+
+// oneOf reports whether the value is one of the allowed ones
+func oneOf(value string, allowed ...string) bool {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the effective configuration as a YAML configuration file, with the source of
+// every value in a comment. Secrets are redacted.
+func (c *Config) Print(w io.Writer) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, resolved := range c.values {
+		node := root
+		parts := strings.Split(resolved.key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			node = child(node, part)
+		}
+		value := resolved.value
+		if resolved.secret && value != "" {
+			value = logging.RedactedValue
+		}
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: parts[len(parts)-1]},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: value, LineComment: resolved.source},
+		)
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return err
+	}
+	return encoder.Close()
 }
 
-// getEnv checks if the environment variable is set, if not returns the default value
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+// child returns the mapping stored under the key, adding it if needed
+func child(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
 	}
-	return value
+	mapping := &yaml.Node{Kind: yaml.MappingNode}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, mapping)
+	return mapping
 }
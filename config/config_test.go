@@ -0,0 +1,133 @@
+package config_test
+
+import (
+	"bytes"
+	"company-service/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFile writes a file in a temporary directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		conf, err := config.Load(nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "mysql", conf.DBDriver)
+		assert.Equal(t, 10*time.Second, conf.RequestTimeout)
+		assert.Equal(t, config.RateLimit{Requests: 5, Period: time.Minute, Burst: 5}, conf.RouteRateLimits["POST /api/login"])
+	})
+
+	t.Run("Flags override the environment which overrides the file", func(t *testing.T) {
+		file := writeFile(t, "config.yaml", `
+database:
+  driver: sqlite
+  name: FromFile
+cache:
+  size: 5
+timeouts:
+  routes:
+    GET /api/companies/{id}: 2s
+cors:
+  allowed_origins: [https://a.example.com, https://b.example.com]
+`)
+		t.Setenv("CONFIG_FILE", file)
+		t.Setenv("DB_NAME", "FromEnv")
+		t.Setenv("CACHE_SIZE", "6")
+
+		conf, err := config.Load(nil, []string{"--cache.size=7", "--in-memory"})
+		require.NoError(t, err)
+		assert.Equal(t, "sqlite", conf.DBDriver)
+		assert.Equal(t, "FromEnv", conf.DBName)
+		assert.Equal(t, 7, conf.CacheSize)
+		assert.True(t, conf.InMemory)
+		assert.Equal(t, 2*time.Second, conf.RouteTimeouts["GET /api/companies/{id}"])
+		assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, conf.CORSAllowedOrigins)
+	})
+
+	t.Run("Empty variables override the file and the defaults", func(t *testing.T) {
+		t.Setenv("CONFIG_FILE", writeFile(t, "config.yaml", "database:\n  name: FromFile\n"))
+		t.Setenv("DB_NAME", "")
+		t.Setenv("CONTENT_SECURITY_POLICY", "")
+
+		conf, err := config.Load(nil, nil)
+		require.NoError(t, err)
+		assert.Empty(t, conf.DBName)
+		assert.Empty(t, conf.ContentSecurityPolicy)
+	})
+
+	t.Run("TOML files", func(t *testing.T) {
+		file := writeFile(t, "config.toml", "[database]\ndriver = \"postgres\"\n\n[rate_limit.routes]\n\"POST /api/companies\" = \"1/1s\"\n")
+		conf, err := config.Load(nil, []string{"--config", file})
+		require.NoError(t, err)
+		assert.Equal(t, "postgres", conf.DBDriver)
+		assert.Equal(t, config.RateLimit{Requests: 1, Period: time.Second, Burst: 1}, conf.RouteRateLimits["POST /api/companies"])
+	})
+
+	t.Run("Secrets from files", func(t *testing.T) {
+		t.Setenv("JWT_SECRET_FILE", writeFile(t, "jwt", "from-file\n"))
+		conf, err := config.Load(nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "from-file", conf.JWTSecret)
+
+		t.Setenv("JWT_SECRET", "from-env")
+		_, err = config.Load(nil, nil)
+		assert.ErrorContains(t, err, "both JWT_SECRET and JWT_SECRET_FILE are set")
+	})
+
+	t.Run("Invalid values", func(t *testing.T) {
+		_, err := config.Load(nil, []string{"--cache.ttl=soon"})
+		assert.ErrorContains(t, err, "invalid cache.ttl from flag --cache.ttl")
+
+		_, err = config.Load(nil, []string{"--config", writeFile(t, "config.yaml", "database:\n  drivr: sqlite\n")})
+		assert.ErrorContains(t, err, `unknown setting "database.drivr"`)
+
+		_, err = config.Load(nil, []string{"--database.driver=oracle"})
+		assert.ErrorContains(t, err, "database.driver must be mysql, postgres or sqlite")
+	})
+
+	t.Run("Production refuses the default credentials", func(t *testing.T) {
+		_, err := config.Load(nil, []string{"--environment=production"})
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "jwt.secret")
+		assert.ErrorContains(t, err, "api.password")
+		assert.ErrorContains(t, err, "database.password")
+
+		_, err = config.Load(nil, []string{
+			"--environment=production",
+			"--jwt.secret=" + strings.Repeat("s", config.MinJWTSecretLength),
+			"--api.password=admin-password",
+			"--database.password=db-password",
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func TestPrint(t *testing.T) {
+	t.Setenv("DB_PASSWORD", "db-password")
+	conf, err := config.Load(nil, []string{"--database.driver=sqlite"})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, conf.Print(&out))
+	printed := out.String()
+	assert.Contains(t, printed, "driver: sqlite # flag --database.driver")
+	assert.Contains(t, printed, "password: '[REDACTED]' # env DB_PASSWORD")
+	assert.NotContains(t, printed, "db-password")
+
+	// The printed configuration can be loaded back
+	reloaded, err := config.Load(nil, []string{"--config", writeFile(t, "config.yaml", printed)})
+	require.NoError(t, err)
+	assert.Equal(t, conf.RouteRateLimits, reloaded.RouteRateLimits)
+}
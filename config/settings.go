@@ -0,0 +1,350 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is one configuration value. Its key is the dotted path in the configuration file and
+// the name of its command line flag, env is its environment variable. Every source provides the
+// value as a string that set parses into the Config.
+type setting struct {
+	key    string
+	env    string
+	def    string
+	secret bool
+	// boolean settings are command line switches, e.g. --in-memory
+	boolean bool
+	usage   string
+	set     func(value string) error
+}
+
+// settings returns the settings of the configuration, bound to the fields of c
+func settings(c *Config) []setting {
+	return []setting{
+		{key: "environment", env: "ENVIRONMENT", def: "development", usage: "development or production, production refuses the default credentials", set: stringValue(&c.Environment)},
+		{key: "in_memory", boolean: true, env: "IN_MEMORY", def: "false", usage: "keep companies and events in memory instead of using the database and Kafka", set: boolValue(&c.InMemory)},
+
+		// Database
+		{key: "database.driver", env: "DB_DRIVER", def: "mysql", usage: "mysql, postgres or sqlite", set: stringValue(&c.DBDriver)},
+		{key: "database.path", env: "DB_PATH", def: "companies.db", usage: "file of the sqlite database", set: stringValue(&c.DBPath)},
+		{key: "database.sslmode", env: "DB_SSLMODE", def: "disable", usage: "sslmode of the postgres connection", set: stringValue(&c.DBSSLMode)},
+		{key: "database.user", env: "DB_USER", def: DefaultDBUser, usage: "database user", set: stringValue(&c.DBUser)},
+		{key: "database.password", env: "DB_PASSWORD", def: DefaultDBPassword, secret: true, usage: "database password", set: stringValue(&c.DBPassword)},
+		{key: "database.name", env: "DB_NAME", def: "Companies", usage: "database name", set: stringValue(&c.DBName)},
+		{key: "database.host", env: "DB_HOST", def: "127.0.0.1", usage: "database host", set: stringValue(&c.DBHost)},
+		{key: "database.port", env: "DB_PORT", def: "3306", usage: "database port", set: stringValue(&c.DBPort)},
+
+		// API
+		{key: "api.port", env: "API_PORT", def: "8080", usage: "port of the HTTP server", set: stringValue(&c.APIPort)},
+		{key: "grpc.port", env: "GRPC_PORT", def: "9090", usage: "port of the gRPC server, empty disables it", set: stringValue(&c.GRPCPort)},
+		{key: "api.user", env: "API_USER", def: DefaultAPIUser, usage: "username of the default admin user", set: stringValue(&c.User)},
+		{key: "api.password", env: "API_PASSWORD", def: DefaultAPIPassword, secret: true, usage: "password of the default admin user", set: stringValue(&c.Password)},
+		{key: "jwt.secret", env: "JWT_SECRET", def: DefaultJWTSecret, secret: true, usage: "secret signing the login tokens", set: stringValue(&c.JWTSecret)},
+		{key: "jwt.user_cache_ttl", env: "JWT_USER_CACHE_TTL", def: "10s", usage: "how long the user of a token is remembered, a disabled user is refused at the latest after it", set: durationValue(&c.UserCacheTTL)},
+
+		// Logging
+		{key: "log.level", env: "LOG_LEVEL", def: "info", usage: "debug, info, warn or error", set: stringValue(&c.LogLevel)},
+		{key: "log.format", env: "LOG_FORMAT", def: "json", usage: "json or text", set: stringValue(&c.LogFormat)},
+
+		// Company names
+		{key: "companies.name_normalization", env: "NAME_NORMALIZATION", def: "nfkc,casefold,whitespace", usage: "comma separated nfkc, casefold and whitespace", set: stringValue(&c.NameNormalization)},
+
+		// Kafka
+		{key: "kafka.url", env: "KAFKA_URL", def: "localhost:9092", usage: "Kafka bootstrap servers", set: stringValue(&c.KafkaURL)},
+		{key: "kafka.topic", env: "KAFKA_TOPIC", def: "company_events", usage: "topic of the company events", set: stringValue(&c.KafkaTopic)},
+		{key: "kafka.group_id", env: "KAFKA_GROUP_ID", def: "company_events_group", usage: "consumer group of the company events", set: stringValue(&c.KafkaGroupId)},
+		{key: "kafka.produce_timeout", env: "KAFKA_PRODUCE_TIMEOUT", def: DefaultKafkaProduceTimeout.String(), usage: "how long a request waits for room in the producer queue", set: durationValue(&c.KafkaProduceTimeout)},
+
+		// GetCompany cache
+		{key: "cache.size", env: "CACHE_SIZE", def: "10000", usage: "number of cached companies, 0 disables the cache", set: intValue(&c.CacheSize)},
+		{key: "cache.ttl", env: "CACHE_TTL", def: "30s", usage: "how long a company stays cached", set: durationValue(&c.CacheTTL)},
+		{key: "cache.negative_ttl", env: "CACHE_NEGATIVE_TTL", def: "5s", usage: "how long an unknown ID is remembered, 0 disables negative caching", set: durationValue(&c.CacheNegativeTTL)},
+
+		// Timeouts
+		{key: "timeouts.request", env: "REQUEST_TIMEOUT", def: "10s", usage: "default time budget of a request, 0 disables it", set: durationValue(&c.RequestTimeout)},
+		{key: "timeouts.routes", env: "ROUTE_TIMEOUTS", def: "POST /api/companies/import=25s,GET /api/companies/export=10m", usage: "per route time budgets, e.g. GET /api/companies/{id}=2s", set: routeTimeoutsValue(&c.RouteTimeouts)},
+
+		// HTTP server
+		{key: "server.read_timeout", env: "SERVER_READ_TIMEOUT", def: "15s", usage: "maximum duration to read a request", set: durationValue(&c.ReadTimeout)},
+		{key: "server.read_header_timeout", env: "SERVER_READ_HEADER_TIMEOUT", def: "5s", usage: "maximum duration to read the headers of a request", set: durationValue(&c.ReadHeaderTimeout)},
+		{key: "server.write_timeout", env: "SERVER_WRITE_TIMEOUT", def: "30s", usage: "maximum duration to write a response", set: durationValue(&c.WriteTimeout)},
+		{key: "server.idle_timeout", env: "SERVER_IDLE_TIMEOUT", def: "120s", usage: "how long a keep-alive connection waits for the next request", set: durationValue(&c.IdleTimeout)},
+		{key: "server.max_body_bytes", env: "MAX_BODY_BYTES", def: "1048576", usage: "maximum size of a request body, 0 disables the limit", set: int64Value(&c.MaxBodyBytes)},
+		{key: "server.route_max_body_bytes", env: "ROUTE_MAX_BODY_BYTES", def: "POST /api/login=4096,POST /api/companies/import=33554432,POST /api/jobs=33554432", usage: "per route body limits, e.g. POST /api/login=4096", set: routeMaxBodyBytesValue(&c.RouteMaxBodyBytes)},
+		{key: "server.shutdown_delay", env: "SHUTDOWN_DELAY", def: "5s", usage: "how long the instance reports not ready before it stops accepting connections", set: durationValue(&c.ShutdownDelay)},
+
+		// TLS
+		{key: "tls.cert_file", env: "TLS_CERT_FILE", usage: "certificate of the HTTPS server", set: stringValue(&c.TLSCertFile)},
+		{key: "tls.key_file", env: "TLS_KEY_FILE", usage: "private key of the HTTPS server", set: stringValue(&c.TLSKeyFile)},
+		{key: "tls.client_ca_file", env: "TLS_CLIENT_CA_FILE", usage: "CAs of the client certificates, enables mutual TLS", set: stringValue(&c.TLSClientCAFile)},
+		{key: "tls.client_auth", env: "TLS_CLIENT_AUTH", def: "optional", usage: "optional or require a client certificate", set: stringValue(&c.TLSClientAuth)},
+		{key: "tls.reload_interval", env: "TLS_RELOAD_INTERVAL", def: "1m", usage: "how often the certificate files are checked for changes", set: durationValue(&c.TLSReloadInterval)},
+
+		// Security headers
+		{key: "security.hsts_max_age", env: "HSTS_MAX_AGE", def: "8760h", usage: "max-age of the Strict-Transport-Security header, 0 disables it", set: durationValue(&c.HSTSMaxAge)},
+		{key: "security.content_security_policy", env: "CONTENT_SECURITY_POLICY", def: "default-src 'none'; frame-ancestors 'none'", usage: "value of the Content-Security-Policy header", set: stringValue(&c.ContentSecurityPolicy)},
+
+		// Browser clients
+		{key: "cors.allowed_origins", env: "CORS_ALLOWED_ORIGINS", usage: "comma separated origins allowed to call the API from a browser", set: listValue(&c.CORSAllowedOrigins)},
+		{key: "cors.allow_credentials", boolean: true, env: "CORS_ALLOW_CREDENTIALS", def: "true", usage: "let the allowed origins send the cookies", set: boolValue(&c.CORSAllowCredentials)},
+		{key: "cors.max_age", env: "CORS_MAX_AGE", def: "10m", usage: "how long browsers cache a preflight response", set: durationValue(&c.CORSMaxAge)},
+		{key: "cookies.same_site", env: "COOKIE_SAMESITE", def: "strict", usage: "strict, lax or none", set: sameSiteValue(&c.CookieSameSite)},
+		{key: "csrf.enabled", boolean: true, env: "CSRF_PROTECTION", def: "true", usage: "require the CSRF token on the mutating requests authenticated by cookie", set: boolValue(&c.CSRFProtection)},
+
+		// Rate limiting
+		{key: "rate_limit.default", env: "RATE_LIMIT", def: "20/1s:40", usage: "default quota as requests/period[:burst], off disables rate limiting", set: rateLimitValue(&c.RateLimit)},
+		{key: "rate_limit.routes", env: "ROUTE_RATE_LIMITS", def: "POST /api/login=5/1m:5", usage: "per route quotas, e.g. POST /api/login=5/1m:5", set: routeRateLimitsValue(&c.RouteRateLimits)},
+		{key: "rate_limit.trusted_proxies", env: "TRUSTED_PROXIES", usage: "comma separated IPs or CIDRs of the reverse proxies", set: prefixesValue(&c.TrustedProxies)},
+		{key: "rate_limit.api_keys", env: "RATE_LIMIT_API_KEYS", secret: true, usage: "comma separated API keys with their own quota", set: listValue(&c.RateLimitAPIKeys)},
+
+		// Health checks
+		{key: "health.timeout", env: "HEALTH_TIMEOUT", def: "2s", usage: "time budget of the readiness checks", set: durationValue(&c.HealthTimeout)},
+		{key: "health.cache_ttl", env: "HEALTH_CACHE_TTL", def: "2s", usage: "how long a readiness report is reused", set: durationValue(&c.HealthCacheTTL)},
+
+		// Batch operations
+		{key: "batch.max_operations", env: "BATCH_MAX_OPERATIONS", def: strconv.Itoa(DefaultBatchMaxOperations), usage: "maximum number of operations of a batch", set: intValue(&c.BatchMaxOperations)},
+
+		// GraphQL
+		{key: "graphql.max_depth", env: "GRAPHQL_MAX_DEPTH", def: strconv.Itoa(DefaultGraphQLMaxDepth), usage: "deepest nesting of the fields of a GraphQL query", set: intValue(&c.GraphQLMaxDepth)},
+		{key: "graphql.max_complexity", env: "GRAPHQL_MAX_COMPLEXITY", def: strconv.Itoa(DefaultGraphQLMaxComplexity), usage: "highest cost of a GraphQL query, a field costs 1 and a list multiplies the cost of its items by first", set: intValue(&c.GraphQLMaxComplexity)},
+
+		// API contract
+		{key: "openapi.validate_responses", boolean: true, env: "OPENAPI_VALIDATE_RESPONSES", def: "false", usage: "check the responses against the OpenAPI document and answer 500 on a mismatch, for tests", set: boolValue(&c.ValidateResponses)},
+
+		// Background jobs
+		{key: "jobs.workers", env: "JOB_WORKERS", def: "2", usage: "number of jobs run at the same time", set: intValue(&c.JobWorkers)},
+		{key: "jobs.queue_size", env: "JOB_QUEUE_SIZE", def: "100", usage: "number of jobs that can wait for a worker", set: intValue(&c.JobQueueSize)},
+		{key: "jobs.dir", env: "JOBS_DIR", def: "data/jobs", usage: "scratch directory of the files written by the jobs before they are stored in the database", set: stringValue(&c.JobsDir)},
+
+		// Tracing
+		{key: "tracing.exporter", env: "TRACING_EXPORTER", def: "none", usage: "none, otlp, stdout or file", set: stringValue(&c.TracingExporter)},
+		{key: "tracing.file", env: "TRACING_FILE", def: "traces.json", usage: "file written by the file exporter", set: stringValue(&c.TracingFile)},
+		{key: "tracing.sample_ratio", env: "TRACING_SAMPLE_RATIO", def: "1", usage: "fraction of new traces that are recorded", set: floatValue(&c.TracingSampleRatio)},
+	}
+}
+
+func stringValue(target *string) func(string) error {
+	return func(value string) error {
+		*target = value
+		return nil
+	}
+}
+
+func boolValue(target *bool) func(string) error {
+	return func(value string) (err error) {
+		*target, err = strconv.ParseBool(value)
+		return err
+	}
+}
+
+func intValue(target *int) func(string) error {
+	return func(value string) (err error) {
+		*target, err = strconv.Atoi(value)
+		return err
+	}
+}
+
+func int64Value(target *int64) func(string) error {
+	return func(value string) (err error) {
+		*target, err = strconv.ParseInt(value, 10, 64)
+		return err
+	}
+}
+
+func floatValue(target *float64) func(string) error {
+	return func(value string) (err error) {
+		*target, err = strconv.ParseFloat(value, 64)
+		return err
+	}
+}
+
+func durationValue(target *time.Duration) func(string) error {
+	return func(value string) (err error) {
+		*target, err = time.ParseDuration(value)
+		return err
+	}
+}
+
+func listValue(target *[]string) func(string) error {
+	return func(value string) error {
+		*target = splitList(value)
+		return nil
+	}
+}
+
+func routeTimeoutsValue(target *map[string]time.Duration) func(string) error {
+	return func(value string) (err error) {
+		*target, err = parseRouteTimeouts(value)
+		return err
+	}
+}
+
+func routeMaxBodyBytesValue(target *map[string]int64) func(string) error {
+	return func(value string) (err error) {
+		*target, err = parseRouteMaxBodyBytes(value)
+		return err
+	}
+}
+
+func rateLimitValue(target *RateLimit) func(string) error {
+	return func(value string) (err error) {
+		*target, err = parseRateLimit(value)
+		return err
+	}
+}
+
+func routeRateLimitsValue(target *map[string]RateLimit) func(string) error {
+	return func(value string) (err error) {
+		*target, err = parseRouteRateLimits(value)
+		return err
+	}
+}
+
+func prefixesValue(target *[]netip.Prefix) func(string) error {
+	return func(value string) (err error) {
+		*target, err = parsePrefixes(value)
+		return err
+	}
+}
+
+func sameSiteValue(target *http.SameSite) func(string) error {
+	return func(value string) (err error) {
+		*target, err = parseSameSite(value)
+		return err
+	}
+}
+
+// parseRouteTimeouts parses a comma separated list of "METHOD /path=duration" entries,
+// e.g. "GET /api/companies/{id}=2s,POST /api/companies=5s"
+func parseRouteTimeouts(value string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, entry := range splitList(value) {
+		route, rawDuration, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid entry %q: expected METHOD /path=duration", entry)
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(rawDuration))
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry %q: %w", entry, err)
+		}
+		timeouts[strings.Join(strings.Fields(route), " ")] = duration
+	}
+	return timeouts, nil
+}
+
+// parseRouteMaxBodyBytes parses a comma separated list of "METHOD /path=bytes" entries,
+// e.g. "POST /api/login=4096,POST /api/companies=65536"
+func parseRouteMaxBodyBytes(value string) (map[string]int64, error) {
+	limits := make(map[string]int64)
+	for _, entry := range splitList(value) {
+		route, rawBytes, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid entry %q: expected METHOD /path=bytes", entry)
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(rawBytes), 10, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid entry %q: invalid number of bytes", entry)
+		}
+		limits[strings.Join(strings.Fields(route), " ")] = limit
+	}
+	return limits, nil
+}
+
+// parseRateLimit parses "requests/period" with an optional ":burst", e.g. "100/1m:20".
+// The burst defaults to the number of requests, "0" and "off" disable the limit.
+func parseRateLimit(value string) (RateLimit, error) {
+	value = strings.TrimSpace(value)
+	if value == "0" || value == "off" {
+		return RateLimit{}, nil
+	}
+	rawRate, rawBurst, hasBurst := strings.Cut(value, ":")
+	rawRequests, rawPeriod, found := strings.Cut(rawRate, "/")
+	if !found {
+		return RateLimit{}, fmt.Errorf("%q: expected requests/period[:burst]", value)
+	}
+	requests, err := strconv.Atoi(strings.TrimSpace(rawRequests))
+	if err != nil || requests < 0 {
+		return RateLimit{}, fmt.Errorf("%q: invalid number of requests", value)
+	}
+	period, err := time.ParseDuration(strings.TrimSpace(rawPeriod))
+	if err != nil || period <= 0 {
+		return RateLimit{}, fmt.Errorf("%q: invalid period", value)
+	}
+	burst := requests
+	if hasBurst {
+		burst, err = strconv.Atoi(strings.TrimSpace(rawBurst))
+		if err != nil || burst < 1 {
+			return RateLimit{}, fmt.Errorf("%q: invalid burst", value)
+		}
+	}
+	return RateLimit{Requests: requests, Period: period, Burst: burst}, nil
+}
+
+// parseRouteRateLimits parses a comma separated list of "METHOD /path=limit" entries,
+// e.g. "POST /api/login=5/1m,POST /api/companies=10/1s:20"
+func parseRouteRateLimits(value string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, entry := range splitList(value) {
+		route, rawLimit, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid entry %q: expected METHOD /path=limit", entry)
+		}
+		limit, err := parseRateLimit(rawLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry %q: %w", entry, err)
+		}
+		limits[strings.Join(strings.Fields(route), " ")] = limit
+	}
+	return limits, nil
+}
+
+// parsePrefixes parses a comma separated list of IP addresses and CIDR networks
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range splitList(value) {
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid entry %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// parseSameSite parses a cookie SameSite mode: strict, lax or none
+func parseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("%q: expected strict, lax or none", value)
+	}
+}
+
+// splitList splits a comma separated list and drops the empty entries
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"bytes"
+	"company-service/config"
+	"company-service/database"
+	"company-service/kafka"
+	"company-service/models"
+	"company-service/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Operations of POST /api/companies/batch
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// Outcomes of an operation of a batch
+const (
+	OperationCreated    = "created"
+	OperationUpdated    = "updated"
+	OperationDeleted    = "deleted"
+	OperationInvalid    = "invalid"
+	OperationFailed     = "failed"
+	OperationRolledBack = "rolled_back"
+	OperationNotRun     = "not_run"
+)
+
+// BatchOperation is one operation of a batch. Create takes the company as in POST /api/companies,
+// update takes the ID and the fields as in PATCH /api/companies/{id}, delete takes the ID.
+type BatchOperation struct {
+	Op      string          `json:"op"`
+	ID      string          `json:"id,omitempty"`
+	Company json.RawMessage `json:"company,omitempty"`
+	Fields  json.RawMessage `json:"fields,omitempty"`
+}
+
+// BatchRequest is the body of POST /api/companies/batch, the operations run in order
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchResult is the outcome of one operation, numbered from 0 in the order of the request
+type BatchResult struct {
+	Index   int             `json:"index"`
+	Op      string          `json:"op"`
+	Status  string          `json:"status"`
+	ID      string          `json:"id,omitempty"`
+	Company *models.Company `json:"company,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// BatchReport is the response of a batch. When Committed is false nothing was written.
+type BatchReport struct {
+	Committed bool          `json:"committed"`
+	Results   []BatchResult `json:"results"`
+	// Warning is set when some events could not be published after the commit
+	Warning string `json:"warning,omitempty"`
+}
+
+// batchStep is a validated operation
+type batchStep struct {
+	op      string
+	id      string
+	company *models.Company
+	fields  map[string]interface{}
+}
+
+// BatchCompanies runs the create, update and delete operations of the body in a single
+// transaction. Either all of them are committed or none, and the events are only
+// published after the commit.
+func (app *App) BatchCompanies(w http.ResponseWriter, r *http.Request) {
+	var request BatchRequest
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		utils.SendErrorResponse(w, decodeErrorStatus(err), fmt.Sprintf("Invalid input data for a batch with error: %v", err))
+		return
+	}
+	if len(request.Operations) == 0 {
+		utils.SendErrorResponse(w, http.StatusBadRequest, "The batch has no operations")
+		return
+	}
+	maxOperations := app.Config.BatchMaxOperations
+	if maxOperations <= 0 {
+		maxOperations = config.DefaultBatchMaxOperations
+	}
+	if len(request.Operations) > maxOperations {
+		utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("The batch has more than %d operations", maxOperations))
+		return
+	}
+
+	// Every operation is validated before the transaction starts
+	report := BatchReport{Results: make([]BatchResult, len(request.Operations))}
+	steps := make([]batchStep, len(request.Operations))
+	valid := true
+	for i, operation := range request.Operations {
+		report.Results[i] = BatchResult{Index: i, Op: operation.Op, ID: operation.ID, Status: OperationNotRun}
+		step, err := parseBatchOperation(operation)
+		if err != nil {
+			report.Results[i].Status = OperationInvalid
+			report.Results[i].Error = err.Error()
+			valid = false
+		}
+		steps[i] = step
+	}
+	if !valid {
+		utils.SendJSONResponse(w, http.StatusBadRequest, report)
+		return
+	}
+
+	failed := -1
+	err := app.DB.Transaction(r.Context(), func(tx database.Database) error {
+		for i, step := range steps {
+			company, err := runBatchStep(r.Context(), tx, step)
+			if err != nil {
+				failed = i
+				return err
+			}
+			report.Results[i].ID = company.ID.String()
+			report.Results[i].Company = company
+		}
+		return nil
+	})
+	if err != nil {
+		for i := range report.Results {
+			report.Results[i].Company = nil
+			switch {
+			case i < failed:
+				report.Results[i].Status = OperationRolledBack
+			case i == failed:
+				report.Results[i].Status = OperationFailed
+				report.Results[i].Error = err.Error()
+			}
+		}
+		status := DatabaseErrorStatus(err)
+		if errors.Is(err, database.ErrDuplicateName) {
+			status = http.StatusConflict
+		}
+		slog.WarnContext(r.Context(), "Batch rolled back", slog.Int("operations", len(steps)), slog.Int("failed", failed), slog.Any("error", err))
+		utils.SendJSONResponse(w, status, report)
+		return
+	}
+
+	report.Committed = true
+	failedEvents := 0
+	for i, step := range steps {
+		result := &report.Results[i]
+		eventType := "company_created"
+		switch step.op {
+		case BatchCreate:
+			result.Status = OperationCreated
+		case BatchUpdate:
+			result.Status, eventType = OperationUpdated, "company_updated"
+		case BatchDelete:
+			result.Status, eventType = OperationDeleted, "company_deleted"
+			result.Company = nil
+		}
+		company := step.company
+		if result.Company != nil {
+			company = result.Company
+		}
+		if err := app.PublishEvent(r.Context(), kafka.NewEvent(eventType, company)); err != nil {
+			slog.ErrorContext(r.Context(), "Kafka publish failed", slog.Any("error", err))
+			failedEvents++
+		}
+	}
+	if failedEvents > 0 {
+		report.Warning = fmt.Sprintf("Kafka publishing failed for %d of %d events", failedEvents, len(steps))
+	}
+	slog.InfoContext(r.Context(), "Batch committed", slog.Int("operations", len(steps)))
+	utils.SendJSONResponse(w, http.StatusOK, report)
+}
+
+// parseBatchOperation validates an operation with the rules of the endpoint doing the same change
+func parseBatchOperation(operation BatchOperation) (batchStep, error) {
+	step := batchStep{op: operation.Op, id: operation.ID}
+	if operation.Op != BatchCreate {
+		parsed, err := utils.GenerateUUIDFromString(operation.ID)
+		if err != nil {
+			return step, errors.New("invalid 'id': it must be a UUID")
+		}
+		step.company = &models.Company{ID: parsed}
+	}
+	switch operation.Op {
+	case BatchCreate:
+		if operation.ID != "" || operation.Fields != nil {
+			return step, errors.New("a create operation only takes a company")
+		}
+		if err := decodeStrict(operation.Company, &step.company); err != nil {
+			return step, fmt.Errorf("invalid 'company': %v", err)
+		}
+		if step.company == nil {
+			return step, errors.New("invalid 'company': it is required")
+		}
+		if err := utils.ValidateCompanyInput(step.company); err != nil {
+			return step, err
+		}
+		step.company.ID = utils.GenerateUUID()
+	case BatchUpdate:
+		if operation.Company != nil {
+			return step, errors.New("an update operation takes fields, not a company")
+		}
+		if err := decodeStrict(operation.Fields, &step.fields); err != nil {
+			return step, fmt.Errorf("invalid 'fields': %v", err)
+		}
+		if len(step.fields) == 0 {
+			return step, errors.New("invalid 'fields': at least one field is required")
+		}
+		for field := range step.fields {
+			// The writable fields are the columns of an import
+			if !importColumns[field] {
+				return step, fmt.Errorf("invalid 'fields': unknown field %q", field)
+			}
+		}
+		if err := utils.ValidateCompanyUpdate(step.fields); err != nil {
+			return step, err
+		}
+	case BatchDelete:
+		if operation.Company != nil || operation.Fields != nil {
+			return step, errors.New("a delete operation only takes an id")
+		}
+	default:
+		return step, fmt.Errorf("invalid 'op': allowed values are '%s', '%s' and '%s'", BatchCreate, BatchUpdate, BatchDelete)
+	}
+	return step, nil
+}
+
+// decodeStrict decodes a JSON value refusing unknown fields, a missing value leaves target untouched
+func decodeStrict(data json.RawMessage, target interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(target)
+}
+
+// runBatchStep applies an operation in the transaction and returns the company it wrote
+func runBatchStep(ctx context.Context, tx database.Database, step batchStep) (*models.Company, error) {
+	switch step.op {
+	case BatchCreate:
+		company := *step.company
+		if err := tx.CreateCompany(ctx, &company); err != nil {
+			return nil, err
+		}
+		return &company, nil
+	case BatchUpdate:
+		return tx.UpdateCompany(ctx, step.id, step.fields)
+	default:
+		return step.company, tx.DeleteCompany(ctx, step.id)
+	}
+}
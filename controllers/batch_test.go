@@ -0,0 +1,114 @@
+package controllers_test
+
+import (
+	"company-service/controllers"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batchCompanies(t *testing.T, app *controllers.App, body string) (int, controllers.BatchReport) {
+	req := httptest.NewRequest(http.MethodPost, "/api/companies/batch", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	app.BatchCompanies(rr, req)
+
+	var report controllers.BatchReport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report), rr.Body.String())
+	return rr.Code, report
+}
+
+func batchStatuses(report controllers.BatchReport) []string {
+	var result []string
+	for _, operation := range report.Results {
+		result = append(result, operation.Status)
+	}
+	return result
+}
+
+func TestBatchCompaniesCommits(t *testing.T) {
+	app, producer := newImportApp(t)
+	acme, err := app.DB.GetCompanyByName(context.Background(), "Acme")
+	require.NoError(t, err)
+
+	code, report := batchCompanies(t, app, `{"operations":[
+		{"op":"create","company":{"name":"Globex","employees":10,"registered":true,"type":"Corporations"}},
+		{"op":"create","company":{"name":"Initech","employees":20,"registered":true,"type":"Corporations"}},
+		{"op":"update","id":"`+acme.ID.String()+`","fields":{"employees":50}},
+		{"op":"delete","id":"`+acme.ID.String()+`"}
+	]}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, report.Committed)
+	assert.Equal(t, []string{"created", "created", "updated", "deleted"}, batchStatuses(report))
+	assert.Equal(t, 50, report.Results[2].Company.Employees)
+	assert.Equal(t, acme.ID.String(), report.Results[3].ID)
+
+	ctx := context.Background()
+	assert.True(t, app.DB.CheckIfExistsByName(ctx, "Globex"))
+	assert.True(t, app.DB.CheckIfExistsByName(ctx, "Initech"))
+	assert.False(t, app.DB.CheckIfExistsByName(ctx, "Acme"))
+
+	var eventTypes []string
+	for _, event := range producer.Events() {
+		eventTypes = append(eventTypes, event.EventType)
+	}
+	assert.Equal(t, []string{"company_created", "company_created", "company_updated", "company_deleted"}, eventTypes)
+	assert.Equal(t, report.Results[0].ID, producer.Events()[0].Company.ID.String())
+}
+
+func TestBatchCompaniesRollsBack(t *testing.T) {
+	app, producer := newImportApp(t)
+	acme, err := app.DB.GetCompanyByName(context.Background(), "Acme")
+	require.NoError(t, err)
+
+	// The third operation clashes with the name created by the first one
+	code, report := batchCompanies(t, app, `{"operations":[
+		{"op":"create","company":{"name":"Globex","employees":10,"registered":true,"type":"Corporations"}},
+		{"op":"update","id":"`+acme.ID.String()+`","fields":{"employees":50}},
+		{"op":"create","company":{"name":"GLOBEX","employees":1,"registered":true,"type":"NonProfit"}},
+		{"op":"delete","id":"`+acme.ID.String()+`"}
+	]}`)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.False(t, report.Committed)
+	assert.Equal(t, []string{"rolled_back", "rolled_back", "failed", "not_run"}, batchStatuses(report))
+	assert.NotEmpty(t, report.Results[2].Error)
+
+	ctx := context.Background()
+	assert.False(t, app.DB.CheckIfExistsByName(ctx, "Globex"))
+	unchanged, err := app.DB.GetCompany(ctx, acme.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, acme.Employees, unchanged.Employees)
+	assert.Empty(t, producer.Events())
+
+	// A missing company is reported as not found
+	code, report = batchCompanies(t, app, `{"operations":[{"op":"delete","id":"8b9e0d4c-3f6a-4c1e-9d7a-2b5f0e1c3a4d"}]}`)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, []string{"failed"}, batchStatuses(report))
+}
+
+func TestBatchCompaniesInvalidRequest(t *testing.T) {
+	app, producer := newImportApp(t)
+	code, report := batchCompanies(t, app, `{"operations":[
+		{"op":"create","company":{"name":"Globex","employees":10,"registered":true,"type":"Corporations"}},
+		{"op":"create","company":{"name":"Initech","employees":0,"registered":true,"type":"Corporations"}},
+		{"op":"update","id":"not-a-uuid","fields":{"employees":1}},
+		{"op":"update","id":"8b9e0d4c-3f6a-4c1e-9d7a-2b5f0e1c3a4d","fields":{"website":"x"}},
+		{"op":"upsert"}
+	]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []string{"not_run", "invalid", "invalid", "invalid", "invalid"}, batchStatuses(report))
+	assert.False(t, app.DB.CheckIfExistsByName(context.Background(), "Globex"))
+	assert.Empty(t, producer.Events())
+
+	for _, body := range []string{`{"operations":[]}`, `{"ops":[]}`} {
+		req := httptest.NewRequest(http.MethodPost, "/api/companies/batch", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		app.BatchCompanies(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
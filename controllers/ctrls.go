@@ -3,16 +3,23 @@ package controllers
 import (
 	"company-service/config"
 	"company-service/database"
+	"company-service/jobs"
 	"company-service/kafka"
 	"company-service/middleware"
 	"company-service/models"
+	"company-service/ratelimit"
 	"company-service/utils"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/graphql-go/graphql"
+	"go.opentelemetry.io/otel"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
-	"log"
+	"log/slog"
+	"mime"
 	"net/http"
 	"time"
 )
@@ -22,15 +29,34 @@ type App struct {
 	DB            database.Database
 	KafkaProducer kafka.Producer
 	Config        *config.Config
+	// RateLimitStore keeps the token buckets of the clients, in memory unless replaced
+	RateLimitStore ratelimit.Store
+	// Jobs runs the background jobs, its workers are started by Jobs.Start
+	Jobs *jobs.Runner
+	// Users finds the users of the tokens, remembering them for Config.UserCacheTTL
+	Users middleware.UserStore
+
+	graphQLSchema graphql.Schema
 }
 
 // NewApp initializes and returns an instance of the App struct
 func NewApp(db database.Database, producer kafka.Producer, conf *config.Config) *App {
-	return &App{
-		DB:            db,
-		KafkaProducer: producer,
-		Config:        conf,
+	app := &App{
+		DB:             db,
+		KafkaProducer:  producer,
+		Config:         conf,
+		RateLimitStore: ratelimit.NewMemoryStore(),
+		Jobs:           jobs.NewRunner(db, conf.JobWorkers, conf.JobQueueSize),
+		Users:          middleware.NewUserCache(db, conf.UserCacheTTL),
+	}
+	app.registerJobs()
+	schema, err := app.newGraphQLSchema()
+	if err != nil {
+		// The schema is static, it only fails to build on a programming error
+		panic(fmt.Sprintf("invalid GraphQL schema: %v", err))
 	}
+	app.graphQLSchema = schema
+	return app
 }
 
 // Login authenticates the user and sends a JWT token
@@ -46,25 +72,30 @@ func (app *App) Login(w http.ResponseWriter, r *http.Request) {
 	decoder.DisallowUnknownFields() // Ensure that unknown fields are not allowed
 	err := decoder.Decode(&loginRequest)
 	if err != nil {
-		utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid input data for login with error: %v", err))
+		utils.SendErrorResponse(w, decodeErrorStatus(err), fmt.Sprintf("Invalid input data for login with error: %v", err))
 		return
 	}
 
 	// Find the user by username
 	// Get the user from the database
-	user, err := app.DB.GetUserByUsername(loginRequest.Username) //database.GetUserByUsername(loginRequest.Username, app.DB)
+	user, err := app.DB.GetUserByUsername(r.Context(), loginRequest.Username) //database.GetUserByUsername(loginRequest.Username, app.DB)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			utils.SendErrorResponse(w, http.StatusUnauthorized, "Invalid username or password")
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			utils.SendErrorResponse(w, http.StatusGatewayTimeout, fmt.Sprintf("Error querying the database with error: %v", err))
 		} else {
 			utils.SendErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error querying the database with error: %v", err))
 		}
 		return
 	}
 
-	// Compare the hashed password
+	// Compare the hashed password, bcrypt is slow on purpose so it gets its own span
+	_, span := otel.Tracer("company-service/controllers").Start(r.Context(), "bcrypt.CompareHashAndPassword")
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginRequest.Password))
-	if err != nil {
+	span.End()
+	// Disabled users get the same answer as a wrong password
+	if err != nil || user.Disabled {
 		utils.SendErrorResponse(w, http.StatusUnauthorized, "Invalid username or password")
 		return
 	}
@@ -75,15 +106,32 @@ func (app *App) Login(w http.ResponseWriter, r *http.Request) {
 		utils.SendErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Could not generate token with error: %v", err))
 		return
 	}
+	csrfToken, err := middleware.GenerateCSRFToken()
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Could not generate token with error: %v", err))
+		return
+	}
+	expires := time.Now().Add(15 * time.Minute)
 	// Set the token in an HTTP-only, Secure cookie
 	http.SetCookie(w, &http.Cookie{
-		Name:     "auth_token",                     // Name of the cookie
-		Value:    tokenString,                      // The JWT token value
-		HttpOnly: true,                             // Make the cookie HTTP-only to prevent XSS attacks
-		Secure:   true,                             // Set to true if using HTTPS
-		Path:     "/",                              // Available throughout the entire site
-		Expires:  time.Now().Add(15 * time.Minute), // Set the expiration time (e.g., 1 hour)
+		Name:     "auth_token",              // Name of the cookie
+		Value:    tokenString,               // The JWT token value
+		HttpOnly: true,                      // Make the cookie HTTP-only to prevent XSS attacks
+		Secure:   true,                      // Set to true if using HTTPS
+		Path:     "/",                       // Available throughout the entire site
+		Expires:  expires,                   // Set the expiration time (e.g., 1 hour)
+		SameSite: app.Config.CookieSameSite, // Whether the cookie is sent on cross-site requests
 	})
+	// The CSRF token of the double-submit check, the client echoes it in the X-CSRF-Token header
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.CSRFCookieName,
+		Value:    csrfToken,
+		Secure:   true,
+		Path:     "/",
+		Expires:  expires,
+		SameSite: app.Config.CookieSameSite,
+	})
+	w.Header().Set(middleware.CSRFHeader, csrfToken)
 
 	// Send the response with the token
 	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"message": "Login successful"})
@@ -99,14 +147,10 @@ func (app *App) CreateCompany(w http.ResponseWriter, r *http.Request) {
 	decoder.DisallowUnknownFields() //Ensure that are not allowed uknown fields
 	err := decoder.Decode(&company)
 	if err != nil {
-		utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid input data to create a new company record with error: %v", err))
+		utils.SendErrorResponse(w, decodeErrorStatus(err), fmt.Sprintf("Invalid input data to create a new company record with error: %v", err))
 		return
 	}
 
-	if app.DB.CheckIfExistsByName(company.Name) {
-		utils.SendErrorResponse(w, http.StatusConflict, "The company with the same name already exists")
-		return
-	}
 	// Validate the company input
 	if err := utils.ValidateCompanyInput(company); err != nil {
 		utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
@@ -116,21 +160,22 @@ func (app *App) CreateCompany(w http.ResponseWriter, r *http.Request) {
 	id := utils.GenerateUUID()
 	company.ID = id
 
-	err = app.DB.CreateCompany(company)
-	if err != nil {
-		utils.SendErrorResponse(w, http.StatusInternalServerError, err.Error())
+	// The uniqueness of the name is enforced by the database, a clash is reported as a conflict
+	err = app.DB.CreateCompany(r.Context(), company)
+	if errors.Is(err, database.ErrDuplicateName) {
+		utils.SendErrorResponse(w, http.StatusConflict, "The company with the same name already exists")
 		return
 	}
-	eventMessage := kafka.EventMessage{
-		EventType: "company_created",
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		Company:   company,
+	if err != nil {
+		utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
+		return
 	}
+	eventMessage := kafka.NewEvent("company_created", company)
 	// Publish the event to the message broker
-	err = app.KafkaProducer.ProduceEvent(&eventMessage)
+	err = app.PublishEvent(r.Context(), eventMessage)
 	if err != nil {
 		// Log the Kafka error for retry or monitoring
-		log.Printf("Kafka publish failed with error: %v", err)
+		slog.ErrorContext(r.Context(), "Kafka publish failed", slog.Any("error", err))
 		// Continue without failing the HTTP request
 		// Include a warning in the JSON response
 		response := map[string]interface{}{
@@ -147,29 +192,65 @@ func (app *App) CreateCompany(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// GetCompany retrieves a company record with the given ID
+// GetCompany retrieves a company record with the given ID. The fields query parameter selects
+// the fields of the response, which are the only columns read, and expand embeds related resources.
 func (app *App) GetCompany(w http.ResponseWriter, r *http.Request) {
-	//Get UUID parameter
-	id, err := utils.GetUUIDParam(r, "id")
+	// A UUID, checked by ValidationMiddleware
+	id := mux.Vars(r)["id"]
+	options, err := parseReadOptions(r.URL.Query())
 	if err != nil {
 		utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if options.projected() {
+		app.getCompanyProjection(w, r, id, options)
+		return
+	}
 	// Check if the data ID exists in the datastore and return it
-	company, err := app.DB.GetCompany(id)
+	company, err := app.DB.GetCompany(r.Context(), id)
 	if err != nil {
-		utils.SendErrorResponse(w, http.StatusNotFound, err.Error())
+		utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
 		return
 	}
+	// The ETag is sent back in the If-Match header of a conditional PUT
+	w.Header().Set("ETag", companyETag(company))
 	utils.SendJSONResponse(w, http.StatusOK, &company)
 }
 
-// UpdateCompany a company record with the given id
-func (app *App) UpdateCompany(w http.ResponseWriter, r *http.Request) {
-	//Get UUID parameter
-	id, err := utils.GetUUIDParam(r, "id")
+// getCompanyProjection sends the selected fields and the expanded relations of the company.
+// The representation is partial, so it has no ETag.
+func (app *App) getCompanyProjection(w http.ResponseWriter, r *http.Request, id string, options readOptions) {
+	var company *models.Company
+	var err error
+	if options.columns != nil {
+		company, err = app.DB.GetCompanyFields(r.Context(), id, options.names())
+	} else {
+		company, err = app.DB.GetCompany(r.Context(), id)
+	}
 	if err != nil {
-		utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+		utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
+		return
+	}
+	document, err := app.projectCompany(r.Context(), company, options)
+	if err != nil {
+		utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, document)
+}
+
+// UpdateCompany a company record with the given id. A plain JSON body holds the fields to change,
+// a merge patch or a JSON patch is selected by the Content-Type.
+func (app *App) UpdateCompany(w http.ResponseWriter, r *http.Request) {
+	// A UUID, checked by ValidationMiddleware
+	id := mux.Vars(r)["id"]
+	switch mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType {
+	case MergePatchType, JSONPatchType:
+		app.patchCompany(w, r, id, mediaType)
+		return
+	case "", "application/json":
+	default:
+		utils.SendErrorResponse(w, http.StatusUnsupportedMediaType, fmt.Sprintf("The body must be application/json, %s or %s", MergePatchType, JSONPatchType))
 		return
 	}
 	var updatedFields map[string]interface{}
@@ -177,11 +258,17 @@ func (app *App) UpdateCompany(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	defer r.Body.Close()            //Ensure the request body is closed
 	decoder.DisallowUnknownFields() //Ensure that are not allowed uknown fields
-	err = decoder.Decode(&updatedFields)
+	err := decoder.Decode(&updatedFields)
 	if err != nil {
-		utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid input data to update a company record: %v", err))
+		utils.SendErrorResponse(w, decodeErrorStatus(err), fmt.Sprintf("Invalid input data to update a company record: %v", err))
 		return
 	}
+	for field := range updatedFields {
+		if err = checkPatchField(field, false); err != nil {
+			utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 	err = utils.ValidateCompanyUpdate(updatedFields)
 	if err != nil {
 		utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
@@ -189,25 +276,26 @@ func (app *App) UpdateCompany(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if the data ID exists in the datastore and update it
-	company, err := app.DB.UpdateCompany(id, updatedFields)
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			utils.SendErrorResponse(w, http.StatusNotFound, err.Error())
-		} else {
-			utils.SendErrorResponse(w, http.StatusInternalServerError, err.Error())
-		}
+	company, err := app.DB.UpdateCompany(r.Context(), id, updatedFields)
+	if errors.Is(err, database.ErrDuplicateName) {
+		utils.SendErrorResponse(w, http.StatusConflict, "The company with the same name already exists")
 		return
 	}
-	eventMessage := kafka.EventMessage{
-		EventType: "company_updated",
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		Company:   company,
+	if err != nil {
+		utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
+		return
 	}
+	app.companyUpdated(w, r, company)
+}
+
+// companyUpdated publishes the event of an updated company and sends it in the response
+func (app *App) companyUpdated(w http.ResponseWriter, r *http.Request, company *models.Company) {
+	eventMessage := kafka.NewEvent("company_updated", company)
 	// Publish the event to the message broker
-	err = app.KafkaProducer.ProduceEvent(&eventMessage)
+	err := app.PublishEvent(r.Context(), eventMessage)
 	if err != nil {
 		// Log the Kafka error for retry or monitoring
-		log.Printf("Kafka publish failed with error: %v", err)
+		slog.ErrorContext(r.Context(), "Kafka publish failed", slog.Any("error", err))
 		// Continue without failing the HTTP request
 		// Include a warning in the JSON response
 		response := map[string]interface{}{
@@ -226,37 +314,66 @@ func (app *App) UpdateCompany(w http.ResponseWriter, r *http.Request) {
 
 // DeleteCompany a company record with the given id
 func (app *App) DeleteCompany(w http.ResponseWriter, r *http.Request) {
-	//Get UUID parameter
-	id, err := utils.GetUUIDParam(r, "id")
-	if err != nil {
-		utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
-		return
-	}
+	// A UUID, checked by ValidationMiddleware
+	id := mux.Vars(r)["id"]
 
 	// Check if the data ID exists in the datastore and delete it
-	err = app.DB.DeleteCompany(id)
+	err := app.DB.DeleteCompany(r.Context(), id)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			utils.SendErrorResponse(w, http.StatusNotFound, err.Error())
-		} else {
-			utils.SendErrorResponse(w, http.StatusInternalServerError, err.Error())
-		}
+		utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
 		return
 	}
 	parsedUUID, err := utils.GenerateUUIDFromString(id)
 	if err != nil {
-		log.Printf("Could not parse UUID from string: %v", err)
-	}
-	eventMessage := kafka.EventMessage{
-		EventType: "company_deleted",
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		Company:   &models.Company{ID: parsedUUID},
+		slog.ErrorContext(r.Context(), "Could not parse UUID from string", slog.Any("error", err))
 	}
+	eventMessage := kafka.NewEvent("company_deleted", &models.Company{ID: parsedUUID})
 	// Publish the event to the message broker
-	err = app.KafkaProducer.ProduceEvent(&eventMessage)
+	err = app.PublishEvent(r.Context(), eventMessage)
 	if err != nil {
 		// Log the Kafka error for retry or monitoring
-		log.Printf("Kafka publish failed with error: %v", err)
+		slog.ErrorContext(r.Context(), "Kafka publish failed", slog.Any("error", err))
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// PublishEvent publishes an event for a change that is already committed. The request
+// cancellation is not propagated so a disconnecting client does not lose the event. The
+// delivery is asynchronous, the configured Kafka timeout only bounds the wait for room in
+// the queue of the producer.
+func (app *App) PublishEvent(ctx context.Context, event *kafka.EventMessage) error {
+	timeout := app.Config.KafkaProduceTimeout
+	if timeout <= 0 {
+		timeout = config.DefaultKafkaProduceTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	return app.KafkaProducer.ProduceEvent(ctx, event)
+}
+
+// StatusClientClosedRequest is the status of a request whose client went away, as logged by nginx
+const StatusClientClosedRequest = 499
+
+// DatabaseErrorStatus maps an error returned by the database to an HTTP status code. The gRPC
+// API maps these statuses to its codes, so both APIs report a database error the same way.
+func DatabaseErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return StatusClientClosedRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// decodeErrorStatus maps an error returned while decoding the request body to an HTTP status code
+func decodeErrorStatus(err error) int {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"company-service/config"
 	"company-service/controllers"
+	"company-service/database"
 	"company-service/mocks"
 	"company-service/models"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -80,6 +82,26 @@ func TestLogin(t *testing.T) {
 			expectedCode: http.StatusUnauthorized,
 			expectedBody: map[string]string{"error": "Invalid username or password"},
 		},
+		{
+			name: "Disabled user",
+			requestBody: map[string]string{
+				"username": "user2",
+				"password": "test2",
+			},
+			mockSetup: func(mockDB *mocks.MockDatabase) {
+				hashedPassword, err := bcrypt.GenerateFromPassword([]byte("test2"), bcrypt.DefaultCost)
+				if err != nil {
+					t.Fatalf("Failed to hash password: %v", err)
+				}
+				mockDB.On("GetUserByUsername", "user2").Return(&models.User{
+					Username: "user2",
+					Password: string(hashedPassword),
+					Disabled: true,
+				}, nil)
+			},
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: map[string]string{"error": "Invalid username or password"},
+		},
 		{
 			name: "Invalid input data uknown field",
 			requestBody: map[string]string{
@@ -158,7 +180,6 @@ func TestCreateCompany(t *testing.T) {
 				"type":        "NonProfit",
 			},
 			mockSetup: func(mockDB *mocks.MockDatabase, mockKafka *mocks.MockKafkaProducer) {
-				mockDB.On("CheckIfExistsByName", mock.AnythingOfType("string")).Return(false)
 				mockDB.On("CreateCompany", mock.AnythingOfType("*models.Company")).Return(nil)
 				mockKafka.On("ProduceEvent", mock.AnythingOfType("*kafka.EventMessage")).Return(nil)
 				// Mock ProduceEvent
@@ -187,7 +208,6 @@ func TestCreateCompany(t *testing.T) {
 				"name": "Tech company",
 			},
 			mockSetup: func(mockDB *mocks.MockDatabase, mockKafka *mocks.MockKafkaProducer) {
-				mockDB.On("CheckIfExistsByName", mock.AnythingOfType("string")).Return(false)
 				mockDB.On("CreateCompany", mock.AnythingOfType("*models.Company")).Return(nil)
 			},
 			expectedCode:  http.StatusBadRequest,
@@ -202,7 +222,6 @@ func TestCreateCompany(t *testing.T) {
 				"type":       "InvalidType",
 			},
 			mockSetup: func(mockDB *mocks.MockDatabase, mockKafka *mocks.MockKafkaProducer) {
-				mockDB.On("CheckIfExistsByName", mock.AnythingOfType("string")).Return(false)
 				mockDB.On("CreateCompany", mock.AnythingOfType("*models.Company")).Return(nil)
 			},
 			expectedCode:  http.StatusBadRequest,
@@ -214,7 +233,6 @@ func TestCreateCompany(t *testing.T) {
 				"name": "This is a very long name for a company",
 			},
 			mockSetup: func(mockDB *mocks.MockDatabase, mockKafka *mocks.MockKafkaProducer) {
-				mockDB.On("CheckIfExistsByName", mock.AnythingOfType("string")).Return(false)
 				mockDB.On("CreateCompany", mock.AnythingOfType("*models.Company")).Return(nil)
 			},
 			expectedCode:  http.StatusBadRequest,
@@ -229,8 +247,7 @@ func TestCreateCompany(t *testing.T) {
 				"type":       "NonProfit",
 			},
 			mockSetup: func(mockDB *mocks.MockDatabase, mockKafka *mocks.MockKafkaProducer) {
-				mockDB.On("CheckIfExistsByName", mock.AnythingOfType("string")).Return(true)
-				mockDB.On("CreateCompany", mock.AnythingOfType("*models.Company")).Return(nil)
+				mockDB.On("CreateCompany", mock.AnythingOfType("*models.Company")).Return(database.ErrDuplicateName)
 			},
 			expectedCode:  http.StatusConflict,
 			expectedError: map[string]string{"error": "The company with the same name already exists"},
@@ -339,7 +356,7 @@ func TestGetCompany(t *testing.T) {
 			},
 			expectedCode: http.StatusBadRequest,
 			expectedError: map[string]string{
-				"error": "the parameter id is not UUID",
+				"error": "invalid 'id': it must be a UUID",
 			},
 		},
 		{
@@ -353,6 +370,28 @@ func TestGetCompany(t *testing.T) {
 				"error": "record not found",
 			},
 		},
+		{
+			name: "Database timeout",
+			id:   validUUID.String(),
+			mockSetup: func(mockDB *mocks.MockDatabase) {
+				mockDB.On("GetCompany", validUUID.String()).Return(nil, context.DeadlineExceeded)
+			},
+			expectedCode: http.StatusGatewayTimeout,
+			expectedError: map[string]string{
+				"error": "context deadline exceeded",
+			},
+		},
+		{
+			name: "Database error",
+			id:   validUUID.String(),
+			mockSetup: func(mockDB *mocks.MockDatabase) {
+				mockDB.On("GetCompany", validUUID.String()).Return(nil, errors.New("connection refused"))
+			},
+			expectedCode: http.StatusInternalServerError,
+			expectedError: map[string]string{
+				"error": "connection refused",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -363,6 +402,7 @@ func TestGetCompany(t *testing.T) {
 
 			// Set up the router and handler for this test
 			router := mux.NewRouter()
+			router.Use(app.ValidationMiddleware())
 			router.HandleFunc("/api/companies/{id}", app.GetCompany).Methods(http.MethodGet)
 
 			// Prepare request and response recorder
@@ -470,7 +510,7 @@ func TestApp_UpdateCompany(t *testing.T) {
 			},
 			expectedCode: http.StatusBadRequest,
 			expectedError: map[string]string{
-				"error": "the parameter id is not UUID",
+				"error": "invalid 'id': it must be a UUID",
 			},
 		},
 		{
@@ -492,6 +532,20 @@ func TestApp_UpdateCompany(t *testing.T) {
 				"error": "record not found",
 			},
 		},
+		{
+			name: "Rename into an existing name",
+			id:   validUUID.String(),
+			requestBody: map[string]interface{}{
+				"name": "Tech company",
+			},
+			mockSetup: func(mockDB *mocks.MockDatabase, mockKafka *mocks.MockKafkaProducer) {
+				mockDB.On("UpdateCompany", validUUID.String(), mock.AnythingOfType("map[string]interface {}")).Return(nil, database.ErrDuplicateName)
+			},
+			expectedCode: http.StatusConflict,
+			expectedError: map[string]string{
+				"error": "The company with the same name already exists",
+			},
+		},
 		{
 			name: "Invalid input data - invalid type",
 			id:   validUUID.String(),
@@ -507,7 +561,7 @@ func TestApp_UpdateCompany(t *testing.T) {
 			},
 			expectedCode: http.StatusBadRequest,
 			expectedError: map[string]string{
-				"error": "invalid 'type'. Allowed values are 'Corporations', 'NonProfit', 'Cooperative', 'Sole Proprietorship'",
+				"error": "invalid 'type': it must be one of 'Corporations', 'NonProfit', 'Cooperative', 'Sole Proprietorship'",
 			},
 		},
 	}
@@ -520,6 +574,7 @@ func TestApp_UpdateCompany(t *testing.T) {
 
 			// Set up the router and handler for this test
 			router := mux.NewRouter()
+			router.Use(app.ValidationMiddleware())
 			router.HandleFunc("/api/companies/{id}", app.UpdateCompany).Methods(http.MethodPatch)
 
 			// Prepare request and response recorder
@@ -585,7 +640,7 @@ func TestDeleteCompany(t *testing.T) {
 			},
 			expectedCode: http.StatusBadRequest,
 			expectedBody: map[string]string{
-				"error": "the parameter id is not UUID",
+				"error": "invalid 'id': it must be a UUID",
 			},
 		},
 		{
@@ -611,6 +666,7 @@ func TestDeleteCompany(t *testing.T) {
 
 			// Set up the router and handler for this test
 			router := mux.NewRouter()
+			router.Use(app.ValidationMiddleware())
 			router.HandleFunc("/api/companies/{id}", app.DeleteCompany).Methods(http.MethodDelete)
 
 			// Prepare request and response recorder
@@ -647,3 +703,17 @@ func compareCompanyIgnoringID(expected, actual models.Company) bool {
 		expected.CreatedAt == actual.CreatedAt &&
 		expected.UpdatedAt == actual.UpdatedAt
 }
+
+func TestDatabaseErrorStatus(t *testing.T) {
+	for _, tt := range []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("company not found: %w", gorm.ErrRecordNotFound), http.StatusNotFound},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{fmt.Errorf("query: %w", context.Canceled), controllers.StatusClientClosedRequest},
+		{errors.New("connection refused"), http.StatusInternalServerError},
+	} {
+		assert.Equal(t, tt.status, controllers.DatabaseErrorStatus(tt.err), tt.err.Error())
+	}
+}
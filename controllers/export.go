@@ -0,0 +1,314 @@
+package controllers
+
+import (
+	"bufio"
+	"company-service/database"
+	"company-service/models"
+	"company-service/utils"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// exportColumn is a company field that can be selected in an export
+type exportColumn struct {
+	name  string
+	node  parquet.Node
+	value func(company *models.Company) interface{}
+}
+
+// exportColumns lists the columns of an export in their default order
+var exportColumns = []exportColumn{
+	{"id", parquet.String(), func(c *models.Company) interface{} { return c.ID.String() }},
+	{"name", parquet.String(), func(c *models.Company) interface{} { return c.Name }},
+	{"description", parquet.String(), func(c *models.Company) interface{} { return c.Description }},
+	{"employees", parquet.Int(64), func(c *models.Company) interface{} { return c.Employees }},
+	{"registered", parquet.Leaf(parquet.BooleanType), func(c *models.Company) interface{} { return c.Registered }},
+	{"type", parquet.String(), func(c *models.Company) interface{} { return c.Type }},
+	{"created_at", parquet.Timestamp(parquet.Microsecond), func(c *models.Company) interface{} { return c.CreatedAt }},
+	{"updated_at", parquet.Timestamp(parquet.Microsecond), func(c *models.Company) interface{} { return c.UpdatedAt }},
+}
+
+// companyWriter writes the rows of an export in one format
+type companyWriter interface {
+	Write(company *models.Company) error
+	Close() error
+}
+
+// exportFormats maps the format query parameter to the content type, the file extension and the writer
+var exportFormats = map[string]struct {
+	contentType string
+	newWriter   func(w io.Writer, columns []exportColumn) (companyWriter, error)
+}{
+	"csv":     {"text/csv; charset=utf-8", newCSVWriter},
+	"ndjson":  {"application/x-ndjson", newNDJSONWriter},
+	"parquet": {"application/vnd.apache.parquet", newParquetWriter},
+}
+
+// ExportCompanies streams the companies matching the filters as CSV, NDJSON or Parquet.
+// The rows are written while they are read from the database cursor.
+func (app *App) ExportCompanies(w http.ResponseWriter, r *http.Request) {
+	options, err := parseExportOptions(r.URL.Query())
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The export may outlast the server write timeout, it is bounded by the route time budget instead
+	if deadline, ok := r.Context().Deadline(); ok {
+		_ = http.NewResponseController(w).SetWriteDeadline(deadline)
+	}
+	w.Header().Set("Content-Type", exportFormats[options.format].contentType)
+	w.Header().Set("Content-Disposition", exportDisposition(options.format, time.Now()))
+
+	sent := &sentWriter{Writer: w}
+	count, err := app.exportCompanies(r.Context(), sent, options, nil)
+	if err != nil {
+		if !sent.sent {
+			// Nothing was sent yet, so the failure can still be reported with a status
+			w.Header().Del("Content-Disposition")
+			utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
+			return
+		}
+		// The status is already sent, abort the connection so the client does not keep a truncated file
+		slog.ErrorContext(r.Context(), "Export failed", slog.Int("rows", count), slog.Any("error", err))
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// exportOptions are the parameters of an export, given by the query of the endpoint or the parameters of a job
+type exportOptions struct {
+	format  string
+	filter  database.CompanyFilter
+	columns []exportColumn
+}
+
+// parseExportOptions reads the format, columns and filter query parameters of an export
+func parseExportOptions(query url.Values) (exportOptions, error) {
+	options := exportOptions{format: query.Get("format")}
+	if options.format == "" {
+		options.format = "csv"
+	}
+	if _, ok := exportFormats[options.format]; !ok {
+		return options, errors.New("invalid 'format': allowed values are 'csv', 'ndjson' and 'parquet'")
+	}
+	var err error
+	if options.filter, err = parseCompanyFilter(query); err != nil {
+		return options, err
+	}
+	options.columns, err = parseCompanyColumns("columns", query.Get("columns"))
+	return options, err
+}
+
+// exportDisposition returns the Content-Disposition of an export made at the given time
+func exportDisposition(format string, at time.Time) string {
+	return fmt.Sprintf(`attachment; filename="companies-%s.%s"`, at.UTC().Format("20060102"), format)
+}
+
+// exportCompanies writes the matching companies to w while they are read from the database cursor,
+// calling progress after every row when it is not nil, and returns the number of rows
+func (app *App) exportCompanies(ctx context.Context, w io.Writer, options exportOptions, progress func(rows int)) (int, error) {
+	buffered := bufio.NewWriter(w)
+	writer, err := exportFormats[options.format].newWriter(buffered, options.columns)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = app.DB.StreamCompanies(ctx, options.filter, func(company *models.Company) error {
+		count++
+		if progress != nil {
+			progress(count)
+		}
+		return writer.Write(company)
+	})
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil {
+		slog.InfoContext(ctx, "Companies exported", slog.String("format", options.format), slog.Int("rows", count))
+	}
+	return count, err
+}
+
+// sentWriter records whether anything reached the response, after which the status cannot change
+type sentWriter struct {
+	io.Writer
+	sent bool
+}
+
+func (s *sentWriter) Write(p []byte) (int, error) {
+	s.sent = true
+	return s.Writer.Write(p)
+}
+
+// parseCompanyFilter reads the type, registered, min_employees and max_employees query parameters
+func parseCompanyFilter(query url.Values) (database.CompanyFilter, error) {
+	var filter database.CompanyFilter
+	if value := query.Get("type"); value != "" {
+		if !slices.Contains(utils.CompanyTypes, value) {
+			return filter, fmt.Errorf("invalid 'type': must be one of 'Corporations', 'NonProfit', 'Cooperative', 'Sole Proprietorship'")
+		}
+		filter.Type = value
+	}
+	if value := query.Get("registered"); value != "" {
+		registered, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid 'registered': it must be true or false")
+		}
+		filter.Registered = &registered
+	}
+	for _, bound := range []struct {
+		name   string
+		target **int
+	}{{"min_employees", &filter.MinEmployees}, {"max_employees", &filter.MaxEmployees}} {
+		if value := query.Get(bound.name); value != "" {
+			employees, err := strconv.Atoi(value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid '%s': it must be an integer", bound.name)
+			}
+			*bound.target = &employees
+		}
+	}
+	return filter, nil
+}
+
+// parseCompanyColumns returns the columns of a comma separated selection given by the named
+// query parameter, or all of them
+func parseCompanyColumns(param, value string) ([]exportColumn, error) {
+	if value == "" {
+		return exportColumns, nil
+	}
+	var columns []exportColumn
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		index := slices.IndexFunc(exportColumns, func(column exportColumn) bool { return column.name == name })
+		if index < 0 {
+			return nil, fmt.Errorf("invalid '%s': unknown field %q, allowed fields are %s", param, name, strings.Join(database.CompanyColumns, ", "))
+		}
+		if slices.ContainsFunc(columns, func(column exportColumn) bool { return column.name == name }) {
+			return nil, fmt.Errorf("invalid '%s': duplicate field %q", param, name)
+		}
+		columns = append(columns, exportColumns[index])
+	}
+	return columns, nil
+}
+
+type csvWriter struct {
+	writer  *csv.Writer
+	columns []exportColumn
+	record  []string
+}
+
+// newCSVWriter writes a header row with the column names, then one row per company
+func newCSVWriter(w io.Writer, columns []exportColumn) (companyWriter, error) {
+	writer := &csvWriter{writer: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+	for i, column := range columns {
+		writer.record[i] = column.name
+	}
+	return writer, writer.writer.Write(writer.record)
+}
+
+func (c *csvWriter) Write(company *models.Company) error {
+	for i, column := range c.columns {
+		switch value := column.value(company).(type) {
+		case string:
+			c.record[i] = value
+		case int:
+			c.record[i] = strconv.Itoa(value)
+		case bool:
+			c.record[i] = strconv.FormatBool(value)
+		case time.Time:
+			c.record[i] = value.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return c.writer.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type ndjsonWriter struct {
+	encoder *json.Encoder
+	columns []exportColumn
+}
+
+// newNDJSONWriter writes one JSON object per company and line
+func newNDJSONWriter(w io.Writer, columns []exportColumn) (companyWriter, error) {
+	return &ndjsonWriter{encoder: json.NewEncoder(w), columns: columns}, nil
+}
+
+func (n *ndjsonWriter) Write(company *models.Company) error {
+	object := make(map[string]interface{}, len(n.columns))
+	for _, column := range n.columns {
+		object[column.name] = column.value(company)
+	}
+	return n.encoder.Encode(object)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+// parquetRowGroupSize bounds the rows buffered in memory before a row group is written
+const parquetRowGroupSize = 10000
+
+type parquetWriter struct {
+	writer *parquet.Writer
+	// columns are in the order of the schema leaves, sorted by name
+	columns []exportColumn
+	row     parquet.Row
+}
+
+// newParquetWriter writes the companies in row groups of parquetRowGroupSize rows
+func newParquetWriter(w io.Writer, columns []exportColumn) (companyWriter, error) {
+	group := make(parquet.Group, len(columns))
+	for _, column := range columns {
+		group[column.name] = column.node
+	}
+	sorted := slices.Clone(columns)
+	slices.SortFunc(sorted, func(a, b exportColumn) int { return strings.Compare(a.name, b.name) })
+	schema := parquet.NewSchema("company", group)
+	return &parquetWriter{
+		writer:  parquet.NewWriter(w, schema, parquet.MaxRowsPerRowGroup(parquetRowGroupSize)),
+		columns: sorted,
+		row:     make(parquet.Row, len(sorted)),
+	}, nil
+}
+
+func (p *parquetWriter) Write(company *models.Company) error {
+	for i, column := range p.columns {
+		var value parquet.Value
+		switch v := column.value(company).(type) {
+		case int:
+			value = parquet.Int64Value(int64(v))
+		case time.Time:
+			value = parquet.Int64Value(v.UnixMicro())
+		default:
+			value = parquet.ValueOf(v)
+		}
+		p.row[i] = value.Level(0, 0, i)
+	}
+	_, err := p.writer.WriteRows([]parquet.Row{p.row})
+	return err
+}
+
+func (p *parquetWriter) Close() error {
+	return p.writer.Close()
+}
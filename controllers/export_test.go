@@ -0,0 +1,97 @@
+package controllers_test
+
+import (
+	"bytes"
+	"company-service/controllers"
+	"company-service/models"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newExportApp returns an app holding Acme and three more companies
+func newExportApp(t *testing.T) *controllers.App {
+	app, _ := newImportApp(t)
+	for _, company := range []models.Company{
+		{Name: "Globex", Employees: 120, Registered: true, Type: "Corporations"},
+		{Name: "Initech", Employees: 30, Registered: true, Type: "Corporations"},
+		{Name: "Helpers", Employees: 8, Registered: false, Type: "NonProfit"},
+	} {
+		company.ID = uuid.New()
+		require.NoError(t, app.DB.CreateCompany(context.Background(), &company))
+	}
+	return app
+}
+
+func exportCompanies(app *controllers.App, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/companies/export"+query, nil)
+	rr := httptest.NewRecorder()
+	app.ExportCompanies(rr, req)
+	return rr
+}
+
+func TestExportCompaniesCSV(t *testing.T) {
+	rr := exportCompanies(newExportApp(t), "?type=Corporations&min_employees=50&columns=name,employees,registered")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="companies-`+time.Now().UTC().Format("20060102")+`.csv"`, rr.Header().Get("Content-Disposition"))
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"name", "employees", "registered"}, {"Globex", "120", "true"}}, records)
+}
+
+func TestExportCompaniesNDJSON(t *testing.T) {
+	rr := exportCompanies(newExportApp(t), "?format=ndjson&registered=true")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+
+	var names []string
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	for _, line := range lines {
+		var row map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &row))
+		assert.Len(t, row, 8)
+		names = append(names, row["name"].(string))
+	}
+	assert.ElementsMatch(t, []string{"Acme", "Globex", "Initech"}, names)
+}
+
+func TestExportCompaniesParquet(t *testing.T) {
+	rr := exportCompanies(newExportApp(t), "?format=parquet&columns=name,employees,created_at&max_employees=50")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	type row struct {
+		Name      string    `parquet:"name"`
+		Employees int64     `parquet:"employees"`
+		CreatedAt time.Time `parquet:"created_at,timestamp(microsecond)"`
+	}
+	rows, err := parquet.Read[row](bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	employees := map[string]int64{}
+	for _, r := range rows {
+		employees[r.Name] = r.Employees
+		assert.WithinDuration(t, time.Now(), r.CreatedAt, time.Minute)
+	}
+	assert.Equal(t, map[string]int64{"Acme": 5, "Initech": 30, "Helpers": 8}, employees)
+}
+
+func TestExportCompaniesInvalidRequest(t *testing.T) {
+	app := newExportApp(t)
+	for _, query := range []string{"?format=xml", "?columns=name,website", "?columns=name,name", "?type=Partnership", "?min_employees=ten"} {
+		rr := exportCompanies(app, query)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+		assert.Empty(t, rr.Header().Get("Content-Disposition"), query)
+	}
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"company-service/models"
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// companyExpansion loads a resource related to a company, which ?expand= embeds under its name
+type companyExpansion func(ctx context.Context, app *App, company *models.Company) (interface{}, error)
+
+// companyExpansions are the related resources ?expand= can embed in a company, keyed by name.
+// A company has no related resource yet, new ones are registered here.
+var companyExpansions = map[string]companyExpansion{}
+
+// readOptions are the fields and expand query parameters of a read endpoint. A nil columns
+// keeps the whole company.
+type readOptions struct {
+	columns []exportColumn
+	expand  []string
+}
+
+// projected reports whether the response is built from the options rather than the whole company
+func (o readOptions) projected() bool {
+	return o.columns != nil || len(o.expand) > 0
+}
+
+// names returns the names of the selected columns
+func (o readOptions) names() []string {
+	names := make([]string, len(o.columns))
+	for i, column := range o.columns {
+		names[i] = column.name
+	}
+	return names
+}
+
+// parseReadOptions reads the comma separated fields and expand query parameters
+func parseReadOptions(query url.Values) (readOptions, error) {
+	var options readOptions
+	if value := query.Get("fields"); value != "" {
+		columns, err := parseCompanyColumns("fields", value)
+		if err != nil {
+			return options, err
+		}
+		options.columns = columns
+	}
+	if value := query.Get("expand"); value != "" {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := companyExpansions[name]; !ok {
+				if len(companyExpansions) == 0 {
+					return options, fmt.Errorf("invalid 'expand': unknown relation %q, a company has no relation to expand yet", name)
+				}
+				allowed := slices.Sorted(maps.Keys(companyExpansions))
+				return options, fmt.Errorf("invalid 'expand': unknown relation %q, allowed relations are %s", name, strings.Join(allowed, ", "))
+			}
+			if slices.Contains(options.expand, name) {
+				return options, fmt.Errorf("invalid 'expand': duplicate relation %q", name)
+			}
+			options.expand = append(options.expand, name)
+		}
+	}
+	return options, nil
+}
+
+// projectCompany returns the selected fields of the company, always with its ID, and the
+// expanded relations
+func (app *App) projectCompany(ctx context.Context, company *models.Company, options readOptions) (map[string]interface{}, error) {
+	columns := options.columns
+	if columns == nil {
+		columns = exportColumns
+	}
+	document := make(map[string]interface{}, len(columns)+len(options.expand)+1)
+	document["id"] = company.ID.String()
+	for _, column := range columns {
+		document[column.name] = column.value(company)
+	}
+	for _, name := range options.expand {
+		related, err := companyExpansions[name](ctx, app, company)
+		if err != nil {
+			return nil, err
+		}
+		document[name] = related
+	}
+	return document, nil
+}
@@ -0,0 +1,64 @@
+package controllers_test
+
+import (
+	"company-service/config"
+	"company-service/controllers"
+	"company-service/mocks"
+	"company-service/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getCompany(app *controllers.App, id, query string) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	router.Use(app.ValidationMiddleware())
+	router.HandleFunc("/api/companies/{id}", app.GetCompany).Methods(http.MethodGet)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/companies/"+id+query, nil))
+	return rr
+}
+
+func TestGetCompanyFields(t *testing.T) {
+	id := uuid.New()
+	mockDB := new(mocks.MockDatabase)
+	// Only the selected columns are requested from the database
+	mockDB.On("GetCompanyFields", id.String(), []string{"name", "type"}).Return(&models.Company{
+		ID: id, Name: "Acme", Type: "Cooperative",
+	}, nil)
+	app := controllers.NewApp(mockDB, nil, &config.Config{})
+
+	rr := getCompany(app, id.String(), "?fields=name,%20type")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Empty(t, rr.Header().Get("ETag"))
+	var document map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &document))
+	assert.Equal(t, map[string]interface{}{"id": id.String(), "name": "Acme", "type": "Cooperative"}, document)
+	mockDB.AssertExpectations(t)
+}
+
+func TestGetCompanyFieldsInvalid(t *testing.T) {
+	app, _ := newImportApp(t)
+	id := acmeID(t, app)
+	for _, tt := range []struct {
+		query   string
+		message string
+	}{
+		{"?fields=name,website", `unknown field "website", allowed fields are id, name, description`},
+		{"?fields=name,name", `duplicate field "name"`},
+		{"?expand=owner", `unknown relation "owner"`},
+	} {
+		rr := getCompany(app, id, tt.query)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, tt.query)
+		assert.Contains(t, errorMessage(t, rr), tt.message, tt.query)
+	}
+
+	rr := getCompany(app, uuid.NewString(), "?fields=name")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"company-service/config"
+	"company-service/utils"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// GraphQLRequest is the body of POST /api/graphql
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQL runs a GraphQL query or mutation on the companies. A document that cannot run, or
+// that is deeper or more complex than allowed, gets a 400 with its errors. The errors of the
+// fields that failed come with the data in a 200.
+func (app *App) GraphQL(w http.ResponseWriter, r *http.Request) {
+	var request GraphQLRequest
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		utils.SendErrorResponse(w, decodeErrorStatus(err), fmt.Sprintf("Invalid GraphQL request: %v", err))
+		return
+	}
+
+	document, errs := app.parseGraphQL(request)
+	if errs != nil {
+		utils.SendJSONResponse(w, http.StatusBadRequest, &graphql.Result{Errors: errs})
+		return
+	}
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        app.graphQLSchema,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		// The loaders batch and remember the lookups of this request only
+		Context: withCompanyLoader(r.Context(), app.DB),
+	})
+	utils.SendJSONResponse(w, http.StatusOK, result)
+}
+
+// parseGraphQL parses and validates the document, then checks its depth and complexity
+func (app *App) parseGraphQL(request GraphQLRequest) (*ast.Document, []gqlerrors.FormattedError) {
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
+		Body: []byte(request.Query),
+		Name: "GraphQL request",
+	})})
+	if err != nil {
+		return nil, gqlerrors.FormatErrors(err)
+	}
+	if validation := graphql.ValidateDocument(&app.graphQLSchema, document, nil); !validation.IsValid {
+		return nil, validation.Errors
+	}
+
+	operation := selectedOperation(document, request.OperationName)
+	if operation == nil {
+		// Execute reports the missing operation
+		return document, nil
+	}
+	cost := queryCost{fragments: map[string]*ast.FragmentDefinition{}, variables: request.Variables}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			cost.fragments[fragment.Name.Value] = fragment
+		}
+	}
+	depth, complexity := cost.measure(operation.SelectionSet, 0)
+
+	maxDepth := app.Config.GraphQLMaxDepth
+	if maxDepth <= 0 {
+		maxDepth = config.DefaultGraphQLMaxDepth
+	}
+	maxComplexity := app.Config.GraphQLMaxComplexity
+	if maxComplexity <= 0 {
+		maxComplexity = config.DefaultGraphQLMaxComplexity
+	}
+	switch {
+	case depth > maxDepth:
+		return nil, []gqlerrors.FormattedError{gqlerrors.NewFormattedError(fmt.Sprintf("The query is %d levels deep, the limit is %d", depth, maxDepth))}
+	case complexity > maxComplexity:
+		return nil, []gqlerrors.FormattedError{gqlerrors.NewFormattedError(fmt.Sprintf("The query costs %d, the limit is %d", complexity, maxComplexity))}
+	}
+	return document, nil
+}
+
+// selectedOperation returns the operation of the given name, or the only one of the document
+func selectedOperation(document *ast.Document, name string) *ast.OperationDefinition {
+	var selected *ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if selected != nil {
+				return nil
+			}
+			selected = operation
+		} else if operation.Name != nil && operation.Name.Value == name {
+			return operation
+		}
+	}
+	return selected
+}
+
+// queryCost measures a validated document, whose fragments cannot form cycles
+type queryCost struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// measure returns the depth and the complexity of a selection set found at the given depth.
+// Every field costs 1 plus the cost of its selection, times the page size for a paginated
+// field. The introspection fields are free, they are bounded by the schema.
+func (c queryCost) measure(selectionSet *ast.SelectionSet, depth int) (int, int) {
+	maxDepth, complexity := depth, 0
+	if selectionSet == nil {
+		return maxDepth, complexity
+	}
+	for _, selection := range selectionSet.Selections {
+		var fieldDepth, cost int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(selection.Name.Value, "__") {
+				continue
+			}
+			fieldDepth, cost = c.measure(selection.SelectionSet, depth+1)
+			cost = 1 + c.pageSize(selection)*cost
+		case *ast.InlineFragment:
+			fieldDepth, cost = c.measure(selection.SelectionSet, depth)
+		case *ast.FragmentSpread:
+			if fragment, ok := c.fragments[selection.Name.Value]; ok {
+				fieldDepth, cost = c.measure(fragment.SelectionSet, depth)
+			}
+		}
+		maxDepth = max(maxDepth, fieldDepth)
+		complexity += cost
+	}
+	return maxDepth, complexity
+}
+
+// pageSize is the number of items a paginated field may return, 1 for the other fields
+func (c queryCost) pageSize(field *ast.Field) int {
+	if !paginatedFields[field.Name.Value] {
+		return 1
+	}
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != "first" {
+			continue
+		}
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			var first int
+			if _, err := fmt.Sscan(value.Value, &first); err == nil {
+				return max(first, 1)
+			}
+		case *ast.Variable:
+			// JSON numbers decode as float64
+			if first, ok := c.variables[value.Name.Value].(float64); ok {
+				return max(int(first), 1)
+			}
+		}
+	}
+	return graphQLPageSize
+}
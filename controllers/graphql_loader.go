@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"company-service/database"
+	"company-service/models"
+	"context"
+	"slices"
+	"sync"
+)
+
+type companyLoaderKey struct{}
+
+// companyLoader batches the company lookups of a GraphQL request. The resolvers register the
+// IDs they need and return thunks, graphql-go runs the thunks once every field of the level is
+// resolved, and the first of them reads all the registered IDs with a single GetCompanies.
+type companyLoader struct {
+	db  database.Database
+	ctx context.Context
+
+	mu      sync.Mutex
+	pending []string
+	loaded  map[string]*models.Company
+	err     error
+}
+
+// withCompanyLoader returns a context carrying a new loader, to be used for a single request
+func withCompanyLoader(ctx context.Context, db database.Database) context.Context {
+	loader := &companyLoader{db: db, loaded: make(map[string]*models.Company)}
+	ctx = context.WithValue(ctx, companyLoaderKey{}, loader)
+	loader.ctx = ctx
+	return ctx
+}
+
+// companyLoaderFrom returns the loader of the request
+func companyLoaderFrom(ctx context.Context) *companyLoader {
+	return ctx.Value(companyLoaderKey{}).(*companyLoader)
+}
+
+// load registers the ID of a company and returns the thunk resolving it, to null if the
+// company does not exist
+func (l *companyLoader) load(id string) func() (interface{}, error) {
+	l.mu.Lock()
+	if _, ok := l.loaded[id]; !ok && l.err == nil && !slices.Contains(l.pending, id) {
+		l.pending = append(l.pending, id)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if len(l.pending) > 0 && l.err == nil {
+			l.flush()
+		}
+		if l.err != nil {
+			return nil, graphQLDatabaseError(l.err)
+		}
+		if company := l.loaded[id]; company != nil {
+			return company, nil
+		}
+		// An untyped nil, for graphql-go to resolve the field to null
+		return nil, nil
+	}
+}
+
+// flush reads the pending IDs, remembering those that do not exist
+func (l *companyLoader) flush() {
+	ids := l.pending
+	l.pending = nil
+	companies, err := l.db.GetCompanies(l.ctx, ids)
+	if err != nil {
+		l.err = err
+		return
+	}
+	for _, id := range ids {
+		l.loaded[id] = nil
+	}
+	for i := range companies {
+		l.loaded[companies[i].ID.String()] = &companies[i]
+	}
+}
+
+// prime remembers companies read by other means, so loading them costs no query
+func (l *companyLoader) prime(companies ...*models.Company) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, company := range companies {
+		l.loaded[company.ID.String()] = company
+	}
+}
+
+// forget drops a company that was deleted, so a later load reads it again
+func (l *companyLoader) forget(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.loaded, id)
+}
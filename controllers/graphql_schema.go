@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"company-service/database"
+	"company-service/kafka"
+	"company-service/models"
+	"company-service/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/graphql-go/graphql"
+)
+
+// Page sizes of the companies query
+const (
+	graphQLPageSize    = 100
+	maxGraphQLPageSize = 1000
+)
+
+// paginatedFields are the fields whose first argument multiplies the cost of their selection
+var paginatedFields = map[string]bool{"companies": true}
+
+// graphQLError is an error of a resolver, its code tells the client what went wrong without
+// parsing the message
+type graphQLError struct {
+	message string
+	code    string
+}
+
+func (e *graphQLError) Error() string { return e.message }
+
+// Extensions lets graphql-go send the code in the extensions of the error
+func (e *graphQLError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.code}
+}
+
+// badInput reports an invalid argument
+func badInput(format string, args ...interface{}) error {
+	return &graphQLError{message: fmt.Sprintf(format, args...), code: "BAD_USER_INPUT"}
+}
+
+// graphQLDatabaseError maps an error returned by the database to the code of its HTTP status
+func graphQLDatabaseError(err error) error {
+	switch DatabaseErrorStatus(err) {
+	case http.StatusNotFound:
+		return &graphQLError{message: err.Error(), code: "NOT_FOUND"}
+	case http.StatusGatewayTimeout:
+		return &graphQLError{message: err.Error(), code: "TIMEOUT"}
+	default:
+		return &graphQLError{message: err.Error(), code: "INTERNAL"}
+	}
+}
+
+// graphQLID checks that an id argument is a UUID and returns its canonical form
+func graphQLID(args map[string]interface{}) (string, error) {
+	id, _ := args["id"].(string)
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return "", badInput("invalid 'id': it must be a UUID")
+	}
+	return parsed.String(), nil
+}
+
+// newGraphQLSchema builds the GraphQL schema of the companies. The resolvers go through the same
+// validation, database and events as the HTTP handlers. A company has no relation yet: a
+// relation resolves its companies with the companyLoader of the request, like the company
+// query, so that a list costs a single query per relation.
+func (app *App) newGraphQLSchema() (graphql.Schema, error) {
+	company := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Company",
+		Fields: graphql.Fields{
+			"id":          companyField(graphql.ID, func(c *models.Company) interface{} { return c.ID.String() }),
+			"name":        companyField(graphql.String, func(c *models.Company) interface{} { return c.Name }),
+			"description": companyField(graphql.String, func(c *models.Company) interface{} { return c.Description }),
+			"employees":   companyField(graphql.Int, func(c *models.Company) interface{} { return c.Employees }),
+			"registered":  companyField(graphql.Boolean, func(c *models.Company) interface{} { return c.Registered }),
+			"type":        companyField(graphql.String, func(c *models.Company) interface{} { return c.Type }),
+			"createdAt":   companyField(graphql.DateTime, func(c *models.Company) interface{} { return c.CreatedAt }),
+			"updatedAt":   companyField(graphql.DateTime, func(c *models.Company) interface{} { return c.UpdatedAt }),
+		},
+	})
+	pageInfo := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"endCursor":   &graphql.Field{Type: graphql.String, Description: "The after argument of the next page"},
+			"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+	connection := graphql.NewObject(graphql.ObjectConfig{
+		Name: "CompanyConnection",
+		Fields: graphql.Fields{
+			"nodes":    &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(company)))},
+			"pageInfo": &graphql.Field{Type: graphql.NewNonNull(pageInfo)},
+		},
+	})
+	filter := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "CompanyFilter",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"type":         &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"registered":   &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+			"minEmployees": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"maxEmployees": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+		},
+	})
+	input := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "CompanyInput",
+		Description: "The body of POST /api/companies",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name":        &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"description": &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"employees":   &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.Int)},
+			"registered":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.Boolean)},
+			"type":        &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+	update := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "CompanyUpdate",
+		Description: "The fields to change, like the body of PATCH /api/companies/{id}",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"description": &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"employees":   &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"registered":  &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+			"type":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+		},
+	})
+	id := graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"company": &graphql.Field{
+				Type:        company,
+				Description: "The company with this ID, or null",
+				Args:        id,
+				Resolve:     app.resolveCompany,
+			},
+			"companies": &graphql.Field{
+				Type:        graphql.NewNonNull(connection),
+				Description: "The companies matching the filter ordered by ID, first at a time after the cursor",
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: filter},
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: graphQLPageSize},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: app.resolveCompanies,
+			},
+		},
+	})
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createCompany": &graphql.Field{
+				Type:    graphql.NewNonNull(company),
+				Args:    graphql.FieldConfigArgument{"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(input)}},
+				Resolve: app.resolveCreateCompany,
+			},
+			"updateCompany": &graphql.Field{
+				Type: graphql.NewNonNull(company),
+				Args: graphql.FieldConfigArgument{
+					"id":    id["id"],
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(update)},
+				},
+				Resolve: app.resolveUpdateCompany,
+			},
+			"deleteCompany": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.ID),
+				Description: "Deletes the company and returns its ID",
+				Args:        id,
+				Resolve:     app.resolveDeleteCompany,
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+// companyField is a non-null field of a company
+func companyField(fieldType graphql.Output, value func(c *models.Company) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type: graphql.NewNonNull(fieldType),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return value(p.Source.(*models.Company)), nil
+		},
+	}
+}
+
+// resolveCompany loads the company through the loader, so the company fields of a query are
+// read together
+func (app *App) resolveCompany(p graphql.ResolveParams) (interface{}, error) {
+	id, err := graphQLID(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	return companyLoaderFrom(p.Context).load(id), nil
+}
+
+// resolveCompanies reads a page of companies from a cursor, with one more company to tell
+// whether there is a next page
+func (app *App) resolveCompanies(p graphql.ResolveParams) (interface{}, error) {
+	first, _ := p.Args["first"].(int)
+	if first < 1 || first > maxGraphQLPageSize {
+		return nil, badInput("invalid 'first': it must be between 1 and %d", maxGraphQLPageSize)
+	}
+	filter := database.CompanyFilter{Limit: first + 1}
+	if after, ok := p.Args["after"].(string); ok {
+		parsed, err := uuid.Parse(after)
+		if err != nil {
+			return nil, badInput("invalid 'after': it must be the endCursor of a previous page")
+		}
+		filter.After = parsed.String()
+	}
+	if fields, ok := p.Args["filter"].(map[string]interface{}); ok {
+		if companyType, ok := fields["type"].(string); ok {
+			if !slices.Contains(utils.CompanyTypes, companyType) {
+				return nil, badInput("invalid 'filter.type': it must be one of '%s'", strings.Join(utils.CompanyTypes, "', '"))
+			}
+			filter.Type = companyType
+		}
+		if registered, ok := fields["registered"].(bool); ok {
+			filter.Registered = &registered
+		}
+		if minEmployees, ok := fields["minEmployees"].(int); ok {
+			filter.MinEmployees = &minEmployees
+		}
+		if maxEmployees, ok := fields["maxEmployees"].(int); ok {
+			filter.MaxEmployees = &maxEmployees
+		}
+	}
+
+	var nodes []*models.Company
+	err := app.DB.StreamCompanies(p.Context, filter, func(company *models.Company) error {
+		copied := *company
+		nodes = append(nodes, &copied)
+		return nil
+	})
+	if err != nil {
+		return nil, graphQLDatabaseError(err)
+	}
+	hasNextPage := len(nodes) > first
+	if hasNextPage {
+		nodes = nodes[:first]
+	}
+	var endCursor interface{}
+	if len(nodes) > 0 {
+		endCursor = nodes[len(nodes)-1].ID.String()
+	}
+	companyLoaderFrom(p.Context).prime(nodes...)
+	return map[string]interface{}{
+		"nodes":    nodes,
+		"pageInfo": map[string]interface{}{"endCursor": endCursor, "hasNextPage": hasNextPage},
+	}, nil
+}
+
+// resolveCreateCompany creates the company like POST /api/companies
+func (app *App) resolveCreateCompany(p graphql.ResolveParams) (interface{}, error) {
+	input, _ := p.Args["input"].(map[string]interface{})
+	company := &models.Company{}
+	company.Name, _ = input["name"].(string)
+	company.Description, _ = input["description"].(string)
+	company.Employees, _ = input["employees"].(int)
+	company.Registered, _ = input["registered"].(bool)
+	company.Type, _ = input["type"].(string)
+	if err := utils.ValidateCompanyInput(company); err != nil {
+		return nil, badInput("%s", err.Error())
+	}
+	company.ID = utils.GenerateUUID()
+
+	err := app.DB.CreateCompany(p.Context, company)
+	if errors.Is(err, database.ErrDuplicateName) {
+		return nil, &graphQLError{message: "The company with the same name already exists", code: "CONFLICT"}
+	}
+	if err != nil {
+		return nil, graphQLDatabaseError(err)
+	}
+	app.publishGraphQLEvent(p.Context, "company_created", company)
+	companyLoaderFrom(p.Context).prime(company)
+	return company, nil
+}
+
+// resolveUpdateCompany changes the given fields like PATCH /api/companies/{id}
+func (app *App) resolveUpdateCompany(p graphql.ResolveParams) (interface{}, error) {
+	id, err := graphQLID(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	// The same values as a JSON body, where the numbers are float64
+	fields, _ := p.Args["input"].(map[string]interface{})
+	if len(fields) == 0 {
+		return nil, badInput("invalid 'input': it must change at least one field")
+	}
+	if employees, ok := fields["employees"].(int); ok {
+		fields["employees"] = float64(employees)
+	}
+	if err = utils.ValidateCompanyUpdate(fields); err != nil {
+		return nil, badInput("%s", err.Error())
+	}
+
+	company, err := app.DB.UpdateCompany(p.Context, id, fields)
+	if errors.Is(err, database.ErrDuplicateName) {
+		return nil, &graphQLError{message: "The company with the same name already exists", code: "CONFLICT"}
+	}
+	if err != nil {
+		return nil, graphQLDatabaseError(err)
+	}
+	app.publishGraphQLEvent(p.Context, "company_updated", company)
+	companyLoaderFrom(p.Context).prime(company)
+	return company, nil
+}
+
+// resolveDeleteCompany deletes the company like DELETE /api/companies/{id}
+func (app *App) resolveDeleteCompany(p graphql.ResolveParams) (interface{}, error) {
+	id, err := graphQLID(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	if err = app.DB.DeleteCompany(p.Context, id); err != nil {
+		return nil, graphQLDatabaseError(err)
+	}
+	app.publishGraphQLEvent(p.Context, "company_deleted", &models.Company{ID: uuid.MustParse(id)})
+	companyLoaderFrom(p.Context).forget(id)
+	return id, nil
+}
+
+// publishGraphQLEvent publishes the event of a committed change, a failed publishing is only
+// logged since the mutation succeeded
+func (app *App) publishGraphQLEvent(ctx context.Context, eventType string, company *models.Company) {
+	if err := app.PublishEvent(ctx, kafka.NewEvent(eventType, company)); err != nil {
+		slog.ErrorContext(ctx, "Kafka publish failed", slog.Any("error", err))
+	}
+}
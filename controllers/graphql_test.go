@@ -0,0 +1,198 @@
+package controllers_test
+
+import (
+	"company-service/database"
+	"company-service/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingDatabase counts the lookups of companies by ID
+type countingDatabase struct {
+	database.Database
+	batches, ids int
+}
+
+func (d *countingDatabase) GetCompanies(ctx context.Context, ids []string) ([]models.Company, error) {
+	d.batches++
+	d.ids += len(ids)
+	return d.Database.GetCompanies(ctx, ids)
+}
+
+type graphQLResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func (c *apiClient) graphQL(query string, variables map[string]interface{}) (*httptest.ResponseRecorder, graphQLResponse) {
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	require.NoError(c.t, err)
+	rr := c.send(http.MethodPost, "/api/graphql", "application/json", string(body))
+	var response graphQLResponse
+	if rr.Code == http.StatusOK || rr.Code == http.StatusBadRequest {
+		require.NoError(c.t, json.Unmarshal(rr.Body.Bytes(), &response), rr.Body.String())
+	}
+	return rr, response
+}
+
+func TestGraphQLCompanyBatchesLookups(t *testing.T) {
+	app, _ := newImportApp(t)
+	db := &countingDatabase{Database: app.DB}
+	app.DB = db
+	client := newAPIClient(t, app)
+	acme := acmeID(t, app)
+
+	rr, response := client.graphQL(`query($id: ID!, $missing: ID!) {
+		first: company(id: $id) { name employees }
+		second: company(id: $id) { id type registered }
+		missing: company(id: $missing) { name }
+	}`, map[string]interface{}{"id": acme, "missing": "5a2c6f1e-6a0b-4b8e-9c1d-2f3e4a5b6c7d"})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Empty(t, response.Errors)
+	assert.JSONEq(t, `{"name":"Acme","employees":5}`, string(response.Data["first"]))
+	assert.JSONEq(t, fmt.Sprintf(`{"id":%q,"type":"Cooperative","registered":true}`, acme), string(response.Data["second"]))
+	assert.JSONEq(t, `null`, string(response.Data["missing"]))
+	// The three fields are read together, each ID once
+	assert.Equal(t, 1, db.batches)
+	assert.Equal(t, 2, db.ids)
+
+	rr, response = client.graphQL(`{ company(id: "acme") { name } }`, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, "invalid 'id': it must be a UUID", response.Errors[0].Message)
+	assert.Equal(t, "BAD_USER_INPUT", response.Errors[0].Extensions["code"])
+}
+
+func TestGraphQLCompaniesPagination(t *testing.T) {
+	app, _ := newImportApp(t)
+	client := newAPIClient(t, app)
+	for i, name := range []string{"Globex", "Initech", "Hooli", "Umbrella"} {
+		rr, response := client.graphQL(`mutation($input: CompanyInput!) { createCompany(input: $input) { id } }`,
+			map[string]interface{}{"input": map[string]interface{}{"name": name, "employees": 10 * (i + 1), "registered": true, "type": "Corporations"}})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.Empty(t, response.Errors)
+	}
+
+	query := `query($after: String) {
+		companies(filter: {type: "Corporations", minEmployees: 20}, first: 2, after: $after) {
+			nodes { name }
+			pageInfo { endCursor hasNextPage }
+		}
+	}`
+	var names []string
+	var after interface{}
+	for pages := 1; ; pages++ {
+		rr, response := client.graphQL(query, map[string]interface{}{"after": after})
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.Empty(t, response.Errors)
+		var page struct {
+			Nodes    []struct{ Name string }
+			PageInfo struct {
+				EndCursor   string
+				HasNextPage bool
+			}
+		}
+		require.NoError(t, json.Unmarshal(response.Data["companies"], &page))
+		for _, node := range page.Nodes {
+			names = append(names, node.Name)
+		}
+		if !page.PageInfo.HasNextPage {
+			assert.Equal(t, 2, pages)
+			break
+		}
+		after = page.PageInfo.EndCursor
+	}
+	assert.ElementsMatch(t, []string{"Initech", "Hooli", "Umbrella"}, names)
+
+	_, response := client.graphQL(`{ companies(filter: {type: "Guild"}) { nodes { name } } }`, nil)
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, "BAD_USER_INPUT", response.Errors[0].Extensions["code"])
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	app, producer := newImportApp(t)
+	client := newAPIClient(t, app)
+	acme := acmeID(t, app)
+
+	rr, response := client.graphQL(`mutation($id: ID!) { updateCompany(id: $id, input: {employees: 12}) { name employees } }`,
+		map[string]interface{}{"id": acme})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Empty(t, response.Errors)
+	assert.JSONEq(t, `{"name":"Acme","employees":12}`, string(response.Data["updateCompany"]))
+
+	// The validators and the unique names of the HTTP API apply
+	_, response = client.graphQL(`mutation { createCompany(input: {name: "acme", employees: 1, registered: true, type: "Cooperative"}) { id } }`, nil)
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, "CONFLICT", response.Errors[0].Extensions["code"])
+	_, response = client.graphQL(`mutation { createCompany(input: {name: "Globex", employees: 0, registered: true, type: "Cooperative"}) { id } }`, nil)
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, "invalid 'Employees': it must be a positive number", response.Errors[0].Message)
+
+	rr, response = client.graphQL(`mutation($id: ID!) { deleteCompany(id: $id) }`, map[string]interface{}{"id": acme})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.JSONEq(t, fmt.Sprintf("%q", acme), string(response.Data["deleteCompany"]))
+	_, response = client.graphQL(`mutation($id: ID!) { deleteCompany(id: $id) }`, map[string]interface{}{"id": acme})
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, "NOT_FOUND", response.Errors[0].Extensions["code"])
+
+	var types []string
+	for _, event := range producer.Events() {
+		types = append(types, event.EventType)
+	}
+	assert.Equal(t, []string{"company_updated", "company_deleted"}, types)
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	app, _ := newImportApp(t)
+	app.Config.GraphQLMaxDepth = 3
+	app.Config.GraphQLMaxComplexity = 500
+	client := newAPIClient(t, app)
+
+	rr, response := client.graphQL(`{ companies { pageInfo { hasNextPage } } }`, nil)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Empty(t, response.Errors)
+
+	for _, tt := range []struct {
+		query   string
+		message string
+	}{
+		{`{ companies(first: 300) { nodes { name } } }`, "The query costs 601, the limit is 500"},
+		{`query($first: Int) { companies(first: $first) { nodes { name id } } }`, "The query costs 1501, the limit is 500"},
+		{`fragment page on CompanyConnection { pageInfo { hasNextPage } } { companies { ...page } }`, ""},
+		{`{ unknown }`, `Cannot query field "unknown" on type "Query".`},
+	} {
+		rr, response := client.graphQL(tt.query, map[string]interface{}{"first": 500})
+		if tt.message == "" {
+			assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+			continue
+		}
+		assert.Equal(t, http.StatusBadRequest, rr.Code, tt.query)
+		require.Len(t, response.Errors, 1, rr.Body.String())
+		assert.Equal(t, tt.message, response.Errors[0].Message)
+	}
+
+	app.Config.GraphQLMaxDepth = 2
+	rr, response = client.graphQL(`{ companies { pageInfo { hasNextPage } } }`, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, "The query is 3 levels deep, the limit is 2", response.Errors[0].Message)
+}
+
+func TestGraphQLRequiresAuthentication(t *testing.T) {
+	app, _ := newImportApp(t)
+	client := newAPIClient(t, app)
+	client.cookies = nil
+
+	rr, _ := client.graphQL(`{ companies { nodes { name } } }`, nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
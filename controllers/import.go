@@ -0,0 +1,490 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"company-service/database"
+	"company-service/kafka"
+	"company-service/models"
+	"company-service/utils"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Modes of POST /api/companies/import, selected by the mode query parameter
+const (
+	// ImportSkipDuplicates leaves the existing companies untouched
+	ImportSkipDuplicates = "skip_duplicates"
+	// ImportUpsert updates the existing company with the same name
+	ImportUpsert = "upsert"
+)
+
+// Outcomes of a row of an import
+const (
+	RowCreated = "created"
+	RowUpdated = "updated"
+	RowSkipped = "skipped"
+	RowInvalid = "invalid"
+	RowFailed  = "failed"
+)
+
+// ImportRowResult is the outcome of one row of an import, rows are numbered from 1 without the CSV header
+type ImportRowResult struct {
+	Row     int    `json:"row"`
+	Status  string `json:"status"`
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// ImportSummary counts the rows of an import by outcome
+type ImportSummary struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+	Invalid int `json:"invalid"`
+	Failed  int `json:"failed"`
+}
+
+// ImportReport is the response of an import. In a dry run nothing is written and the
+// statuses are the outcomes the import would have had.
+type ImportReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Mode    string            `json:"mode"`
+	Summary ImportSummary     `json:"summary"`
+	Rows    []ImportRowResult `json:"rows"`
+	// Error is set when the import stopped before the end of the body
+	Error string `json:"error,omitempty"`
+}
+
+// importColumns are the CSV columns accepted by the import, name is required
+var importColumns = map[string]bool{"name": true, "description": true, "employees": true, "registered": true, "type": true}
+
+// ImportCompanies creates the companies of a CSV (text/csv, with a header row) or NDJSON
+// (application/x-ndjson) body and reports the outcome of every row
+func (app *App) ImportCompanies(w http.ResponseWriter, r *http.Request) {
+	options, status, err := parseImportOptions(r.URL.Query().Get("mode"), r.URL.Query().Get("dry_run"), r.Header.Get("Content-Type"))
+	if err != nil {
+		utils.SendErrorResponse(w, status, err.Error())
+		return
+	}
+
+	// Read the whole body first so that a body over the size limit is refused before any write
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		utils.SendErrorResponse(w, decodeErrorStatus(err), fmt.Sprintf("Invalid input data to import companies with error: %v", err))
+		return
+	}
+
+	report, err := app.importCompanies(r.Context(), body, options, nil)
+	status = http.StatusOK
+	if err != nil {
+		status = http.StatusBadRequest
+		if r.Context().Err() != nil {
+			status = DatabaseErrorStatus(err)
+		}
+	}
+	utils.SendJSONResponse(w, status, report)
+}
+
+// importOptions are the parameters of an import, given by the query of the endpoint or the parameters of a job
+type importOptions struct {
+	mode   string
+	dryRun bool
+	read   func(io.Reader, func(int, *models.Company, error) error) error
+}
+
+// parseImportOptions checks the mode, the dry run flag and the media type of an import,
+// and returns the HTTP status of the error
+func parseImportOptions(mode, dryRun, contentType string) (importOptions, int, error) {
+	options := importOptions{mode: mode}
+	if options.mode == "" {
+		options.mode = ImportSkipDuplicates
+	}
+	if options.mode != ImportSkipDuplicates && options.mode != ImportUpsert {
+		return options, http.StatusBadRequest, fmt.Errorf("invalid 'mode': allowed values are '%s' and '%s'", ImportSkipDuplicates, ImportUpsert)
+	}
+	if dryRun != "" {
+		var err error
+		if options.dryRun, err = strconv.ParseBool(dryRun); err != nil {
+			return options, http.StatusBadRequest, errors.New("invalid 'dry_run': it must be true or false")
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		options.read = readCSVCompanies
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		options.read = readNDJSONCompanies
+	default:
+		return options, http.StatusUnsupportedMediaType, errors.New("the body must be text/csv or application/x-ndjson")
+	}
+	return options, 0, nil
+}
+
+// importBatchSize is the number of valid rows of an import written together, in a transaction
+// with multi-row inserts
+const importBatchSize = 500
+
+// importCompanies imports the rows of the body and reports their outcome, calling progress after
+// every row when it is not nil. The error is also set in the report when the import stopped early.
+func (app *App) importCompanies(ctx context.Context, body []byte, options importOptions, progress func(rows int)) (ImportReport, error) {
+	report := ImportReport{DryRun: options.dryRun, Mode: options.mode, Rows: []ImportRowResult{}}
+	normalizer, err := utils.NewNameNormalizer(app.Config.NameNormalization)
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	importer := &companyImporter{app: app, ctx: ctx, mode: options.mode, normalizer: normalizer, seen: make(map[string]bool)}
+	// The valid rows wait in the batch until it is full, their outcome is then known
+	var batch []importRow
+	flush := func() {
+		importer.importBatch(report.Rows, batch)
+		for _, row := range batch {
+			report.Summary.add(report.Rows[row.index].Status)
+		}
+		batch = batch[:0]
+	}
+	err = options.read(bytes.NewReader(body), func(row int, company *models.Company, rowErr error) error {
+		result := ImportRowResult{Row: row, Status: RowInvalid}
+		if company != nil {
+			result.Name = company.Name
+		}
+		if rowErr == nil {
+			rowErr = utils.ValidateCompanyInput(company)
+		}
+		switch {
+		case rowErr != nil:
+			result.Error = rowErr.Error()
+			report.Summary.add(result.Status)
+		case options.dryRun:
+			result = importer.checkCompany(result, company)
+			report.Summary.add(result.Status)
+		default:
+			batch = append(batch, importRow{index: len(report.Rows), company: company})
+		}
+		report.Rows = append(report.Rows, result)
+		if len(batch) == importBatchSize {
+			flush()
+		}
+		if progress != nil {
+			progress(len(report.Rows) - len(batch))
+		}
+		// Stop once the import is cancelled or out of time, every following row would fail too
+		return ctx.Err()
+	})
+	if len(batch) > 0 {
+		flush()
+		if progress != nil {
+			progress(len(report.Rows))
+		}
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	slog.InfoContext(ctx, "Companies imported",
+		slog.Bool("dry_run", options.dryRun), slog.String("mode", options.mode), slog.Any("summary", report.Summary))
+	return report, err
+}
+
+// add counts a row with the given status
+func (s *ImportSummary) add(status string) {
+	switch status {
+	case RowCreated:
+		s.Created++
+	case RowUpdated:
+		s.Updated++
+	case RowSkipped:
+		s.Skipped++
+	case RowInvalid:
+		s.Invalid++
+	case RowFailed:
+		s.Failed++
+	}
+}
+
+// importRow is a valid row of an import waiting to be written, index is its place in the report
+type importRow struct {
+	index   int
+	company *models.Company
+}
+
+// companyImporter writes the valid rows of an import
+type companyImporter struct {
+	app  *App
+	ctx  context.Context
+	mode string
+
+	normalizer utils.NameNormalizer
+	// seen holds the normalized names of the previous rows of a dry run
+	seen map[string]bool
+}
+
+// checkCompany reports the outcome a row of a dry run would have, without writing it
+func (i *companyImporter) checkCompany(result ImportRowResult, company *models.Company) ImportRowResult {
+	// The rows of a dry run are not written, so the duplicates within the body are tracked here
+	key := i.normalizer.Normalize(company.Name)
+	exists := i.seen[key]
+	if !exists {
+		_, err := i.app.DB.GetCompanyByName(i.ctx, company.Name)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			result.Status = RowFailed
+			result.Error = err.Error()
+			return result
+		}
+		exists = err == nil
+	}
+	i.seen[key] = true
+	result.Status = RowCreated
+	if exists && i.mode == ImportUpsert {
+		result.Status = RowUpdated
+	} else if exists {
+		result.Status = RowSkipped
+	}
+	return result
+}
+
+// importBatch writes the rows of the batch in a transaction and sets their result, then
+// publishes their events. When the transaction fails every row of the batch fails.
+func (i *companyImporter) importBatch(rows []ImportRowResult, batch []importRow) {
+	events := make([]*kafka.EventMessage, len(batch))
+	err := i.app.DB.Transaction(i.ctx, func(tx database.Database) error {
+		// The first row of each name is inserted with the others, unless a name is taken. The
+		// rows repeating a name, or the whole batch when a name is taken, are written one by one.
+		var first, rest []importRow
+		names := make(map[string]bool, len(batch))
+		for _, row := range batch {
+			row.company.ID = utils.GenerateUUID()
+			if key := i.normalizer.Normalize(row.company.Name); !names[key] {
+				names[key] = true
+				first = append(first, row)
+			} else {
+				rest = append(rest, row)
+			}
+		}
+		companies := make([]*models.Company, len(first))
+		for k, row := range first {
+			companies[k] = row.company
+		}
+		err := tx.CreateCompanies(i.ctx, companies)
+		switch {
+		case err == nil:
+			for _, row := range first {
+				rows[row.index].Status = RowCreated
+				rows[row.index].ID = row.company.ID.String()
+			}
+		case errors.Is(err, database.ErrDuplicateName):
+			rest = batch
+		default:
+			return err
+		}
+		for _, row := range rest {
+			rows[row.index] = i.importCompany(tx, rows[row.index], row.company)
+		}
+		return nil
+	})
+	if err != nil {
+		for _, row := range batch {
+			rows[row.index].Status = RowFailed
+			rows[row.index].ID = ""
+			rows[row.index].Error = err.Error()
+		}
+		return
+	}
+	for k, row := range batch {
+		switch rows[row.index].Status {
+		case RowCreated:
+			events[k] = kafka.NewEvent("company_created", row.company)
+		case RowUpdated:
+			events[k] = kafka.NewEvent("company_updated", row.company)
+		}
+	}
+	i.publish(rows, batch, events)
+}
+
+// importCompany creates the company, or applies the mode when its name is taken. In upsert mode
+// the company is replaced by the one written.
+func (i *companyImporter) importCompany(tx database.Database, result ImportRowResult, company *models.Company) ImportRowResult {
+	// The uniqueness of the name is enforced by the database, no existence check is needed first
+	err := tx.CreateCompany(i.ctx, company)
+	if errors.Is(err, database.ErrDuplicateName) {
+		if i.mode == ImportSkipDuplicates {
+			result.Status = RowSkipped
+			result.Error = "The company with the same name already exists"
+			return result
+		}
+		return i.updateCompany(tx, result, company)
+	}
+	if err != nil {
+		result.Status = RowFailed
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = RowCreated
+	result.ID = company.ID.String()
+	return result
+}
+
+// updateCompany replaces the fields of the company with the same name
+func (i *companyImporter) updateCompany(tx database.Database, result ImportRowResult, company *models.Company) ImportRowResult {
+	ctx := i.ctx
+	existing, err := tx.GetCompanyByName(ctx, company.Name)
+	if err == nil {
+		existing, err = tx.UpdateCompany(ctx, existing.ID.String(), map[string]interface{}{
+			"name":        company.Name,
+			"description": company.Description,
+			"employees":   company.Employees,
+			"registered":  company.Registered,
+			"type":        company.Type,
+		})
+	}
+	if err != nil {
+		result.Status = RowFailed
+		result.Error = err.Error()
+		return result
+	}
+	*company = *existing
+	result.Status = RowUpdated
+	result.ID = existing.ID.String()
+	return result
+}
+
+// publish produces the events of a batch once it is committed. They are produced concurrently,
+// so the import waits for the slowest delivery report of the batch rather than for each in
+// turn. A failure is reported as a warning of the row.
+func (i *companyImporter) publish(rows []ImportRowResult, batch []importRow, events []*kafka.EventMessage) {
+	var wg sync.WaitGroup
+	for k, event := range events {
+		if event == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(result *ImportRowResult) {
+			defer wg.Done()
+			if err := i.app.PublishEvent(i.ctx, event); err != nil {
+				slog.ErrorContext(i.ctx, "Kafka publish failed", slog.Any("error", err))
+				result.Warning = "Kafka publishing failed"
+			}
+		}(&rows[batch[k].index])
+	}
+	wg.Wait()
+}
+
+// readCSVCompanies reads the companies of a CSV body whose first row names the columns.
+// The rows that cannot be parsed are passed to fn with an error, fn stops the reading by returning one.
+func readCSVCompanies(r io.Reader, fn func(int, *models.Company, error) error) error {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for index, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !importColumns[column] {
+			return fmt.Errorf("unknown CSV column %q", column)
+		}
+		if _, ok := columns[column]; ok {
+			return fmt.Errorf("duplicate CSV column %q", column)
+		}
+		columns[column] = index
+	}
+	if _, ok := columns["name"]; !ok {
+		return errors.New("the CSV header must have a name column")
+	}
+
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var parseError *csv.ParseError
+		if err != nil && !errors.As(err, &parseError) {
+			return err
+		}
+		var company *models.Company
+		if err == nil {
+			company, err = csvCompany(record, columns)
+		}
+		if err = fn(row, company, err); err != nil {
+			return err
+		}
+	}
+}
+
+// csvCompany builds a company from a CSV record, the empty cells keep the zero value
+func csvCompany(record []string, columns map[string]int) (*models.Company, error) {
+	cell := func(column string) string {
+		if index, ok := columns[column]; ok {
+			return strings.TrimSpace(record[index])
+		}
+		return ""
+	}
+	company := &models.Company{
+		Name:        cell("name"),
+		Description: cell("description"),
+		Type:        cell("type"),
+	}
+	var err error
+	if value := cell("employees"); value != "" {
+		if company.Employees, err = strconv.Atoi(value); err != nil {
+			return company, fmt.Errorf("invalid 'Employees': %q is not an integer", value)
+		}
+	}
+	if value := cell("registered"); value != "" {
+		if company.Registered, err = strconv.ParseBool(value); err != nil {
+			return company, fmt.Errorf("invalid 'Registered': %q is not a boolean", value)
+		}
+	}
+	return company, nil
+}
+
+// readNDJSONCompanies reads one JSON company per line, the blank lines are ignored.
+// The lines that cannot be decoded are passed to fn with an error, fn stops the reading by returning one.
+func readNDJSONCompanies(r io.Reader, fn func(int, *models.Company, error) error) error {
+	reader := bufio.NewReader(r)
+	row := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			row++
+			var company *models.Company
+			decoder := json.NewDecoder(bytes.NewReader(line))
+			decoder.DisallowUnknownFields()
+			decodeErr := decoder.Decode(&company)
+			if decodeErr == nil && company == nil {
+				decodeErr = errors.New("the row must be a JSON object")
+			}
+			if decodeErr != nil {
+				decodeErr = fmt.Errorf("invalid JSON: %w", decodeErr)
+			}
+			if fnErr := fn(row, company, decodeErr); fnErr != nil {
+				return fnErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
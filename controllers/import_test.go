@@ -0,0 +1,179 @@
+package controllers_test
+
+import (
+	"company-service/config"
+	"company-service/controllers"
+	"company-service/database"
+	"company-service/kafka"
+	"company-service/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newImportApp returns an app backed by the in-memory database, holding one company named Acme
+func newImportApp(t *testing.T) (*controllers.App, *kafka.MemoryProducer) {
+	conf := &config.Config{User: "admin", Password: "admin-password", NameNormalization: "nfkc,casefold,whitespace", ValidateResponses: true}
+	db, err := database.NewMemoryDatabase(conf)
+	require.NoError(t, err)
+	require.NoError(t, db.CreateCompany(context.Background(), &models.Company{
+		ID: uuid.New(), Name: "Acme", Employees: 5, Registered: true, Type: "Cooperative",
+	}))
+	producer := kafka.NewMemoryProducer()
+	return controllers.NewApp(db, producer, conf), producer
+}
+
+func importCompanies(t *testing.T, app *controllers.App, query, contentType, body string) (int, controllers.ImportReport) {
+	req := httptest.NewRequest(http.MethodPost, "/api/companies/import"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	app.ImportCompanies(rr, req)
+
+	var report controllers.ImportReport
+	if rr.Code != http.StatusUnsupportedMediaType {
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	}
+	return rr.Code, report
+}
+
+func statuses(report controllers.ImportReport) []string {
+	var result []string
+	for _, row := range report.Rows {
+		result = append(result, row.Status)
+	}
+	return result
+}
+
+func TestImportCompaniesCSV(t *testing.T) {
+	app, producer := newImportApp(t)
+	body := "name,employees,registered,type\n" +
+		"Globex,10,true,Corporations\n" +
+		"ACME,3,true,NonProfit\n" +
+		"Initech,many,true,Corporations\n" +
+		"globex,1,true,Corporations\n" +
+		",1,true,Corporations\n"
+
+	code, report := importCompanies(t, app, "", "text/csv; charset=utf-8", body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, controllers.ImportSkipDuplicates, report.Mode)
+	assert.Equal(t, []string{"created", "skipped", "invalid", "skipped", "invalid"}, statuses(report))
+	assert.Equal(t, controllers.ImportSummary{Created: 1, Skipped: 2, Invalid: 2}, report.Summary)
+	assert.Contains(t, report.Rows[2].Error, "Employees")
+
+	// One event per created company
+	events := producer.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, "company_created", events[0].EventType)
+	assert.Equal(t, report.Rows[0].ID, events[0].Company.ID.String())
+}
+
+func TestImportCompaniesNDJSONUpsert(t *testing.T) {
+	app, producer := newImportApp(t)
+	body := `{"name":"acme","employees":42,"registered":true,"type":"NonProfit"}` + "\n\n" +
+		`{"name":"Hooli","employees":7,"registered":true,"type":"Corporations"}` + "\n" +
+		`{"name":"Bad","unknown":true}` + "\n"
+
+	code, report := importCompanies(t, app, "?mode=upsert", "application/x-ndjson", body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"updated", "created", "invalid"}, statuses(report))
+	assert.Equal(t, 3, report.Rows[2].Row)
+
+	updated, err := app.DB.GetCompanyByName(context.Background(), "Acme")
+	require.NoError(t, err)
+	assert.Equal(t, 42, updated.Employees)
+	assert.Equal(t, "NonProfit", updated.Type)
+	assert.Equal(t, updated.ID.String(), report.Rows[0].ID)
+
+	// The events of a batch are produced concurrently, in no particular order
+	var types []string
+	for _, event := range producer.Events() {
+		types = append(types, event.EventType)
+	}
+	assert.ElementsMatch(t, []string{"company_updated", "company_created"}, types)
+}
+
+func TestImportCompaniesBatches(t *testing.T) {
+	app, producer := newImportApp(t)
+	var body strings.Builder
+	body.WriteString("name,employees,registered,type\n")
+	for i := range 1200 {
+		fmt.Fprintf(&body, "Company %d,%d,true,Corporations\n", i, i+1)
+	}
+
+	code, report := importCompanies(t, app, "", "text/csv", body.String())
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, controllers.ImportSummary{Created: 1200}, report.Summary)
+	assert.Equal(t, 1200, report.Rows[1199].Row)
+	assert.Len(t, producer.Events(), 1200)
+	company, err := app.DB.GetCompany(context.Background(), report.Rows[1199].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Company 1199", company.Name)
+
+	// The names are taken, so the rows are written one by one
+	code, report = importCompanies(t, app, "?mode=upsert", "text/csv", strings.ReplaceAll(body.String(), ",Corporations", ",NonProfit"))
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, controllers.ImportSummary{Updated: 1200}, report.Summary)
+	company, err = app.DB.GetCompany(context.Background(), report.Rows[1199].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "NonProfit", company.Type)
+}
+
+// failingNameDatabase fails the lookups by name
+type failingNameDatabase struct {
+	database.Database
+}
+
+func (d *failingNameDatabase) GetCompanyByName(ctx context.Context, name string) (*models.Company, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestImportCompaniesDryRun(t *testing.T) {
+	app, producer := newImportApp(t)
+	body := "name,employees,registered,type\nGlobex,1,true,Corporations\nAcme,1,true,Cooperative\nGLOBEX,1,true,Corporations\n"
+
+	code, report := importCompanies(t, app, "?dry_run=true", "text/csv", body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []string{"created", "skipped", "skipped"}, statuses(report))
+
+	_, report = importCompanies(t, app, "?dry_run=true&mode=upsert", "text/csv", body)
+	assert.Equal(t, []string{"created", "updated", "updated"}, statuses(report))
+
+	// Nothing is written and no event is produced
+	assert.False(t, app.DB.CheckIfExistsByName(context.Background(), "Globex"))
+	assert.Empty(t, producer.Events())
+}
+
+func TestImportCompaniesDryRunDatabaseError(t *testing.T) {
+	app, _ := newImportApp(t)
+	app.DB = &failingNameDatabase{Database: app.DB}
+
+	// A row whose name cannot be looked up is not reported as new
+	code, report := importCompanies(t, app, "?dry_run=true", "text/csv", "name,employees,registered,type\nGlobex,1,true,Corporations\n")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"failed"}, statuses(report))
+	assert.Equal(t, "connection refused", report.Rows[0].Error)
+}
+
+func TestImportCompaniesInvalidRequest(t *testing.T) {
+	app, _ := newImportApp(t)
+
+	code, report := importCompanies(t, app, "", "text/csv", "name,website\nGlobex,globex.com\n")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, report.Error, "website")
+
+	code, _ = importCompanies(t, app, "?mode=replace", "text/csv", "name\nGlobex\n")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = importCompanies(t, app, "", "application/json", `[{"name":"Globex"}]`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, code)
+}
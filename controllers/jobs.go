@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"company-service/database"
+	"company-service/jobs"
+	"company-service/middleware"
+	"company-service/models"
+	"company-service/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Job types run by App.Jobs
+const (
+	// JobImportCompanies runs an import, its result is the ImportReport
+	JobImportCompanies = "companies.import"
+	// JobExportCompanies writes an export to a file downloaded from /api/jobs/{id}/result
+	JobExportCompanies = "companies.export"
+	// JobReindexCompanies derives the normalized names again, e.g. after a change of NAME_NORMALIZATION
+	JobReindexCompanies = "companies.reindex"
+)
+
+// maxReindexConflicts bounds the conflicts listed in the result of a reindex job
+const maxReindexConflicts = 100
+
+// importJobParams are the parameters of a companies.import job, the rows are given inline in data
+type importJobParams struct {
+	ContentType string `json:"content_type"`
+	Mode        string `json:"mode"`
+	DryRun      bool   `json:"dry_run"`
+	Data        string `json:"data"`
+}
+
+// exportJobResult is the result of a companies.export job
+type exportJobResult struct {
+	Format string `json:"format"`
+	Rows   int    `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// reindexJobResult is the result of a companies.reindex job
+type reindexJobResult struct {
+	Companies int `json:"companies"`
+	Updated   int `json:"updated"`
+	// Conflicts lists the companies whose new normalized name clashes with another company,
+	// they keep their previous one
+	Conflicts []reindexConflict `json:"conflicts"`
+	// MoreConflicts counts the conflicts left out of the list
+	MoreConflicts int `json:"more_conflicts,omitempty"`
+}
+
+type reindexConflict struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// registerJobs adds the job types of the app to its runner
+func (app *App) registerJobs() {
+	app.Jobs.Register(JobImportCompanies, jobs.Type{
+		Validate: func(params json.RawMessage) error {
+			_, _, err := app.importJobOptions(params)
+			return err
+		},
+		Run: app.runImportJob,
+	})
+	app.Jobs.Register(JobExportCompanies, jobs.Type{
+		Validate: func(params json.RawMessage) error {
+			_, err := exportJobOptions(params)
+			return err
+		},
+		Run: app.runExportJob,
+	})
+	app.Jobs.Register(JobReindexCompanies, jobs.Type{
+		Validate: func(params json.RawMessage) error {
+			if len(params) == 0 || string(params) == "null" {
+				return nil
+			}
+			return decodeStrict(params, &struct{}{})
+		},
+		Run: app.runReindexJob,
+	})
+}
+
+func (app *App) importJobOptions(params json.RawMessage) (importOptions, []byte, error) {
+	var p importJobParams
+	if err := decodeStrict(params, &p); err != nil {
+		return importOptions{}, nil, err
+	}
+	options, _, err := parseImportOptions(p.Mode, strconv.FormatBool(p.DryRun), p.ContentType)
+	if err != nil {
+		return options, nil, err
+	}
+	if p.Data == "" {
+		return options, nil, errors.New("invalid 'data': it is required")
+	}
+	return options, []byte(p.Data), nil
+}
+
+func (app *App) runImportJob(ctx context.Context, job *models.Job, progress jobs.Progress) (interface{}, error) {
+	options, data, err := app.importJobOptions(json.RawMessage(job.Params))
+	if err != nil {
+		return nil, err
+	}
+	report, err := app.importCompanies(ctx, data, options, func(rows int) { progress(rows, 0) })
+	return report, err
+}
+
+// exportJobOptions reads the parameters of a companies.export job, the query parameters of
+// GET /api/companies/export given as a JSON object of strings
+func exportJobOptions(params json.RawMessage) (exportOptions, error) {
+	var p map[string]string
+	if err := decodeStrict(params, &p); err != nil {
+		return exportOptions{}, err
+	}
+	query := url.Values{}
+	for key, value := range p {
+		query.Set(key, value)
+	}
+	return parseExportOptions(query)
+}
+
+func (app *App) runExportJob(ctx context.Context, job *models.Job, progress jobs.Progress) (interface{}, error) {
+	options, err := exportJobOptions(json.RawMessage(job.Params))
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(app.Config.JobsDir, 0o750); err != nil {
+		return nil, err
+	}
+	// The export is written to a scratch file, which keeps the database cursor of the export
+	// apart from the transaction storing the file for every instance to serve it
+	file, err := os.CreateTemp(app.Config.JobsDir, job.ID.String()+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	rows, err := app.exportCompanies(ctx, file, options, func(rows int) { progress(rows, 0) })
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	size, err := app.DB.WriteJobOutput(ctx, job.ID.String(), file)
+	if err != nil {
+		return nil, err
+	}
+	return exportJobResult{Format: options.format, Rows: rows, Bytes: size}, nil
+}
+
+// runReindexJob derives the normalized name of every company again with the current policy.
+// A company whose new normalized name clashes with another one is reported and left unchanged,
+// the names have to be fixed by hand before running the job again.
+func (app *App) runReindexJob(ctx context.Context, job *models.Job, progress jobs.Progress) (interface{}, error) {
+	// The IDs are read first, the cursor must not be open while the companies are updated
+	var ids []string
+	err := app.DB.StreamCompanies(ctx, database.CompanyFilter{}, func(company *models.Company) error {
+		ids = append(ids, company.ID.String())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := reindexJobResult{Companies: len(ids), Conflicts: []reindexConflict{}}
+	for i, id := range ids {
+		changed, err := app.DB.ReindexCompanyName(ctx, id)
+		switch {
+		case errors.Is(err, database.ErrDuplicateName):
+			if len(result.Conflicts) == maxReindexConflicts {
+				result.MoreConflicts++
+				break
+			}
+			conflict := reindexConflict{ID: id}
+			if company, err := app.DB.GetCompany(ctx, id); err == nil {
+				conflict.Name = company.Name
+			}
+			result.Conflicts = append(result.Conflicts, conflict)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// Deleted since the IDs were read
+		case err != nil:
+			return result, err
+		case changed:
+			result.Updated++
+		}
+		progress(i+1, len(ids))
+	}
+	return result, nil
+}
+
+// CreateJob queues a job, the body names its type and gives its parameters
+func (app *App) CreateJob(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Type   string          `json:"type"`
+		Params json.RawMessage `json:"params"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		utils.SendErrorResponse(w, decodeErrorStatus(err), fmt.Sprintf("Invalid input data to create a job with error: %v", err))
+		return
+	}
+
+	job, err := app.Jobs.Submit(r.Context(), request.Type, request.Params, middleware.Username(r.Context()))
+	switch {
+	case errors.Is(err, jobs.ErrUnknownType), errors.Is(err, jobs.ErrInvalidParams):
+		utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, jobs.ErrQueueFull):
+		utils.SendErrorResponse(w, http.StatusServiceUnavailable, jobs.ErrQueueFull.Error())
+		return
+	case err != nil:
+		utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Location", "/api/jobs/"+job.ID.String())
+	utils.SendJSONResponse(w, http.StatusAccepted, job)
+}
+
+// GetJob returns the status, progress and result of a job
+func (app *App) GetJob(w http.ResponseWriter, r *http.Request) {
+	// A UUID, checked by ValidationMiddleware
+	id := mux.Vars(r)["id"]
+	job, err := app.Jobs.Get(r.Context(), id)
+	if err != nil {
+		utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, job)
+}
+
+// CancelJob cancels a queued or running job. A running job is reported with 202 Accepted
+// until its worker stops it.
+func (app *App) CancelJob(w http.ResponseWriter, r *http.Request) {
+	// A UUID, checked by ValidationMiddleware
+	id := mux.Vars(r)["id"]
+	job, err := app.Jobs.Cancel(r.Context(), id)
+	if errors.Is(err, database.ErrJobStatus) {
+		utils.SendErrorResponse(w, http.StatusConflict, fmt.Sprintf("The job is %s and cannot be cancelled", job.Status))
+		return
+	}
+	if err != nil {
+		utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
+		return
+	}
+	status := http.StatusOK
+	if job.Status == models.JobRunning {
+		status = http.StatusAccepted
+	}
+	utils.SendJSONResponse(w, status, job)
+}
+
+// GetJobResult downloads the file written by a succeeded export job
+func (app *App) GetJobResult(w http.ResponseWriter, r *http.Request) {
+	// A UUID, checked by ValidationMiddleware
+	id := mux.Vars(r)["id"]
+	job, err := app.Jobs.Get(r.Context(), id)
+	if err != nil {
+		utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
+		return
+	}
+	if job.Type != JobExportCompanies || job.Status != models.JobSucceeded {
+		utils.SendErrorResponse(w, http.StatusConflict, "Only a succeeded export job has a file to download")
+		return
+	}
+	var result exportJobResult
+	if err = json.Unmarshal([]byte(job.Result), &result); err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", exportFormats[result.Format].contentType)
+	w.Header().Set("Content-Disposition", exportDisposition(result.Format, *job.FinishedAt))
+	w.Header().Set("Content-Length", strconv.FormatInt(result.Bytes, 10))
+	w.Header().Set("Last-Modified", job.FinishedAt.UTC().Format(http.TimeFormat))
+
+	// The file is stored in the database, so any instance serves it
+	sent := &sentWriter{Writer: w}
+	if _, err = app.DB.ReadJobOutput(r.Context(), id, sent); err != nil {
+		if !sent.sent {
+			w.Header().Del("Content-Disposition")
+			w.Header().Del("Content-Length")
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				utils.SendErrorResponse(w, http.StatusGone, "The file of the export is no longer available")
+				return
+			}
+			utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
+			return
+		}
+		// The status is already sent, abort the connection so the client does not keep a truncated file
+		slog.ErrorContext(r.Context(), "Job result download failed", slog.String("id", id), slog.Any("error", err))
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,134 @@
+package controllers_test
+
+import (
+	"company-service/controllers"
+	"company-service/kafka"
+	"company-service/models"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jobsRouter serves the job endpoints without authentication, with the workers of the app started
+func jobsRouter(t *testing.T, app *controllers.App) *mux.Router {
+	app.Config.JobsDir = t.TempDir()
+	require.NoError(t, app.Jobs.Start(context.Background()))
+	t.Cleanup(func() { _ = app.Jobs.Shutdown(context.Background()) })
+
+	router := mux.NewRouter()
+	router.Use(app.ValidationMiddleware())
+	router.HandleFunc("/api/jobs", app.CreateJob).Methods(http.MethodPost)
+	router.HandleFunc("/api/jobs/{id}", app.GetJob).Methods(http.MethodGet)
+	router.HandleFunc("/api/jobs/{id}/cancel", app.CancelJob).Methods(http.MethodPost)
+	router.HandleFunc("/api/jobs/{id}/result", app.GetJobResult).Methods(http.MethodGet)
+	return router
+}
+
+func serve(router *mux.Router, method, target, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rr
+}
+
+// submitJob creates a job and waits until it is finished
+func submitJob(t *testing.T, router *mux.Router, body string) models.Job {
+	rr := serve(router, http.MethodPost, "/api/jobs", body)
+	require.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+	location := rr.Header().Get("Location")
+	require.NotEmpty(t, location)
+
+	var job models.Job
+	require.Eventually(t, func() bool {
+		rr := serve(router, http.MethodGet, location, "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &job))
+		return job.Finished()
+	}, 5*time.Second, 10*time.Millisecond)
+	return job
+}
+
+func TestImportJob(t *testing.T) {
+	app, _ := newImportApp(t)
+	router := jobsRouter(t, app)
+
+	data, err := json.Marshal("name,employees,registered,type\nGlobex,10,true,Corporations\nAcme,3,true,NonProfit\n")
+	require.NoError(t, err)
+	job := submitJob(t, router, `{"type":"companies.import","params":{"content_type":"text/csv","data":`+string(data)+`}}`)
+	assert.Equal(t, models.JobSucceeded, job.Status)
+	assert.Equal(t, 2, job.Progress)
+
+	var report controllers.ImportReport
+	require.NoError(t, json.Unmarshal([]byte(job.Result), &report))
+	assert.Equal(t, controllers.ImportSummary{Created: 1, Skipped: 1}, report.Summary)
+	assert.True(t, app.DB.CheckIfExistsByName(context.Background(), "Globex"))
+
+	// Only export jobs have a file to download
+	rr := serve(router, http.MethodGet, "/api/jobs/"+job.ID.String()+"/result", "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	rr = serve(router, http.MethodPost, "/api/jobs/"+job.ID.String()+"/cancel", "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestExportJob(t *testing.T) {
+	app := newExportApp(t)
+	router := jobsRouter(t, app)
+
+	job := submitJob(t, router, `{"type":"companies.export","params":{"type":"Corporations","columns":"name,employees"}}`)
+	require.Equal(t, models.JobSucceeded, job.Status, job.Error)
+	assert.JSONEq(t, `{"format":"csv","rows":2,"bytes":37}`, string(job.Result))
+
+	rr := serve(router, http.MethodGet, "/api/jobs/"+job.ID.String()+"/result", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Header().Get("Content-Disposition"), ".csv")
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, [][]string{{"name", "employees"}, {"Globex", "120"}, {"Initech", "30"}}, records)
+
+	// Another instance sharing the database serves the file too
+	other := jobsRouter(t, controllers.NewApp(app.DB, kafka.NewMemoryProducer(), app.Config))
+	rr = serve(other, http.MethodGet, "/api/jobs/"+job.ID.String()+"/result", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "37", rr.Header().Get("Content-Length"))
+	assert.Equal(t, 37, rr.Body.Len())
+}
+
+func TestReindexJob(t *testing.T) {
+	router := jobsRouter(t, newExportApp(t))
+
+	job := submitJob(t, router, `{"type":"companies.reindex","params":{}}`)
+	require.Equal(t, models.JobSucceeded, job.Status, job.Error)
+	// The policy did not change since the companies were created
+	assert.JSONEq(t, `{"companies":4,"updated":0,"conflicts":[]}`, string(job.Result))
+	assert.Equal(t, 4, job.Progress)
+
+	rr := serve(router, http.MethodPost, "/api/jobs", `{"type":"companies.reindex","params":{"all":true}}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestCreateJobInvalidRequest(t *testing.T) {
+	app, _ := newImportApp(t)
+	router := jobsRouter(t, app)
+	for _, body := range []string{
+		`{"type":"companies.delete"}`,
+		`{"type":"companies.import","params":{"content_type":"text/csv"}}`,
+		`{"type":"companies.import","params":{"content_type":"application/xml","data":"x"}}`,
+		`{"type":"companies.export","params":{"format":"xml"}}`,
+		`{"type":"companies.export","params":{"format":1}}`,
+		`{"type":"companies.export","unknown":true}`,
+	} {
+		rr := serve(router, http.MethodPost, "/api/jobs", body)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+	rr := serve(router, http.MethodGet, "/api/jobs/"+"8b9e0d4c-3f6a-4c1e-9d7a-2b5f0e1c3a4d", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,509 @@
+package controllers
+
+import (
+	"company-service/models"
+	"company-service/utils"
+	"embed"
+	"encoding"
+	"encoding/json"
+	"html/template"
+	"maps"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OpenAPIVersion is the version of the OpenAPI specification the document follows
+const OpenAPIVersion = "3.1.0"
+
+// apiVersion is the version of the API in the document
+const apiVersion = "1.0.0"
+
+// docsPolicy replaces the Content-Security-Policy of the API on the docs page, which needs its stylesheet
+const docsPolicy = "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'"
+
+//go:embed docs.html
+var docsFS embed.FS
+
+var docsTemplate = template.Must(template.New("docs.html").Funcs(template.FuncMap{"lower": strings.ToLower}).ParseFS(docsFS, "docs.html"))
+
+// schema is a JSON Schema, as used by OpenAPI 3.1
+type schema map[string]interface{}
+
+// operation documents a route
+type operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []parameter           `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	Responses   map[int]response      `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type parameter struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Schema      schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type mediaType struct {
+	Schema schema `json:"schema"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Headers     map[string]header    `json:"headers,omitempty"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type header struct {
+	Description string `json:"description,omitempty"`
+	Schema      schema `json:"schema"`
+}
+
+// openAPIDocument is the OpenAPI document of the API, served at /api/openapi.json
+type openAPIDocument struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       openAPIInfo                      `json:"info"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components openAPIComponents                `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]schema `json:"schemas"`
+	SecuritySchemes map[string]schema `json:"securitySchemes"`
+}
+
+// OpenAPI sends the OpenAPI document generated from the routes of the app
+func (app *App) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	utils.SendJSONResponse(w, http.StatusOK, app.openAPIDocument())
+}
+
+// docsOperation is an operation listed by the docs page
+type docsOperation struct {
+	Method string
+	Path   string
+	*operation
+	// Body holds the schemas of the request body indented as JSON, by media type
+	Body      string
+	Responses []docsResponse
+}
+
+type docsResponse struct {
+	Status      int
+	Description string
+	Schema      string
+}
+
+// docsSchema is a component schema listed by the docs page
+type docsSchema struct {
+	Name   string
+	Schema string
+}
+
+// Docs renders the OpenAPI document as an HTML page. It is rendered on the server so the page
+// needs neither scripts nor third party assets.
+func (app *App) Docs(w http.ResponseWriter, r *http.Request) {
+	document := app.openAPIDocument()
+	var operations []docsOperation
+	for _, route := range app.routes() {
+		op := document.Paths["/api"+route.path][strings.ToLower(route.method)]
+		entry := docsOperation{Method: route.method, Path: "/api" + route.path, operation: op}
+		if op.RequestBody != nil {
+			entry.Body = indentSchemas(op.RequestBody.Content)
+		}
+		for _, status := range slices.Sorted(maps.Keys(op.Responses)) {
+			entry.Responses = append(entry.Responses, docsResponse{
+				Status:      status,
+				Description: op.Responses[status].Description,
+				Schema:      indentSchemas(op.Responses[status].Content),
+			})
+		}
+		operations = append(operations, entry)
+	}
+	var components []docsSchema
+	for _, name := range slices.Sorted(maps.Keys(document.Components.Schemas)) {
+		encoded, _ := json.MarshalIndent(document.Components.Schemas[name], "", "  ")
+		components = append(components, docsSchema{Name: name, Schema: string(encoded)})
+	}
+	w.Header().Set("Content-Security-Policy", docsPolicy)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := docsTemplate.Execute(w, map[string]interface{}{
+		"Info":       document.Info,
+		"Version":    document.OpenAPI,
+		"Operations": operations,
+		"Schemas":    components,
+	})
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// indentSchemas formats the schema of each media type as indented JSON
+func indentSchemas(content map[string]mediaType) string {
+	var parts []string
+	for _, name := range slices.Sorted(maps.Keys(content)) {
+		encoded, _ := json.MarshalIndent(content[name].Schema, "", "  ")
+		parts = append(parts, name+": "+string(encoded))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// pathParam matches the variables of a route template
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// openAPIDocument builds the document from the route table. The path parameters, the security
+// requirements and the responses of the middlewares are derived from the routes.
+func (app *App) openAPIDocument() *openAPIDocument {
+	schemas := newSchemaRegistry()
+	document := &openAPIDocument{
+		OpenAPI: OpenAPIVersion,
+		Info: openAPIInfo{
+			Title:       "Company Service API",
+			Description: "Create, read, update and delete companies, in bulk or in the background. Write operations publish events to Kafka.",
+			Version:     apiVersion,
+		},
+		Paths: make(map[string]map[string]*operation),
+		Components: openAPIComponents{
+			SecuritySchemes: map[string]schema{
+				"cookieAuth": {"type": "apiKey", "in": "cookie", "name": "auth_token", "description": "JWT set by POST /api/login"},
+				"csrfToken":  {"type": "apiKey", "in": "header", "name": "X-CSRF-Token", "description": "CSRF token sent by POST /api/login, required on the unsafe methods"},
+			},
+		},
+	}
+	for _, route := range app.routes() {
+		op := route.doc
+		op.Responses = make(map[int]response, len(route.doc.Responses)+4)
+		for status, resp := range route.doc.Responses {
+			op.Responses[status] = resp
+		}
+		var params []parameter
+		for _, match := range pathParam.FindAllStringSubmatch(route.path, -1) {
+			params = append(params, parameter{Name: match[1], In: "path", Required: true, Schema: schema{"type": "string", "format": "uuid"}})
+		}
+		op.Parameters = append(params, op.Parameters...)
+		if route.auth {
+			requirement := map[string][]string{"cookieAuth": {}}
+			if route.method != http.MethodGet {
+				requirement["csrfToken"] = []string{}
+				op.Responses[http.StatusForbidden] = errorResponse("Missing or invalid CSRF token")
+			}
+			op.Security = []map[string][]string{requirement}
+			op.Responses[http.StatusUnauthorized] = errorResponse("Missing or invalid auth_token cookie")
+		}
+		// The responses of ValidationMiddleware, the rate limit and the time budget of the route
+		if len(op.Parameters) > 0 || op.RequestBody != nil {
+			addResponse(op.Responses, http.StatusBadRequest, errorResponse("Invalid parameter or body"))
+		}
+		if op.RequestBody != nil {
+			addResponse(op.Responses, http.StatusRequestEntityTooLarge, errorResponse("Body too large"))
+			addResponse(op.Responses, http.StatusUnsupportedMediaType, errorResponse("Unsupported Content-Type"))
+		}
+		op.Responses[http.StatusTooManyRequests] = errorResponse("Rate limit exceeded, see the Retry-After header")
+		addResponse(op.Responses, http.StatusInternalServerError, errorResponse("Unexpected error"))
+		addResponse(op.Responses, http.StatusGatewayTimeout, errorResponse("The time budget of the route ran out"))
+		if document.Paths["/api"+route.path] == nil {
+			document.Paths["/api"+route.path] = make(map[string]*operation)
+		}
+		document.Paths["/api"+route.path][strings.ToLower(route.method)] = &op
+	}
+	document.Components.Schemas = schemas.build()
+	return document
+}
+
+// addResponse documents a response unless the route documents its own
+func addResponse(responses map[int]response, status int, resp response) {
+	if _, ok := responses[status]; !ok {
+		responses[status] = resp
+	}
+}
+
+// newSchemaRegistry returns the component schemas: the ones reflected from the models and the
+// types of the controllers, and the ones of the request bodies built from the validation rules
+func newSchemaRegistry() *schemaRegistry {
+	registry := &schemaRegistry{schemas: make(map[string]schema)}
+	for _, value := range []interface{}{models.Company{}, models.Job{}, ImportReport{}, BatchRequest{}, BatchReport{}} {
+		registry.reflect(reflect.TypeOf(value))
+	}
+	return registry
+}
+
+// schemaRegistry collects the schemas of the components
+type schemaRegistry struct {
+	schemas map[string]schema
+}
+
+func (s *schemaRegistry) build() map[string]schema {
+	constrainCompany(s.schemas["Company"])
+	s.schemas["CompanyInput"] = companyInputSchema()
+	s.schemas["CompanyUpdate"] = companyUpdateSchema(false)
+	s.schemas["CompanyMergePatch"] = companyUpdateSchema(true)
+	s.schemas["CompanyFields"] = companyFieldsSchema(s.schemas["Company"])
+	s.schemas["CompanyResponse"] = schema{
+		"type":     "object",
+		"required": []string{"message", "company"},
+		"properties": schema{
+			"message": schema{"type": "string", "description": "Mentions a failed Kafka publishing, the change is committed anyway"},
+			"company": ref("Company"),
+		},
+	}
+	s.schemas["JSONPatch"] = schema{
+		"type": "array",
+		"items": schema{
+			"type":     "object",
+			"required": []string{"op", "path"},
+			"properties": schema{
+				"op":    schema{"enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+				"path":  schema{"type": "string"},
+				"from":  schema{"type": "string"},
+				"value": schema{},
+			},
+		},
+	}
+	s.schemas["GraphQLRequest"] = schema{
+		"type":                 "object",
+		"required":             []string{"query"},
+		"additionalProperties": false,
+		"properties": schema{
+			"query":         schema{"type": "string", "minLength": 1},
+			"operationName": schema{"anyOf": []schema{{"type": "string"}, {"type": "null"}}, "description": "Required when the query has several operations"},
+			"variables":     schema{"anyOf": []schema{{"type": "object"}, {"type": "null"}}},
+		},
+	}
+	s.schemas["GraphQLResponse"] = schema{
+		"type": "object",
+		"properties": schema{
+			"data": schema{"anyOf": []schema{{"type": "object"}, {"type": "null"}}},
+			"errors": schema{
+				"type": "array",
+				"items": schema{
+					"type":     "object",
+					"required": []string{"message"},
+					"properties": schema{
+						"message":    schema{"type": "string"},
+						"path":       schema{"type": "array"},
+						"extensions": schema{"type": "object", "description": "The code of the error, e.g. NOT_FOUND"},
+					},
+				},
+			},
+		},
+	}
+	s.schemas["Message"] = schema{"type": "object", "required": []string{"message"}, "properties": schema{"message": schema{"type": "string"}}}
+	s.schemas["Error"] = schema{"type": "object", "required": []string{"error"}, "properties": schema{"error": schema{"type": "string"}}}
+	return s.schemas
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	uuidType          = reflect.TypeOf(uuid.UUID{})
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// reflect returns the schema of the JSON encoding of t, the structs are added as components
+func (s *schemaRegistry) reflect(t reflect.Type) schema {
+	if t.Kind() == reflect.Pointer {
+		return s.reflect(t.Elem())
+	}
+	switch {
+	case t == timeType:
+		return schema{"type": "string", "format": "date-time"}
+	case t == uuidType:
+		return schema{"type": "string", "format": "uuid"}
+	case t.Implements(marshalerType) || t == reflect.TypeOf(json.RawMessage{}):
+		// Any JSON document
+		return schema{}
+	case t.Implements(textMarshalerType):
+		return schema{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": s.reflect(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": s.reflect(t.Elem())}
+	case reflect.Struct:
+		name := componentName(t.Name())
+		if _, ok := s.schemas[name]; !ok {
+			// Registered before the fields so that recursive types terminate
+			s.schemas[name] = schema{}
+			s.schemas[name] = s.object(t)
+		}
+		return ref(name)
+	}
+	return schema{}
+}
+
+// object returns the schema of a struct, the fields without omitempty are required
+func (s *schemaRegistry) object(t reflect.Type) schema {
+	properties := schema{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		property := s.reflect(field.Type)
+		if field.Type.Kind() == reflect.Pointer && options != "omitempty" {
+			property = schema{"anyOf": []schema{property, {"type": "null"}}}
+		}
+		properties[name] = property
+		if options != "omitempty" {
+			required = append(required, name)
+		}
+	}
+	// Like the decoding of the handlers, which disallows unknown fields
+	return schema{"type": "object", "required": required, "properties": properties, "additionalProperties": false}
+}
+
+// componentName exports the names of the unexported types, e.g. exportJobResult
+func componentName(name string) string {
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func ref(name string) schema {
+	return schema{"$ref": "#/components/schemas/" + name}
+}
+
+// companyFields returns the schemas of the writable company fields with their validation rules
+func companyFields() schema {
+	return schema{
+		"name":        schema{"type": "string", "minLength": 1, "maxLength": utils.MaxNameLength},
+		"description": schema{"type": "string", "maxLength": utils.MaxDescriptionLength},
+		"employees":   schema{"type": "integer", "minimum": 0},
+		"registered":  schema{"type": "boolean"},
+		"type":        schema{"type": "string", "enum": utils.CompanyTypes},
+	}
+}
+
+// constrainCompany adds the validation rules to the reflected company
+func constrainCompany(company schema) {
+	properties := company["properties"].(schema)
+	for name, field := range companyFields() {
+		properties[name] = field
+	}
+}
+
+// companyInputSchema is the body of POST /api/companies and PUT /api/companies/{id}, checked
+// by utils.ValidateCompanyInput
+func companyInputSchema() schema {
+	properties := companyFields()
+	properties["employees"] = schema{"type": "integer", "minimum": 1}
+	properties["registered"] = schema{"const": true}
+	properties["id"] = schema{"type": "string", "format": "uuid", "description": "Ignored on creation, must match the path on replacement"}
+	// The other fields of a company are accepted, so that a company read from the API can be sent back
+	for _, name := range []string{"created_at", "updated_at"} {
+		properties[name] = schema{"type": "string", "format": "date-time", "description": "Ignored"}
+	}
+	properties["deletedAt"] = schema{"anyOf": []schema{{"type": "string", "format": "date-time"}, {"type": "null"}}, "description": "Ignored"}
+	return schema{
+		"type":                 "object",
+		"required":             []string{"name", "employees", "registered", "type"},
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// companyUpdateSchema is the plain JSON body of PATCH /api/companies/{id}, checked by
+// utils.ValidateCompanyUpdate, or its merge patch, where null removes a field. Only the
+// description can be removed, which the handler checks.
+func companyUpdateSchema(mergePatch bool) schema {
+	properties := companyFields()
+	if mergePatch {
+		for name, property := range properties {
+			properties[name] = schema{"anyOf": []schema{property.(schema), {"type": "null"}}}
+		}
+	}
+	for name := range immutableFields {
+		properties[name] = schema{"readOnly": true}
+	}
+	return schema{"type": "object", "properties": properties, "additionalProperties": false}
+}
+
+// companyFieldsSchema is the response of GET /api/companies/{id}?fields=, the selected fields
+// with the id, and the expanded relations
+func companyFieldsSchema(company schema) schema {
+	return schema{"type": "object", "required": []string{"id"}, "properties": company["properties"]}
+}
+
+// jsonContent is the content of a JSON body with the given schema
+func jsonContent(s schema) map[string]mediaType {
+	return map[string]mediaType{"application/json": {Schema: s}}
+}
+
+// jsonBody is a required JSON request body
+func jsonBody(s schema) *requestBody {
+	return &requestBody{Required: true, Content: jsonContent(s)}
+}
+
+// jsonResponse is a response with a JSON body
+func jsonResponse(description string, s schema) response {
+	return response{Description: description, Content: jsonContent(s)}
+}
+
+// errorResponse is a response with the body of utils.SendErrorResponse
+func errorResponse(description string) response {
+	return jsonResponse(description, ref("Error"))
+}
+
+// queryParam is an optional query parameter
+func queryParam(name, description string, s schema) parameter {
+	return parameter{Name: name, In: "query", Description: description, Schema: s}
+}
+
+// exportParams are the query parameters of GET /api/companies/export
+func exportParams() []parameter {
+	return []parameter{
+		queryParam("format", "Format of the file", schema{"enum": slices.Sorted(maps.Keys(exportFormats)), "default": "csv"}),
+		queryParam("columns", "Comma separated columns, all of them by default", schema{"type": "string"}),
+		queryParam("type", "Only the companies of this type", schema{"enum": utils.CompanyTypes}),
+		queryParam("registered", "Only the registered or unregistered companies", schema{"type": "boolean"}),
+		queryParam("min_employees", "Only the companies with at least this many employees", schema{"type": "integer"}),
+		queryParam("max_employees", "Only the companies with at most this many employees", schema{"type": "integer"}),
+	}
+}
+
+// exportContent is the content of an export file in every format
+func exportContent() map[string]mediaType {
+	content := make(map[string]mediaType, len(exportFormats))
+	for _, format := range exportFormats {
+		name, _, _ := strings.Cut(format.contentType, ";")
+		content[name] = mediaType{Schema: schema{"type": "string", "format": "binary"}}
+	}
+	return content
+}
@@ -0,0 +1,101 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type openAPIDocument struct {
+	OpenAPI    string                                       `json:"openapi"`
+	Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+	Components struct {
+		Schemas map[string]map[string]interface{} `json:"schemas"`
+	} `json:"components"`
+}
+
+func getOpenAPI(t *testing.T, router *mux.Router) openAPIDocument {
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var document openAPIDocument
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &document))
+	return document
+}
+
+// Every route registered on the router must be documented, and every documented route must exist
+func TestOpenAPICoversRoutes(t *testing.T) {
+	app, _ := newImportApp(t)
+	router := app.Router()
+	document := getOpenAPI(t, router)
+	assert.Equal(t, "3.1.0", document.OpenAPI)
+
+	registered := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			// The OPTIONS catch-all of the CORS preflights has no path
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// The /api prefix of the subrouter
+			return nil
+		}
+		for _, method := range methods {
+			registered[method+" "+path] = true
+			assert.Contains(t, document.Paths[path], strings.ToLower(method), "%s %s has no entry in the OpenAPI document", method, path)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for path, operations := range document.Paths {
+		for method := range operations {
+			assert.True(t, registered[strings.ToUpper(method)+" "+path], "%s %s is documented but not registered", method, path)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	app, _ := newImportApp(t)
+	document := getOpenAPI(t, app.Router())
+
+	// The request body schemas carry the validation rules
+	input := document.Components.Schemas["CompanyInput"]
+	assert.ElementsMatch(t, []interface{}{"name", "employees", "registered", "type"}, input["required"])
+	properties := input["properties"].(map[string]interface{})
+	assert.Equal(t, float64(15), properties["name"].(map[string]interface{})["maxLength"])
+	assert.Equal(t, []interface{}{"Corporations", "NonProfit", "Cooperative", "Sole Proprietorship"}, properties["type"].(map[string]interface{})["enum"])
+
+	// The models are reflected from their JSON encoding
+	job := document.Components.Schemas["Job"]["properties"].(map[string]interface{})
+	assert.NotContains(t, job, "Params")
+	assert.NotContains(t, job, "params")
+	assert.Equal(t, map[string]interface{}{"type": "string", "format": "date-time"}, job["created_at"])
+
+	// The authenticated unsafe routes need the CSRF token, the public ones nothing
+	patch := document.Paths["/api/companies/{id}"]["patch"]
+	assert.Equal(t, []interface{}{map[string]interface{}{"cookieAuth": []interface{}{}, "csrfToken": []interface{}{}}}, patch["security"])
+	assert.NotContains(t, document.Paths["/api/companies/{id}"]["get"], "security")
+	assert.Contains(t, patch["requestBody"].(map[string]interface{})["content"], "application/json-patch+json")
+	parameters := patch["parameters"].([]interface{})
+	assert.Equal(t, "id", parameters[0].(map[string]interface{})["name"])
+}
+
+func TestDocs(t *testing.T) {
+	app, _ := newImportApp(t)
+	rr := httptest.NewRecorder()
+	app.Router().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/docs", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.NotContains(t, rr.Header().Get("Content-Security-Policy"), "script-src")
+	assert.Contains(t, rr.Body.String(), "/api/companies/{id}")
+	assert.Contains(t, rr.Body.String(), `id="schema-CompanyInput"`)
+}
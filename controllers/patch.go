@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"company-service/database"
+	"company-service/models"
+	"company-service/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// Media types of the patch formats accepted by PATCH /api/companies/{id}
+const (
+	// MergePatchType is a JSON Merge Patch (RFC 7396), null removes a field
+	MergePatchType = "application/merge-patch+json"
+	// JSONPatchType is a JSON Patch (RFC 6902), a list of operations including test
+	JSONPatchType = "application/json-patch+json"
+)
+
+// immutableFields are the company fields a patch may test but never change
+var immutableFields = map[string]bool{"id": true, "created_at": true, "updated_at": true}
+
+// patchError is an error of a patch reported with its own HTTP status
+type patchError struct {
+	status int
+	err    error
+}
+
+func (e *patchError) Error() string { return e.err.Error() }
+
+func (e *patchError) Unwrap() error { return e.err }
+
+// checkPatchField refuses the unknown fields, and the immutable ones unless they are only read
+func checkPatchField(name string, readOnly bool) error {
+	if immutableFields[name] {
+		if readOnly {
+			return nil
+		}
+		return fmt.Errorf("'%s' cannot be changed", name)
+	}
+	// The writable fields are the columns of an import
+	if !importColumns[name] {
+		return fmt.Errorf("unknown field '%s'", name)
+	}
+	return nil
+}
+
+// patchCompany applies a merge patch or a JSON patch to the company in a transaction. The row is
+// locked when it is read, so that the test operations of a JSON patch hold until the update is
+// committed and a concurrent patch is applied on top of this one instead of overwriting it.
+func (app *App) patchCompany(w http.ResponseWriter, r *http.Request, id, mediaType string) {
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		utils.SendErrorResponse(w, decodeErrorStatus(err), fmt.Sprintf("Invalid input data to update a company record: %v", err))
+		return
+	}
+	apply, err := parsePatch(mediaType, body)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid patch: %v", err))
+		return
+	}
+
+	var company *models.Company
+	updated := false
+	err = app.DB.Transaction(r.Context(), func(tx database.Database) error {
+		current, err := tx.GetCompanyForUpdate(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		document, err := companyDocument(current)
+		if err != nil {
+			return err
+		}
+		patched, err := apply(document)
+		if err != nil {
+			return err
+		}
+		fields, err := patchedFields(document, patched)
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 {
+			company = current
+			return nil
+		}
+		if err = utils.ValidateCompanyUpdate(fields); err != nil {
+			return &patchError{status: http.StatusBadRequest, err: err}
+		}
+		company, err = tx.UpdateCompany(r.Context(), id, fields)
+		updated = err == nil
+		return err
+	})
+	var patchErr *patchError
+	switch {
+	case errors.As(err, &patchErr):
+		utils.SendErrorResponse(w, patchErr.status, patchErr.Error())
+		return
+	case errors.Is(err, database.ErrDuplicateName):
+		utils.SendErrorResponse(w, http.StatusConflict, "The company with the same name already exists")
+		return
+	case err != nil:
+		utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
+		return
+	}
+	if !updated {
+		// Nothing changed, so there is no event to publish
+		utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"message": "Company unchanged",
+			"company": company,
+		})
+		return
+	}
+	app.companyUpdated(w, r, company)
+}
+
+// parsePatch checks the fields touched by the patch and returns the function applying it to a document
+func parsePatch(mediaType string, body []byte) (func(document []byte) ([]byte, error), error) {
+	if mediaType == MergePatchType {
+		var patch map[string]json.RawMessage
+		if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+			return nil, errors.New("a merge patch must be a JSON object")
+		}
+		for field := range patch {
+			if err := checkPatchField(field, false); err != nil {
+				return nil, err
+			}
+		}
+		return func(document []byte) ([]byte, error) {
+			return jsonpatch.MergePatch(document, body)
+		}, nil
+	}
+
+	patch, err := jsonpatch.DecodePatch(body)
+	if err != nil {
+		return nil, err
+	}
+	for i, operation := range patch {
+		kind := operation.Kind()
+		switch kind {
+		case "add", "remove", "replace", "move", "copy", "test":
+		default:
+			return nil, fmt.Errorf("operation %d: unknown op %q", i, kind)
+		}
+		path, err := operation.Path()
+		if err == nil {
+			err = checkPatchPath(path, kind == "test")
+		}
+		if err == nil && (kind == "move" || kind == "copy") {
+			var from string
+			if from, err = operation.From(); err == nil {
+				// A move removes the source field
+				err = checkPatchPath(from, kind == "copy")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %v", i, err)
+		}
+	}
+	return func(document []byte) ([]byte, error) {
+		patched, err := patch.Apply(document)
+		switch {
+		case errors.Is(err, jsonpatch.ErrTestFailed), errors.Is(err, jsonpatch.ErrMissing):
+			return nil, &patchError{status: http.StatusConflict, err: fmt.Errorf("the patch does not apply: %v", err)}
+		case err != nil:
+			return nil, &patchError{status: http.StatusBadRequest, err: fmt.Errorf("invalid patch: %v", err)}
+		}
+		return patched, nil
+	}, nil
+}
+
+// checkPatchPath checks the JSON pointer of an operation, which must name a top level field
+func checkPatchPath(path string, readOnly bool) error {
+	if !strings.HasPrefix(path, "/") {
+		return errors.New("the path must name a field of the company")
+	}
+	field := strings.NewReplacer("~1", "/", "~0", "~").Replace(path[1:])
+	if strings.Contains(path[1:], "/") {
+		return fmt.Errorf("the path %q is not a field of the company", path)
+	}
+	return checkPatchField(field, readOnly)
+}
+
+// companyDocument returns the JSON document a patch applies to
+func companyDocument(company *models.Company) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"id":          company.ID,
+		"name":        company.Name,
+		"description": company.Description,
+		"employees":   company.Employees,
+		"registered":  company.Registered,
+		"type":        company.Type,
+		"created_at":  company.CreatedAt,
+		"updated_at":  company.UpdatedAt,
+	})
+}
+
+// patchedFields returns the writable fields changed by a patch. A removed description becomes
+// empty, the other fields are required.
+func patchedFields(document, patched []byte) (map[string]interface{}, error) {
+	var before, after map[string]interface{}
+	if err := json.Unmarshal(document, &before); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patched, &after); err != nil {
+		return nil, &patchError{status: http.StatusBadRequest, err: errors.New("the patched company is not a JSON object")}
+	}
+	fields := make(map[string]interface{})
+	for field := range importColumns {
+		value, ok := after[field]
+		if !ok || value == nil {
+			if field != "description" {
+				return nil, &patchError{status: http.StatusBadRequest, err: fmt.Errorf("'%s' cannot be removed", field)}
+			}
+			value = ""
+		}
+		if !reflect.DeepEqual(value, before[field]) {
+			fields[field] = value
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,139 @@
+package controllers_test
+
+import (
+	"company-service/controllers"
+	"company-service/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// patchCompany sends a PATCH for Acme, the company of newImportApp, and returns the stored Acme
+func patchCompany(t *testing.T, app *controllers.App, contentType, body string) (*httptest.ResponseRecorder, *models.Company) {
+	id := acmeID(t, app)
+	router := mux.NewRouter()
+	router.Use(app.ValidationMiddleware())
+	router.HandleFunc("/api/companies/{id}", app.UpdateCompany).Methods(http.MethodPatch)
+	req := httptest.NewRequest(http.MethodPatch, "/api/companies/"+id, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	stored, err := app.DB.GetCompany(context.Background(), id)
+	require.NoError(t, err)
+	return rr, stored
+}
+
+func errorMessage(t *testing.T, rr *httptest.ResponseRecorder) string {
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	return response["error"]
+}
+
+func TestMergePatchCompany(t *testing.T) {
+	app, producer := newImportApp(t)
+	_, err := app.DB.UpdateCompany(context.Background(), acmeID(t, app), map[string]interface{}{"description": "Roadrunner traps"})
+	require.NoError(t, err)
+
+	rr, stored := patchCompany(t, app, controllers.MergePatchType, `{"description":null,"employees":12}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Empty(t, stored.Description)
+	assert.Equal(t, 12, stored.Employees)
+	require.Len(t, producer.Events(), 1)
+	assert.Equal(t, "company_updated", producer.Events()[0].EventType)
+
+	// A patch changing nothing does not produce an event
+	rr, _ = patchCompany(t, app, controllers.MergePatchType, `{"employees":12}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, producer.Events(), 1)
+}
+
+func TestJSONPatchCompany(t *testing.T) {
+	app, _ := newImportApp(t)
+	rr, stored := patchCompany(t, app, controllers.JSONPatchType, `[
+		{"op":"test","path":"/employees","value":5},
+		{"op":"replace","path":"/employees","value":6},
+		{"op":"copy","from":"/name","path":"/description"}
+	]`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, 6, stored.Employees)
+	assert.Equal(t, "Acme", stored.Description)
+
+	// The failed test leaves the company untouched
+	rr, stored = patchCompany(t, app, controllers.JSONPatchType, `[
+		{"op":"replace","path":"/description","value":"changed"},
+		{"op":"test","path":"/employees","value":5}
+	]`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, errorMessage(t, rr), "test failed")
+	assert.Equal(t, "Acme", stored.Description)
+}
+
+func TestJSONPatchCompanyConcurrently(t *testing.T) {
+	app, producer := newImportApp(t)
+	patch := `[{"op":"test","path":"/employees","value":5},{"op":"replace","path":"/employees","value":6}]`
+
+	// The test holds until the update is committed, so a single patch passes it
+	codes := make(chan int, 8)
+	var wg sync.WaitGroup
+	for range cap(codes) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr, _ := patchCompany(t, app, controllers.JSONPatchType, patch)
+			codes <- rr.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusOK: 1, http.StatusConflict: cap(codes) - 1}, counts)
+	assert.Len(t, producer.Events(), 1)
+}
+
+func TestPatchCompanyRejected(t *testing.T) {
+	app, producer := newImportApp(t)
+	for _, tt := range []struct {
+		contentType string
+		body        string
+		code        int
+		message     string
+	}{
+		{controllers.MergePatchType, `{"id":"8b9e0d4c-3f6a-4c1e-9d7a-2b5f0e1c3a4d"}`, http.StatusBadRequest, "'id' cannot be changed"},
+		{controllers.MergePatchType, `{"website":"acme.com"}`, http.StatusBadRequest, "unknown field 'website'"},
+		{controllers.MergePatchType, `{"name":null}`, http.StatusBadRequest, "'name' cannot be removed"},
+		{controllers.MergePatchType, `{"employees":-1}`, http.StatusBadRequest, "invalid 'employees': it must be at least 0"},
+		{controllers.MergePatchType, `[]`, http.StatusBadRequest, "JSON object"},
+		{controllers.JSONPatchType, `[{"op":"replace","path":"/created_at","value":"2020-01-01T00:00:00Z"}]`, http.StatusBadRequest, "'created_at' cannot be changed"},
+		{controllers.JSONPatchType, `[{"op":"move","from":"/id","path":"/description"}]`, http.StatusBadRequest, "'id' cannot be changed"},
+		{controllers.JSONPatchType, `[{"op":"add","path":"/website","value":"acme.com"}]`, http.StatusBadRequest, "unknown field 'website'"},
+		{controllers.JSONPatchType, `[{"op":"replace","path":"/type","value":"Partnership"}]`, http.StatusBadRequest, "invalid 'type'"},
+		{controllers.JSONPatchType, `{"op":"remove"}`, http.StatusBadRequest, "it must be a JSON array"},
+		{"application/json", `{"created_at":"2020-01-01T00:00:00Z"}`, http.StatusBadRequest, "'created_at' cannot be changed"},
+		{"text/plain", `employees=3`, http.StatusUnsupportedMediaType, controllers.MergePatchType},
+	} {
+		rr, stored := patchCompany(t, app, tt.contentType, tt.body)
+		assert.Equal(t, tt.code, rr.Code, tt.body)
+		assert.Contains(t, errorMessage(t, rr), tt.message, tt.body)
+		assert.Equal(t, 5, stored.Employees)
+		assert.Equal(t, "Cooperative", stored.Type)
+	}
+	assert.Empty(t, producer.Events())
+}
+
+func acmeID(t *testing.T, app *controllers.App) string {
+	acme, err := app.DB.GetCompanyByName(context.Background(), "Acme")
+	require.NoError(t, err)
+	return acme.ID.String()
+}
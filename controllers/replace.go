@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"company-service/database"
+	"company-service/kafka"
+	"company-service/models"
+	"company-service/utils"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// errPrecondition is returned when the If-Match or If-None-Match condition of a request does not hold
+var errPrecondition = errors.New("the company does not match the If-Match or If-None-Match condition")
+
+// companyETag returns the strong entity tag of a company, a hash of its representation
+func companyETag(company *models.Company) string {
+	document, err := companyDocument(company)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(document)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// matchETag reports whether an If-Match or If-None-Match header lists the entity tag, "*" matches
+// any existing company. Weak tags never match, the comparison is strong.
+func matchETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || (tag == etag && etag != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions evaluates the If-Match and If-None-Match headers against the current company,
+// nil when it does not exist
+func checkPreconditions(r *http.Request, current *models.Company) error {
+	etag := ""
+	if current != nil {
+		etag = companyETag(current)
+	}
+	if header := r.Header.Get("If-Match"); header != "" && (current == nil || !matchETag(header, etag)) {
+		return errPrecondition
+	}
+	if header := r.Header.Get("If-None-Match"); header != "" && current != nil && matchETag(header, etag) {
+		return errPrecondition
+	}
+	return nil
+}
+
+// ReplaceCompany replaces the company with the complete representation of the body, or creates it
+// with the ID of the path when it does not exist. If-Match makes the replacement conditional on
+// the ETag of the current company, If-None-Match: * restricts it to a creation.
+func (app *App) ReplaceCompany(w http.ResponseWriter, r *http.Request) {
+	// A UUID, checked by ValidationMiddleware
+	id := mux.Vars(r)["id"]
+	var company *models.Company
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&company)
+	if err == nil && company == nil {
+		err = errors.New("the body must be a company")
+	}
+	if err != nil {
+		utils.SendErrorResponse(w, decodeErrorStatus(err), fmt.Sprintf("Invalid input data to replace a company record with error: %v", err))
+		return
+	}
+	parsed, err := utils.GenerateUUIDFromString(id)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if company.ID != uuid.Nil && company.ID != parsed {
+		utils.SendErrorResponse(w, http.StatusBadRequest, "The id of the body does not match the id of the path")
+		return
+	}
+	if err = utils.ValidateCompanyInput(company); err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created := false
+	var stored *models.Company
+	replace := func(tx database.Database) error {
+		// Locked, so that the preconditions hold until the update is committed
+		current, err := tx.GetCompanyForUpdate(r.Context(), id)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err = checkPreconditions(r, current); err != nil {
+			return err
+		}
+		if current == nil {
+			// The timestamps are set by the database, the ones of the body are ignored
+			replacement := models.Company{
+				ID:          parsed,
+				Name:        company.Name,
+				Description: company.Description,
+				Employees:   company.Employees,
+				Registered:  company.Registered,
+				Type:        company.Type,
+			}
+			err = tx.CreateCompany(r.Context(), &replacement)
+			created = err == nil
+		} else {
+			_, err = tx.UpdateCompany(r.Context(), id, map[string]interface{}{
+				"name":        company.Name,
+				"description": company.Description,
+				"employees":   company.Employees,
+				"registered":  company.Registered,
+				"type":        company.Type,
+			})
+		}
+		if err != nil {
+			return err
+		}
+		// Read back so that the ETag matches the one a GET returns
+		stored, err = tx.GetCompany(r.Context(), id)
+		return err
+	}
+	err = app.DB.Transaction(r.Context(), replace)
+	if errors.Is(err, database.ErrDuplicateID) {
+		// A concurrent request created the company after it was read, the preconditions are
+		// evaluated again against it
+		err = app.DB.Transaction(r.Context(), replace)
+	}
+	switch {
+	case errors.Is(err, errPrecondition):
+		utils.SendErrorResponse(w, http.StatusPreconditionFailed, errPrecondition.Error())
+		return
+	case errors.Is(err, database.ErrDuplicateName):
+		utils.SendErrorResponse(w, http.StatusConflict, "The company with the same name already exists")
+		return
+	case errors.Is(err, database.ErrDuplicateID):
+		utils.SendErrorResponse(w, http.StatusConflict, "The company with the same id was created concurrently")
+		return
+	case err != nil:
+		utils.SendErrorResponse(w, DatabaseErrorStatus(err), err.Error())
+		return
+	}
+
+	status, eventType, message := http.StatusOK, "company_updated", "Company replaced successfully"
+	if created {
+		status, eventType, message = http.StatusCreated, "company_created", "Company created successfully"
+		w.Header().Set("Location", "/api/companies/"+id)
+	}
+	w.Header().Set("ETag", companyETag(stored))
+	if err = app.PublishEvent(r.Context(), kafka.NewEvent(eventType, stored)); err != nil {
+		slog.ErrorContext(r.Context(), "Kafka publish failed", slog.Any("error", err))
+		message += ", but Kafka publishing failed"
+	}
+	utils.SendJSONResponse(w, status, map[string]interface{}{
+		"message": message,
+		"company": stored,
+	})
+}
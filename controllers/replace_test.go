@@ -0,0 +1,166 @@
+package controllers_test
+
+import (
+	"company-service/controllers"
+	"company-service/database"
+	"company-service/models"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func companyRouter(app *controllers.App) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(app.ValidationMiddleware())
+	router.HandleFunc("/api/companies/{id}", app.GetCompany).Methods(http.MethodGet)
+	router.HandleFunc("/api/companies/{id}", app.ReplaceCompany).Methods(http.MethodPut)
+	return router
+}
+
+func replaceCompany(router *mux.Router, id, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/api/companies/"+id, strings.NewReader(body))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+const globex = `{"name":"Globex","description":"Hammocks","employees":10,"registered":true,"type":"Corporations"}`
+
+func TestReplaceCompanyUpsert(t *testing.T) {
+	app, producer := newImportApp(t)
+	router := companyRouter(app)
+	id := uuid.NewString()
+
+	rr := replaceCompany(router, id, globex, nil)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	assert.Equal(t, "/api/companies/"+id, rr.Header().Get("Location"))
+	etag := rr.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	created, err := app.DB.GetCompany(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, "Hammocks", created.Description)
+
+	// The ETag of a GET is the one returned by the PUT
+	get := httptest.NewRecorder()
+	router.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/api/companies/"+id, nil))
+	require.Equal(t, http.StatusOK, get.Code)
+	assert.Equal(t, etag, get.Header().Get("ETag"))
+
+	// The omitted description is cleared by the replacement
+	rr = replaceCompany(router, id, `{"id":"`+id+`","name":"Globex","employees":20,"registered":true,"type":"Cooperative"}`,
+		map[string]string{"If-Match": etag})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+	replaced, err := app.DB.GetCompany(context.Background(), id)
+	require.NoError(t, err)
+	assert.Empty(t, replaced.Description)
+	assert.Equal(t, 20, replaced.Employees)
+	assert.Equal(t, "Cooperative", replaced.Type)
+
+	events := producer.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, "company_created", events[0].EventType)
+	assert.Equal(t, "company_updated", events[1].EventType)
+	assert.Equal(t, id, events[1].Company.ID.String())
+}
+
+func TestReplaceCompanyPreconditions(t *testing.T) {
+	app, producer := newImportApp(t)
+	router := companyRouter(app)
+	acme := acmeID(t, app)
+	body := `{"name":"Acme","employees":9,"registered":true,"type":"Cooperative"}`
+
+	for _, headers := range []map[string]string{
+		{"If-Match": `"stale"`},
+		{"If-None-Match": "*"},
+	} {
+		rr := replaceCompany(router, acme, body, headers)
+		assert.Equal(t, http.StatusPreconditionFailed, rr.Code, headers)
+	}
+	// If-Match requires an existing company
+	rr := replaceCompany(router, uuid.NewString(), globex, map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+	rr = replaceCompany(router, uuid.NewString(), globex, map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	rr = replaceCompany(router, acme, body, map[string]string{"If-Match": `"stale", *`})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, producer.Events(), 2)
+}
+
+func TestReplaceCompanyInvalidRequest(t *testing.T) {
+	app, producer := newImportApp(t)
+	router := companyRouter(app)
+	acme := acmeID(t, app)
+
+	for _, tt := range []struct {
+		id   string
+		body string
+		code int
+	}{
+		{acme, `{"id":"` + uuid.NewString() + `","name":"Acme","employees":9,"registered":true,"type":"Cooperative"}`, http.StatusBadRequest},
+		{acme, `{"name":"Acme","employees":9,"type":"Cooperative"}`, http.StatusBadRequest},
+		{acme, `{"name":"Acme","employees":9,"registered":true,"type":"Cooperative","website":"acme.com"}`, http.StatusBadRequest},
+		{acme, `null`, http.StatusBadRequest},
+		{"not-a-uuid", globex, http.StatusBadRequest},
+		{uuid.NewString(), `{"name":"ACME","employees":9,"registered":true,"type":"Cooperative"}`, http.StatusConflict},
+	} {
+		rr := replaceCompany(router, tt.id, tt.body, nil)
+		assert.Equal(t, tt.code, rr.Code, tt.body)
+	}
+	assert.Empty(t, producer.Events())
+}
+
+// racingDatabase misses the companies it has not seen yet, as if another request created them
+// between the read and the insert of a PUT
+type racingDatabase struct {
+	database.Database
+	seen map[string]bool
+}
+
+func (d *racingDatabase) Transaction(ctx context.Context, fn func(tx database.Database) error) error {
+	return d.Database.Transaction(ctx, func(tx database.Database) error {
+		return fn(&racingDatabase{Database: tx, seen: d.seen})
+	})
+}
+
+func (d *racingDatabase) GetCompanyForUpdate(ctx context.Context, id string) (*models.Company, error) {
+	if !d.seen[id] {
+		d.seen[id] = true
+		return nil, gorm.ErrRecordNotFound
+	}
+	return d.Database.GetCompanyForUpdate(ctx, id)
+}
+
+func TestReplaceCompanyCreatedConcurrently(t *testing.T) {
+	app, producer := newImportApp(t)
+	acme := acmeID(t, app)
+	app.DB = &racingDatabase{Database: app.DB, seen: make(map[string]bool)}
+	router := companyRouter(app)
+	body := `{"name":"Acme","employees":9,"registered":true,"type":"Cooperative"}`
+
+	// The creation fails on the ID, the retry replaces the company created in between
+	rr := replaceCompany(router, acme, body, nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	events := producer.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, "company_updated", events[0].EventType)
+
+	// Unless the request is restricted to a creation
+	id := uuid.NewString()
+	require.Equal(t, http.StatusCreated, replaceCompany(router, id, globex, nil).Code)
+	app.DB.(*racingDatabase).seen = map[string]bool{}
+	rr = replaceCompany(router, id, globex, map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code, rr.Body.String())
+}
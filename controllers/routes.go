@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"company-service/middleware"
+	"company-service/tracing"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+)
+
+// route is an endpoint of the API, its doc is its entry in the OpenAPI document
+type route struct {
+	method string
+	// path is relative to /api
+	path    string
+	handler http.HandlerFunc
+	// auth requires the JWT cookie, and the CSRF token on the unsafe methods
+	auth bool
+	doc  operation
+}
+
+// Router builds the HTTP router with all the API endpoints of the app
+func (app *App) Router() *mux.Router {
+	router := mux.NewRouter()
+	// The server span is named after the route template, e.g. "/api/companies/{id}"
+	router.Use(otelmux.Middleware(tracing.ServiceName))
+	router.Use(
+		middleware.RequestIDMiddleware,
+		middleware.AccessLogMiddleware,
+		middleware.SecurityHeadersMiddleware(app.Config),
+		middleware.CORSMiddleware(app.Config),
+	)
+
+	apiRouter := router.PathPrefix("/api").Subrouter()
+	apiRouter.Use(
+		middleware.RateLimitMiddleware(app.Config, app.RateLimitStore),
+		middleware.BodyLimitMiddleware(app.Config),
+		middleware.TimeoutMiddleware(app.Config),
+		app.ValidationMiddleware(),
+	)
+	for _, route := range app.routes() {
+		handler := route.handler
+		if route.auth {
+			handler = middleware.JwtMiddleware(handler, app.Config, app.Users)
+		}
+		apiRouter.HandleFunc(route.path, handler).Methods(route.method)
+	}
+
+	// Lets the CORS preflight requests of every path reach the middlewares
+	router.Methods(http.MethodOptions).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return router
+}
+
+// routes lists the endpoints of the API in their matching order. Every endpoint is registered
+// and documented from this table, so a new route needs its doc here.
+func (app *App) routes() []route {
+	etag := map[string]header{"ETag": {Description: "Version of the company, for If-Match", Schema: schema{"type": "string"}}}
+	return []route{
+		{
+			method: http.MethodPost, path: "/login", handler: app.Login,
+			doc: operation{
+				OperationID: "login",
+				Summary:     "Log in",
+				Description: "Sets the auth_token cookie (JWT, 15 minutes) and the csrf_token cookie. The CSRF token is also sent in the X-CSRF-Token header, the unsafe authenticated requests must send it back in that header.",
+				Tags:        []string{"auth"},
+				RequestBody: jsonBody(schema{
+					"type":       "object",
+					"required":   []string{"username", "password"},
+					"properties": schema{"username": schema{"type": "string"}, "password": schema{"type": "string"}},
+				}),
+				Responses: map[int]response{
+					http.StatusOK:           {Description: "Logged in", Content: jsonContent(ref("Message")), Headers: map[string]header{"X-CSRF-Token": {Schema: schema{"type": "string"}}}},
+					http.StatusBadRequest:   errorResponse("Invalid body"),
+					http.StatusUnauthorized: errorResponse("Invalid username or password"),
+				},
+			},
+		},
+		{
+			method: http.MethodPost, path: "/companies", handler: app.CreateCompany, auth: true,
+			doc: operation{
+				OperationID: "createCompany",
+				Summary:     "Create a company",
+				Description: "Publishes a company_created event.",
+				Tags:        []string{"companies"},
+				RequestBody: jsonBody(ref("CompanyInput")),
+				Responses: map[int]response{
+					http.StatusCreated:    jsonResponse("Company created", ref("CompanyResponse")),
+					http.StatusBadRequest: errorResponse("Invalid company"),
+					http.StatusConflict:   errorResponse("A company with the same name exists"),
+				},
+			},
+		},
+		{
+			method: http.MethodPost, path: "/companies/import", handler: app.ImportCompanies, auth: true,
+			doc: operation{
+				OperationID: "importCompanies",
+				Summary:     "Import companies from CSV or NDJSON",
+				Description: "Validates every row like POST /api/companies and reports the outcome of each one. Publishes a company_created or company_updated event per written row.",
+				Tags:        []string{"companies"},
+				Parameters: []parameter{
+					queryParam("mode", "What to do with a row whose name exists", schema{"enum": []string{ImportSkipDuplicates, ImportUpsert}, "default": ImportSkipDuplicates}),
+					queryParam("dry_run", "Validate and report without writing", schema{"type": "boolean"}),
+				},
+				RequestBody: &requestBody{Required: true, Content: map[string]mediaType{
+					"text/csv":             {Schema: schema{"type": "string", "description": "Header row naming the name, description, employees, registered and type columns"}},
+					"application/x-ndjson": {Schema: schema{"type": "string", "description": "One company object per line"}},
+				}},
+				Responses: map[int]response{
+					http.StatusOK:                   jsonResponse("Import report", ref("ImportReport")),
+					http.StatusBadRequest:           errorResponse("Invalid mode or CSV header"),
+					http.StatusUnsupportedMediaType: errorResponse("Unsupported Content-Type"),
+				},
+			},
+		},
+		{
+			method: http.MethodPost, path: "/companies/batch", handler: app.BatchCompanies, auth: true,
+			doc: operation{
+				OperationID: "batchCompanies",
+				Summary:     "Run company operations in one transaction",
+				Description: "Either every operation is committed or none. The events are published after the commit.",
+				Tags:        []string{"companies"},
+				RequestBody: jsonBody(ref("BatchRequest")),
+				Responses: map[int]response{
+					http.StatusOK:         jsonResponse("Committed", ref("BatchReport")),
+					http.StatusBadRequest: jsonResponse("Invalid batch or operation, nothing was run", ref("BatchReport")),
+					http.StatusNotFound:   jsonResponse("A company is missing, rolled back", ref("BatchReport")),
+					http.StatusConflict:   jsonResponse("A name clashes, rolled back", ref("BatchReport")),
+				},
+			},
+		},
+		// Registered before /companies/{id}, which would otherwise match "export"
+		{
+			method: http.MethodGet, path: "/companies/export", handler: app.ExportCompanies, auth: true,
+			doc: operation{
+				OperationID: "exportCompanies",
+				Summary:     "Download the companies as a file",
+				Description: "Streamed from a database cursor. The connection is aborted if the export fails after the first bytes.",
+				Tags:        []string{"companies"},
+				Parameters:  exportParams(),
+				Responses: map[int]response{
+					http.StatusOK:         {Description: "The file, as an attachment", Content: exportContent()},
+					http.StatusBadRequest: errorResponse("Invalid query parameter"),
+				},
+			},
+		},
+		{
+			method: http.MethodPost, path: "/jobs", handler: app.CreateJob, auth: true,
+			doc: operation{
+				OperationID: "createJob",
+				Summary:     "Run an import, an export or a reindex in the background",
+				Description: "The params of " + JobImportCompanies + " are content_type, mode, dry_run and data, the ones of " + JobExportCompanies + " are the query parameters of GET /api/companies/export. " + JobReindexCompanies + " takes no params.",
+				Tags:        []string{"jobs"},
+				RequestBody: jsonBody(schema{
+					"type":     "object",
+					"required": []string{"type", "params"},
+					"properties": schema{
+						"type":   schema{"enum": []string{JobImportCompanies, JobExportCompanies, JobReindexCompanies}},
+						"params": schema{"type": "object"},
+					},
+				}),
+				Responses: map[int]response{
+					http.StatusAccepted:           {Description: "Job queued", Content: jsonContent(ref("Job")), Headers: map[string]header{"Location": {Schema: schema{"type": "string"}}}},
+					http.StatusBadRequest:         errorResponse("Unknown type or invalid parameters"),
+					http.StatusServiceUnavailable: errorResponse("The queue is full"),
+				},
+			},
+		},
+		{
+			method: http.MethodGet, path: "/jobs/{id}", handler: app.GetJob, auth: true,
+			doc: operation{
+				OperationID: "getJob",
+				Summary:     "Get the status, progress and result of a job",
+				Tags:        []string{"jobs"},
+				Responses: map[int]response{
+					http.StatusOK:       jsonResponse("The job", ref("Job")),
+					http.StatusNotFound: errorResponse("No such job"),
+				},
+			},
+		},
+		{
+			method: http.MethodPost, path: "/jobs/{id}/cancel", handler: app.CancelJob, auth: true,
+			doc: operation{
+				OperationID: "cancelJob",
+				Summary:     "Cancel a queued or running job",
+				Tags:        []string{"jobs"},
+				Responses: map[int]response{
+					http.StatusOK:       jsonResponse("Cancelled", ref("Job")),
+					http.StatusAccepted: jsonResponse("Running, cancelled once it stops", ref("Job")),
+					http.StatusNotFound: errorResponse("No such job"),
+					http.StatusConflict: errorResponse("The job is finished"),
+				},
+			},
+		},
+		{
+			method: http.MethodGet, path: "/jobs/{id}/result", handler: app.GetJobResult, auth: true,
+			doc: operation{
+				OperationID: "getJobResult",
+				Summary:     "Download the file of a succeeded export job",
+				Tags:        []string{"jobs"},
+				Responses: map[int]response{
+					http.StatusOK:       {Description: "The file, as an attachment", Content: exportContent()},
+					http.StatusNotFound: errorResponse("No such job"),
+					http.StatusConflict: errorResponse("Not a succeeded export job"),
+					http.StatusGone:     errorResponse("The file is no longer available"),
+				},
+			},
+		},
+		{
+			method: http.MethodGet, path: "/companies/{id}", handler: app.GetCompany,
+			doc: operation{
+				OperationID: "getCompany",
+				Summary:     "Get a company",
+				Tags:        []string{"companies"},
+				Parameters: []parameter{
+					queryParam("fields", "Comma separated fields to return with the id, a partial response has no ETag", schema{"type": "string"}),
+					queryParam("expand", "Comma separated relations to embed", schema{"type": "string"}),
+				},
+				Responses: map[int]response{
+					http.StatusOK:         {Description: "The company, or the selected fields", Content: jsonContent(schema{"anyOf": []schema{ref("Company"), ref("CompanyFields")}}), Headers: etag},
+					http.StatusBadRequest: errorResponse("Invalid id, fields or expand"),
+					http.StatusNotFound:   errorResponse("No such company"),
+				},
+			},
+		},
+		{
+			method: http.MethodPut, path: "/companies/{id}", handler: app.ReplaceCompany, auth: true,
+			doc: operation{
+				OperationID: "replaceCompany",
+				Summary:     "Replace or create a company",
+				Description: "Creates the company with this id when it does not exist. If-Match only replaces an unchanged company, If-None-Match: * only creates one.",
+				Tags:        []string{"companies"},
+				Parameters: []parameter{
+					{Name: "If-Match", In: "header", Description: "ETag of the company as it was read", Schema: schema{"type": "string"}},
+					{Name: "If-None-Match", In: "header", Description: "* to only create the company", Schema: schema{"type": "string"}},
+				},
+				RequestBody: jsonBody(ref("CompanyInput")),
+				Responses: map[int]response{
+					http.StatusOK:                 {Description: "Company replaced", Content: jsonContent(ref("CompanyResponse")), Headers: etag},
+					http.StatusCreated:            {Description: "Company created", Content: jsonContent(ref("CompanyResponse")), Headers: etag},
+					http.StatusBadRequest:         errorResponse("Invalid company"),
+					http.StatusConflict:           errorResponse("A company with the same name exists"),
+					http.StatusPreconditionFailed: errorResponse("The precondition failed"),
+				},
+			},
+		},
+		{
+			method: http.MethodPatch, path: "/companies/{id}", handler: app.UpdateCompany, auth: true,
+			doc: operation{
+				OperationID: "updateCompany",
+				Summary:     "Update a company",
+				Description: "The Content-Type selects the format of the body. A failed test of a JSON Patch changes nothing.",
+				Tags:        []string{"companies"},
+				RequestBody: &requestBody{Required: true, Content: map[string]mediaType{
+					"application/json": {Schema: ref("CompanyUpdate")},
+					MergePatchType:     {Schema: ref("CompanyMergePatch")},
+					JSONPatchType:      {Schema: ref("JSONPatch")},
+				}},
+				Responses: map[int]response{
+					http.StatusOK:                   jsonResponse("Company updated", ref("CompanyResponse")),
+					http.StatusBadRequest:           errorResponse("Invalid change"),
+					http.StatusNotFound:             errorResponse("No such company"),
+					http.StatusConflict:             errorResponse("A name clashes or a JSON Patch test failed"),
+					http.StatusUnsupportedMediaType: errorResponse("Unsupported Content-Type"),
+				},
+			},
+		},
+		{
+			method: http.MethodDelete, path: "/companies/{id}", handler: app.DeleteCompany, auth: true,
+			doc: operation{
+				OperationID: "deleteCompany",
+				Summary:     "Delete a company",
+				Description: "Publishes a company_deleted event.",
+				Tags:        []string{"companies"},
+				Responses: map[int]response{
+					http.StatusNoContent: {Description: "Company deleted"},
+					http.StatusNotFound:  errorResponse("No such company"),
+				},
+			},
+		},
+		{
+			method: http.MethodPost, path: "/graphql", handler: app.GraphQL, auth: true,
+			doc: operation{
+				OperationID: "graphql",
+				Summary:     "Run a GraphQL query or mutation on the companies",
+				Description: "The errors of the fields that failed come with the data. A document that is invalid, too deep or too complex is rejected as a whole.",
+				Tags:        []string{"graphql"},
+				RequestBody: jsonBody(ref("GraphQLRequest")),
+				Responses: map[int]response{
+					http.StatusOK:         jsonResponse("The result", ref("GraphQLResponse")),
+					http.StatusBadRequest: jsonResponse("Invalid request or document", schema{"anyOf": []schema{ref("GraphQLResponse"), ref("Error")}}),
+				},
+			},
+		},
+		{
+			method: http.MethodGet, path: "/openapi.json", handler: app.OpenAPI,
+			doc: operation{
+				OperationID: "getOpenAPI",
+				Summary:     "Get this OpenAPI document",
+				Tags:        []string{"docs"},
+				Responses:   map[int]response{http.StatusOK: jsonResponse("The document", schema{"type": "object"})},
+			},
+		},
+		{
+			method: http.MethodGet, path: "/docs", handler: app.Docs,
+			doc: operation{
+				OperationID: "getDocs",
+				Summary:     "Browse this document as HTML",
+				Tags:        []string{"docs"},
+				Responses: map[int]response{
+					http.StatusOK: {Description: "The docs page", Content: map[string]mediaType{"text/html": {Schema: schema{"type": "string"}}}},
+				},
+			},
+		},
+	}
+}
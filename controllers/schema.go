@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// schemaError is a value that does not match its schema. Its message names the value by its
+// path in the document, e.g. "invalid 'operations[0].op': it must be a string".
+type schemaError struct {
+	message string
+}
+
+func (e *schemaError) Error() string {
+	return e.message
+}
+
+// invalid reports a value that breaks a rule of its schema, at the root of the body when path is empty
+func invalid(path, rule string) error {
+	if path == "" {
+		return &schemaError{"invalid body: " + rule}
+	}
+	return &schemaError{fmt.Sprintf("invalid '%s': %s", path, rule)}
+}
+
+// schemaValidator checks JSON values, decoded with json.Decoder.UseNumber, against the schemas
+// of the OpenAPI document. It supports the subset of JSON Schema the document uses.
+type schemaValidator struct {
+	components map[string]schema
+	// request rejects the readOnly properties, which a client cannot send
+	request bool
+}
+
+// validate returns the first rule of s that value breaks, path is the location of value
+func (v schemaValidator) validate(value interface{}, s schema, path string) error {
+	if name, ok := s["$ref"].(string); ok {
+		return v.validate(value, v.components[strings.TrimPrefix(name, "#/components/schemas/")], path)
+	}
+	if alternatives, ok := s["anyOf"].([]schema); ok {
+		var first error
+		for _, alternative := range alternatives {
+			err := v.validate(value, alternative, path)
+			if err == nil {
+				return nil
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+	if expected, ok := s["const"]; ok && value != expected {
+		return invalid(path, fmt.Sprintf("it must be %v", expected))
+	}
+	if enum, ok := s["enum"].([]string); ok {
+		if text, isString := value.(string); !isString || !slices.Contains(enum, text) {
+			return invalid(path, "it must be one of '"+strings.Join(enum, "', '")+"'")
+		}
+	}
+
+	switch s["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid(path, "it must be a JSON object")
+		}
+		return v.validateObject(object, s, path)
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return invalid(path, "it must be a JSON array")
+		}
+		if items, ok := s["items"].(schema); ok {
+			for i, item := range array {
+				if err := v.validate(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return invalid(path, "it must be a string")
+		}
+		return validateString(text, s, path)
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return invalid(path, "it must be "+article(s["type"].(string)))
+		}
+		parsed, err := number.Float64()
+		if err != nil || (s["type"] == "integer" && parsed != math.Trunc(parsed)) {
+			return invalid(path, "it must be "+article(s["type"].(string)))
+		}
+		if minimum, ok := s["minimum"].(int); ok && parsed < float64(minimum) {
+			return invalid(path, fmt.Sprintf("it must be at least %d", minimum))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return invalid(path, "it must be true or false")
+		}
+	case "null":
+		if value != nil {
+			return invalid(path, "it must be null")
+		}
+	}
+	return nil
+}
+
+// validateObject checks the properties of an object, then the required ones. Unless
+// additionalProperties is false, unknown properties are allowed.
+func (v schemaValidator) validateObject(object map[string]interface{}, s schema, path string) error {
+	properties, _ := s["properties"].(schema)
+	for _, name := range slices.Sorted(maps.Keys(object)) {
+		property, known := properties[name].(schema)
+		switch {
+		case !known && s["additionalProperties"] == false:
+			return &schemaError{fmt.Sprintf("unknown field '%s'", childPath(path, name))}
+		case !known:
+			if additional, ok := s["additionalProperties"].(schema); ok {
+				if err := v.validate(object[name], additional, childPath(path, name)); err != nil {
+					return err
+				}
+			}
+		case v.request && property["readOnly"] == true:
+			return &schemaError{fmt.Sprintf("'%s' cannot be changed", childPath(path, name))}
+		default:
+			if err := v.validate(object[name], property, childPath(path, name)); err != nil {
+				return err
+			}
+		}
+	}
+	required, _ := s["required"].([]string)
+	for _, name := range required {
+		if _, ok := object[name]; !ok {
+			return invalid(childPath(path, name), "it is required")
+		}
+	}
+	return nil
+}
+
+// validateString checks the length and the format of a string
+func validateString(text string, s schema, path string) error {
+	length := utf8.RuneCountInString(text)
+	if minimum, ok := s["minLength"].(int); ok && length < minimum {
+		if minimum == 1 {
+			return invalid(path, "it cannot be empty")
+		}
+		return invalid(path, fmt.Sprintf("it must be at least %d characters", minimum))
+	}
+	if maximum, ok := s["maxLength"].(int); ok && length > maximum {
+		return invalid(path, fmt.Sprintf("it must be at most %d characters", maximum))
+	}
+	switch s["format"] {
+	case "uuid":
+		if _, err := uuid.Parse(text); err != nil {
+			return invalid(path, "it must be a UUID")
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, text); err != nil {
+			return invalid(path, "it must be an RFC 3339 date-time")
+		}
+	}
+	return nil
+}
+
+// childPath is the path of a property of the value at path
+func childPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// article prefixes a JSON type with its indefinite article
+func article(jsonType string) string {
+	if jsonType == "integer" {
+		return "an integer"
+	}
+	return "a " + jsonType
+}
@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"bytes"
+	"company-service/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ValidationMiddleware checks the path parameters, the query parameters, the Content-Type and the
+// JSON body of a request against the OpenAPI document before the handler of its route runs. An
+// invalid request gets a 400, or a 415 for an undocumented media type. With conf.ValidateResponses
+// the responses are checked too, a response that breaks the contract is replaced with a 500.
+func (app *App) ValidationMiddleware() mux.MiddlewareFunc {
+	document := app.openAPIDocument()
+	requests := schemaValidator{components: document.Components.Schemas, request: true}
+	responses := schemaValidator{components: document.Components.Schemas}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			op := document.operation(r)
+			if op == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if status, err := requests.checkRequest(r, op); err != nil {
+				utils.SendErrorResponse(w, status, err.Error())
+				return
+			}
+			if !app.Config.ValidateResponses {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			buffered := &bufferedResponse{ResponseWriter: w, header: w.Header().Clone()}
+			next.ServeHTTP(buffered, r)
+			if buffered.status == 0 {
+				buffered.status = http.StatusOK
+			}
+			if err := responses.checkResponse(op, buffered); err != nil {
+				message := fmt.Sprintf("The response %d of %s does not match the OpenAPI document: %v", buffered.status, op.OperationID, err)
+				slog.ErrorContext(r.Context(), "Response contract violated", slog.String("operation", op.OperationID), slog.Int("status", buffered.status), slog.Any("error", err))
+				utils.SendErrorResponse(w, http.StatusInternalServerError, message)
+				return
+			}
+			header := w.Header()
+			clear(header)
+			maps.Copy(header, buffered.header)
+			w.WriteHeader(buffered.status)
+			_, _ = w.Write(buffered.body.Bytes())
+		})
+	}
+}
+
+// operation returns the documented operation of the route matched by the request
+func (d *openAPIDocument) operation(r *http.Request) *operation {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return nil
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return nil
+	}
+	return d.Paths[template][strings.ToLower(r.Method)]
+}
+
+// checkRequest validates the parameters and the body of the request, and returns the status of the error.
+// A JSON body is read and replaced with a copy for the handler.
+func (v schemaValidator) checkRequest(r *http.Request, op *operation) (int, error) {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	for _, param := range op.Parameters {
+		var value string
+		switch param.In {
+		case "path":
+			value = vars[param.Name]
+		case "query":
+			value = query.Get(param.Name)
+		case "header":
+			value = r.Header.Get(param.Name)
+		}
+		if value == "" {
+			if param.Required {
+				return http.StatusBadRequest, invalid(param.Name, "it is required")
+			}
+			continue
+		}
+		parsed, err := parseParam(param.Name, value, param.Schema)
+		if err == nil {
+			err = v.validate(parsed, param.Schema, param.Name)
+		}
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	if op.RequestBody == nil {
+		return 0, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if _, ok := op.RequestBody.Content["application/json"]; ok && mediaType == "" {
+		mediaType = "application/json"
+	}
+	content, ok := op.RequestBody.Content[mediaType]
+	if !ok {
+		allowed := slices.Sorted(maps.Keys(op.RequestBody.Content))
+		return http.StatusUnsupportedMediaType, fmt.Errorf("unsupported Content-Type %q, the body must be %s", r.Header.Get("Content-Type"), strings.Join(allowed, ", "))
+	}
+	if !isJSON(mediaType) {
+		// The CSV and NDJSON imports are streamed, their rows are validated by the handler
+		return 0, nil
+	}
+	data, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return decodeErrorStatus(err), invalid("", err.Error())
+	}
+	value, err := decodeJSON(data)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err = v.validate(value, content.Schema, ""); err != nil {
+		return http.StatusBadRequest, err
+	}
+	return 0, nil
+}
+
+// checkResponse validates the status, the media type and the JSON body of a response
+func (v schemaValidator) checkResponse(op *operation, response *bufferedResponse) error {
+	documented, ok := op.Responses[response.status]
+	if !ok {
+		return errors.New("the status is not documented")
+	}
+	if len(documented.Content) == 0 {
+		if response.body.Len() > 0 {
+			return errors.New("the response has a body")
+		}
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(response.header.Get("Content-Type"))
+	content, ok := documented.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("the Content-Type %q is not documented", response.header.Get("Content-Type"))
+	}
+	if !isJSON(mediaType) {
+		return nil
+	}
+	value, err := decodeJSON(response.body.Bytes())
+	if err != nil {
+		return err
+	}
+	return v.validate(value, content.Schema, "")
+}
+
+// parseParam converts the value of a parameter to the JSON type of its schema
+func parseParam(name, value string, s schema) (interface{}, error) {
+	switch s["type"] {
+	case "integer":
+		if _, err := strconv.Atoi(value); err != nil {
+			return nil, invalid(name, "it must be an integer")
+		}
+		return json.Number(value), nil
+	case "boolean":
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, invalid(name, "it must be true or false")
+		}
+		return parsed, nil
+	}
+	return value, nil
+}
+
+// decodeJSON decodes a single JSON value, keeping the numbers as json.Number
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err == io.EOF {
+		return nil, invalid("", "it is required")
+	} else if err != nil {
+		return nil, invalid("", err.Error())
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, invalid("", "it must hold a single JSON value")
+	}
+	return value, nil
+}
+
+// isJSON reports whether the media type is JSON, e.g. application/json or application/merge-patch+json
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// bufferedResponse holds a response until it is checked, the handler writes to its own header map
+type bufferedResponse struct {
+	http.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to extend the write deadline of an export
+func (b *bufferedResponse) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
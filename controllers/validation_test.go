@@ -0,0 +1,133 @@
+package controllers_test
+
+import (
+	"company-service/controllers"
+	"company-service/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// apiClient sends requests through the router of the app, authenticated by a login
+type apiClient struct {
+	t       *testing.T
+	router  *mux.Router
+	cookies []*http.Cookie
+}
+
+func newAPIClient(t *testing.T, app *controllers.App) *apiClient {
+	app.Config.JWTSecret = "secretTest"
+	client := &apiClient{t: t, router: app.Router()}
+	rr := client.send(http.MethodPost, "/api/login", "application/json", `{"username":"admin","password":"admin-password"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	client.cookies = rr.Result().Cookies()
+	return client
+}
+
+func (c *apiClient) send(method, target, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	c.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestValidationRejectsRequests(t *testing.T) {
+	app, producer := newImportApp(t)
+	client := newAPIClient(t, app)
+	acme := acmeID(t, app)
+
+	for _, tt := range []struct {
+		method, target, contentType, body string
+		code                              int
+		message                           string
+	}{
+		{http.MethodGet, "/api/companies/not-a-uuid", "", "", http.StatusBadRequest, "invalid 'id': it must be a UUID"},
+		{http.MethodGet, "/api/companies/export?min_employees=ten", "", "", http.StatusBadRequest, "invalid 'min_employees': it must be an integer"},
+		{http.MethodGet, "/api/companies/export?format=xml", "", "", http.StatusBadRequest, "invalid 'format': it must be one of 'csv', 'ndjson', 'parquet'"},
+		{http.MethodPost, "/api/companies/import?dry_run=maybe", "text/csv", "name\n", http.StatusBadRequest, "invalid 'dry_run': it must be true or false"},
+		{http.MethodPost, "/api/companies", "text/plain", "Globex", http.StatusUnsupportedMediaType, `unsupported Content-Type "text/plain", the body must be application/json`},
+		{http.MethodPost, "/api/companies", "application/json", ``, http.StatusBadRequest, "invalid body: it is required"},
+		{http.MethodPost, "/api/companies", "application/json", `{"name":"Globex"} {}`, http.StatusBadRequest, "invalid body: it must hold a single JSON value"},
+		{http.MethodPost, "/api/companies", "application/json", `[]`, http.StatusBadRequest, "invalid body: it must be a JSON object"},
+		{http.MethodPost, "/api/companies", "application/json", `{"name":"Globex","employees":10,"registered":true}`, http.StatusBadRequest, "invalid 'type': it is required"},
+		{http.MethodPost, "/api/companies", "application/json", `{"name":"Globex","employees":"10","registered":true,"type":"Corporations"}`, http.StatusBadRequest, "invalid 'employees': it must be an integer"},
+		{http.MethodPost, "/api/companies", "application/json", `{"name":"Globex","employees":1.5,"registered":true,"type":"Corporations"}`, http.StatusBadRequest, "invalid 'employees': it must be an integer"},
+		{http.MethodPost, "/api/companies", "application/json", `{"name":"Globex Corporation","employees":10,"registered":true,"type":"Corporations"}`, http.StatusBadRequest, "invalid 'name': it must be at most 15 characters"},
+		{http.MethodPost, "/api/companies", "application/json", `{"name":"Globex","employees":10,"registered":false,"type":"Corporations"}`, http.StatusBadRequest, "invalid 'registered': it must be true"},
+		{http.MethodPost, "/api/companies", "application/json", `{"name":"Globex","employees":10,"registered":true,"type":"Corporations","website":"globex.com"}`, http.StatusBadRequest, "unknown field 'website'"},
+		{http.MethodPatch, "/api/companies/" + acme, "application/json", `{"updated_at":"2020-01-01T00:00:00Z"}`, http.StatusBadRequest, "'updated_at' cannot be changed"},
+		{http.MethodPost, "/api/companies/batch", "application/json", `{"operations":[{"op":"delete","id":"x","fields":{},"extra":1}]}`, http.StatusBadRequest, "unknown field 'operations[0].extra'"},
+		{http.MethodPost, "/api/jobs", "application/json", `{"type":"companies.purge","params":{}}`, http.StatusBadRequest, "invalid 'type': it must be one of 'companies.import', 'companies.export', 'companies.reindex'"},
+	} {
+		rr := client.send(tt.method, tt.target, tt.contentType, tt.body)
+		assert.Equal(t, tt.code, rr.Code, "%s %s %s", tt.method, tt.target, tt.body)
+		assert.Equal(t, tt.message, errorMessage(t, rr), "%s %s %s", tt.method, tt.target, tt.body)
+	}
+	assert.Empty(t, producer.Events())
+}
+
+// The responses of the main flows match the document, ValidateResponses would turn them into 500s otherwise
+func TestValidationAcceptsResponses(t *testing.T) {
+	app, _ := newImportApp(t)
+	client := newAPIClient(t, app)
+	acme := acmeID(t, app)
+
+	for _, tt := range []struct {
+		method, target, contentType, body string
+		code                              int
+	}{
+		{http.MethodPost, "/api/companies", "", `{"name":"Globex","employees":10,"registered":true,"type":"Corporations"}`, http.StatusCreated},
+		{http.MethodGet, "/api/companies/" + acme, "", "", http.StatusOK},
+		{http.MethodGet, "/api/companies/" + acme + "?fields=name", "", "", http.StatusOK},
+		{http.MethodPost, "/api/companies/import?dry_run=true", "text/csv", "name,employees,registered,type\nInitech,3,true,Corporations\n", http.StatusOK},
+		{http.MethodPost, "/api/companies/batch", "application/json", `{"operations":[{"op":"update","id":"` + acme + `","fields":{"employees":6}},{"op":"create","company":{"name":"Hooli","employees":1,"registered":true,"type":"Corporations"}}]}`, http.StatusOK},
+		{http.MethodPost, "/api/companies/batch", "application/json", `{"operations":[{"op":"create","company":{"name":"Acme","employees":1,"registered":true,"type":"Corporations"}}]}`, http.StatusConflict},
+		{http.MethodGet, "/api/companies/export?format=ndjson", "", "", http.StatusOK},
+		{http.MethodPatch, "/api/companies/" + acme, controllers.MergePatchType, `{"description":null}`, http.StatusOK},
+		{http.MethodDelete, "/api/companies/" + acme, "", "", http.StatusNoContent},
+		{http.MethodGet, "/api/companies/" + acme, "", "", http.StatusNotFound},
+		{http.MethodGet, "/api/openapi.json", "", "", http.StatusOK},
+	} {
+		rr := client.send(tt.method, tt.target, tt.contentType, tt.body)
+		assert.Equal(t, tt.code, rr.Code, "%s %s: %s", tt.method, tt.target, rr.Body.String())
+	}
+}
+
+func TestValidationCatchesContractDrift(t *testing.T) {
+	app, _ := newImportApp(t)
+	router := mux.NewRouter()
+	router.Use(app.ValidationMiddleware())
+	router.HandleFunc("/api/companies/{id}", func(w http.ResponseWriter, r *http.Request) {
+		utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{"id": mux.Vars(r)["id"], "employees": "many"})
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/api/companies/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}).Methods(http.MethodDelete)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/companies/"+acmeID(t, app), nil))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, errorMessage(t, rr), "invalid 'employees': it must be an integer")
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api/companies/"+acmeID(t, app), nil))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, errorMessage(t, rr), "The response 202 of deleteCompany does not match the OpenAPI document: the status is not documented")
+
+	// Outside of the tests the responses are sent as they are
+	app.Config.ValidateResponses = false
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api/companies/"+acmeID(t, app), nil))
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+}
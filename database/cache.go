@@ -0,0 +1,353 @@
+package database
+
+import (
+	"company-service/models"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+// CacheOptions configures a CachedDatabase
+type CacheOptions struct {
+	// Size is the maximum number of entries, the least recently used ones are evicted first
+	Size int
+	// TTL is how long a company stays cached
+	TTL time.Duration
+	// NegativeTTL is how long a missing company is remembered, zero disables negative caching
+	NegativeTTL time.Duration
+}
+
+// CacheStats holds the counters of a CachedDatabase
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+}
+
+// CachedDatabase is a Database decorator that serves GetCompany from a bounded LRU cache
+// with a TTL. Concurrent misses for the same ID share a single database query.
+// Entries are dropped on every write going through the decorator and by Invalidate,
+// which is called for every company event (see kafka.NewInvalidatingProducer).
+type CachedDatabase struct {
+	Database
+	options CacheOptions
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// loads tracks the IDs being loaded, an invalidation only discards the loads of its ID
+	loads map[string]*cacheLoad
+	group singleflight.Group
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// cacheEntry is a cached lookup, a nil company remembers that the ID does not exist
+type cacheEntry struct {
+	id      string
+	company *models.Company
+	expires time.Time
+}
+
+// cacheLoad counts the loads of an ID in flight, and its invalidations since they started
+type cacheLoad struct {
+	count      int
+	generation uint64
+}
+
+// NewCachedDatabase wraps the database with a read-through cache
+func NewCachedDatabase(db Database, options CacheOptions) *CachedDatabase {
+	return &CachedDatabase{
+		Database: db,
+		options:  options,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		loads:    make(map[string]*cacheLoad),
+	}
+}
+
+// GetCompany returns the company from the cache, loading it from the database on a miss
+func (c *CachedDatabase) GetCompany(ctx context.Context, id string) (*models.Company, error) {
+	if entry, ok := c.get(id); ok {
+		c.hits.Add(1)
+		if entry.company == nil {
+			return nil, notFound(id)
+		}
+		return copyCompany(entry.company), nil
+	}
+	c.misses.Add(1)
+
+	generation := c.startLoad(id)
+	defer c.endLoad(id)
+	result, err, _ := c.group.Do(id, func() (interface{}, error) {
+		company, err := c.Database.GetCompany(ctx, id)
+		switch {
+		case err == nil:
+			c.put(id, company, c.options.TTL, generation)
+		case errors.Is(err, gorm.ErrRecordNotFound) && c.options.NegativeTTL > 0:
+			c.put(id, nil, c.options.NegativeTTL, generation)
+		}
+		return company, err
+	})
+	if err != nil {
+		// The shared query ran with the context of another caller, retry with ours if it was cancelled
+		if (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) && ctx.Err() == nil {
+			return c.Database.GetCompany(ctx, id)
+		}
+		return nil, err
+	}
+	return copyCompany(result.(*models.Company)), nil
+}
+
+// GetCompanyFields serves the whole company from the cache when it is there. Otherwise the
+// partial company is read from the database and not cached.
+func (c *CachedDatabase) GetCompanyFields(ctx context.Context, id string, columns []string) (*models.Company, error) {
+	if entry, ok := c.get(id); ok {
+		c.hits.Add(1)
+		if entry.company == nil {
+			return nil, notFound(id)
+		}
+		return copyCompany(entry.company), nil
+	}
+	c.misses.Add(1)
+	return c.Database.GetCompanyFields(ctx, id, columns)
+}
+
+// GetCompanies serves the cached companies and reads the others in a single query, caching them
+func (c *CachedDatabase) GetCompanies(ctx context.Context, ids []string) ([]models.Company, error) {
+	companies := make([]models.Company, 0, len(ids))
+	var missing []string
+	for _, id := range ids {
+		entry, ok := c.get(id)
+		switch {
+		case !ok:
+			missing = append(missing, id)
+		case entry.company != nil:
+			companies = append(companies, *entry.company)
+		}
+	}
+	c.hits.Add(uint64(len(ids) - len(missing)))
+	if len(missing) == 0 {
+		return companies, nil
+	}
+	c.misses.Add(uint64(len(missing)))
+
+	generations := make(map[string]uint64, len(missing))
+	for _, id := range missing {
+		generations[id] = c.startLoad(id)
+		defer c.endLoad(id)
+	}
+	loaded, err := c.Database.GetCompanies(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for i := range loaded {
+		id := loaded[i].ID.String()
+		c.put(id, &loaded[i], c.options.TTL, generations[id])
+	}
+	return append(companies, loaded...), nil
+}
+
+// CreateCompany creates the company and drops a negative entry for its ID
+func (c *CachedDatabase) CreateCompany(ctx context.Context, company *models.Company) error {
+	err := c.Database.CreateCompany(ctx, company)
+	c.Invalidate(company.ID.String())
+	return err
+}
+
+// UpdateCompany updates the company and drops it from the cache
+func (c *CachedDatabase) UpdateCompany(ctx context.Context, id string, fields map[string]interface{}) (*models.Company, error) {
+	company, err := c.Database.UpdateCompany(ctx, id, fields)
+	c.Invalidate(id)
+	return company, err
+}
+
+// DeleteCompany deletes the company and drops it from the cache
+func (c *CachedDatabase) DeleteCompany(ctx context.Context, id string) error {
+	err := c.Database.DeleteCompany(ctx, id)
+	c.Invalidate(id)
+	return err
+}
+
+// CreateCompanies creates the companies and drops the negative entries of their IDs
+func (c *CachedDatabase) CreateCompanies(ctx context.Context, companies []*models.Company) error {
+	err := c.Database.CreateCompanies(ctx, companies)
+	for _, company := range companies {
+		c.Invalidate(company.ID.String())
+	}
+	return err
+}
+
+// Transaction runs fn in a transaction of the wrapped database, so its reads bypass the cache.
+// The companies written by fn, nested transactions included, are dropped from the cache once
+// the transaction is over.
+func (c *CachedDatabase) Transaction(ctx context.Context, fn func(tx Database) error) error {
+	var ids []string
+	err := c.Database.Transaction(ctx, func(tx Database) error {
+		return fn(&companyWrites{Database: tx, ids: &ids})
+	})
+	for _, id := range ids {
+		c.Invalidate(id)
+	}
+	return err
+}
+
+// companyWrites records the IDs of the companies written in a transaction, the writes of its
+// nested transactions go to the same list
+type companyWrites struct {
+	Database
+	ids *[]string
+}
+
+func (w *companyWrites) CreateCompany(ctx context.Context, company *models.Company) error {
+	*w.ids = append(*w.ids, company.ID.String())
+	return w.Database.CreateCompany(ctx, company)
+}
+
+func (w *companyWrites) CreateCompanies(ctx context.Context, companies []*models.Company) error {
+	for _, company := range companies {
+		*w.ids = append(*w.ids, company.ID.String())
+	}
+	return w.Database.CreateCompanies(ctx, companies)
+}
+
+func (w *companyWrites) UpdateCompany(ctx context.Context, id string, fields map[string]interface{}) (*models.Company, error) {
+	*w.ids = append(*w.ids, id)
+	return w.Database.UpdateCompany(ctx, id, fields)
+}
+
+func (w *companyWrites) DeleteCompany(ctx context.Context, id string) error {
+	*w.ids = append(*w.ids, id)
+	return w.Database.DeleteCompany(ctx, id)
+}
+
+func (w *companyWrites) Transaction(ctx context.Context, fn func(tx Database) error) error {
+	return w.Database.Transaction(ctx, func(tx Database) error {
+		return fn(&companyWrites{Database: tx, ids: w.ids})
+	})
+}
+
+// Invalidate drops the cached entry of the company with the given ID
+func (c *CachedDatabase) Invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The loads of the ID that started before the invalidation must not store their possibly
+	// stale result, the loads of the other IDs are not affected
+	if load, ok := c.loads[id]; ok {
+		load.generation++
+	}
+	c.group.Forget(id)
+	if element, ok := c.entries[id]; ok {
+		c.lru.Remove(element)
+		delete(c.entries, id)
+	}
+}
+
+// Stats returns the cache counters
+func (c *CachedDatabase) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   entries,
+	}
+}
+
+// get returns the live entry for the ID and marks it as recently used
+func (c *CachedDatabase) get(id string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[id]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(element)
+		delete(c.entries, id)
+		return cacheEntry{}, false
+	}
+	c.lru.MoveToFront(element)
+	return *entry, true
+}
+
+// startLoad registers a load of the ID and returns its generation, endLoad must follow
+func (c *CachedDatabase) startLoad(id string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	load, ok := c.loads[id]
+	if !ok {
+		load = &cacheLoad{}
+		c.loads[id] = load
+	}
+	load.count++
+	return load.generation
+}
+
+// endLoad forgets a load of the ID once it is over
+func (c *CachedDatabase) endLoad(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	load := c.loads[id]
+	if load.count--; load.count == 0 {
+		delete(c.loads, id)
+	}
+}
+
+// put stores an entry unless the ID was invalidated since its load, of the given generation,
+// started
+func (c *CachedDatabase) put(id string, company *models.Company, ttl time.Duration, generation uint64) {
+	if c.options.Size <= 0 || ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if load, ok := c.loads[id]; !ok || load.generation != generation {
+		return
+	}
+	entry := &cacheEntry{id: id, company: copyCompany(company), expires: time.Now().Add(ttl)}
+	if element, ok := c.entries[id]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[id] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.options.Size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).id)
+		c.evictions.Add(1)
+	}
+}
+
+// notFound returns the error the wrapped databases return for a missing company
+func notFound(id string) error {
+	return fmt.Errorf("company with ID %s not found: %w", id, gorm.ErrRecordNotFound)
+}
+
+// copyCompany returns a copy so callers cannot modify the cached value
+func copyCompany(company *models.Company) *models.Company {
+	if company == nil {
+		return nil
+	}
+	copied := *company
+	return &copied
+}
@@ -0,0 +1,273 @@
+package database_test
+
+import (
+	"company-service/config"
+	"company-service/database"
+	"company-service/kafka"
+	"company-service/models"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// countingDatabase counts the GetCompany calls reaching the wrapped database
+type countingDatabase struct {
+	database.Database
+	calls atomic.Int64
+	delay time.Duration
+}
+
+func (c *countingDatabase) GetCompany(ctx context.Context, id string) (*models.Company, error) {
+	c.calls.Add(1)
+	time.Sleep(c.delay)
+	return c.Database.GetCompany(ctx, id)
+}
+
+func newCachedDatabase(t *testing.T, options database.CacheOptions) (*database.CachedDatabase, *countingDatabase) {
+	memory, err := database.NewMemoryDatabase(&config.Config{User: "admin", Password: "admin-password"})
+	require.NoError(t, err)
+	counting := &countingDatabase{Database: memory}
+	return database.NewCachedDatabase(counting, options), counting
+}
+
+func TestCachedDatabase(t *testing.T) {
+	ctx := context.Background()
+	options := database.CacheOptions{Size: 2, TTL: time.Minute, NegativeTTL: time.Minute}
+
+	t.Run("Hits and misses", func(t *testing.T) {
+		cache, counting := newCachedDatabase(t, options)
+		company := newCompany()
+		require.NoError(t, cache.CreateCompany(ctx, company))
+
+		for i := 0; i < 3; i++ {
+			got, err := cache.GetCompany(ctx, company.ID.String())
+			require.NoError(t, err)
+			assert.Equal(t, company.Name, got.Name)
+		}
+		assert.Equal(t, int64(1), counting.calls.Load())
+		stats := cache.Stats()
+		assert.Equal(t, uint64(2), stats.Hits)
+		assert.Equal(t, uint64(1), stats.Misses)
+	})
+
+	t.Run("Cached values cannot be modified by callers", func(t *testing.T) {
+		cache, _ := newCachedDatabase(t, options)
+		company := newCompany()
+		require.NoError(t, cache.CreateCompany(ctx, company))
+
+		got, err := cache.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+		got.Name = "changed"
+		got, err = cache.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, company.Name, got.Name)
+	})
+
+	t.Run("Batched reads are served from the cache", func(t *testing.T) {
+		cache, counting := newCachedDatabase(t, options)
+		cached, loaded := newCompany(), newCompany()
+		require.NoError(t, cache.CreateCompany(ctx, cached))
+		require.NoError(t, cache.CreateCompany(ctx, loaded))
+		_, err := cache.GetCompany(ctx, cached.ID.String())
+		require.NoError(t, err)
+
+		companies, err := cache.GetCompanies(ctx, []string{cached.ID.String(), loaded.ID.String()})
+		require.NoError(t, err)
+		assert.Len(t, companies, 2)
+		// The company read by the batch is cached too
+		_, err = cache.GetCompany(ctx, loaded.ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), counting.calls.Load())
+		assert.Equal(t, uint64(2), cache.Stats().Hits)
+	})
+
+	t.Run("Selected fields are served from the cache", func(t *testing.T) {
+		cache, counting := newCachedDatabase(t, options)
+		company := newCompany()
+		require.NoError(t, cache.CreateCompany(ctx, company))
+
+		// A partial company read on a miss is not cached
+		_, err := cache.GetCompanyFields(ctx, company.ID.String(), []string{"name"})
+		require.NoError(t, err)
+		_, err = cache.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), counting.calls.Load())
+
+		got, err := cache.GetCompanyFields(ctx, company.ID.String(), []string{"name"})
+		require.NoError(t, err)
+		assert.Equal(t, company.Name, got.Name)
+		assert.Equal(t, uint64(1), cache.Stats().Hits)
+	})
+
+	t.Run("Negative caching", func(t *testing.T) {
+		cache, counting := newCachedDatabase(t, options)
+		id := uuid.NewString()
+
+		for i := 0; i < 2; i++ {
+			_, err := cache.GetCompany(ctx, id)
+			assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+		}
+		assert.Equal(t, int64(1), counting.calls.Load())
+	})
+
+	t.Run("Writes invalidate", func(t *testing.T) {
+		cache, _ := newCachedDatabase(t, options)
+		company := newCompany()
+
+		// A cached miss is dropped once the company is created
+		_, err := cache.GetCompany(ctx, company.ID.String())
+		require.Error(t, err)
+		require.NoError(t, cache.CreateCompany(ctx, company))
+		_, err = cache.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+
+		_, err = cache.UpdateCompany(ctx, company.ID.String(), map[string]interface{}{"employees": 99})
+		require.NoError(t, err)
+		got, err := cache.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, 99, got.Employees)
+
+		require.NoError(t, cache.DeleteCompany(ctx, company.ID.String()))
+		_, err = cache.GetCompany(ctx, company.ID.String())
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+
+	t.Run("Transactions invalidate", func(t *testing.T) {
+		cache, _ := newCachedDatabase(t, options)
+		company := newCompany()
+		require.NoError(t, cache.CreateCompany(ctx, company))
+		_, err := cache.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+
+		err = cache.Transaction(ctx, func(tx database.Database) error {
+			_, err := tx.UpdateCompany(ctx, company.ID.String(), map[string]interface{}{"employees": 99})
+			return err
+		})
+		require.NoError(t, err)
+		got, err := cache.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, 99, got.Employees)
+	})
+
+	t.Run("Nested transactions and bulk creations invalidate", func(t *testing.T) {
+		cache, _ := newCachedDatabase(t, options)
+		nested, imported := newCompany(), newCompany()
+		// Cached misses, which must not outlive the creations
+		for _, company := range []*models.Company{nested, imported} {
+			_, err := cache.GetCompany(ctx, company.ID.String())
+			require.Error(t, err)
+		}
+
+		err := cache.Transaction(ctx, func(tx database.Database) error {
+			if err := tx.Transaction(ctx, func(tx database.Database) error {
+				return tx.CreateCompany(ctx, nested)
+			}); err != nil {
+				return err
+			}
+			return tx.CreateCompanies(ctx, []*models.Company{imported})
+		})
+		require.NoError(t, err)
+		for _, company := range []*models.Company{nested, imported} {
+			_, err := cache.GetCompany(ctx, company.ID.String())
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("Invalidations only discard the loads of their ID", func(t *testing.T) {
+		cache, counting := newCachedDatabase(t, options)
+		counting.delay = 50 * time.Millisecond
+		company := newCompany()
+		require.NoError(t, cache.CreateCompany(ctx, company))
+
+		load := func(invalidated string) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				_, err := cache.GetCompany(ctx, company.ID.String())
+				assert.NoError(t, err)
+			}()
+			time.Sleep(10 * time.Millisecond)
+			cache.Invalidate(invalidated)
+			<-done
+		}
+		// The load of the invalidated company may be stale, it is not cached
+		load(company.ID.String())
+		assert.Equal(t, 0, cache.Stats().Entries)
+		// The invalidation of another company leaves the load alone
+		load(uuid.NewString())
+		assert.Equal(t, 1, cache.Stats().Entries)
+		_, err := cache.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), counting.calls.Load())
+	})
+
+	t.Run("Produced events invalidate", func(t *testing.T) {
+		cache, counting := newCachedDatabase(t, options)
+		company := newCompany()
+		require.NoError(t, cache.CreateCompany(ctx, company))
+		_, err := cache.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+
+		producer := kafka.NewInvalidatingProducer(kafka.NewMemoryProducer(), cache)
+		require.NoError(t, producer.ProduceEvent(ctx, &kafka.EventMessage{EventType: "company_updated", Company: company}))
+		_, err = cache.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), counting.calls.Load())
+	})
+
+	t.Run("Least recently used entries are evicted", func(t *testing.T) {
+		cache, counting := newCachedDatabase(t, options)
+		companies := []*models.Company{newCompany(), newCompany(), newCompany()}
+		for _, company := range companies {
+			require.NoError(t, cache.CreateCompany(ctx, company))
+			_, err := cache.GetCompany(ctx, company.ID.String())
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 2, cache.Stats().Entries)
+		assert.Equal(t, uint64(1), cache.Stats().Evictions)
+
+		// The first company was evicted and is loaded again
+		_, err := cache.GetCompany(ctx, companies[0].ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), counting.calls.Load())
+	})
+
+	t.Run("Entries expire", func(t *testing.T) {
+		cache, counting := newCachedDatabase(t, database.CacheOptions{Size: 2, TTL: 10 * time.Millisecond})
+		company := newCompany()
+		require.NoError(t, cache.CreateCompany(ctx, company))
+		_, err := cache.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+		_, err = cache.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), counting.calls.Load())
+	})
+
+	t.Run("Concurrent misses share one query", func(t *testing.T) {
+		cache, counting := newCachedDatabase(t, options)
+		counting.delay = 50 * time.Millisecond
+		company := newCompany()
+		require.NoError(t, cache.CreateCompany(ctx, company))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := cache.GetCompany(ctx, company.ID.String())
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int64(1), counting.calls.Load())
+	})
+}
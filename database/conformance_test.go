@@ -0,0 +1,694 @@
+package database_test
+
+import (
+	"bytes"
+	"company-service/config"
+	"company-service/database"
+	"company-service/models"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const namePolicy = "nfkc,casefold,whitespace"
+
+// TestConformanceSQLite runs the conformance suite against a temporary SQLite file
+func TestConformanceSQLite(t *testing.T) {
+	conf := &config.Config{
+		DBDriver: "sqlite",
+		DBPath:   filepath.Join(t.TempDir(), "companies.db"),
+		User:     "admin",
+		Password: "admin-password",
+
+		NameNormalization: namePolicy,
+	}
+	runConformance(t, conf, openGorm(t, conf))
+}
+
+// TestReindexAfterPolicyChange reopens a SQLite file with a stricter name policy, under which
+// two existing names clash
+func TestReindexAfterPolicyChange(t *testing.T) {
+	ctx := context.Background()
+	conf := &config.Config{
+		DBDriver:          "sqlite",
+		DBPath:            filepath.Join(t.TempDir(), "companies.db"),
+		User:              "admin",
+		Password:          "admin-password",
+		NameNormalization: "whitespace",
+	}
+	db := openGorm(t, conf)
+	first, second := newCompany(), newCompany()
+	first.Name, second.Name = "Acme", "ACME"
+	require.NoError(t, db.CreateCompany(ctx, first))
+	require.NoError(t, db.CreateCompany(ctx, second))
+	require.NoError(t, db.Close())
+
+	conf.NameNormalization = "casefold"
+	db = openGorm(t, conf)
+	t.Cleanup(func() { db.Close() })
+	// An update that does not rename the company keeps its normalized name
+	_, err := db.UpdateCompany(ctx, second.ID.String(), map[string]interface{}{"employees": 3})
+	require.NoError(t, err)
+	changed, err := db.ReindexCompanyName(ctx, first.ID.String())
+	require.NoError(t, err)
+	assert.True(t, changed)
+	_, err = db.ReindexCompanyName(ctx, second.ID.String())
+	assert.True(t, errors.Is(err, database.ErrDuplicateName))
+	found, err := db.GetCompanyByName(ctx, "aCmE")
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, found.ID)
+}
+
+// TestSQLitePathQuery opens SQLite paths that have a query of their own, as a file: URI or as
+// the pragmas of the driver
+func TestSQLitePathQuery(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for path, file := range map[string]string{
+		"file:" + filepath.Join(dir, "uri.db") + "?mode=rwc":        "uri.db",
+		filepath.Join(dir, "wal.db") + "?_pragma=journal_mode(WAL)": "wal.db-wal",
+	} {
+		db := openGorm(t, &config.Config{DBDriver: "sqlite", DBPath: path, User: "admin", Password: "admin-password"})
+		require.NoError(t, db.CreateCompany(ctx, newCompany()), path)
+		assert.FileExists(t, filepath.Join(dir, file), path)
+		require.NoError(t, db.Close())
+	}
+}
+
+// TestConformanceMemory runs the conformance suite against the in-memory implementation
+func TestConformanceMemory(t *testing.T) {
+	conf := &config.Config{User: "admin", Password: "admin-password", NameNormalization: namePolicy}
+	db, err := database.NewMemoryDatabase(conf)
+	require.NoError(t, err)
+	runConformance(t, conf, db)
+}
+
+// TestConformanceMySQL runs the conformance suite against the MySQL server described by the DB_* variables
+func TestConformanceMySQL(t *testing.T) {
+	if os.Getenv("TEST_MYSQL") == "" {
+		t.Skip("set TEST_MYSQL=1 and the DB_* variables to run against MySQL")
+	}
+	conf := serverConfig("mysql")
+	runConformance(t, conf, openGorm(t, conf))
+}
+
+// TestConformancePostgres runs the conformance suite against the PostgreSQL server described by the DB_* variables
+func TestConformancePostgres(t *testing.T) {
+	if os.Getenv("TEST_POSTGRES") == "" {
+		t.Skip("set TEST_POSTGRES=1 and the DB_* variables to run against PostgreSQL")
+	}
+	conf := serverConfig("postgres")
+	runConformance(t, conf, openGorm(t, conf))
+}
+
+func serverConfig(driver string) *config.Config {
+	return &config.Config{
+		DBDriver:   driver,
+		DBUser:     os.Getenv("DB_USER"),
+		DBPassword: os.Getenv("DB_PASSWORD"),
+		DBName:     os.Getenv("DB_NAME"),
+		DBHost:     os.Getenv("DB_HOST"),
+		DBPort:     os.Getenv("DB_PORT"),
+		DBSSLMode:  "disable",
+		User:       "conformance",
+		Password:   "conformance-password",
+
+		NameNormalization: namePolicy,
+	}
+}
+
+func openGorm(t *testing.T, conf *config.Config) database.Database {
+	db, err := database.InitDB(conf)
+	require.NoError(t, err)
+	return db
+}
+
+// uniqueName returns a company name that fits the 15 character limit and does not clash between runs
+func uniqueName() string {
+	return "co-" + uuid.NewString()[:8]
+}
+
+func newCompany() *models.Company {
+	return &models.Company{
+		ID:          uuid.New(),
+		Name:        uniqueName(),
+		Description: "Conformance test company",
+		Employees:   10,
+		Registered:  true,
+		Type:        "Cooperative",
+	}
+}
+
+// runConformance checks that a Database backend behaves the way the controllers expect
+func runConformance(t *testing.T, conf *config.Config, db database.Database) {
+	ctx := context.Background()
+	t.Cleanup(func() { assert.NoError(t, db.Close()) })
+
+	t.Run("Default user is created with a hashed password", func(t *testing.T) {
+		user, err := db.GetUserByUsername(ctx, conf.User)
+		require.NoError(t, err)
+		assert.Equal(t, conf.User, user.Username)
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(conf.Password)))
+
+		// Creating it again must be a no-op
+		assert.NoError(t, db.CreateDefaultUser(ctx, conf))
+	})
+
+	t.Run("Unknown user", func(t *testing.T) {
+		_, err := db.GetUserByUsername(ctx, "missing-"+uuid.NewString())
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+
+	t.Run("Create, update and disable users", func(t *testing.T) {
+		username := "user-" + uuid.NewString()[:8]
+		require.NoError(t, db.CreateUser(ctx, &models.User{Username: username, Password: "hash"}))
+		assert.True(t, errors.Is(db.CreateUser(ctx, &models.User{Username: username, Password: "hash"}), database.ErrDuplicateUsername))
+
+		user, err := db.UpdateUser(ctx, username, map[string]interface{}{"password": "new-hash", "disabled": true})
+		require.NoError(t, err)
+		assert.True(t, user.Disabled)
+		user, err = db.GetUserByUsername(ctx, username)
+		require.NoError(t, err)
+		assert.Equal(t, "new-hash", user.Password)
+		assert.True(t, user.Disabled)
+
+		_, err = db.UpdateUser(ctx, "missing-"+uuid.NewString(), map[string]interface{}{"disabled": true})
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+
+	t.Run("List companies page by page", func(t *testing.T) {
+		created := map[uuid.UUID]bool{}
+		for i := 0; i < 3; i++ {
+			company := newCompany()
+			require.NoError(t, db.CreateCompany(ctx, company))
+			created[company.ID] = true
+		}
+
+		after := ""
+		for {
+			page, err := db.ListCompanies(ctx, after, 2)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page), 2)
+			if len(page) == 0 {
+				break
+			}
+			for _, company := range page {
+				assert.Greater(t, company.ID.String(), after)
+				after = company.ID.String()
+				delete(created, company.ID)
+			}
+		}
+		assert.Empty(t, created)
+	})
+
+	t.Run("Stream companies with a filter", func(t *testing.T) {
+		big, small := newCompany(), newCompany()
+		big.Employees, small.Employees = 100000, 100001
+		small.Registered = false
+		require.NoError(t, db.CreateCompany(ctx, big))
+		require.NoError(t, db.CreateCompany(ctx, small))
+
+		minEmployees, registered := 100000, true
+		var streamed []uuid.UUID
+		err := db.StreamCompanies(ctx, database.CompanyFilter{Type: "Cooperative", MinEmployees: &minEmployees, Registered: &registered},
+			func(company *models.Company) error {
+				streamed = append(streamed, company.ID)
+				return nil
+			})
+		require.NoError(t, err)
+		// Earlier runs against a server may have left matching companies
+		assert.Contains(t, streamed, big.ID)
+		assert.NotContains(t, streamed, small.ID)
+
+		// An error returned by fn stops the stream
+		stop := errors.New("stop")
+		calls := 0
+		err = db.StreamCompanies(ctx, database.CompanyFilter{}, func(company *models.Company) error {
+			calls++
+			return stop
+		})
+		assert.ErrorIs(t, err, stop)
+		assert.Equal(t, 1, calls)
+
+		// After resumes the listing past a company
+		streamed = nil
+		err = db.StreamCompanies(ctx, database.CompanyFilter{Type: "Cooperative", MinEmployees: &minEmployees, After: big.ID.String()},
+			func(company *models.Company) error {
+				streamed = append(streamed, company.ID)
+				return nil
+			})
+		require.NoError(t, err)
+		assert.NotContains(t, streamed, big.ID)
+		for _, id := range streamed {
+			assert.Greater(t, id.String(), big.ID.String())
+		}
+
+		// Limit stops the listing
+		calls = 0
+		err = db.StreamCompanies(ctx, database.CompanyFilter{Limit: 1}, func(company *models.Company) error {
+			calls++
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Get companies by ID", func(t *testing.T) {
+		first, second := newCompany(), newCompany()
+		require.NoError(t, db.CreateCompany(ctx, first))
+		require.NoError(t, db.CreateCompany(ctx, second))
+
+		companies, err := db.GetCompanies(ctx, []string{second.ID.String(), uuid.NewString(), first.ID.String(), first.ID.String()})
+		require.NoError(t, err)
+		var names []string
+		for _, company := range companies {
+			names = append(names, company.Name)
+		}
+		assert.ElementsMatch(t, []string{first.Name, second.Name}, names)
+
+		companies, err = db.GetCompanies(ctx, nil)
+		require.NoError(t, err)
+		assert.Empty(t, companies)
+	})
+
+	t.Run("Create and get company", func(t *testing.T) {
+		company := newCompany()
+		require.NoError(t, db.CreateCompany(ctx, company))
+
+		got, err := db.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, company.ID, got.ID)
+		assert.Equal(t, company.Name, got.Name)
+		assert.Equal(t, company.Description, got.Description)
+		assert.Equal(t, company.Employees, got.Employees)
+		assert.Equal(t, company.Registered, got.Registered)
+		assert.Equal(t, company.Type, got.Type)
+		assert.False(t, got.CreatedAt.IsZero())
+		assert.True(t, db.CheckIfExistsByName(ctx, company.Name))
+	})
+
+	t.Run("Get selected fields", func(t *testing.T) {
+		company := newCompany()
+		require.NoError(t, db.CreateCompany(ctx, company))
+
+		got, err := db.GetCompanyFields(ctx, company.ID.String(), []string{"name", "type"})
+		require.NoError(t, err)
+		assert.Equal(t, company.ID, got.ID)
+		assert.Equal(t, company.Name, got.Name)
+		assert.Equal(t, company.Type, got.Type)
+
+		_, err = db.GetCompanyFields(ctx, company.ID.String(), []string{"name", "normalized_name"})
+		assert.Error(t, err)
+		_, err = db.GetCompanyFields(ctx, uuid.NewString(), []string{"name"})
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+
+	t.Run("Duplicate name is rejected", func(t *testing.T) {
+		company := newCompany()
+		require.NoError(t, db.CreateCompany(ctx, company))
+
+		duplicate := newCompany()
+		duplicate.Name = company.Name
+		assert.True(t, errors.Is(db.CreateCompany(ctx, duplicate), database.ErrDuplicateName))
+	})
+
+	t.Run("Create companies together", func(t *testing.T) {
+		first, second := newCompany(), newCompany()
+		require.NoError(t, db.CreateCompanies(ctx, []*models.Company{first, second}))
+		got, err := db.GetCompanies(ctx, []string{first.ID.String(), second.ID.String()})
+		require.NoError(t, err)
+		assert.Len(t, got, 2)
+
+		// A taken name, or one repeated in the list, fails all of them
+		for _, taken := range []string{first.Name, "repeated"} {
+			fresh, duplicate := newCompany(), newCompany()
+			if taken == "repeated" {
+				duplicate.Name = fresh.Name
+			} else {
+				duplicate.Name = taken
+			}
+			err = db.CreateCompanies(ctx, []*models.Company{fresh, duplicate})
+			assert.True(t, errors.Is(err, database.ErrDuplicateName), err)
+			_, err = db.GetCompany(ctx, fresh.ID.String())
+			assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+		}
+	})
+
+	t.Run("Duplicate ID is rejected", func(t *testing.T) {
+		company := newCompany()
+		require.NoError(t, db.CreateCompany(ctx, company))
+
+		duplicate := newCompany()
+		duplicate.ID = company.ID
+		err := db.Transaction(ctx, func(tx database.Database) error {
+			err := tx.CreateCompany(ctx, duplicate)
+			assert.True(t, errors.Is(err, database.ErrDuplicateID), err)
+			// The transaction is still usable after the conflict
+			_, err = tx.GetCompany(ctx, company.ID.String())
+			return err
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("Get company for update", func(t *testing.T) {
+		company := newCompany()
+		require.NoError(t, db.CreateCompany(ctx, company))
+		err := db.Transaction(ctx, func(tx database.Database) error {
+			got, err := tx.GetCompanyForUpdate(ctx, company.ID.String())
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, company.Name, got.Name)
+			_, err = tx.GetCompanyForUpdate(ctx, uuid.NewString())
+			assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+			_, err = tx.UpdateCompany(ctx, company.ID.String(), map[string]interface{}{"employees": 8})
+			return err
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("Names are compared after normalisation", func(t *testing.T) {
+		company := newCompany()
+		company.Name = "Ac " + uuid.NewString()[:8]
+		require.NoError(t, db.CreateCompany(ctx, company))
+
+		for _, variant := range []string{
+			strings.ToUpper(company.Name),
+			" " + strings.Replace(company.Name, " ", "   ", 1) + " ",
+			"Ａｃ" + company.Name[2:], // Full-width letters
+		} {
+			duplicate := newCompany()
+			duplicate.Name = variant
+			assert.True(t, errors.Is(db.CreateCompany(ctx, duplicate), database.ErrDuplicateName), variant)
+			assert.True(t, db.CheckIfExistsByName(ctx, variant), variant)
+			byName, err := db.GetCompanyByName(ctx, variant)
+			require.NoError(t, err, variant)
+			assert.Equal(t, company.ID, byName.ID, variant)
+		}
+
+		other := newCompany()
+		require.NoError(t, db.CreateCompany(ctx, other))
+		_, err := db.UpdateCompany(ctx, other.ID.String(), map[string]interface{}{"name": strings.ToLower(company.Name)})
+		assert.True(t, errors.Is(err, database.ErrDuplicateName))
+
+		// Renaming a company to a variant of its own name is allowed
+		_, err = db.UpdateCompany(ctx, company.ID.String(), map[string]interface{}{"name": strings.ToUpper(company.Name)})
+		assert.NoError(t, err)
+	})
+
+	t.Run("Normalised name longer than the name", func(t *testing.T) {
+		// Each U+FDFA is 3 bytes and expands to 18 characters under NFKC
+		company := newCompany()
+		company.Name = strings.Repeat("\uFDFA", 5)
+		require.NoError(t, db.CreateCompany(ctx, company))
+		byName, err := db.GetCompanyByName(ctx, company.Name)
+		require.NoError(t, err)
+		assert.Equal(t, company.ID, byName.ID)
+		require.NoError(t, db.DeleteCompany(ctx, company.ID.String()))
+	})
+
+	t.Run("Invalid type is rejected", func(t *testing.T) {
+		company := newCompany()
+		company.Type = "Partnership"
+		assert.Error(t, db.CreateCompany(ctx, company))
+	})
+
+	t.Run("Missing company", func(t *testing.T) {
+		id := uuid.NewString()
+		_, err := db.GetCompany(ctx, id)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+		_, err = db.GetIfExistsByID(ctx, id)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+		_, err = db.UpdateCompany(ctx, id, map[string]interface{}{"employees": 3})
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+		assert.True(t, errors.Is(db.DeleteCompany(ctx, id), gorm.ErrRecordNotFound))
+		assert.False(t, db.CheckIfExistsByName(ctx, uniqueName()))
+		_, err = db.GetCompanyByName(ctx, uniqueName())
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+
+	t.Run("Update company", func(t *testing.T) {
+		company := newCompany()
+		require.NoError(t, db.CreateCompany(ctx, company))
+
+		newName := uniqueName()
+		updated, err := db.UpdateCompany(ctx, company.ID.String(), map[string]interface{}{
+			"name":      newName,
+			"employees": 42,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, newName, updated.Name)
+		assert.Equal(t, 42, updated.Employees)
+		assert.Equal(t, company.Description, updated.Description)
+
+		got, err := db.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, newName, got.Name)
+		assert.Equal(t, 42, got.Employees)
+		assert.False(t, db.CheckIfExistsByName(ctx, company.Name))
+	})
+
+	t.Run("Transaction commits", func(t *testing.T) {
+		kept, removed := newCompany(), newCompany()
+		require.NoError(t, db.CreateCompany(ctx, removed))
+		err := db.Transaction(ctx, func(tx database.Database) error {
+			if err := tx.CreateCompany(ctx, kept); err != nil {
+				return err
+			}
+			// The writes of the transaction are visible inside it
+			if _, err := tx.UpdateCompany(ctx, kept.ID.String(), map[string]interface{}{"employees": 7}); err != nil {
+				return err
+			}
+			return tx.DeleteCompany(ctx, removed.ID.String())
+		})
+		require.NoError(t, err)
+
+		got, err := db.GetCompany(ctx, kept.ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, 7, got.Employees)
+		_, err = db.GetCompany(ctx, removed.ID.String())
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+
+	t.Run("Transaction rolls back", func(t *testing.T) {
+		existing, created := newCompany(), newCompany()
+		require.NoError(t, db.CreateCompany(ctx, existing))
+		err := db.Transaction(ctx, func(tx database.Database) error {
+			if err := tx.CreateCompany(ctx, created); err != nil {
+				return err
+			}
+			if _, err := tx.UpdateCompany(ctx, existing.ID.String(), map[string]interface{}{"employees": 7}); err != nil {
+				return err
+			}
+			duplicate := newCompany()
+			duplicate.Name = created.Name
+			return tx.CreateCompany(ctx, duplicate)
+		})
+		assert.True(t, errors.Is(err, database.ErrDuplicateName))
+
+		_, err = db.GetCompany(ctx, created.ID.String())
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+		assert.False(t, db.CheckIfExistsByName(ctx, created.Name))
+		got, err := db.GetCompany(ctx, existing.ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, existing.Employees, got.Employees)
+	})
+
+	t.Run("Job status transitions", func(t *testing.T) {
+		job := &models.Job{
+			ID:        uuid.New(),
+			Type:      "conformance",
+			Status:    models.JobQueued,
+			Params:    models.RawJSON(`{"size":3}`),
+			CreatedBy: conf.User,
+		}
+		require.NoError(t, db.CreateJob(ctx, job))
+
+		started := time.Now()
+		running, err := db.UpdateJob(ctx, job.ID.String(), []string{models.JobQueued}, map[string]interface{}{
+			"status":     models.JobRunning,
+			"started_at": started,
+			"progress":   1,
+			"total":      3,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, models.JobRunning, running.Status)
+		assert.Equal(t, 1, running.Progress)
+		require.NotNil(t, running.StartedAt)
+		assert.WithinDuration(t, started, *running.StartedAt, time.Second)
+
+		// A second claim of the same job fails and returns the current state
+		current, err := db.UpdateJob(ctx, job.ID.String(), []string{models.JobQueued}, map[string]interface{}{"status": models.JobRunning})
+		assert.True(t, errors.Is(err, database.ErrJobStatus))
+		require.NotNil(t, current)
+		assert.Equal(t, models.JobRunning, current.Status)
+
+		// Updating a column to its current value still succeeds
+		_, err = db.UpdateJob(ctx, job.ID.String(), []string{models.JobRunning}, map[string]interface{}{"progress": 1, "total": 3})
+		require.NoError(t, err)
+
+		ids := func(jobs []models.Job) []uuid.UUID {
+			var ids []uuid.UUID
+			for _, job := range jobs {
+				ids = append(ids, job.ID)
+			}
+			return ids
+		}
+		listed, err := db.ListJobs(ctx, models.JobRunning)
+		require.NoError(t, err)
+		assert.Contains(t, ids(listed), job.ID)
+
+		_, err = db.UpdateJob(ctx, job.ID.String(), []string{models.JobRunning}, map[string]interface{}{
+			"status":      models.JobSucceeded,
+			"progress":    3,
+			"result":      models.RawJSON(`{"rows":3}`),
+			"finished_at": time.Now(),
+		})
+		require.NoError(t, err)
+		got, err := db.GetJob(ctx, job.ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, models.JobSucceeded, got.Status)
+		assert.JSONEq(t, `{"size":3}`, string(got.Params))
+		assert.JSONEq(t, `{"rows":3}`, string(got.Result))
+		assert.True(t, got.Finished())
+
+		listed, err = db.ListJobs(ctx, models.JobRunning, models.JobQueued)
+		require.NoError(t, err)
+		assert.NotContains(t, ids(listed), job.ID)
+
+		_, err = db.GetJob(ctx, uuid.NewString())
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+		_, err = db.UpdateJob(ctx, uuid.NewString(), []string{models.JobQueued}, map[string]interface{}{"status": models.JobRunning})
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+
+	t.Run("Job leases", func(t *testing.T) {
+		live := time.Now().Add(time.Hour)
+		expired := time.Now().Add(-time.Minute)
+		jobs := map[string]*models.Job{}
+		for name, lease := range map[string]*time.Time{"live": &live, "expired": &expired, "none": nil} {
+			jobs[name] = &models.Job{ID: uuid.New(), Type: "conformance", Status: models.JobQueued}
+			require.NoError(t, db.CreateJob(ctx, jobs[name]))
+			fields := map[string]interface{}{"status": models.JobRunning, "owner": "runner-" + name, "lease_expires_at": nil}
+			if lease != nil {
+				fields["lease_expires_at"] = *lease
+			}
+			_, err := db.UpdateJob(ctx, jobs[name].ID.String(), []string{models.JobQueued}, fields)
+			require.NoError(t, err)
+		}
+
+		// Only the owner updates a running job
+		_, err := db.UpdateRunningJob(ctx, jobs["live"].ID.String(), "runner-live", map[string]interface{}{"progress": 2})
+		require.NoError(t, err)
+		current, err := db.UpdateRunningJob(ctx, jobs["live"].ID.String(), "runner-other", map[string]interface{}{"progress": 3})
+		assert.True(t, errors.Is(err, database.ErrJobStatus))
+		require.NotNil(t, current)
+		assert.Equal(t, 2, current.Progress)
+
+		requeued, err := db.RequeueExpiredJobs(ctx, time.Now())
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{jobs["expired"].ID.String(), jobs["none"].ID.String()}, requeued)
+		got, err := db.GetJob(ctx, jobs["expired"].ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, models.JobQueued, got.Status)
+		assert.Empty(t, got.Owner)
+		assert.Nil(t, got.LeaseExpiresAt)
+		got, err = db.GetJob(ctx, jobs["live"].ID.String())
+		require.NoError(t, err)
+		assert.Equal(t, models.JobRunning, got.Status)
+		require.NotNil(t, got.LeaseExpiresAt)
+		assert.WithinDuration(t, live, *got.LeaseExpiresAt, time.Second)
+
+		// A requeued job cannot be updated by its former owner
+		_, err = db.UpdateRunningJob(ctx, jobs["expired"].ID.String(), "runner-expired", map[string]interface{}{"status": models.JobSucceeded})
+		assert.True(t, errors.Is(err, database.ErrJobStatus))
+		for _, job := range jobs {
+			_, err = db.UpdateJob(ctx, job.ID.String(), nil, map[string]interface{}{"status": models.JobCancelled})
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("Job output", func(t *testing.T) {
+		id := uuid.NewString()
+		_, err := db.ReadJobOutput(ctx, id, io.Discard)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+
+		// Larger than a chunk of the SQL databases
+		data := bytes.Repeat([]byte("0123456789abcdef"), 150000)
+		written, err := db.WriteJobOutput(ctx, id, bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), written)
+		var read bytes.Buffer
+		n, err := db.ReadJobOutput(ctx, id, &read)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), n)
+		assert.True(t, bytes.Equal(data, read.Bytes()))
+
+		// A second write replaces the file
+		_, err = db.WriteJobOutput(ctx, id, strings.NewReader("short"))
+		require.NoError(t, err)
+		read.Reset()
+		_, err = db.ReadJobOutput(ctx, id, &read)
+		require.NoError(t, err)
+		assert.Equal(t, "short", read.String())
+	})
+
+	t.Run("Reindex company name", func(t *testing.T) {
+		company := newCompany()
+		require.NoError(t, db.CreateCompany(ctx, company))
+		before, err := db.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+
+		// The policy did not change, so neither does the row
+		changed, err := db.ReindexCompanyName(ctx, company.ID.String())
+		require.NoError(t, err)
+		assert.False(t, changed)
+		after, err := db.GetCompany(ctx, company.ID.String())
+		require.NoError(t, err)
+		assert.True(t, before.UpdatedAt.Equal(after.UpdatedAt))
+
+		_, err = db.ReindexCompanyName(ctx, uuid.NewString())
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		require.NoError(t, db.Ping(ctx))
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		assert.Error(t, db.Ping(cancelled))
+	})
+
+	t.Run("Cancelled context", func(t *testing.T) {
+		company := newCompany()
+		require.NoError(t, db.CreateCompany(ctx, company))
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := db.GetCompany(cancelled, company.ID.String())
+		assert.True(t, errors.Is(err, context.Canceled))
+		_, err = db.UpdateCompany(cancelled, company.ID.String(), map[string]interface{}{"employees": 3})
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.True(t, errors.Is(db.CreateCompany(cancelled, newCompany()), context.Canceled))
+	})
+
+	t.Run("Delete company", func(t *testing.T) {
+		company := newCompany()
+		require.NoError(t, db.CreateCompany(ctx, company))
+		require.NoError(t, db.DeleteCompany(ctx, company.ID.String()))
+
+		_, err := db.GetCompany(ctx, company.ID.String())
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+		assert.False(t, db.CheckIfExistsByName(ctx, company.Name))
+	})
+}
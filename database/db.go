@@ -3,59 +3,233 @@ package database
 import (
 	"company-service/config"
 	"company-service/models"
+	"company-service/utils"
+	"context"
 	"errors"
 	"fmt"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 )
 
+// ErrDuplicateName is returned when a company name clashes with an existing one after normalisation
+var ErrDuplicateName = errors.New("the company with the same name already exists")
+
+// ErrDuplicateID is returned when a company is created with the ID of an existing one
+var ErrDuplicateID = errors.New("the company with the same ID already exists")
+
+// ErrDuplicateUsername is returned when a username is already taken
+var ErrDuplicateUsername = errors.New("the user with the same username already exists")
+
+// ErrJobStatus is returned when a job is not in one of the statuses an update requires
+var ErrJobStatus = errors.New("the job is not in the expected status")
+
+// CompanyColumns are the columns of the companies table that can be selected
+var CompanyColumns = []string{"id", "name", "description", "employees", "registered", "type", "created_at", "updated_at"}
+
+// CompanyFilter selects the companies of a listing or an export, the zero value selects all of them
+type CompanyFilter struct {
+	Type         string
+	Registered   *bool
+	MinEmployees *int
+	MaxEmployees *int
+	// After only selects the companies whose ID is greater, to resume a listing
+	After string
+	// Limit stops the listing after this many companies, zero lists them all
+	Limit int
+}
+
 type GormDatabase struct {
-	db *gorm.DB
+	db         *gorm.DB
+	normalizer utils.NameNormalizer
 }
 type Database interface {
-	GetUserByUsername(username string) (*models.User, error)
-	CreateCompany(company *models.Company) error
-	GetCompany(id string) (*models.Company, error)
-	UpdateCompany(id string, fields map[string]interface{}) (*models.Company, error)
-	DeleteCompany(id string) error
-	CreateDefaultUser(conf *config.Config) error
-	GetIfExistsByID(id string) (*models.Company, error)
-	CheckIfExistsByName(name string) bool
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	CreateCompany(ctx context.Context, company *models.Company) error
+	// CreateCompanies creates the companies with multi-row inserts, all of them or none. A name
+	// taken by an existing company or by another one of the list fails with ErrDuplicateName.
+	CreateCompanies(ctx context.Context, companies []*models.Company) error
+	GetCompany(ctx context.Context, id string) (*models.Company, error)
+	// GetCompanyForUpdate returns the company and locks its row until the end of the transaction,
+	// so that no other writer changes it between the read and the update. It is only meaningful
+	// on the tx of Transaction.
+	GetCompanyForUpdate(ctx context.Context, id string) (*models.Company, error)
+	UpdateCompany(ctx context.Context, id string, fields map[string]interface{}) (*models.Company, error)
+	DeleteCompany(ctx context.Context, id string) error
+	CreateDefaultUser(ctx context.Context, conf *config.Config) error
+	GetIfExistsByID(ctx context.Context, id string) (*models.Company, error)
+	CheckIfExistsByName(ctx context.Context, name string) bool
+	// GetCompanyFields returns the company with at least the given columns loaded, the database
+	// only reads those columns when it can
+	GetCompanyFields(ctx context.Context, id string, columns []string) (*models.Company, error)
+	// GetCompanyByName returns the company whose name matches after normalisation
+	GetCompanyByName(ctx context.Context, name string) (*models.Company, error)
+	// GetCompanies returns the existing companies among the given IDs in a single query, in no
+	// particular order. The unknown IDs are left out.
+	GetCompanies(ctx context.Context, ids []string) ([]models.Company, error)
+	// ListCompanies returns up to limit companies ordered by ID, starting after the given ID
+	ListCompanies(ctx context.Context, after string, limit int) ([]models.Company, error)
+	// StreamCompanies calls fn for every company matching the filter in ID order, reading them
+	// from a cursor. It stops at the first error returned by fn.
+	StreamCompanies(ctx context.Context, filter CompanyFilter, fn func(company *models.Company) error) error
+	CreateUser(ctx context.Context, user *models.User) error
+	// UpdateUser applies the given column values to the user, e.g. "password" or "disabled"
+	UpdateUser(ctx context.Context, username string, fields map[string]interface{}) (*models.User, error)
+	CreateJob(ctx context.Context, job *models.Job) error
+	GetJob(ctx context.Context, id string) (*models.Job, error)
+	// UpdateJob applies the column values to the job when its status is one of from, or in any
+	// status when from is empty. It returns ErrJobStatus when the job is in another status.
+	UpdateJob(ctx context.Context, id string, from []string, fields map[string]interface{}) (*models.Job, error)
+	// UpdateRunningJob applies the column values to the job when it is running and owned by
+	// owner. It returns ErrJobStatus when the job is over or was requeued.
+	UpdateRunningJob(ctx context.Context, id, owner string, fields map[string]interface{}) (*models.Job, error)
+	// RequeueExpiredJobs queues again the running jobs whose lease expired before now, their
+	// owner stopped without recording an outcome. It returns the IDs of the requeued jobs.
+	RequeueExpiredJobs(ctx context.Context, now time.Time) ([]string, error)
+	// ListJobs returns the jobs in the given statuses, oldest first
+	ListJobs(ctx context.Context, statuses ...string) ([]models.Job, error)
+	// WriteJobOutput stores the file written by a job, replacing the previous one, and returns its size
+	WriteJobOutput(ctx context.Context, id string, r io.Reader) (int64, error)
+	// ReadJobOutput copies the file written by a job to w. It returns a gorm.ErrRecordNotFound
+	// error when the job has no file.
+	ReadJobOutput(ctx context.Context, id string, w io.Writer) (int64, error)
+	// ReindexCompanyName derives the normalized name of the company again with the current
+	// policy and tells whether it changed. It returns ErrDuplicateName when the new normalized
+	// name clashes with another company, which is left unchanged.
+	ReindexCompanyName(ctx context.Context, id string) (bool, error)
+	// Transaction calls fn with a Database whose calls are committed together when fn returns
+	// nil and rolled back otherwise. fn must only use tx, and must not call Ping or Close on it.
+	Transaction(ctx context.Context, fn func(tx Database) error) error
+	// Ping checks that the database is reachable
+	Ping(ctx context.Context) error
 	Close() error
 }
 
-// InitDB initializes the database connection and runs migrations
+// InitDB initializes the database connection, runs migrations and creates the default admin user
 func InitDB(conf *config.Config) (*GormDatabase, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		conf.DBUser, conf.DBPassword, conf.DBHost, conf.DBPort, conf.DBName)
+	newDB, err := OpenDB(conf)
+	if err != nil {
+		return nil, err
+	}
+	if err = newDB.Migrate(); err != nil {
+		newDB.Close()
+		return nil, err
+	}
+	err = newDB.CreateDefaultUser(context.Background(), conf)
+	if err != nil {
+		newDB.Close()
+		return nil, fmt.Errorf("failed to create default admin user: %w", err)
+	}
 
-	// Open the database connection
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	return newDB, nil
+}
+
+// OpenDB opens the database connection without touching the schema
+func OpenDB(conf *config.Config) (*GormDatabase, error) {
+	dialector, err := openDialector(conf)
+	if err != nil {
+		return nil, err
+	}
+	normalizer, err := utils.NewNameNormalizer(conf.NameNormalization)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open the database connection, translating constraint violations into GORM errors
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true, Logger: newGormLogger()})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
+	return &GormDatabase{db: db, normalizer: normalizer}, nil
+}
 
-	// Run migrations
-	err = db.AutoMigrate(&models.Company{}, &models.User{})
+// Migrate creates or updates the tables and fills the columns added since the last migration
+func (g *GormDatabase) Migrate() error {
+	err := g.db.AutoMigrate(&models.Company{}, &models.User{}, &models.Job{}, &models.JobOutput{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to migrate the database: %w", err)
+		return fmt.Errorf("failed to migrate the database: %w", err)
 	}
-	newDB := &GormDatabase{db: db}
-	err = newDB.CreateDefaultUser(conf)
+	err = g.backfillNormalizedNames()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create default admin user: %w", err)
+		return fmt.Errorf("failed to normalize the existing company names: %w", err)
 	}
+	return nil
+}
 
-	return newDB, nil
+// openDialector returns the GORM dialector for the driver selected in the configuration
+func openDialector(conf *config.Config) (gorm.Dialector, error) {
+	switch conf.DBDriver {
+	case "", "mysql":
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+			conf.DBUser, conf.DBPassword, conf.DBHost, conf.DBPort, conf.DBName)
+		return mysql.Open(dsn), nil
+	case "postgres":
+		return postgres.Open(postgresDSN(conf)), nil
+	case "sqlite":
+		dsn, err := sqliteDSN(conf.DBPath)
+		if err != nil {
+			return nil, err
+		}
+		return sqlite.Open(dsn), nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", conf.DBDriver)
+	}
+}
+
+// postgresDSN returns the connection URL of the PostgreSQL server, the credentials and the
+// database name are escaped so they may contain any character
+func postgresDSN(conf *config.Config) string {
+	host := conf.DBHost
+	if conf.DBPort != "" {
+		host = net.JoinHostPort(conf.DBHost, conf.DBPort)
+	}
+	query := url.Values{"TimeZone": {"UTC"}}
+	if conf.DBSSLMode != "" {
+		query.Set("sslmode", conf.DBSSLMode)
+	}
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(conf.DBUser, conf.DBPassword),
+		Host:     host,
+		Path:     "/" + conf.DBName,
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
+
+// sqliteDSN adds the pragmas of the service to the path of the database, which may be a file: URI
+// and may have a query of its own. Foreign keys are off by default in SQLite, they are enabled to
+// match the other backends. The busy timeout makes the writers wait for a transaction to end
+// instead of failing. The pragmas of the path run after them, so they can override them.
+func sqliteDSN(path string) (string, error) {
+	name, rawQuery, _ := strings.Cut(path, "?")
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("invalid query in the sqlite path %q: %w", path, err)
+	}
+	query := url.Values{"_pragma": {"foreign_keys(1)", "busy_timeout(5000)"}}
+	for key, value := range values {
+		query[key] = append(query[key], value...)
+	}
+	return name + "?" + query.Encode(), nil
 }
 
 // CreateDefaultUser creates the default admin user if it does not exist
-func (g *GormDatabase) CreateDefaultUser(conf *config.Config) error {
+func (g *GormDatabase) CreateDefaultUser(ctx context.Context, conf *config.Config) error {
 	var user models.User
 
 	// Check if the admin user already exists
-	err := g.db.Where("username = ?", conf.User).First(&user).Error
+	err := g.db.WithContext(ctx).Where("username = ?", conf.User).First(&user).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		// Hash the password before saving it
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(conf.Password), bcrypt.DefaultCost)
@@ -69,7 +243,7 @@ func (g *GormDatabase) CreateDefaultUser(conf *config.Config) error {
 		}
 
 		// Create the admin user in the database
-		if err = g.db.Create(&defaultUser).Error; err != nil {
+		if err = g.db.WithContext(ctx).Create(&defaultUser).Error; err != nil {
 			return fmt.Errorf("could not create default admin user: %w ", err)
 		}
 	} else if err != nil {
@@ -80,26 +254,118 @@ func (g *GormDatabase) CreateDefaultUser(conf *config.Config) error {
 }
 
 // GetUserByUsername retrieves a user by their username from the database.
-func (g *GormDatabase) GetUserByUsername(username string) (*models.User, error) {
+func (g *GormDatabase) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
 	var user models.User
-	err := g.db.Where("username = ?", username).First(&user).Error
+	err := g.db.WithContext(ctx).Where("username = ?", username).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+// CreateUser creates a new user, the password must already be hashed
+func (g *GormDatabase) CreateUser(ctx context.Context, user *models.User) error {
+	if err := g.db.WithContext(ctx).Create(user).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrDuplicateUsername
+		}
+		return fmt.Errorf("could not create user: %w", err)
+	}
+	return nil
+}
+
+// UpdateUser updates the columns of the user with the given username
+func (g *GormDatabase) UpdateUser(ctx context.Context, username string, fields map[string]interface{}) (*models.User, error) {
+	user, err := g.GetUserByUsername(ctx, username)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("user %s not found: %w", username, err)
+		}
+		return nil, err
+	}
+	if err = g.db.WithContext(ctx).Model(user).Updates(fields).Error; err != nil {
+		return nil, fmt.Errorf("could not update user: %w", err)
+	}
+	return user, nil
+}
+
+// backfillNormalizedNames fills the normalized name of the rows created before the column existed
+func (g *GormDatabase) backfillNormalizedNames() error {
+	var companies []models.Company
+	if err := g.db.Select("id", "name").Where("normalized_name IS NULL").Find(&companies).Error; err != nil {
+		return err
+	}
+	for _, company := range companies {
+		err := g.db.Model(&models.Company{}).Where("id = ?", company.ID).
+			Update("normalized_name", g.normalizer.Normalize(company.Name)).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return fmt.Errorf("company %s: %w", company.ID, ErrDuplicateName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // CreateCompany creates a new company record in the database
-func (g *GormDatabase) CreateCompany(company *models.Company) error {
-	if err := g.db.Create(company).Error; err != nil {
-		return fmt.Errorf("could not create a new company record with error: %v", err)
+func (g *GormDatabase) CreateCompany(ctx context.Context, company *models.Company) error {
+	company.NormalizedName = g.normalizer.Normalize(company.Name)
+	// In a savepoint when called in a transaction, which stays usable to tell the keys apart
+	err := g.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return tx.Create(company).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return g.duplicateCompanyKey(ctx, company.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("could not create a new company record with error: %w", err)
 	}
 	return nil
 }
 
+// createBatchSize is the number of rows of a multi-row insert, well below the placeholder limits
+const createBatchSize = 500
+
+// CreateCompanies inserts the companies createBatchSize rows at a time, in a savepoint when
+// called in a transaction
+func (g *GormDatabase) CreateCompanies(ctx context.Context, companies []*models.Company) error {
+	if len(companies) == 0 {
+		return nil
+	}
+	for _, company := range companies {
+		company.NormalizedName = g.normalizer.Normalize(company.Name)
+	}
+	err := g.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(companies, createBatchSize).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrDuplicateName
+	}
+	if err != nil {
+		return fmt.Errorf("could not create the company records with error: %w", err)
+	}
+	return nil
+}
+
+// duplicateCompanyKey tells which unique key a company insert violated, its ID or its name
+func (g *GormDatabase) duplicateCompanyKey(ctx context.Context, id uuid.UUID) error {
+	// A locking read sees a row committed by a concurrent transaction, a snapshot read may not.
+	// FOR SHARE needs MySQL 8.0, the minimum version of the README.
+	err := g.db.WithContext(ctx).Clauses(clause.Locking{Strength: "SHARE"}).Select("id").Take(&models.Company{}, "id = ?", id).Error
+	switch {
+	case err == nil:
+		return ErrDuplicateID
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrDuplicateName
+	default:
+		return fmt.Errorf("could not create a new company record with error: %w", err)
+	}
+}
+
 // GetCompany retrieves a company by its ID from the database
-func (g *GormDatabase) GetCompany(id string) (*models.Company, error) {
-	company, err := g.GetIfExistsByID(id)
+func (g *GormDatabase) GetCompany(ctx context.Context, id string) (*models.Company, error) {
+	company, err := g.GetIfExistsByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -107,57 +373,369 @@ func (g *GormDatabase) GetCompany(id string) (*models.Company, error) {
 }
 
 // UpdateCompany updates a company record in the database
-func (g *GormDatabase) UpdateCompany(id string, updatedFeilds map[string]interface{}) (*models.Company, error) {
-	company, err := g.GetIfExistsByID(id)
+func (g *GormDatabase) UpdateCompany(ctx context.Context, id string, updatedFeilds map[string]interface{}) (*models.Company, error) {
+	company, err := g.GetIfExistsByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	if err = g.db.Model(&company).Updates(updatedFeilds).Error; err != nil {
-		return nil, fmt.Errorf("could not update company: %v", err)
+	if name, ok := updatedFeilds["name"].(string); ok {
+		// Copy the fields so the normalized name does not leak into the caller's map
+		fields := make(map[string]interface{}, len(updatedFeilds)+1)
+		for column, value := range updatedFeilds {
+			fields[column] = value
+		}
+		fields["normalized_name"] = g.normalizer.Normalize(name)
+		updatedFeilds = fields
+	}
+	if err = g.db.WithContext(ctx).Model(&company).Updates(updatedFeilds).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrDuplicateName
+		}
+		return nil, fmt.Errorf("could not update company: %w", err)
 	}
 	return company, nil
 }
 
 // DeleteCompany deletes a company record from the database
-func (g *GormDatabase) DeleteCompany(id string) error {
-	_, err := g.GetIfExistsByID(id)
+func (g *GormDatabase) DeleteCompany(ctx context.Context, id string) error {
+	_, err := g.GetIfExistsByID(ctx, id)
 	if err != nil {
 		return err
 	}
-	result := g.db.Delete(&models.Company{}, "id = ?", id)
+	result := g.db.WithContext(ctx).Delete(&models.Company{}, "id = ?", id)
 	if result.Error != nil {
-		return fmt.Errorf("could not delete company: %v", result.Error)
+		return fmt.Errorf("could not delete company: %w", result.Error)
 	}
 	return nil
 }
 
+// GetCompanyForUpdate reads the company with SELECT ... FOR UPDATE. SQLite has no row locks,
+// its writers are serialized by the database lock instead.
+func (g *GormDatabase) GetCompanyForUpdate(ctx context.Context, id string) (*models.Company, error) {
+	var company models.Company
+	err := g.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Take(&company, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, notFound(id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get company: %w", err)
+	}
+	return &company, nil
+}
+
 // GetIfExistsByID checks if a company record exists in the database by its ID
-func (g *GormDatabase) GetIfExistsByID(id string) (*models.Company, error) {
+func (g *GormDatabase) GetIfExistsByID(ctx context.Context, id string) (*models.Company, error) {
 	// Check if the record exists by ID
 	var company models.Company
-	if err := g.db.First(&company, "id = ?", id).Error; err != nil {
+	if err := g.db.WithContext(ctx).First(&company, "id = ?", id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			// Return a custom error if the record is not found
-			return nil, fmt.Errorf("company with ID %s not found", id)
+			// Return a custom error if the record is not found, keeping gorm.ErrRecordNotFound in the chain
+			return nil, fmt.Errorf("company with ID %s not found: %w", id, err)
 		}
 		// Return other errors (e.g., database issues)
-		return nil, fmt.Errorf("error checking company existence: %v", err)
+		return nil, fmt.Errorf("error checking company existence: %w", err)
 	}
 	// Return nil if the record exists
 	return &company, nil
 }
 
-// CheckIfExistsByName checks if a company record exists in the database by its name
-func (g *GormDatabase) CheckIfExistsByName(name string) bool {
-	// Check if the record exists by ID
+// GetCompanyFields selects the given columns of the company, and its ID
+func (g *GormDatabase) GetCompanyFields(ctx context.Context, id string, columns []string) (*models.Company, error) {
+	selected := []string{"id"}
+	for _, column := range columns {
+		if !slices.Contains(CompanyColumns, column) {
+			return nil, fmt.Errorf("unknown company column %q", column)
+		}
+		if !slices.Contains(selected, column) {
+			selected = append(selected, column)
+		}
+	}
+	var company models.Company
+	if err := g.db.WithContext(ctx).Select(selected).First(&company, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("company with ID %s not found: %w", id, err)
+		}
+		return nil, fmt.Errorf("error checking company existence: %w", err)
+	}
+	return &company, nil
+}
+
+// CheckIfExistsByName checks if a company record exists in the database by its normalized name
+func (g *GormDatabase) CheckIfExistsByName(ctx context.Context, name string) bool {
 	var company models.Company
-	if err := g.db.First(&company, "name = ?", name).Error; err == nil {
+	if err := g.db.WithContext(ctx).First(&company, "normalized_name = ?", g.normalizer.Normalize(name)).Error; err == nil {
 		return true
 	}
 	// Return false if the record does not exist
 	return false
 }
 
+// GetCompanyByName retrieves the company with the same normalized name
+func (g *GormDatabase) GetCompanyByName(ctx context.Context, name string) (*models.Company, error) {
+	var company models.Company
+	if err := g.db.WithContext(ctx).First(&company, "normalized_name = ?", g.normalizer.Normalize(name)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("company with name %s not found: %w", name, err)
+		}
+		return nil, fmt.Errorf("error checking company existence: %w", err)
+	}
+	return &company, nil
+}
+
+// ListCompanies returns a page of companies ordered by ID
+func (g *GormDatabase) ListCompanies(ctx context.Context, after string, limit int) ([]models.Company, error) {
+	var companies []models.Company
+	err := g.db.WithContext(ctx).Where("id > ?", after).Order("id").Limit(limit).Find(&companies).Error
+	if err != nil {
+		return nil, fmt.Errorf("could not list companies: %w", err)
+	}
+	return companies, nil
+}
+
+// GetCompanies retrieves the companies with the given IDs
+func (g *GormDatabase) GetCompanies(ctx context.Context, ids []string) ([]models.Company, error) {
+	var companies []models.Company
+	if len(ids) == 0 {
+		return companies, nil
+	}
+	if err := g.db.WithContext(ctx).Where("id IN ?", ids).Find(&companies).Error; err != nil {
+		return nil, fmt.Errorf("could not get companies: %w", err)
+	}
+	return companies, nil
+}
+
+// StreamCompanies reads the matching companies from a cursor, so they are never all in memory
+func (g *GormDatabase) StreamCompanies(ctx context.Context, filter CompanyFilter, fn func(company *models.Company) error) error {
+	query := g.db.WithContext(ctx).Model(&models.Company{})
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Registered != nil {
+		query = query.Where("registered = ?", *filter.Registered)
+	}
+	if filter.MinEmployees != nil {
+		query = query.Where("employees >= ?", *filter.MinEmployees)
+	}
+	if filter.MaxEmployees != nil {
+		query = query.Where("employees <= ?", *filter.MaxEmployees)
+	}
+	if filter.After != "" {
+		query = query.Where("id > ?", filter.After)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	rows, err := query.Order("id").Rows()
+	if err != nil {
+		return fmt.Errorf("could not list companies: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var company models.Company
+		if err := g.db.ScanRows(rows, &company); err != nil {
+			return fmt.Errorf("could not read company: %w", err)
+		}
+		if err := fn(&company); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not list companies: %w", err)
+	}
+	return nil
+}
+
+// CreateJob stores a new job
+func (g *GormDatabase) CreateJob(ctx context.Context, job *models.Job) error {
+	if err := g.db.WithContext(ctx).Create(job).Error; err != nil {
+		return fmt.Errorf("could not create job: %w", err)
+	}
+	return nil
+}
+
+// GetJob retrieves a job by its ID
+func (g *GormDatabase) GetJob(ctx context.Context, id string) (*models.Job, error) {
+	var job models.Job
+	if err := g.db.WithContext(ctx).First(&job, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("job with ID %s not found: %w", id, err)
+		}
+		return nil, fmt.Errorf("could not get job: %w", err)
+	}
+	return &job, nil
+}
+
+// UpdateJob updates the job in a single statement guarded by its status, so that two workers
+// cannot both claim a job and a finished job cannot be cancelled
+func (g *GormDatabase) UpdateJob(ctx context.Context, id string, from []string, fields map[string]interface{}) (*models.Job, error) {
+	query := g.db.WithContext(ctx).Model(&models.Job{}).Where("id = ?", id)
+	if len(from) > 0 {
+		query = query.Where("status IN ?", from)
+	}
+	result := query.Updates(fields)
+	if result.Error != nil {
+		return nil, fmt.Errorf("could not update job: %w", result.Error)
+	}
+	job, err := g.GetJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// MySQL reports no affected row when the values do not change, so the status is checked again
+	if result.RowsAffected == 0 && len(from) > 0 && !slices.Contains(from, job.Status) {
+		return job, fmt.Errorf("job %s is %s: %w", id, job.Status, ErrJobStatus)
+	}
+	return job, nil
+}
+
+// UpdateRunningJob updates the job in a single statement guarded by its status and owner, so a
+// runner that lost its lease cannot overwrite the job run by another one
+func (g *GormDatabase) UpdateRunningJob(ctx context.Context, id, owner string, fields map[string]interface{}) (*models.Job, error) {
+	result := g.db.WithContext(ctx).Model(&models.Job{}).
+		Where("id = ? AND status = ? AND owner = ?", id, models.JobRunning, owner).
+		Updates(fields)
+	if result.Error != nil {
+		return nil, fmt.Errorf("could not update job: %w", result.Error)
+	}
+	job, err := g.GetJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 && (job.Status != models.JobRunning || job.Owner != owner) {
+		return job, fmt.Errorf("job %s is %s for %q: %w", id, job.Status, job.Owner, ErrJobStatus)
+	}
+	return job, nil
+}
+
+// RequeueExpiredJobs queues the running jobs whose lease expired, or that have no lease
+func (g *GormDatabase) RequeueExpiredJobs(ctx context.Context, now time.Time) ([]string, error) {
+	expired := func(db *gorm.DB) *gorm.DB {
+		return db.Model(&models.Job{}).Where("status = ? AND (lease_expires_at IS NULL OR lease_expires_at < ?)", models.JobRunning, now)
+	}
+	var ids []string
+	if err := g.db.WithContext(ctx).Scopes(expired).Pluck("id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("could not list expired jobs: %w", err)
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+	// Guarded again, a lease may have been renewed in between
+	err := g.db.WithContext(ctx).Scopes(expired).Where("id IN ?", ids).
+		Updates(map[string]interface{}{"status": models.JobQueued, "owner": "", "lease_expires_at": nil}).Error
+	if err != nil {
+		return nil, fmt.Errorf("could not requeue jobs: %w", err)
+	}
+	return ids, nil
+}
+
+// ListJobs returns the jobs in the given statuses, oldest first
+func (g *GormDatabase) ListJobs(ctx context.Context, statuses ...string) ([]models.Job, error) {
+	var jobs []models.Job
+	err := g.db.WithContext(ctx).Where("status IN ?", statuses).Order("created_at").Find(&jobs).Error
+	if err != nil {
+		return nil, fmt.Errorf("could not list jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// jobOutputChunkSize is the size of the rows holding the file of a job
+const jobOutputChunkSize = 1 << 20
+
+// WriteJobOutput stores the file in chunks, in a transaction so that a failed write keeps the previous file
+func (g *GormDatabase) WriteJobOutput(ctx context.Context, id string, r io.Reader) (int64, error) {
+	jobID, err := uuid.Parse(id)
+	if err != nil {
+		return 0, fmt.Errorf("could not write job output: %w", err)
+	}
+	var written int64
+	err = g.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("job_id = ?", id).Delete(&models.JobOutput{}).Error; err != nil {
+			return err
+		}
+		chunk := make([]byte, jobOutputChunkSize)
+		for seq := 0; ; seq++ {
+			n, err := io.ReadFull(r, chunk)
+			if n > 0 {
+				if err := tx.Create(&models.JobOutput{JobID: jobID, Seq: seq, Data: chunk[:n]}).Error; err != nil {
+					return err
+				}
+				written += int64(n)
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not write job output: %w", err)
+	}
+	return written, nil
+}
+
+// ReadJobOutput reads the file one chunk at a time
+func (g *GormDatabase) ReadJobOutput(ctx context.Context, id string, w io.Writer) (int64, error) {
+	var written int64
+	for seq := 0; ; seq++ {
+		var chunk models.JobOutput
+		err := g.db.WithContext(ctx).Where("job_id = ? AND seq = ?", id, seq).Take(&chunk).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if seq == 0 {
+				return 0, fmt.Errorf("output of job %s not found: %w", id, err)
+			}
+			return written, nil
+		}
+		if err != nil {
+			return written, fmt.Errorf("could not read job output: %w", err)
+		}
+		n, err := w.Write(chunk.Data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// ReindexCompanyName updates the normalized name alone, so the company keeps its updated_at and ETag
+func (g *GormDatabase) ReindexCompanyName(ctx context.Context, id string) (bool, error) {
+	var company models.Company
+	if err := g.db.WithContext(ctx).Select("id", "name", "normalized_name").Take(&company, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, notFound(id)
+		}
+		return false, fmt.Errorf("could not get company: %w", err)
+	}
+	normalized := g.normalizer.Normalize(company.Name)
+	if normalized == company.NormalizedName {
+		return false, nil
+	}
+	err := g.db.WithContext(ctx).Model(&models.Company{}).Where("id = ?", id).UpdateColumn("normalized_name", normalized).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return false, ErrDuplicateName
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not reindex company: %w", err)
+	}
+	return true, nil
+}
+
+// Transaction runs fn in a database transaction, nested calls use savepoints
+func (g *GormDatabase) Transaction(ctx context.Context, fn func(tx Database) error) error {
+	return g.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&GormDatabase{db: tx, normalizer: g.normalizer})
+	})
+}
+
+// Ping checks that a connection of the pool can reach the database
+func (g *GormDatabase) Ping(ctx context.Context) error {
+	db, err := g.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.PingContext(ctx)
+}
+
 // Close the database connection
 func (g *GormDatabase) Close() error {
 	db, err := g.db.DB()
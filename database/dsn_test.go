@@ -0,0 +1,29 @@
+package database
+
+import (
+	"company-service/config"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresDSN(t *testing.T) {
+	conf := &config.Config{
+		DBHost:     "db.internal",
+		DBPort:     "5433",
+		DBUser:     "app user",
+		DBPassword: `p@ss word='x'&y=z/?#`,
+		DBName:     "Companies",
+		DBSSLMode:  "require",
+	}
+	parsed, err := pgconn.ParseConfig(postgresDSN(conf))
+	require.NoError(t, err)
+	assert.Equal(t, "db.internal", parsed.Host)
+	assert.Equal(t, uint16(5433), parsed.Port)
+	assert.Equal(t, "app user", parsed.User)
+	assert.Equal(t, conf.DBPassword, parsed.Password)
+	assert.Equal(t, "Companies", parsed.Database)
+	assert.Equal(t, "UTC", parsed.RuntimeParams["TimeZone"])
+}
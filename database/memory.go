@@ -0,0 +1,735 @@
+package database
+
+import (
+	"company-service/config"
+	"company-service/models"
+	"company-service/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// validCompanyTypes mirrors the check constraint on the companies table
+var validCompanyTypes = map[string]bool{
+	"Corporations":        true,
+	"NonProfit":           true,
+	"Cooperative":         true,
+	"Sole Proprietorship": true,
+}
+
+// MemoryDatabase is a thread-safe in-memory implementation of the Database interface.
+// It follows the same rules as GormDatabase (unique names, allowed types, not-found errors)
+// and is meant for hermetic tests and demo mode.
+type MemoryDatabase struct {
+	mu         sync.RWMutex
+	companies  map[uuid.UUID]models.Company
+	users      map[string]models.User
+	jobs       map[uuid.UUID]models.Job
+	outputs    map[uuid.UUID][]byte
+	nextUserID uint
+	normalizer utils.NameNormalizer
+}
+
+// NewMemoryDatabase creates an empty in-memory database with the default admin user
+func NewMemoryDatabase(conf *config.Config) (*MemoryDatabase, error) {
+	normalizer, err := utils.NewNameNormalizer(conf.NameNormalization)
+	if err != nil {
+		return nil, err
+	}
+	m := &MemoryDatabase{
+		companies:  make(map[uuid.UUID]models.Company),
+		users:      make(map[string]models.User),
+		jobs:       make(map[uuid.UUID]models.Job),
+		outputs:    make(map[uuid.UUID][]byte),
+		nextUserID: 1,
+		normalizer: normalizer,
+	}
+	if err := m.CreateDefaultUser(context.Background(), conf); err != nil {
+		return nil, fmt.Errorf("failed to create default admin user: %w", err)
+	}
+	return m, nil
+}
+
+// CreateDefaultUser creates the default admin user if it does not exist
+func (m *MemoryDatabase) CreateDefaultUser(ctx context.Context, conf *config.Config) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[conf.User]; ok {
+		return nil
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(conf.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("could not hash the password: %w ", err)
+	}
+	now := time.Now()
+	user := models.User{Username: conf.User, Password: string(hashedPassword)}
+	user.ID = m.nextUserID
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	m.users[conf.User] = user
+	m.nextUserID++
+	return nil
+}
+
+// GetUserByUsername retrieves a user by their username
+func (m *MemoryDatabase) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[username]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &user, nil
+}
+
+// CreateCompany stores a new company record
+func (m *MemoryDatabase) CreateCompany(ctx context.Context, company *models.Company) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not create a new company record with error: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.companies[company.ID]; ok {
+		return ErrDuplicateID
+	}
+	company.NormalizedName = m.normalizer.Normalize(company.Name)
+	if err := m.checkCompany(company, uuid.Nil); err != nil {
+		if errors.Is(err, ErrDuplicateName) {
+			return err
+		}
+		return fmt.Errorf("could not create a new company record with error: %v", err)
+	}
+	now := time.Now()
+	company.CreatedAt = now
+	company.UpdatedAt = now
+	m.companies[company.ID] = *company
+	return nil
+}
+
+// CreateCompanies stores the companies, none of them when one cannot be stored
+func (m *MemoryDatabase) CreateCompanies(ctx context.Context, companies []*models.Company) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not create the company records with error: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make(map[string]bool, len(companies))
+	for _, company := range companies {
+		if _, ok := m.companies[company.ID]; ok {
+			return ErrDuplicateID
+		}
+		company.NormalizedName = m.normalizer.Normalize(company.Name)
+		if err := m.checkCompany(company, uuid.Nil); err != nil {
+			if errors.Is(err, ErrDuplicateName) {
+				return err
+			}
+			return fmt.Errorf("could not create the company records with error: %v", err)
+		}
+		if names[company.NormalizedName] {
+			return ErrDuplicateName
+		}
+		names[company.NormalizedName] = true
+	}
+	now := time.Now()
+	for _, company := range companies {
+		company.CreatedAt = now
+		company.UpdatedAt = now
+		m.companies[company.ID] = *company
+	}
+	return nil
+}
+
+// GetCompany retrieves a company by its ID
+func (m *MemoryDatabase) GetCompany(ctx context.Context, id string) (*models.Company, error) {
+	return m.GetIfExistsByID(ctx, id)
+}
+
+// GetCompanyForUpdate is GetCompany, a transaction holds the lock of the whole database
+func (m *MemoryDatabase) GetCompanyForUpdate(ctx context.Context, id string) (*models.Company, error) {
+	return m.GetIfExistsByID(ctx, id)
+}
+
+// UpdateCompany applies the given column values to a company record
+func (m *MemoryDatabase) UpdateCompany(ctx context.Context, id string, updatedFields map[string]interface{}) (*models.Company, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not update company: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	company, err := m.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = applyFields(company, updatedFields); err != nil {
+		return nil, fmt.Errorf("could not update company: %v", err)
+	}
+	// Like GormDatabase, only a new name is normalized, the other updates keep the stored key
+	if _, ok := updatedFields["name"]; ok {
+		company.NormalizedName = m.normalizer.Normalize(company.Name)
+	}
+	if err = m.checkCompany(company, company.ID); err != nil {
+		if errors.Is(err, ErrDuplicateName) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("could not update company: %v", err)
+	}
+	company.UpdatedAt = time.Now()
+	m.companies[company.ID] = *company
+	return company, nil
+}
+
+// DeleteCompany removes a company record
+func (m *MemoryDatabase) DeleteCompany(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not delete company: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	company, err := m.lookup(id)
+	if err != nil {
+		return err
+	}
+	delete(m.companies, company.ID)
+	return nil
+}
+
+// GetIfExistsByID returns a copy of the company with the given ID
+func (m *MemoryDatabase) GetIfExistsByID(ctx context.Context, id string) (*models.Company, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("error checking company existence: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lookup(id)
+}
+
+// GetCompanyFields returns a copy of the whole company, there is nothing to save by reading less
+func (m *MemoryDatabase) GetCompanyFields(ctx context.Context, id string, columns []string) (*models.Company, error) {
+	for _, column := range columns {
+		if !slices.Contains(CompanyColumns, column) {
+			return nil, fmt.Errorf("unknown company column %q", column)
+		}
+	}
+	return m.GetIfExistsByID(ctx, id)
+}
+
+// CheckIfExistsByName checks if a company with the same normalized name exists
+func (m *MemoryDatabase) CheckIfExistsByName(ctx context.Context, name string) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	normalized := m.normalizer.Normalize(name)
+	for _, company := range m.companies {
+		if company.NormalizedName == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCompanyByName returns a copy of the company with the same normalized name
+func (m *MemoryDatabase) GetCompanyByName(ctx context.Context, name string) (*models.Company, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("error checking company existence: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	normalized := m.normalizer.Normalize(name)
+	for _, company := range m.companies {
+		if company.NormalizedName == normalized {
+			return &company, nil
+		}
+	}
+	return nil, fmt.Errorf("company with name %s not found: %w", name, gorm.ErrRecordNotFound)
+}
+
+// Ping only fails when the context is done, the data is always reachable
+func (m *MemoryDatabase) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// GetCompanies returns copies of the companies with the given IDs
+func (m *MemoryDatabase) GetCompanies(ctx context.Context, ids []string) ([]models.Company, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not get companies: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	companies := make([]models.Company, 0, len(ids))
+	seen := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		company, err := m.lookup(id)
+		if err != nil || seen[company.ID] {
+			continue
+		}
+		seen[company.ID] = true
+		companies = append(companies, *company)
+	}
+	return companies, nil
+}
+
+// ListCompanies returns a page of companies ordered by ID
+func (m *MemoryDatabase) ListCompanies(ctx context.Context, after string, limit int) ([]models.Company, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not list companies: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	companies := make([]models.Company, 0, len(m.companies))
+	for _, company := range m.companies {
+		if company.ID.String() > after {
+			companies = append(companies, company)
+		}
+	}
+	sort.Slice(companies, func(i, j int) bool { return companies[i].ID.String() < companies[j].ID.String() })
+	if len(companies) > limit {
+		companies = companies[:limit]
+	}
+	return companies, nil
+}
+
+// StreamCompanies calls fn for a snapshot of the matching companies, taken before the first call
+// so that fn may use the database
+func (m *MemoryDatabase) StreamCompanies(ctx context.Context, filter CompanyFilter, fn func(company *models.Company) error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not list companies: %w", err)
+	}
+	m.mu.RLock()
+	companies := make([]models.Company, 0, len(m.companies))
+	for _, company := range m.companies {
+		if matches(filter, &company) {
+			companies = append(companies, company)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(companies, func(i, j int) bool { return companies[i].ID.String() < companies[j].ID.String() })
+	if filter.Limit > 0 && len(companies) > filter.Limit {
+		companies = companies[:filter.Limit]
+	}
+	for i := range companies {
+		if err := fn(&companies[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches tells whether the company is selected by the filter
+func matches(filter CompanyFilter, company *models.Company) bool {
+	return (filter.Type == "" || company.Type == filter.Type) &&
+		(filter.Registered == nil || company.Registered == *filter.Registered) &&
+		(filter.MinEmployees == nil || company.Employees >= *filter.MinEmployees) &&
+		(filter.MaxEmployees == nil || company.Employees <= *filter.MaxEmployees) &&
+		(filter.After == "" || company.ID.String() > filter.After)
+}
+
+// CreateUser stores a new user, the password must already be hashed
+func (m *MemoryDatabase) CreateUser(ctx context.Context, user *models.User) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not create user: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user.Username == "" || user.Password == "" {
+		return errors.New("could not create user: username and password cannot be empty")
+	}
+	if _, ok := m.users[user.Username]; ok {
+		return ErrDuplicateUsername
+	}
+	now := time.Now()
+	user.ID = m.nextUserID
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	m.users[user.Username] = *user
+	m.nextUserID++
+	return nil
+}
+
+// UpdateUser applies the given column values to a user
+func (m *MemoryDatabase) UpdateUser(ctx context.Context, username string, fields map[string]interface{}) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not update user: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[username]
+	if !ok {
+		return nil, fmt.Errorf("user %s not found: %w", username, gorm.ErrRecordNotFound)
+	}
+	for column, value := range fields {
+		var valid bool
+		switch column {
+		case "password":
+			user.Password, valid = value.(string)
+		case "disabled":
+			user.Disabled, valid = value.(bool)
+		default:
+			return nil, fmt.Errorf("could not update user: unknown column %q", column)
+		}
+		if !valid {
+			return nil, fmt.Errorf("could not update user: invalid value %v for column %q", value, column)
+		}
+	}
+	user.UpdatedAt = time.Now()
+	m.users[username] = user
+	return &user, nil
+}
+
+// CreateJob stores a new job
+func (m *MemoryDatabase) CreateJob(ctx context.Context, job *models.Job) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not create job: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.jobs[job.ID]; ok {
+		return fmt.Errorf("could not create job: duplicate id %s", job.ID)
+	}
+	now := time.Now()
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	m.jobs[job.ID] = *job
+	return nil
+}
+
+// GetJob returns a copy of the job with the given ID
+func (m *MemoryDatabase) GetJob(ctx context.Context, id string) (*models.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not get job: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lookupJob(id)
+}
+
+// UpdateJob applies the given column values to the job when its status is one of from
+func (m *MemoryDatabase) UpdateJob(ctx context.Context, id string, from []string, fields map[string]interface{}) (*models.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not update job: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, err := m.lookupJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(from) > 0 && !slices.Contains(from, job.Status) {
+		return job, fmt.Errorf("job %s is %s: %w", id, job.Status, ErrJobStatus)
+	}
+	if err = setJobFields(job, fields); err != nil {
+		return nil, err
+	}
+	m.jobs[job.ID] = *job
+	return job, nil
+}
+
+// UpdateRunningJob applies the given column values to the job when it is running for owner
+func (m *MemoryDatabase) UpdateRunningJob(ctx context.Context, id, owner string, fields map[string]interface{}) (*models.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not update job: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, err := m.lookupJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != models.JobRunning || job.Owner != owner {
+		return job, fmt.Errorf("job %s is %s for %q: %w", id, job.Status, job.Owner, ErrJobStatus)
+	}
+	if err = setJobFields(job, fields); err != nil {
+		return nil, err
+	}
+	m.jobs[job.ID] = *job
+	return job, nil
+}
+
+// RequeueExpiredJobs queues the running jobs whose lease expired, or that have no lease
+func (m *MemoryDatabase) RequeueExpiredJobs(ctx context.Context, now time.Time) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not requeue jobs: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := []string{}
+	for id, job := range m.jobs {
+		if job.Status != models.JobRunning || (job.LeaseExpiresAt != nil && !job.LeaseExpiresAt.Before(now)) {
+			continue
+		}
+		job.Status, job.Owner, job.LeaseExpiresAt = models.JobQueued, "", nil
+		job.UpdatedAt = time.Now()
+		m.jobs[id] = job
+		ids = append(ids, id.String())
+	}
+	return ids, nil
+}
+
+// setJobFields applies the column values of an update to the job
+func setJobFields(job *models.Job, fields map[string]interface{}) error {
+	for column, value := range fields {
+		var valid bool
+		switch column {
+		case "status":
+			job.Status, valid = value.(string)
+		case "progress":
+			job.Progress, valid = value.(int)
+		case "total":
+			job.Total, valid = value.(int)
+		case "result":
+			job.Result, valid = value.(models.RawJSON)
+		case "error":
+			job.Error, valid = value.(string)
+		case "owner":
+			job.Owner, valid = value.(string)
+		case "started_at":
+			var at time.Time
+			at, valid = value.(time.Time)
+			job.StartedAt = &at
+		case "finished_at":
+			var at time.Time
+			at, valid = value.(time.Time)
+			job.FinishedAt = &at
+		case "lease_expires_at":
+			if value == nil {
+				job.LeaseExpiresAt, valid = nil, true
+				break
+			}
+			var at time.Time
+			at, valid = value.(time.Time)
+			job.LeaseExpiresAt = &at
+		default:
+			return fmt.Errorf("could not update job: unknown column %q", column)
+		}
+		if !valid {
+			return fmt.Errorf("could not update job: invalid value %v for column %q", value, column)
+		}
+	}
+	job.UpdatedAt = time.Now()
+	return nil
+}
+
+// ListJobs returns copies of the jobs in the given statuses, oldest first
+func (m *MemoryDatabase) ListJobs(ctx context.Context, statuses ...string) ([]models.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not list jobs: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	jobs := []models.Job{}
+	for _, job := range m.jobs {
+		if slices.Contains(statuses, job.Status) {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) })
+	return jobs, nil
+}
+
+// WriteJobOutput stores a copy of the file written by a job
+func (m *MemoryDatabase) WriteJobOutput(ctx context.Context, id string, r io.Reader) (int64, error) {
+	jobID, err := uuid.Parse(id)
+	if err != nil {
+		return 0, fmt.Errorf("could not write job output: %w", err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, fmt.Errorf("could not write job output: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("could not write job output: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outputs[jobID] = data
+	return int64(len(data)), nil
+}
+
+// ReadJobOutput copies the file written by a job to w
+func (m *MemoryDatabase) ReadJobOutput(ctx context.Context, id string, w io.Writer) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("could not read job output: %w", err)
+	}
+	jobID, err := uuid.Parse(id)
+	m.mu.RLock()
+	data, ok := m.outputs[jobID]
+	m.mu.RUnlock()
+	if err != nil || !ok {
+		return 0, fmt.Errorf("output of job %s not found: %w", id, gorm.ErrRecordNotFound)
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// ReindexCompanyName derives the normalized name again, leaving the other columns unchanged
+func (m *MemoryDatabase) ReindexCompanyName(ctx context.Context, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, fmt.Errorf("could not reindex company: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	company, err := m.lookup(id)
+	if err != nil {
+		return false, err
+	}
+	normalized := m.normalizer.Normalize(company.Name)
+	if normalized == company.NormalizedName {
+		return false, nil
+	}
+	company.NormalizedName = normalized
+	if err = m.checkCompany(company, company.ID); err != nil {
+		return false, err
+	}
+	m.companies[company.ID] = *company
+	return true, nil
+}
+
+// lookupJob returns a copy of the job with the given ID. The caller must hold the lock.
+func (m *MemoryDatabase) lookupJob(id string) (*models.Job, error) {
+	parsed, err := uuid.Parse(id)
+	if err == nil {
+		if job, ok := m.jobs[parsed]; ok {
+			return &job, nil
+		}
+	}
+	return nil, fmt.Errorf("job with ID %s not found: %w", id, gorm.ErrRecordNotFound)
+}
+
+// Transaction runs fn against a copy of the data, which replaces the data when fn succeeds.
+// The other calls wait until the transaction is over.
+func (m *MemoryDatabase) Transaction(ctx context.Context, fn func(tx Database) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &MemoryDatabase{
+		companies:  maps.Clone(m.companies),
+		users:      maps.Clone(m.users),
+		jobs:       maps.Clone(m.jobs),
+		outputs:    maps.Clone(m.outputs),
+		nextUserID: m.nextUserID,
+		normalizer: m.normalizer,
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.companies, m.users, m.jobs, m.outputs, m.nextUserID = tx.companies, tx.users, tx.jobs, tx.outputs, tx.nextUserID
+	return nil
+}
+
+// Close is a no-op, the data lives as long as the MemoryDatabase value
+func (m *MemoryDatabase) Close() error {
+	return nil
+}
+
+// lookup returns a copy of the company with the given ID. The caller must hold the lock.
+func (m *MemoryDatabase) lookup(id string) (*models.Company, error) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return nil, notFound(id)
+	}
+	company, ok := m.companies[parsed]
+	if !ok {
+		return nil, notFound(id)
+	}
+	return &company, nil
+}
+
+// checkCompany enforces the table constraints. The caller must hold the lock.
+func (m *MemoryDatabase) checkCompany(company *models.Company, self uuid.UUID) error {
+	if company.Name == "" {
+		return errors.New("name cannot be empty")
+	}
+	if len(company.Name) > 15 {
+		return errors.New("name is longer than 15 characters")
+	}
+	if utf8.RuneCountInString(company.NormalizedName) > 270 {
+		return errors.New("normalized name is longer than 270 characters")
+	}
+	if len(company.Description) > 3000 {
+		return errors.New("description is longer than 3000 characters")
+	}
+	if !validCompanyTypes[company.Type] {
+		return fmt.Errorf("check constraint failed: invalid type %q", company.Type)
+	}
+	for id, existing := range m.companies {
+		if id != self && existing.NormalizedName == company.NormalizedName {
+			return ErrDuplicateName
+		}
+	}
+	return nil
+}
+
+// applyFields sets the columns of a map based update on the company, the same way GORM does
+func applyFields(company *models.Company, fields map[string]interface{}) error {
+	for column, value := range fields {
+		var ok bool
+		switch column {
+		case "name":
+			company.Name, ok = value.(string)
+		case "description":
+			company.Description, ok = value.(string)
+		case "registered":
+			company.Registered, ok = value.(bool)
+		case "type":
+			company.Type, ok = value.(string)
+		case "employees":
+			switch v := value.(type) {
+			case int:
+				company.Employees, ok = v, true
+			case int64:
+				company.Employees, ok = int(v), true
+			case float64: // JSON decodes numbers as float64
+				company.Employees, ok = int(v), true
+			}
+		default:
+			return fmt.Errorf("unknown column %q", column)
+		}
+		if !ok {
+			return fmt.Errorf("invalid value %v for column %q", value, column)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package database
+
+import (
+	"company-service/config"
+	"company-service/models"
+	"company-service/utils"
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMemoryUpdateKeepsNormalizedName is the memory side of TestReindexAfterPolicyChange, the
+// policy changes under the stored rows
+func TestMemoryUpdateKeepsNormalizedName(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewMemoryDatabase(&config.Config{User: "admin", Password: "admin-password", NameNormalization: "whitespace"})
+	require.NoError(t, err)
+	first := &models.Company{ID: uuid.New(), Name: "Acme", Employees: 1, Registered: true, Type: "Cooperative"}
+	second := &models.Company{ID: uuid.New(), Name: "ACME", Employees: 1, Registered: true, Type: "Cooperative"}
+	require.NoError(t, db.CreateCompany(ctx, first))
+	require.NoError(t, db.CreateCompany(ctx, second))
+
+	db.normalizer, err = utils.NewNameNormalizer("casefold")
+	require.NoError(t, err)
+	changed, err := db.ReindexCompanyName(ctx, first.ID.String())
+	require.NoError(t, err)
+	assert.True(t, changed)
+	updated, err := db.UpdateCompany(ctx, second.ID.String(), map[string]interface{}{"employees": 3})
+	require.NoError(t, err)
+	assert.Equal(t, "ACME", updated.NormalizedName)
+}
@@ -0,0 +1,242 @@
+package grpcapi
+
+import (
+	"company-service/controllers"
+	"company-service/database"
+	"company-service/grpcapi/companypb"
+	"company-service/kafka"
+	"company-service/models"
+	"company-service/utils"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Page sizes of ListCompanies
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// CreateCompany creates a company and publishes its company_created event
+func (s *Server) CreateCompany(ctx context.Context, req *companypb.CreateCompanyRequest) (*companypb.Company, error) {
+	if req.GetCompany() == nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid 'company': it is required")
+	}
+	company := companyFromProto(req.GetCompany())
+	if err := utils.ValidateCompanyInput(company); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	company.ID = utils.GenerateUUID()
+
+	err := s.app.DB.CreateCompany(ctx, company)
+	if errors.Is(err, database.ErrDuplicateName) {
+		return nil, status.Error(codes.AlreadyExists, "The company with the same name already exists")
+	}
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	s.publish(ctx, "company_created", company)
+	return companyToProto(company), nil
+}
+
+// GetCompany returns the company with the given ID
+func (s *Server) GetCompany(ctx context.Context, req *companypb.GetCompanyRequest) (*companypb.Company, error) {
+	id, err := parseID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	company, err := s.app.DB.GetCompany(ctx, id.String())
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	return companyToProto(company), nil
+}
+
+// UpdateCompany changes the fields of the update mask and publishes the company_updated event
+func (s *Server) UpdateCompany(ctx context.Context, req *companypb.UpdateCompanyRequest) (*companypb.Company, error) {
+	id, err := parseID(req.GetCompany().GetId())
+	if err != nil {
+		return nil, err
+	}
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid 'update_mask': it is required")
+	}
+	// The same values as a JSON body of PATCH /api/companies/{id}, where the numbers are float64
+	input := req.GetCompany()
+	fields := make(map[string]interface{}, len(paths))
+	for _, path := range paths {
+		switch path {
+		case "name":
+			fields[path] = input.GetName()
+		case "description":
+			fields[path] = input.GetDescription()
+		case "employees":
+			fields[path] = float64(input.GetEmployees())
+		case "registered":
+			fields[path] = input.GetRegistered()
+		case "type":
+			fields[path] = input.GetType()
+		case "id", "created_at", "updated_at":
+			return nil, status.Errorf(codes.InvalidArgument, "'%s' cannot be changed", path)
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unknown field '%s'", path)
+		}
+	}
+	if err = utils.ValidateCompanyUpdate(fields); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	company, err := s.app.DB.UpdateCompany(ctx, id.String(), fields)
+	if errors.Is(err, database.ErrDuplicateName) {
+		return nil, status.Error(codes.AlreadyExists, "The company with the same name already exists")
+	}
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	s.publish(ctx, "company_updated", company)
+	return companyToProto(company), nil
+}
+
+// DeleteCompany deletes the company and publishes its company_deleted event
+func (s *Server) DeleteCompany(ctx context.Context, req *companypb.DeleteCompanyRequest) (*emptypb.Empty, error) {
+	id, err := parseID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if err = s.app.DB.DeleteCompany(ctx, id.String()); err != nil {
+		return nil, databaseError(err)
+	}
+	s.publish(ctx, "company_deleted", &models.Company{ID: id})
+	return &emptypb.Empty{}, nil
+}
+
+// ListCompanies returns a page of companies in ID order. The page token is the ID of the
+// last company of the previous page.
+func (s *Server) ListCompanies(ctx context.Context, req *companypb.ListCompaniesRequest) (*companypb.ListCompaniesResponse, error) {
+	size := int(req.GetPageSize())
+	switch {
+	case size < 0:
+		return nil, status.Error(codes.InvalidArgument, "invalid 'page_size': it cannot be negative")
+	case size == 0:
+		size = defaultPageSize
+	case size > maxPageSize:
+		size = maxPageSize
+	}
+	after := ""
+	if req.GetPageToken() != "" {
+		if _, err := uuid.Parse(req.GetPageToken()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid 'page_token': it must be the next_page_token of a previous page")
+		}
+		after = req.GetPageToken()
+	}
+
+	// One more company tells whether there is a next page
+	companies, err := s.app.DB.ListCompanies(ctx, after, size+1)
+	if err != nil {
+		return nil, databaseError(err)
+	}
+	response := &companypb.ListCompaniesResponse{}
+	if len(companies) > size {
+		companies = companies[:size]
+		response.NextPageToken = companies[size-1].ID.String()
+	}
+	for i := range companies {
+		response.Companies = append(response.Companies, companyToProto(&companies[i]))
+	}
+	return response, nil
+}
+
+// publish publishes the event of a committed change, a failed delivery is only logged as the
+// HTTP API does
+func (s *Server) publish(ctx context.Context, eventType string, company *models.Company) {
+	if err := s.app.PublishEvent(ctx, kafka.NewEvent(eventType, company)); err != nil {
+		slog.ErrorContext(ctx, "Kafka publish failed", slog.Any("error", err))
+	}
+}
+
+// parseID checks that the ID of a request is a UUID
+func parseID(id string) (uuid.UUID, error) {
+	if id == "" {
+		return uuid.UUID{}, status.Error(codes.InvalidArgument, "invalid 'id': it is required")
+	}
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.UUID{}, status.Error(codes.InvalidArgument, "invalid 'id': it must be a UUID")
+	}
+	return parsed, nil
+}
+
+// databaseError maps an error returned by the database to the gRPC code of the HTTP status
+// the HTTP API answers with
+func databaseError(err error) error {
+	code := codes.Internal
+	switch controllers.DatabaseErrorStatus(err) {
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	case controllers.StatusClientClosedRequest:
+		code = codes.Canceled
+	}
+	return status.Error(code, err.Error())
+}
+
+// companyFromProto returns the writable fields of a company, the ID and timestamps are set by the server
+func companyFromProto(company *companypb.Company) *models.Company {
+	return &models.Company{
+		Name:        company.GetName(),
+		Description: company.GetDescription(),
+		Employees:   int(company.GetEmployees()),
+		Registered:  company.GetRegistered(),
+		Type:        company.GetType(),
+	}
+}
+
+func companyToProto(company *models.Company) *companypb.Company {
+	return &companypb.Company{
+		Id:          company.ID.String(),
+		Name:        company.Name,
+		Description: company.Description,
+		Employees:   int64(company.Employees),
+		Registered:  company.Registered,
+		Type:        company.Type,
+		CreatedAt:   timestamp(company.CreatedAt),
+		UpdatedAt:   timestamp(company.UpdatedAt),
+	}
+}
+
+// eventTypes maps the types of the Kafka events to the types of the watched events
+var eventTypes = map[string]companypb.CompanyEvent_Type{
+	"company_created": companypb.CompanyEvent_TYPE_CREATED,
+	"company_updated": companypb.CompanyEvent_TYPE_UPDATED,
+	"company_deleted": companypb.CompanyEvent_TYPE_DELETED,
+}
+
+func eventToProto(event *kafka.EventMessage) *companypb.CompanyEvent {
+	watched := &companypb.CompanyEvent{Type: eventTypes[event.EventType]}
+	if event.Company != nil {
+		watched.Company = companyToProto(event.Company)
+	}
+	if at, err := time.Parse(time.RFC3339, event.Timestamp); err == nil {
+		watched.Time = timestamppb.New(at)
+	}
+	return watched
+}
+
+// timestamp leaves the unset times out, e.g. those of a deleted company
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,131 @@
+// Package grpcapi serves the companies over gRPC, with the database, the validation and the
+// events of the HTTP API. The service is defined in proto/company/v1/company.proto.
+package grpcapi
+
+//go:generate protoc -I ../proto --go_out=.. --go_opt=module=company-service --go-grpc_out=.. --go-grpc_opt=module=company-service company/v1/company.proto
+
+import (
+	"company-service/controllers"
+	"company-service/grpcapi/companypb"
+	"company-service/kafka"
+	"company-service/metrics"
+	"company-service/middleware"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// watchBuffer is the number of events a watch stream may fall behind before it is ended
+const watchBuffer = 64
+
+// Server implements the CompanyService
+type Server struct {
+	companypb.UnimplementedCompanyServiceServer
+	app    *controllers.App
+	events *kafka.Broadcaster
+	server *grpc.Server
+	// done is closed by Shutdown to end the watch streams, which never finish on their own
+	done     chan struct{}
+	shutdown sync.Once
+}
+
+// NewServer returns a server authenticating the calls with the JWT secret of the app. The
+// events broadcast by events are streamed by WatchCompanies. The calls are traced and
+// recorded in appMetrics like the HTTP requests, a panicking handler answers INTERNAL. With a
+// TLS configuration the server only accepts TLS connections.
+func NewServer(app *controllers.App, events *kafka.Broadcaster, appMetrics *metrics.Metrics, tlsConfig *tls.Config) *Server {
+	options := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		// The metrics see the code of a recovered panic, the authentication failures as well
+		grpc.ChainUnaryInterceptor(
+			appMetrics.UnaryServerInterceptor(),
+			middleware.UnaryRecoveryInterceptor(),
+			middleware.UnaryAuthInterceptor(app.Config, app.Users),
+		),
+		grpc.ChainStreamInterceptor(
+			appMetrics.StreamServerInterceptor(),
+			middleware.StreamRecoveryInterceptor(),
+			middleware.StreamAuthInterceptor(app.Config, app.Users),
+		),
+	}
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s := &Server{
+		app:    app,
+		events: events,
+		server: grpc.NewServer(options...),
+		done:   make(chan struct{}),
+	}
+	companypb.RegisterCompanyServiceServer(s.server, s)
+	return s
+}
+
+// Serve accepts the connections of the listener until Shutdown
+func (s *Server) Serve(listener net.Listener) error {
+	err := s.server.Serve(listener)
+	if errors.Is(err, grpc.ErrServerStopped) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown ends the watch streams and waits for the other calls to finish. When the context
+// is done first the remaining calls are cancelled and the context error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdown.Do(func() { close(s.done) })
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// WatchCompanies streams the events of the companies until the client cancels the call
+func (s *Server) WatchCompanies(req *companypb.WatchCompaniesRequest, stream companypb.CompanyService_WatchCompaniesServer) error {
+	if req.GetId() != "" {
+		if _, err := parseID(req.GetId()); err != nil {
+			return err
+		}
+	}
+	subscription := s.events.Subscribe(watchBuffer)
+	defer s.events.Unsubscribe(subscription)
+	// The headers tell the client that the events produced from now on will be streamed
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	for {
+		select {
+		case event := <-subscription.Events():
+			if req.GetId() != "" && (event.Company == nil || event.Company.ID.String() != req.GetId()) {
+				continue
+			}
+			if err := stream.Send(eventToProto(&event)); err != nil {
+				return err
+			}
+		case <-subscription.Lagged():
+			return status.Error(codes.ResourceExhausted, "The watch fell behind the changes, call WatchCompanies again")
+		case <-s.done:
+			return status.Error(codes.Unavailable, "The server is shutting down")
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
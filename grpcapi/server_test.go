@@ -0,0 +1,261 @@
+package grpcapi_test
+
+import (
+	"company-service/config"
+	"company-service/controllers"
+	"company-service/database"
+	"company-service/grpcapi"
+	"company-service/grpcapi/companypb"
+	"company-service/kafka"
+	"company-service/metrics"
+	"company-service/middleware"
+	"company-service/models"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+const jwtSecret = "secretTest"
+
+// newClient serves a fresh in-memory app over an in-process connection
+func newClient(t *testing.T) (companypb.CompanyServiceClient, *kafka.MemoryProducer, *grpcapi.Server) {
+	conf := &config.Config{User: "admin", Password: "admin-password", NameNormalization: "nfkc,casefold,whitespace", JWTSecret: jwtSecret}
+	db, err := database.NewMemoryDatabase(conf)
+	require.NoError(t, err)
+	producer := kafka.NewMemoryProducer()
+	// The watch streams are fed with the events read back from the producer, like serve does
+	// with the topic
+	broadcaster := kafka.NewBroadcaster()
+	consumed := producer.Subscribe(64)
+	t.Cleanup(producer.Close)
+	go func() {
+		for event := range consumed {
+			broadcaster.Broadcast(&event)
+		}
+	}()
+	server := grpcapi.NewServer(controllers.NewApp(db, producer, conf), broadcaster, metrics.New(), nil)
+
+	listener := bufconn.Listen(1 << 20)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return companypb.NewCompanyServiceClient(conn), producer, server
+}
+
+// authenticated returns a context carrying the token of the admin
+func authenticated(t *testing.T) context.Context {
+	token, err := middleware.GenerateJWT("admin", jwtSecret)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+func assertCode(t *testing.T, expected codes.Code, message string, err error) {
+	t.Helper()
+	assert.Equal(t, expected, status.Code(err), "%v", err)
+	assert.Equal(t, message, status.Convert(err).Message())
+}
+
+func TestAuthentication(t *testing.T) {
+	client, _, _ := newClient(t)
+
+	_, err := client.GetCompany(context.Background(), &companypb.GetCompanyRequest{Id: "5a2c6f1e-6a0b-4b8e-9c1d-2f3e4a5b6c7d"})
+	assertCode(t, codes.Unauthenticated, "Unauthorized: No token found", err)
+
+	forged, err := middleware.GenerateJWT("admin", "another-secret")
+	require.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+forged)
+	_, err = client.ListCompanies(ctx, &companypb.ListCompaniesRequest{})
+	assertCode(t, codes.Unauthenticated, "Unauthorized: Invalid token", err)
+
+	// A valid token of a user that no longer exists, or was disabled, is refused
+	removed, err := middleware.GenerateJWT("bob", jwtSecret)
+	require.NoError(t, err)
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+removed)
+	_, err = client.ListCompanies(ctx, &companypb.ListCompaniesRequest{})
+	assertCode(t, codes.Unauthenticated, "Unauthorized: The user is disabled", err)
+
+	// The streams are authenticated by their own interceptor
+	stream, err := client.WatchCompanies(context.Background(), &companypb.WatchCompaniesRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assertCode(t, codes.Unauthenticated, "Unauthorized: No token found", err)
+}
+
+func TestCompanyCRUD(t *testing.T) {
+	client, producer, _ := newClient(t)
+	ctx := authenticated(t)
+
+	created, err := client.CreateCompany(ctx, &companypb.CreateCompanyRequest{Company: &companypb.Company{
+		Name: "Globex", Employees: 10, Registered: true, Type: "Corporations",
+	}})
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.GetId())
+	assert.NotNil(t, created.GetCreatedAt())
+
+	// The validators and the unique names of the HTTP API apply
+	_, err = client.CreateCompany(ctx, &companypb.CreateCompanyRequest{Company: &companypb.Company{Name: "Initech", Registered: true, Type: "Corporations"}})
+	assertCode(t, codes.InvalidArgument, "invalid 'Employees': it must be a positive number", err)
+	_, err = client.CreateCompany(ctx, &companypb.CreateCompanyRequest{Company: &companypb.Company{Name: "globex", Employees: 1, Registered: true, Type: "Corporations"}})
+	assertCode(t, codes.AlreadyExists, "The company with the same name already exists", err)
+
+	fetched, err := client.GetCompany(ctx, &companypb.GetCompanyRequest{Id: created.GetId()})
+	require.NoError(t, err)
+	assert.Equal(t, "Globex", fetched.GetName())
+
+	// Counts beyond the range of an int32 are kept
+	large, err := client.CreateCompany(ctx, &companypb.CreateCompanyRequest{Company: &companypb.Company{
+		Name: "Walmart", Employees: 1 << 32, Registered: true, Type: "Corporations",
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<32), large.GetEmployees())
+
+	// Only the fields of the mask change
+	updated, err := client.UpdateCompany(ctx, &companypb.UpdateCompanyRequest{
+		Company:    &companypb.Company{Id: created.GetId(), Employees: 42, Name: "ignored"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"employees"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), updated.GetEmployees())
+	assert.Equal(t, "Globex", updated.GetName())
+	_, err = client.UpdateCompany(ctx, &companypb.UpdateCompanyRequest{
+		Company:    &companypb.Company{Id: created.GetId()},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"created_at"}},
+	})
+	assertCode(t, codes.InvalidArgument, "'created_at' cannot be changed", err)
+	_, err = client.UpdateCompany(ctx, &companypb.UpdateCompanyRequest{
+		Company:    &companypb.Company{Id: created.GetId(), Type: "Partnership"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"type"}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.DeleteCompany(ctx, &companypb.DeleteCompanyRequest{Id: created.GetId()})
+	require.NoError(t, err)
+	_, err = client.GetCompany(ctx, &companypb.GetCompanyRequest{Id: created.GetId()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.GetCompany(ctx, &companypb.GetCompanyRequest{Id: "not-a-uuid"})
+	assertCode(t, codes.InvalidArgument, "invalid 'id': it must be a UUID", err)
+
+	var types []string
+	for _, event := range producer.Events() {
+		types = append(types, event.EventType)
+	}
+	assert.Equal(t, []string{"company_created", "company_created", "company_updated", "company_deleted"}, types)
+}
+
+func TestListCompanies(t *testing.T) {
+	client, _, _ := newClient(t)
+	ctx := authenticated(t)
+	for _, name := range []string{"Globex", "Initech", "Hooli", "Umbrella", "Cyberdyne"} {
+		_, err := client.CreateCompany(ctx, &companypb.CreateCompanyRequest{Company: &companypb.Company{
+			Name: name, Employees: 1, Registered: true, Type: "Corporations",
+		}})
+		require.NoError(t, err)
+	}
+
+	var names []string
+	token := ""
+	for pages := 1; ; pages++ {
+		page, err := client.ListCompanies(ctx, &companypb.ListCompaniesRequest{PageSize: 2, PageToken: token})
+		require.NoError(t, err)
+		for _, company := range page.GetCompanies() {
+			names = append(names, company.GetName())
+		}
+		token = page.GetNextPageToken()
+		if token == "" {
+			assert.Equal(t, 3, pages)
+			break
+		}
+	}
+	assert.ElementsMatch(t, []string{"Globex", "Initech", "Hooli", "Umbrella", "Cyberdyne"}, names)
+
+	_, err := client.ListCompanies(ctx, &companypb.ListCompaniesRequest{PageToken: "page-2"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestWatchCompanies(t *testing.T) {
+	client, _, server := newClient(t)
+	ctx := authenticated(t)
+
+	all, err := client.WatchCompanies(ctx, &companypb.WatchCompaniesRequest{})
+	require.NoError(t, err)
+	// The headers are sent once the stream is subscribed
+	_, err = all.Header()
+	require.NoError(t, err)
+
+	created, err := client.CreateCompany(ctx, &companypb.CreateCompanyRequest{Company: &companypb.Company{
+		Name: "Globex", Employees: 10, Registered: true, Type: "Corporations",
+	}})
+	require.NoError(t, err)
+	one, err := client.WatchCompanies(ctx, &companypb.WatchCompaniesRequest{Id: created.GetId()})
+	require.NoError(t, err)
+	_, err = one.Header()
+	require.NoError(t, err)
+
+	// A change of another company is not streamed to the watch of Globex
+	_, err = client.CreateCompany(ctx, &companypb.CreateCompanyRequest{Company: &companypb.Company{
+		Name: "Initech", Employees: 3, Registered: true, Type: "Corporations",
+	}})
+	require.NoError(t, err)
+	_, err = client.DeleteCompany(ctx, &companypb.DeleteCompanyRequest{Id: created.GetId()})
+	require.NoError(t, err)
+
+	for _, expected := range []struct {
+		eventType companypb.CompanyEvent_Type
+		name      string
+	}{
+		{companypb.CompanyEvent_TYPE_CREATED, "Globex"},
+		{companypb.CompanyEvent_TYPE_CREATED, "Initech"},
+		{companypb.CompanyEvent_TYPE_DELETED, ""},
+	} {
+		event, err := all.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, expected.eventType, event.GetType())
+		assert.Equal(t, expected.name, event.GetCompany().GetName())
+		assert.NotNil(t, event.GetTime())
+	}
+	event, err := one.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, companypb.CompanyEvent_TYPE_DELETED, event.GetType())
+	assert.Equal(t, created.GetId(), event.GetCompany().GetId())
+
+	// The shutdown ends the streams instead of waiting for the clients
+	require.NoError(t, server.Shutdown(ctx))
+	_, err = all.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestWatchCompaniesOfOtherInstances(t *testing.T) {
+	client, producer, _ := newClient(t)
+	ctx := authenticated(t)
+	stream, err := client.WatchCompanies(ctx, &companypb.WatchCompaniesRequest{})
+	require.NoError(t, err)
+	_, err = stream.Header()
+	require.NoError(t, err)
+
+	// An event produced to the topic by another instance, or by an admin command
+	company := &models.Company{ID: uuid.New(), Name: "Hooli", Employees: 40, Registered: true, Type: "Corporations"}
+	require.NoError(t, producer.ProduceEvent(ctx, kafka.NewEvent("company_updated", company)))
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, companypb.CompanyEvent_TYPE_UPDATED, event.GetType())
+	assert.Equal(t, company.ID.String(), event.GetCompany().GetId())
+}
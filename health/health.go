@@ -0,0 +1,136 @@
+package health
+
+import (
+	"company-service/utils"
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check is a dependency probed by the readiness endpoint
+type Check struct {
+	Name  string
+	Probe func(ctx context.Context) error
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the body of the readiness endpoint
+type Report struct {
+	Status    string            `json:"status"`
+	CheckedAt time.Time         `json:"checked_at"`
+	Checks    map[string]Result `json:"checks"`
+}
+
+// Report and check statuses
+const (
+	StatusReady        = "ready"
+	StatusNotReady     = "not_ready"
+	StatusShuttingDown = "shutting_down"
+	StatusUp           = "up"
+	StatusDown         = "down"
+)
+
+// Checker serves the liveness and readiness endpoints. The checks run concurrently with
+// a timeout and their report is reused for cacheTTL, so frequent probes do not load the
+// dependencies. Once Shutdown is called the instance reports not ready without probing.
+type Checker struct {
+	checks   []Check
+	timeout  time.Duration
+	cacheTTL time.Duration
+
+	mu           sync.Mutex
+	report       Report
+	expires      time.Time
+	shuttingDown atomic.Bool
+}
+
+// NewChecker creates a checker probing the given dependencies
+func NewChecker(timeout, cacheTTL time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout, cacheTTL: cacheTTL}
+}
+
+// Shutdown makes the instance report not ready, it is called before the server stops accepting connections
+func (c *Checker) Shutdown() {
+	c.shuttingDown.Store(true)
+}
+
+// Liveness reports that the process is alive and able to serve requests
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readiness reports whether the dependencies are reachable, with 503 when one of them is not
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	if c.shuttingDown.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, Report{Status: StatusShuttingDown, CheckedAt: time.Now(), Checks: map[string]Result{}})
+		return
+	}
+	report := c.Check(r.Context())
+	status := http.StatusOK
+	if report.Status != StatusReady {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// Check returns the cached report, or probes all the dependencies once it expired
+func (c *Checker) Check(ctx context.Context) Report {
+	// Concurrent probes wait for the running checks instead of starting their own
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(c.expires) {
+		return c.report
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+	defer cancel()
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = probe(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusReady, CheckedAt: now, Checks: make(map[string]Result, len(c.checks))}
+	for i, check := range c.checks {
+		report.Checks[check.Name] = results[i]
+		if results[i].Status != StatusUp {
+			report.Status = StatusNotReady
+		}
+	}
+	c.report = report
+	c.expires = time.Now().Add(c.cacheTTL)
+	return report
+}
+
+// probe runs one check and measures its latency
+func probe(ctx context.Context, check Check) Result {
+	start := time.Now()
+	err := check.Probe(ctx)
+	result := Result{Status: StatusUp, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// writeJSON writes the report, probes must never get a response cached by a proxy
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	utils.SendJSONResponse(w, status, value)
+}
@@ -0,0 +1,85 @@
+package health_test
+
+import (
+	"company-service/health"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readiness calls the readiness endpoint and decodes its report
+func readiness(t *testing.T, checker *health.Checker) (int, health.Report) {
+	rec := httptest.NewRecorder()
+	checker.Readiness(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report health.Report
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	return rec.Code, report
+}
+
+func TestChecker(t *testing.T) {
+	up := health.Check{Name: "database", Probe: func(ctx context.Context) error { return nil }}
+	down := health.Check{Name: "kafka", Probe: func(ctx context.Context) error { return errors.New("no broker") }}
+
+	t.Run("Liveness does not probe the dependencies", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		health.NewChecker(time.Second, 0, down).Liveness(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Ready when every dependency is up", func(t *testing.T) {
+		status, report := readiness(t, health.NewChecker(time.Second, 0, up))
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, health.StatusReady, report.Status)
+		assert.Equal(t, health.StatusUp, report.Checks["database"].Status)
+	})
+
+	t.Run("Not ready when a dependency is down", func(t *testing.T) {
+		status, report := readiness(t, health.NewChecker(time.Second, 0, up, down))
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, health.StatusNotReady, report.Status)
+		assert.Equal(t, health.StatusDown, report.Checks["kafka"].Status)
+		assert.Equal(t, "no broker", report.Checks["kafka"].Error)
+	})
+
+	t.Run("Slow dependencies time out", func(t *testing.T) {
+		slow := health.Check{Name: "database", Probe: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}}
+		status, report := readiness(t, health.NewChecker(10*time.Millisecond, 0, slow))
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["database"].Error)
+	})
+
+	t.Run("Reports are cached", func(t *testing.T) {
+		var calls atomic.Int64
+		counting := health.Check{Name: "database", Probe: func(ctx context.Context) error {
+			calls.Add(1)
+			return nil
+		}}
+		checker := health.NewChecker(time.Second, time.Minute, counting)
+		for i := 0; i < 3; i++ {
+			readiness(t, checker)
+		}
+		assert.Equal(t, int64(1), calls.Load())
+	})
+
+	t.Run("Not ready once shutting down", func(t *testing.T) {
+		checker := health.NewChecker(time.Second, time.Minute, up)
+		status, _ := readiness(t, checker)
+		require.Equal(t, http.StatusOK, status)
+
+		checker.Shutdown()
+		status, report := readiness(t, checker)
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, health.StatusShuttingDown, report.Status)
+	})
+}
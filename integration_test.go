@@ -12,19 +12,21 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
-	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"sync"
 	"testing"
 	"time"
 )
 
-// Global variables for DB and Kafka
-
+// TestIntegration runs the API flow against MySQL and Kafka, started for example with docker-compose
 func TestIntegration(t *testing.T) {
+	if os.Getenv("TEST_INTEGRATION") == "" {
+		t.Skip("set TEST_INTEGRATION=1 to run against the database and Kafka from the .env file")
+	}
 
 	conf, err := config.LoadConfig()
 	if err != nil {
@@ -42,21 +44,10 @@ func TestIntegration(t *testing.T) {
 	if err != nil {
 		log.Fatalf("Failed to start Kafka consumer")
 	}
-	var dbInterface database.Database = db
-	var kafkaProducerInterface kafka.Producer = kafkaProducer
-	newApp := controllers.App{DB: dbInterface, KafkaProducer: kafkaProducerInterface, Config: conf}
+	newApp := controllers.NewApp(db, kafkaProducer, conf)
 	consumedEvents := make(chan kafka.EventMessage)
 	var wg sync.WaitGroup
 
-	router := mux.NewRouter()
-	apiRouter := router.PathPrefix("/api").Subrouter()
-	// Public routes: Login
-	apiRouter.HandleFunc("/login", newApp.Login).Methods("POST")
-	apiRouter.HandleFunc("/companies", middleware.JwtMiddleware(newApp.CreateCompany, conf)).Methods("POST")
-	apiRouter.HandleFunc("/companies/{id}", newApp.GetCompany).Methods("GET")
-	apiRouter.HandleFunc("/companies/{id}", middleware.JwtMiddleware(newApp.UpdateCompany, conf)).Methods("PATCH")
-	apiRouter.HandleFunc("/companies/{id}", middleware.JwtMiddleware(newApp.DeleteCompany, conf)).Methods("DELETE")
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer func() {
 		cancel()
@@ -74,6 +65,29 @@ func TestIntegration(t *testing.T) {
 		kafkaConsumer.ConsumeEvents(ctx, conf.KafkaTopic, consumedEvents)
 	}()
 
+	runIntegrationFlow(t, newApp, consumedEvents)
+}
+
+// TestIntegrationInMemory runs the same API flow against the in-memory database and producer
+func TestIntegrationInMemory(t *testing.T) {
+	conf := &config.Config{JWTSecret: "secretTest", User: "user2", Password: "test2", KafkaTopic: "company_events", CSRFProtection: true, ValidateResponses: true}
+	db, err := database.NewMemoryDatabase(conf)
+	if err != nil {
+		t.Fatalf("Could not create in-memory database: %v", err)
+	}
+	producer := kafka.NewMemoryProducer()
+	defer producer.Close()
+
+	consumedEvents := producer.Subscribe(10)
+	runIntegrationFlow(t, controllers.NewApp(db, producer, conf), consumedEvents)
+	assert.Len(t, producer.Events(), 3)
+}
+
+// runIntegrationFlow logs in, then creates, reads, updates and deletes a company, checking the published events
+func runIntegrationFlow(t *testing.T, newApp *controllers.App, consumedEvents <-chan kafka.EventMessage) {
+	conf := newApp.Config
+	router := newApp.Router()
+
 	// Step 0: Login
 	login := map[string]interface{}{
 		"username": conf.User,
@@ -93,6 +107,15 @@ func TestIntegration(t *testing.T) {
 
 	cookies := rr.Result().Cookies()
 	assert.NotEmpty(t, cookies)
+	csrfToken := rr.Header().Get(middleware.CSRFHeader)
+	assert.NotEmpty(t, csrfToken)
+	// authenticate adds the login cookies and the CSRF token to a request
+	authenticate := func(req *http.Request) {
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		req.Header.Set(middleware.CSRFHeader, csrfToken)
+	}
 
 	// Step 1: Create a Company
 	company := models.Company{
@@ -110,7 +133,7 @@ func TestIntegration(t *testing.T) {
 		t.Fatalf("Could not create request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.AddCookie(cookies[0])
+	authenticate(req)
 
 	rr = httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
@@ -176,7 +199,7 @@ func TestIntegration(t *testing.T) {
 		t.Fatalf("Could not create request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.AddCookie(cookies[0])
+	authenticate(req)
 
 	rr = httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
@@ -206,7 +229,7 @@ func TestIntegration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not create request: %v", err)
 	}
-	req.AddCookie(cookies[0])
+	authenticate(req)
 
 	rr = httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
@@ -0,0 +1,463 @@
+// Package jobs runs long operations in the background with a bounded pool of workers. The jobs
+// are persisted through database.Database, so their status survives restarts.
+package jobs
+
+import (
+	"company-service/database"
+	"company-service/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// ErrUnknownType is returned when a job of an unregistered type is submitted
+var ErrUnknownType = errors.New("unknown job type")
+
+// ErrInvalidParams wraps the error returned by the validation of the parameters of a job
+var ErrInvalidParams = errors.New("invalid job parameters")
+
+// ErrQueueFull is returned when the queue has no room left for a new job
+var ErrQueueFull = errors.New("too many queued jobs, try again later")
+
+// errCancelled is the cause of the cancellation of a job cancelled by a user
+var errCancelled = errors.New("job cancelled")
+
+// errLeaseLost is the cause of the cancellation of a job whose lease could not be renewed in time
+var errLeaseLost = errors.New("job lease lost")
+
+// ProgressInterval is the minimum time between two progress updates written to the database
+const ProgressInterval = time.Second
+
+// DefaultLease is how long a running job stays owned by its runner without a renewal. The lease
+// is renewed three times per period, a job whose lease expired is run again by any runner.
+const DefaultLease = 30 * time.Second
+
+// maxErrorLength keeps the error message within the size of its column
+const maxErrorLength = 1000
+
+// Progress records that done items were processed out of total, zero when the total is unknown
+type Progress func(done, total int)
+
+// Type describes a kind of job
+type Type struct {
+	// Validate checks the parameters before the job is queued, it may be nil
+	Validate func(params json.RawMessage) error
+	// Run executes the job and returns its result, stored as JSON even when an error is returned.
+	// It must return once ctx is done.
+	Run func(ctx context.Context, job *models.Job, progress Progress) (interface{}, error)
+}
+
+// Runner queues the submitted jobs and executes them with a fixed number of workers. Several
+// runners may share the database, each job is run by the runner that claimed it.
+type Runner struct {
+	// Lease is how long a claimed job stays owned without a renewal, it must be set before Start
+	Lease time.Duration
+
+	db      database.Database
+	types   map[string]Type
+	queue   chan string
+	workers int
+	// owner identifies this runner in the jobs it claims
+	owner string
+
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+
+	mu sync.Mutex
+	// cancels holds the cancel functions of the jobs running in this process
+	cancels map[string]context.CancelCauseFunc
+	// waiting holds the jobs handed to the queue of this process and not yet taken by a worker
+	waiting map[string]bool
+}
+
+// NewRunner creates a runner with the given number of workers, and room for queueSize jobs waiting for one
+func NewRunner(db database.Database, workers, queueSize int) *Runner {
+	ctx, stop := context.WithCancel(context.Background())
+	host, _ := os.Hostname()
+	return &Runner{
+		Lease:   DefaultLease,
+		owner:   fmt.Sprintf("%s/%s", host, uuid.NewString()),
+		db:      db,
+		types:   make(map[string]Type),
+		queue:   make(chan string, max(queueSize, 1)),
+		workers: max(workers, 1),
+		ctx:     ctx,
+		stop:    stop,
+		cancels: make(map[string]context.CancelCauseFunc),
+		waiting: make(map[string]bool),
+	}
+}
+
+// Register adds a kind of job, it must be called before Start
+func (r *Runner) Register(name string, jobType Type) {
+	r.types[name] = jobType
+}
+
+// Start queues the jobs left by stopped processes and starts the workers. The jobs whose lease
+// expired are started again from the beginning, the jobs of the live runners are left alone.
+// While the runner works, it requeues the jobs of the runners that stop renewing their leases, and
+// picks up the jobs left queued for longer than a lease, e.g. in the queue of a crashed process.
+func (r *Runner) Start(ctx context.Context) error {
+	if _, err := r.db.RequeueExpiredJobs(ctx, time.Now()); err != nil {
+		return err
+	}
+	queued, err := r.db.ListJobs(ctx, models.JobQueued)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < r.workers; i++ {
+		r.wg.Add(1)
+		go r.work()
+	}
+	ids := make([]string, len(queued))
+	for i, job := range queued {
+		ids[i] = job.ID.String()
+	}
+	if len(ids) > 0 {
+		slog.Info("Resuming queued jobs", slog.Int("count", len(ids)))
+	}
+	r.enqueue(ids)
+	r.wg.Add(1)
+	go r.sweep()
+	return nil
+}
+
+// enqueue hands recovered jobs to the workers. They may not fit in the queue, so they wait for
+// room without blocking the caller.
+func (r *Runner) enqueue(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	r.mu.Lock()
+	for _, id := range ids {
+		r.waiting[id] = true
+	}
+	r.mu.Unlock()
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for _, id := range ids {
+			select {
+			case r.queue <- id:
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// sweep requeues the jobs whose lease expired every lease period, and picks up the jobs queued
+// for longer than a lease that this process does not hold, until the runner is stopped
+func (r *Runner) sweep() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.Lease)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			ids, err := r.db.RequeueExpiredJobs(r.ctx, time.Now())
+			if err != nil {
+				if r.ctx.Err() == nil {
+					slog.Warn("Could not requeue expired jobs", slog.Any("error", err))
+				}
+				continue
+			}
+			if len(ids) > 0 {
+				slog.Info("Requeued jobs with an expired lease", slog.Int("count", len(ids)))
+			}
+			r.enqueue(ids)
+
+			stale, err := r.staleQueued(r.ctx)
+			if err != nil {
+				if r.ctx.Err() == nil {
+					slog.Warn("Could not list queued jobs", slog.Any("error", err))
+				}
+				continue
+			}
+			if len(stale) > 0 {
+				slog.Info("Picked up jobs queued for longer than a lease", slog.Int("count", len(stale)))
+			}
+			r.enqueue(stale)
+		}
+	}
+}
+
+// staleQueued returns the jobs queued for longer than a lease that are not waiting in this
+// process. They may wait in the queue of another live runner, the first runner to claim a
+// job runs it and the others skip it.
+func (r *Runner) staleQueued(ctx context.Context) ([]string, error) {
+	queued, err := r.db.ListJobs(ctx, models.JobQueued)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-r.Lease)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ids []string
+	for _, job := range queued {
+		if id := job.ID.String(); job.CreatedAt.Before(cutoff) && !r.waiting[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Shutdown stops the workers. The running jobs are interrupted and queued again for the next start.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	r.stop()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Submit stores a queued job of the given type and hands it to the workers
+func (r *Runner) Submit(ctx context.Context, name string, params json.RawMessage, createdBy string) (*models.Job, error) {
+	jobType, ok := r.types[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownType, name)
+	}
+	if jobType.Validate != nil {
+		if err := jobType.Validate(params); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidParams, err)
+		}
+	}
+	job := &models.Job{
+		ID:        uuid.New(),
+		Type:      name,
+		Status:    models.JobQueued,
+		Params:    models.RawJSON(params),
+		CreatedBy: createdBy,
+	}
+	if err := r.db.CreateJob(ctx, job); err != nil {
+		return nil, err
+	}
+	id := job.ID.String()
+	r.mu.Lock()
+	r.waiting[id] = true
+	r.mu.Unlock()
+	select {
+	case r.queue <- id:
+		slog.InfoContext(ctx, "Job queued", slog.Any("job", job))
+		return job, nil
+	default:
+		r.mu.Lock()
+		delete(r.waiting, id)
+		r.mu.Unlock()
+		// Nobody would pick the job up, fail it rather than leaving it queued until the next restart
+		_, err := r.db.UpdateJob(ctx, id, []string{models.JobQueued}, map[string]interface{}{
+			"status":      models.JobFailed,
+			"error":       ErrQueueFull.Error(),
+			"finished_at": time.Now(),
+		})
+		return nil, errors.Join(ErrQueueFull, err)
+	}
+}
+
+// Get returns the job with the given ID
+func (r *Runner) Get(ctx context.Context, id string) (*models.Job, error) {
+	return r.db.GetJob(ctx, id)
+}
+
+// Cancel cancels a queued job, or interrupts a job running in this process, in which case the
+// returned job is still running and becomes cancelled once its handler returns. Finished jobs
+// cannot be cancelled, database.ErrJobStatus is returned with the job.
+func (r *Runner) Cancel(ctx context.Context, id string) (*models.Job, error) {
+	job, err := r.db.UpdateJob(ctx, id, []string{models.JobQueued}, map[string]interface{}{
+		"status":      models.JobCancelled,
+		"finished_at": time.Now(),
+	})
+	if err == nil || !errors.Is(err, database.ErrJobStatus) || job.Status != models.JobRunning {
+		return job, err
+	}
+	r.mu.Lock()
+	cancel, ok := r.cancels[id]
+	r.mu.Unlock()
+	if !ok {
+		// Running in another process, or about to be requeued by a shutdown
+		return job, err
+	}
+	cancel(errCancelled)
+	return job, nil
+}
+
+// work runs the queued jobs until the runner is stopped
+func (r *Runner) work() {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case id := <-r.queue:
+			r.mu.Lock()
+			delete(r.waiting, id)
+			r.mu.Unlock()
+			r.run(id)
+		}
+	}
+}
+
+// run claims the job and executes it, then records its outcome
+func (r *Runner) run(id string) {
+	job, err := r.db.UpdateJob(r.ctx, id, []string{models.JobQueued}, map[string]interface{}{
+		"status":           models.JobRunning,
+		"owner":            r.owner,
+		"lease_expires_at": time.Now().Add(r.Lease),
+		"started_at":       time.Now(),
+		"progress":         0,
+		"total":            0,
+	})
+	if errors.Is(err, database.ErrJobStatus) {
+		// Cancelled while queued, or claimed by another process
+		return
+	}
+	if err != nil {
+		slog.Error("Could not start job", slog.String("id", id), slog.Any("error", err))
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(r.ctx)
+	r.mu.Lock()
+	r.cancels[id] = cancel
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.cancels, id)
+		r.mu.Unlock()
+		cancel(nil)
+	}()
+
+	r.wg.Add(1)
+	go r.renew(ctx, id, cancel)
+
+	slog.Info("Job started", slog.Any("job", job))
+	var done, total int
+	var lastUpdate time.Time
+	progress := func(d, t int) {
+		done, total = d, t
+		if time.Since(lastUpdate) < ProgressInterval {
+			return
+		}
+		lastUpdate = time.Now()
+		_, err := r.db.UpdateRunningJob(ctx, id, r.owner, map[string]interface{}{"progress": d, "total": t})
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("Could not record job progress", slog.String("id", id), slog.Any("error", err))
+		}
+	}
+	result, err := r.execute(ctx, job, progress)
+
+	fields := map[string]interface{}{"progress": done, "total": total, "finished_at": time.Now(), "lease_expires_at": nil}
+	// A failed job may return a partial result too, e.g. the rows processed before the error
+	if result != nil {
+		encoded, marshalErr := json.Marshal(result)
+		if marshalErr != nil && err == nil {
+			err = marshalErr
+		} else if marshalErr == nil {
+			fields["result"] = models.RawJSON(encoded)
+		}
+	}
+	switch {
+	case err == nil:
+		fields["status"] = models.JobSucceeded
+	case errors.Is(context.Cause(ctx), errCancelled):
+		fields["status"] = models.JobCancelled
+	case errors.Is(context.Cause(ctx), errLeaseLost):
+		// Requeued for another runner, which owns the outcome now
+		slog.Warn("Job interrupted after losing its lease", slog.String("id", id))
+		return
+	case r.ctx.Err() != nil:
+		// Interrupted by the shutdown, the next start runs the job again
+		fields = map[string]interface{}{"status": models.JobQueued, "owner": "", "lease_expires_at": nil}
+	default:
+		fields["status"] = models.JobFailed
+		fields["error"] = truncate(err.Error(), maxErrorLength)
+	}
+
+	// The outcome is recorded even though the job context is done
+	finishCtx, cancelFinish := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFinish()
+	job, err = r.db.UpdateRunningJob(finishCtx, id, r.owner, fields)
+	if err != nil {
+		slog.Error("Could not record job outcome", slog.String("id", id), slog.Any("error", err))
+		return
+	}
+	slog.Info("Job finished", slog.Any("job", job), slog.Int("progress", job.Progress))
+}
+
+// renew extends the lease of a running job until ctx is done. The job is interrupted if it was
+// requeued in the meantime, or if the lease could not be renewed before it expired.
+func (r *Runner) renew(ctx context.Context, id string, cancel context.CancelCauseFunc) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.Lease / 3)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		_, err := r.db.UpdateRunningJob(ctx, id, r.owner, map[string]interface{}{"lease_expires_at": now.Add(r.Lease)})
+		switch {
+		case err == nil:
+			renewed = now
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, database.ErrJobStatus):
+			// Requeued by another runner
+			cancel(errLeaseLost)
+			return
+		default:
+			slog.Warn("Could not renew job lease", slog.String("id", id), slog.Any("error", err))
+			if time.Since(renewed) >= r.Lease {
+				cancel(errLeaseLost)
+				return
+			}
+		}
+	}
+}
+
+// execute runs the handler of the job, turning a panic into an error
+func (r *Runner) execute(ctx context.Context, job *models.Job, progress Progress) (result interface{}, err error) {
+	jobType, ok := r.types[job.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownType, job.Type)
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+	return jobType.Run(ctx, job, progress)
+}
+
+// truncate cuts s to at most n bytes, without splitting a UTF-8 character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,254 @@
+package jobs_test
+
+import (
+	"company-service/config"
+	"company-service/database"
+	"company-service/jobs"
+	"company-service/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDB(t *testing.T) database.Database {
+	db, err := database.NewMemoryDatabase(&config.Config{User: "admin", Password: "admin-password"})
+	require.NoError(t, err)
+	return db
+}
+
+// newRunner returns a runner with a "count" job counting up to its "to" parameter, and a "block"
+// job running until it is cancelled
+func newRunner(t *testing.T, db database.Database, queueSize int) *jobs.Runner {
+	runner := jobs.NewRunner(db, 2, queueSize)
+	runner.Register("count", jobs.Type{
+		Validate: func(params json.RawMessage) error {
+			var p struct{ To int }
+			if err := json.Unmarshal(params, &p); err != nil {
+				return err
+			}
+			if p.To <= 0 {
+				return errors.New("to must be positive")
+			}
+			return nil
+		},
+		Run: func(ctx context.Context, job *models.Job, progress jobs.Progress) (interface{}, error) {
+			var p struct{ To int }
+			if err := json.Unmarshal([]byte(job.Params), &p); err != nil {
+				return nil, err
+			}
+			for i := 1; i <= p.To; i++ {
+				progress(i, p.To)
+			}
+			return map[string]int{"counted": p.To}, nil
+		},
+	})
+	runner.Register("block", jobs.Type{
+		Run: func(ctx context.Context, job *models.Job, progress jobs.Progress) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+	runner.Register("fail", jobs.Type{
+		Run: func(ctx context.Context, job *models.Job, progress jobs.Progress) (interface{}, error) {
+			panic("boom")
+		},
+	})
+	t.Cleanup(func() { _ = runner.Shutdown(context.Background()) })
+	return runner
+}
+
+// waitStatus waits until the job has the given status and returns it
+func waitStatus(t *testing.T, db database.Database, id uuid.UUID, status string) *models.Job {
+	var job *models.Job
+	require.Eventually(t, func() bool {
+		var err error
+		job, err = db.GetJob(context.Background(), id.String())
+		require.NoError(t, err)
+		return job.Status == status
+	}, 5*time.Second, 10*time.Millisecond, "job %s never became %s", id, status)
+	return job
+}
+
+func TestRunnerRunsJobs(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	runner := newRunner(t, db, 10)
+	require.NoError(t, runner.Start(ctx))
+
+	job, err := runner.Submit(ctx, "count", json.RawMessage(`{"to":5}`), "admin")
+	require.NoError(t, err)
+	assert.Equal(t, models.JobQueued, job.Status)
+
+	done := waitStatus(t, db, job.ID, models.JobSucceeded)
+	assert.JSONEq(t, `{"counted":5}`, string(done.Result))
+	assert.Equal(t, 5, done.Progress)
+	assert.Equal(t, 5, done.Total)
+	assert.Equal(t, "admin", done.CreatedBy)
+	assert.NotNil(t, done.StartedAt)
+	assert.NotNil(t, done.FinishedAt)
+
+	failed, err := runner.Submit(ctx, "fail", nil, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, "job panicked: boom", waitStatus(t, db, failed.ID, models.JobFailed).Error)
+}
+
+func TestRunnerRejectsInvalidJobs(t *testing.T) {
+	runner := newRunner(t, newDB(t), 10)
+	_, err := runner.Submit(context.Background(), "unknown", nil, "admin")
+	assert.True(t, errors.Is(err, jobs.ErrUnknownType))
+	_, err = runner.Submit(context.Background(), "count", json.RawMessage(`{"to":0}`), "admin")
+	assert.True(t, errors.Is(err, jobs.ErrInvalidParams))
+}
+
+func TestRunnerQueueFull(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	runner := newRunner(t, db, 1)
+
+	// Without workers the first job fills the queue
+	_, err := runner.Submit(ctx, "count", json.RawMessage(`{"to":1}`), "admin")
+	require.NoError(t, err)
+	_, err = runner.Submit(ctx, "count", json.RawMessage(`{"to":1}`), "admin")
+	assert.True(t, errors.Is(err, jobs.ErrQueueFull))
+
+	failed, err := db.ListJobs(ctx, models.JobFailed)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, jobs.ErrQueueFull.Error(), failed[0].Error)
+}
+
+func TestRunnerCancel(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	runner := newRunner(t, db, 10)
+
+	queued, err := runner.Submit(ctx, "count", json.RawMessage(`{"to":1}`), "admin")
+	require.NoError(t, err)
+	cancelled, err := runner.Cancel(ctx, queued.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, models.JobCancelled, cancelled.Status)
+
+	require.NoError(t, runner.Start(ctx))
+	running, err := runner.Submit(ctx, "block", nil, "admin")
+	require.NoError(t, err)
+	waitStatus(t, db, running.ID, models.JobRunning)
+	job, err := runner.Cancel(ctx, running.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, models.JobRunning, job.Status)
+	waitStatus(t, db, running.ID, models.JobCancelled)
+
+	// A finished job cannot be cancelled
+	job, err = runner.Cancel(ctx, running.ID.String())
+	assert.True(t, errors.Is(err, database.ErrJobStatus))
+	assert.Equal(t, models.JobCancelled, job.Status)
+}
+
+func TestRunnerRestartRecovery(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+
+	// A shutdown queues the running jobs again
+	first := newRunner(t, db, 10)
+	require.NoError(t, first.Start(ctx))
+	blocked, err := first.Submit(ctx, "block", nil, "admin")
+	require.NoError(t, err)
+	waitStatus(t, db, blocked.ID, models.JobRunning)
+	require.NoError(t, first.Shutdown(ctx))
+	waitStatus(t, db, blocked.ID, models.JobQueued)
+
+	// A job left running by a process that crashed is run again
+	crashed := &models.Job{ID: uuid.New(), Type: "count", Status: models.JobRunning, Params: `{"to":3}`}
+	require.NoError(t, db.CreateJob(ctx, crashed))
+
+	second := newRunner(t, db, 10)
+	require.NoError(t, second.Start(ctx))
+	done := waitStatus(t, db, crashed.ID, models.JobSucceeded)
+	assert.JSONEq(t, `{"counted":3}`, string(done.Result))
+	waitStatus(t, db, blocked.ID, models.JobRunning)
+}
+
+func TestRunnerLeases(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+
+	// A job leased by a live runner is left alone, then run once the lease expires
+	expires := time.Now().Add(300 * time.Millisecond)
+	leased := &models.Job{ID: uuid.New(), Type: "count", Status: models.JobRunning, Params: `{"to":2}`, Owner: "other", LeaseExpiresAt: &expires}
+	require.NoError(t, db.CreateJob(ctx, leased))
+
+	runner := newRunner(t, db, 10)
+	runner.Lease = 60 * time.Millisecond
+	require.NoError(t, runner.Start(ctx))
+	job, err := db.GetJob(ctx, leased.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, models.JobRunning, job.Status)
+	assert.Equal(t, "other", job.Owner)
+	done := waitStatus(t, db, leased.ID, models.JobSucceeded)
+	assert.JSONEq(t, `{"counted":2}`, string(done.Result))
+	assert.Nil(t, done.LeaseExpiresAt)
+
+	// The lease of a running job is renewed
+	blocked, err := runner.Submit(ctx, "block", nil, "admin")
+	require.NoError(t, err)
+	first := waitStatus(t, db, blocked.ID, models.JobRunning)
+	require.NotNil(t, first.LeaseExpiresAt)
+	require.Eventually(t, func() bool {
+		job, err := db.GetJob(ctx, blocked.ID.String())
+		require.NoError(t, err)
+		return job.LeaseExpiresAt != nil && job.LeaseExpiresAt.After(*first.LeaseExpiresAt)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A job requeued behind the back of its runner is interrupted without recording an outcome,
+	// then picked up again as a job queued for longer than a lease
+	_, err = db.UpdateJob(ctx, blocked.ID.String(), []string{models.JobRunning}, map[string]interface{}{"status": models.JobQueued, "owner": "", "lease_expires_at": nil})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, err := db.GetJob(ctx, blocked.ID.String())
+		require.NoError(t, err)
+		return job.Status == models.JobRunning && job.StartedAt.After(*first.StartedAt)
+	}, 5*time.Second, 10*time.Millisecond)
+	job, err = db.GetJob(ctx, blocked.ID.String())
+	require.NoError(t, err)
+	assert.Nil(t, job.FinishedAt)
+}
+
+func TestRunnerPicksUpStaleQueuedJobs(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	runner := newRunner(t, db, 10)
+	runner.Lease = 60 * time.Millisecond
+	require.NoError(t, runner.Start(ctx))
+
+	// Accepted by a runner that died before running it, nothing else would hand it to a worker
+	orphan := &models.Job{ID: uuid.New(), Type: "count", Status: models.JobQueued, Params: `{"to":4}`}
+	require.NoError(t, db.CreateJob(ctx, orphan))
+	done := waitStatus(t, db, orphan.ID, models.JobSucceeded)
+	assert.JSONEq(t, `{"counted":4}`, string(done.Result))
+}
+
+func TestRunnerTruncatesLongErrors(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	runner := newRunner(t, db, 10)
+	runner.Register("accents", jobs.Type{
+		Run: func(ctx context.Context, job *models.Job, progress jobs.Progress) (interface{}, error) {
+			// Two bytes per character, the cut falls in the middle of one after the prefix
+			return nil, errors.New("x" + strings.Repeat("é", 600))
+		},
+	})
+	require.NoError(t, runner.Start(ctx))
+
+	job, err := runner.Submit(ctx, "accents", nil, "admin")
+	require.NoError(t, err)
+	failed := waitStatus(t, db, job.ID, models.JobFailed)
+	assert.True(t, utf8.ValidString(failed.Error))
+	assert.Len(t, failed.Error, 999)
+}
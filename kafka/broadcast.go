@@ -0,0 +1,87 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+)
+
+// Broadcaster hands events to the subscribers of this process, e.g. the gRPC watch streams.
+// It is fed by a consumer of the topic, so that the subscribers get the events of every
+// instance, or by a BroadcastProducer when there is no topic.
+type Broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the events broadcast after Subscribe, until Unsubscribe
+type Subscription struct {
+	events chan EventMessage
+	// lagged is closed when an event was dropped because the buffer was full
+	lagged chan struct{}
+}
+
+// NewBroadcaster returns a broadcaster without subscribers
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subscribers: map[*Subscription]struct{}{}}
+}
+
+// Broadcast hands a copy of the event to every subscriber, it never blocks
+func (b *Broadcaster) Broadcast(event *EventMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for subscription := range b.subscribers {
+		select {
+		case subscription.events <- copyEvent(event):
+		default:
+			// Never block on a slow subscriber, it is told it missed events instead
+			delete(b.subscribers, subscription)
+			close(subscription.lagged)
+		}
+	}
+}
+
+// Subscribe returns a subscription buffering up to buffer events
+func (b *Broadcaster) Subscribe(buffer int) *Subscription {
+	subscription := &Subscription{events: make(chan EventMessage, buffer), lagged: make(chan struct{})}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+// Unsubscribe stops the delivery of the events to the subscription
+func (b *Broadcaster) Unsubscribe(subscription *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, subscription)
+}
+
+// Events receives the events in the order they were broadcast
+func (s *Subscription) Events() <-chan EventMessage {
+	return s.events
+}
+
+// Lagged is closed when the subscription fell behind and was dropped, the events still
+// buffered are the last ones it gets
+func (s *Subscription) Lagged() <-chan struct{} {
+	return s.lagged
+}
+
+// BroadcastProducer is a Producer decorator that also broadcasts every event it produces. It
+// stands in for a consumer when the events are not read back from Kafka, e.g. in memory mode.
+type BroadcastProducer struct {
+	Producer
+	broadcaster *Broadcaster
+}
+
+// NewBroadcastProducer wraps the producer so that its events are broadcast
+func NewBroadcastProducer(producer Producer, broadcaster *Broadcaster) *BroadcastProducer {
+	return &BroadcastProducer{Producer: producer, broadcaster: broadcaster}
+}
+
+// ProduceEvent broadcasts the event, then produces it. The change is already committed, so
+// the subscribers get it even when the delivery fails.
+func (p *BroadcastProducer) ProduceEvent(ctx context.Context, event *EventMessage) error {
+	p.broadcaster.Broadcast(event)
+	return p.Producer.ProduceEvent(ctx, event)
+}
@@ -0,0 +1,59 @@
+package kafka_test
+
+import (
+	"company-service/kafka"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcaster(t *testing.T) {
+	broadcaster := kafka.NewBroadcaster()
+	fast, slow, gone := broadcaster.Subscribe(2), broadcaster.Subscribe(1), broadcaster.Subscribe(2)
+	broadcaster.Unsubscribe(gone)
+
+	company := newCompany("Acme")
+	broadcaster.Broadcast(kafka.NewEvent("company_created", company))
+	company.Name = "Changed"
+	broadcaster.Broadcast(kafka.NewEvent("company_updated", company))
+
+	first, second := <-fast.Events(), <-fast.Events()
+	assert.Equal(t, "Acme", first.Company.Name)
+	assert.Equal(t, "company_updated", second.EventType)
+	assert.Empty(t, gone.Events())
+
+	// The slow subscription is dropped instead of blocking, it keeps the events it buffered
+	select {
+	case <-slow.Lagged():
+	default:
+		t.Fatal("the slow subscription is not lagged")
+	}
+	assert.Equal(t, "company_created", (<-slow.Events()).EventType)
+	broadcaster.Broadcast(kafka.NewEvent("company_deleted", company))
+	assert.Empty(t, slow.Events())
+	assert.Len(t, fast.Events(), 1)
+	select {
+	case <-fast.Lagged():
+		t.Fatal("the fast subscription is lagged")
+	default:
+	}
+}
+
+func TestBroadcastProducer(t *testing.T) {
+	memory := kafka.NewMemoryProducer()
+	broadcaster := kafka.NewBroadcaster()
+	subscription := broadcaster.Subscribe(1)
+	producer := kafka.NewBroadcastProducer(memory, broadcaster)
+	ctx := context.Background()
+
+	require.NoError(t, producer.ProduceEvent(ctx, kafka.NewEvent("company_created", newCompany("Acme"))))
+	assert.Equal(t, "Acme", (<-subscription.Events()).Company.Name)
+	assert.Len(t, memory.Events(), 1)
+
+	// The change is committed, so it is broadcast even when the delivery fails
+	memory.Close()
+	assert.ErrorIs(t, producer.ProduceEvent(ctx, kafka.NewEvent("company_updated", newCompany("Globex"))), kafka.ErrProducerClosed)
+	assert.Equal(t, "Globex", (<-subscription.Events()).Company.Name)
+}
@@ -3,59 +3,120 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
-	"log"
+	"github.com/google/uuid"
+	"log/slog"
+	"time"
 )
 
+// LagObserver receives the lag of a partition after each consumed message
+type LagObserver interface {
+	ObserveConsumerLag(topic string, partition int32, lag int64)
+}
+
 // KafkaConsumer wraps the Kafka consumer
 type KafkaConsumer struct {
-	consumer *kafka.Consumer
+	consumer    *kafka.Consumer
+	lagObserver LagObserver
 }
 
 // NewKafkaConsumer initializes a Kafka consumer
 func NewKafkaConsumer(kafkaURL, groupID, topic string) (*KafkaConsumer, error) {
-	// Create a new Kafka consumer instance
-	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+	return newKafkaConsumer(&kafka.ConfigMap{
 		"bootstrap.servers": kafkaURL,
 		"group.id":          groupID,
 		"auto.offset.reset": "earliest", // Start consuming from the earliest message
-	})
+	}, topic)
+}
+
+// NewBroadcastConsumer initializes a consumer reading the events produced from now on. It is
+// alone in a group named after groupPrefix, so that every instance reads all the events, and
+// it commits no offsets, so the group is gone once the consumer is closed.
+func NewBroadcastConsumer(kafkaURL, groupPrefix, topic string) (*KafkaConsumer, error) {
+	return newKafkaConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  kafkaURL,
+		"group.id":           groupPrefix + "-" + uuid.NewString(),
+		"auto.offset.reset":  "latest",
+		"enable.auto.commit": false,
+	}, topic)
+}
+
+func newKafkaConsumer(conf *kafka.ConfigMap, topic string) (*KafkaConsumer, error) {
+	// Create a new Kafka consumer instance
+	c, err := kafka.NewConsumer(conf)
 	if err != nil {
-		log.Fatalf("Failed to create Kafka consumer: %v", err)
+		return nil, fmt.Errorf("failed to create Kafka consumer: %w", err)
 	}
 
 	// Subscribe to the Kafka topic
 	err = c.Subscribe(topic, nil)
 	if err != nil {
+		c.Close()
 		return nil, err
 	}
 
 	return &KafkaConsumer{consumer: c}, nil
 }
 
+// SetLagObserver reports the consumer lag to the observer, it must be called before ConsumeEvents
+func (c *KafkaConsumer) SetLagObserver(observer LagObserver) {
+	c.lagObserver = observer
+}
+
 // ConsumeEvents listens for messages from a specific topic and sends them to a channel
 func (c *KafkaConsumer) ConsumeEvents(ctx context.Context, topic string, consumedEvents chan EventMessage) {
 	for {
 		select {
 		case <-ctx.Done(): // Context canceled or expired
-			log.Println("Context canceled. Stopping message consumption.")
+			slog.InfoContext(ctx, "Context canceled. Stopping message consumption.")
 			return
 
 		default:
-			msg, err := c.consumer.ReadMessage(100 * 1000) // Set timeout for consuming
+			msg, err := c.consumer.ReadMessage(100 * time.Millisecond) // Bounds the wait, so a cancellation is seen
 			if err == nil && msg.TopicPartition.Topic != nil && *msg.TopicPartition.Topic == topic {
+				c.observeLag(msg.TopicPartition)
+				// Continue the trace of the producer, the span ends once the event is handed over
+				spanCtx, span := startConsumerSpan(ctx, msg)
 				var event EventMessage
 				err = json.Unmarshal(msg.Value, &event)
 				if err != nil {
-					log.Printf("Error unmarshaling event: %v", err)
+					slog.ErrorContext(spanCtx, "Error unmarshaling event", slog.Any("error", err), slog.Int64("offset", int64(msg.TopicPartition.Offset)))
+					recordError(span, err)
+					span.End()
 					continue
 				}
-				consumedEvents <- event
+				event.spanContext = span.SpanContext()
+				// The reader may be gone, the cancellation must still stop the loop
+				select {
+				case consumedEvents <- event:
+				case <-ctx.Done():
+					span.End()
+					return
+				}
+				span.End()
 			}
 		}
 	}
 }
 
+// observeLag reports how many messages of the partition are left after the consumed one.
+// The high watermark comes from the consumer's local statistics, no broker call is made.
+func (c *KafkaConsumer) observeLag(partition kafka.TopicPartition) {
+	if c.lagObserver == nil {
+		return
+	}
+	_, high, err := c.consumer.GetWatermarkOffsets(*partition.Topic, partition.Partition)
+	if err != nil || high < 0 {
+		return
+	}
+	lag := high - int64(partition.Offset) - 1
+	if lag < 0 {
+		lag = 0
+	}
+	c.lagObserver.ObserveConsumerLag(*partition.Topic, partition.Partition, lag)
+}
+
 // Close the consumer when done
 func (c *KafkaConsumer) Close() error {
 	err := c.consumer.Close()
@@ -0,0 +1,34 @@
+package kafka
+
+import "context"
+
+// Invalidator drops the state cached for a company
+type Invalidator interface {
+	Invalidate(id string)
+}
+
+// InvalidatingProducer is a Producer decorator that invalidates the cached company of every
+// event it produces, so caches follow the same code paths that emit company events
+type InvalidatingProducer struct {
+	Producer
+	invalidator Invalidator
+}
+
+// NewInvalidatingProducer wraps the producer so that every event invalidates its company
+func NewInvalidatingProducer(producer Producer, invalidator Invalidator) *InvalidatingProducer {
+	return &InvalidatingProducer{Producer: producer, invalidator: invalidator}
+}
+
+// ProduceEvent invalidates the company of the event, then produces it
+func (p *InvalidatingProducer) ProduceEvent(ctx context.Context, event *EventMessage) error {
+	InvalidateEvent(p.invalidator, event)
+	return p.Producer.ProduceEvent(ctx, event)
+}
+
+// InvalidateEvent invalidates the company of an event, e.g. one received by a KafkaConsumer
+// that was produced by another replica
+func InvalidateEvent(invalidator Invalidator, event *EventMessage) {
+	if event.Company != nil {
+		invalidator.Invalidate(event.Company.ID.String())
+	}
+}
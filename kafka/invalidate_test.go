@@ -0,0 +1,39 @@
+package kafka_test
+
+import (
+	"company-service/kafka"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// invalidations records the invalidated IDs
+type invalidations []string
+
+func (i *invalidations) Invalidate(id string) {
+	*i = append(*i, id)
+}
+
+func TestInvalidatingProducer(t *testing.T) {
+	memory := kafka.NewMemoryProducer()
+	var invalidated invalidations
+	producer := kafka.NewInvalidatingProducer(memory, &invalidated)
+	ctx := context.Background()
+
+	company := newCompany("Acme")
+	require.NoError(t, producer.ProduceEvent(ctx, kafka.NewEvent("company_updated", company)))
+	assert.Equal(t, invalidations{company.ID.String()}, invalidated)
+	assert.Len(t, memory.Events(), 1)
+
+	// The company is invalidated before the delivery, which may fail
+	memory.Close()
+	other := newCompany("Globex")
+	assert.Error(t, producer.ProduceEvent(ctx, kafka.NewEvent("company_deleted", other)))
+	assert.Equal(t, invalidations{company.ID.String(), other.ID.String()}, invalidated)
+
+	// An event without a company invalidates nothing
+	kafka.InvalidateEvent(&invalidated, &kafka.EventMessage{EventType: "company_deleted"})
+	assert.Len(t, invalidated, 2)
+}
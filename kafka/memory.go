@@ -0,0 +1,127 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrProducerClosed is returned when producing on a closed producer
+var ErrProducerClosed = errors.New("producer is closed")
+
+// MemoryProducer is a thread-safe in-memory implementation of the Producer interface.
+// It captures every produced event and fans them out to subscribers, which makes it
+// usable in hermetic tests and demo mode.
+type MemoryProducer struct {
+	mu          sync.Mutex
+	events      []EventMessage
+	subscribers []chan EventMessage
+	closed      bool
+}
+
+// NewMemoryProducer creates an in-memory producer
+func NewMemoryProducer() *MemoryProducer {
+	return &MemoryProducer{}
+}
+
+// ProduceEvent records the event and delivers it to all subscribers
+func (p *MemoryProducer) ProduceEvent(ctx context.Context, event *EventMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrProducerClosed
+	}
+	// Round trip through JSON like the Kafka producer and consumer do
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var captured EventMessage
+	if err = json.Unmarshal(eventBytes, &captured); err != nil {
+		return err
+	}
+	// Subscribers continue the trace of the producer, like a Kafka consumer reading the headers
+	captured.spanContext = trace.SpanContextFromContext(ctx)
+	p.events = append(p.events, captured)
+	for _, subscriber := range p.subscribers {
+		select {
+		case subscriber <- copyEvent(&captured):
+		default:
+			// Never block the producer on a slow subscriber, Events keeps the full history
+		}
+	}
+	return nil
+}
+
+// Ping fails once the producer is closed
+func (p *MemoryProducer) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrProducerClosed
+	}
+	return nil
+}
+
+// Events returns a copy of all the events produced so far, in order
+func (p *MemoryProducer) Events() []EventMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	events := make([]EventMessage, len(p.events))
+	for i := range p.events {
+		events[i] = copyEvent(&p.events[i])
+	}
+	return events
+}
+
+// Subscribe returns a channel that receives every event produced from now on.
+// Events are dropped for the subscriber if its buffer is full. The channel is closed by Close.
+func (p *MemoryProducer) Subscribe(buffer int) <-chan EventMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subscriber := make(chan EventMessage, buffer)
+	if p.closed {
+		close(subscriber)
+		return subscriber
+	}
+	p.subscribers = append(p.subscribers, subscriber)
+	return subscriber
+}
+
+// Close stops the producer and closes all subscriber channels
+func (p *MemoryProducer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for _, subscriber := range p.subscribers {
+		close(subscriber)
+	}
+	p.subscribers = nil
+}
+
+// copyEvent copies the event and its company so later changes by the caller are not visible
+func copyEvent(event *EventMessage) EventMessage {
+	captured := *event
+	if event.Company != nil {
+		company := *event.Company
+		captured.Company = &company
+	}
+	return captured
+}
@@ -0,0 +1,50 @@
+package kafka_test
+
+import (
+	"company-service/kafka"
+	"company-service/models"
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCompany(name string) *models.Company {
+	return &models.Company{ID: uuid.New(), Name: name, Employees: 5, Registered: true, Type: "Corporations"}
+}
+
+func TestMemoryProducer(t *testing.T) {
+	producer := kafka.NewMemoryProducer()
+	ctx := context.Background()
+	subscriber := producer.Subscribe(1)
+
+	company := newCompany("Acme")
+	require.NoError(t, producer.ProduceEvent(ctx, kafka.NewEvent("company_created", company)))
+	// The events are copies, a later change of the company does not show
+	company.Name = "Changed"
+	require.NoError(t, producer.ProduceEvent(ctx, kafka.NewEvent("company_updated", company)))
+
+	events := producer.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, "company_created", events[0].EventType)
+	assert.Equal(t, "Acme", events[0].Company.Name)
+	assert.Equal(t, "Changed", events[1].Company.Name)
+	// The subscriber with a full buffer missed the second event
+	event := <-subscriber
+	assert.Equal(t, "company_created", event.EventType)
+	assert.Empty(t, subscriber)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, producer.ProduceEvent(cancelled, kafka.NewEvent("company_deleted", company)), context.Canceled)
+
+	require.NoError(t, producer.Ping(ctx))
+	producer.Close()
+	_, open := <-subscriber
+	assert.False(t, open)
+	assert.ErrorIs(t, producer.Ping(ctx), kafka.ErrProducerClosed)
+	assert.ErrorIs(t, producer.ProduceEvent(ctx, kafka.NewEvent("company_deleted", company)), kafka.ErrProducerClosed)
+	assert.Len(t, producer.Events(), 2)
+}
@@ -2,14 +2,25 @@ package kafka
 
 import (
 	"company-service/models"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
-	"log"
+	"log/slog"
 	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
+// queueFullRetry is how long ProduceEvent waits before retrying when the local queue is full
+const queueFullRetry = 10 * time.Millisecond
+
 type Producer interface {
-	ProduceEvent(event *EventMessage) error
+	ProduceEvent(ctx context.Context, event *EventMessage) error
+	// Ping checks that the broker is reachable
+	Ping(ctx context.Context) error
 	Close()
 }
 
@@ -23,6 +34,27 @@ type EventMessage struct {
 	EventType string          `json:"event_type"`
 	Timestamp string          `json:"timestamp"`
 	Company   *models.Company `json:"company"`
+
+	// spanContext is the trace the event was consumed in, it travels in the message headers
+	spanContext trace.SpanContext
+}
+
+// NewEvent returns an event of the given type for the company, stamped with the current time
+func NewEvent(eventType string, company *models.Company) *EventMessage {
+	return &EventMessage{
+		EventType: eventType,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Company:   company,
+	}
+}
+
+// ContextWithTrace returns a copy of the context carrying the trace of the consumed event,
+// so the work done for the event joins the trace of the request that produced it
+func (e EventMessage) ContextWithTrace(ctx context.Context) context.Context {
+	if !e.spanContext.IsValid() {
+		return ctx
+	}
+	return trace.ContextWithSpanContext(ctx, e.spanContext)
 }
 
 // NewKafkaProducer creates a new Kafka producer
@@ -37,47 +69,117 @@ func NewKafkaProducer(kafkaURL string, topic string) (*KafkaProducer, error) {
 	return &KafkaProducer{producer: p, topic: topic}, nil
 }
 
-// ProduceEvent produces an event to the Kafka topic
-func (p *KafkaProducer) ProduceEvent(event *EventMessage) error {
-	deliveryChan := make(chan kafka.Event)
+// ProduceEvent queues an event for the Kafka topic and returns without waiting for the broker,
+// the delivery report is logged when it arrives. The context only bounds the wait for room
+// when the local queue of librdkafka is full.
+func (p *KafkaProducer) ProduceEvent(ctx context.Context, event *EventMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	// Serialize the event to JSON
 	eventBytes, err := json.Marshal(event)
 	if err != nil {
-		log.Printf("Error marshaling event: %v", err)
+		slog.ErrorContext(ctx, "Error marshaling event", slog.Any("error", err))
 		return err
 	}
 
-	// Produce the event
-	err = p.producer.Produce(&kafka.Message{
+	// The span lasts until the delivery report, its trace context goes in the headers
+	var headers []kafka.Header
+	ctx, span := startProducerSpan(ctx, p.topic, event, &headers)
+	message := &kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &p.topic, Partition: kafka.PartitionAny},
 		Value:          eventBytes,
-	}, deliveryChan)
-
-	if err != nil {
+		Headers:        headers,
+	}
+	// Buffered so the delivery report never blocks librdkafka
+	deliveryChan := make(chan kafka.Event, 1)
+	if err = p.produce(ctx, message, deliveryChan); err != nil {
+		recordError(span, err)
+		span.End()
 		return err
 	}
 
 	p.wg.Add(1)
-	// Handle the delivery report asynchronously
+	// Handle the delivery report asynchronously, Close waits for it
 	go func() {
-		defer func() {
-			close(deliveryChan)
-			p.wg.Done()
-		}()
-		ev := <-deliveryChan
-		switch e := ev.(type) {
-		case *kafka.Message:
-			if e.TopicPartition.Error != nil {
-				log.Printf("Error producing message: %v", e.TopicPartition.Error)
-			} else {
-				log.Printf("Produced event: %s", e.Value)
-			}
-		}
+		defer p.wg.Done()
+		defer span.End()
+		recordError(span, deliveryError(ctx, event, <-deliveryChan))
 	}()
+	return nil
+}
+
+// produce hands the message to librdkafka, retrying while its local queue is full until the
+// context is done
+func (p *KafkaProducer) produce(ctx context.Context, message *kafka.Message, deliveryChan chan kafka.Event) error {
+	for {
+		err := p.producer.Produce(message, deliveryChan)
+		var kafkaErr kafka.Error
+		if !errors.As(err, &kafkaErr) || kafkaErr.Code() != kafka.ErrQueueFull {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("the producer queue stayed full until the deadline: %w", ctx.Err())
+		case <-time.After(queueFullRetry):
+		}
+	}
+}
 
+// deliveryError logs a delivery report and returns its error. Only the event type and the
+// company ID are logged, never the payload.
+func deliveryError(ctx context.Context, event *EventMessage, ev kafka.Event) error {
+	switch e := ev.(type) {
+	case *kafka.Message:
+		if e.TopicPartition.Error != nil {
+			slog.ErrorContext(ctx, "Error producing message", eventAttrs(event, slog.Any("error", e.TopicPartition.Error))...)
+			return e.TopicPartition.Error
+		}
+		slog.DebugContext(ctx, "Produced event", eventAttrs(event,
+			slog.Int("partition", int(e.TopicPartition.Partition)),
+			slog.Int64("offset", int64(e.TopicPartition.Offset)),
+		)...)
+	case kafka.Error:
+		slog.ErrorContext(ctx, "Error producing message", eventAttrs(event, slog.Any("error", e))...)
+		return e
+	}
 	return nil
 }
 
+// eventAttrs returns the log attributes identifying an event followed by the extra ones
+func eventAttrs(event *EventMessage, extra ...any) []any {
+	attrs := []any{slog.String("event_type", event.EventType)}
+	if event.Company != nil {
+		attrs = append(attrs, slog.String("company_id", event.Company.ID.String()))
+	}
+	return append(attrs, extra...)
+}
+
+// Ping fetches the metadata of the topic from the broker. librdkafka does not take a context,
+// the request is bounded by the deadline of the context instead (one second without deadline).
+func (p *KafkaProducer) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	timeout := time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	metadata, err := p.producer.GetMetadata(&p.topic, false, int(timeout.Milliseconds()))
+	if err != nil {
+		return fmt.Errorf("could not fetch the metadata of topic %s: %w", p.topic, err)
+	}
+	if topic, ok := metadata.Topics[p.topic]; ok && topic.Error.Code() != kafka.ErrNoError {
+		return fmt.Errorf("topic %s is not available: %w", p.topic, topic.Error)
+	}
+	return nil
+}
+
+// QueueLength returns the number of messages and requests waiting to be sent to the broker
+func (p *KafkaProducer) QueueLength() int {
+	return p.producer.Len()
+}
+
 // Close the producer when done
 func (p *KafkaProducer) Close() {
 	p.wg.Wait()
@@ -0,0 +1,83 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the producer and consumer spans, it uses the global tracer provider
+var tracer = otel.Tracer("company-service/kafka")
+
+// headerCarrier adapts the headers of a Kafka message to the propagation.TextMapCarrier interface
+type headerCarrier struct {
+	headers *[]kafka.Header
+}
+
+func (c headerCarrier) Get(key string) string {
+	for _, header := range *c.headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) {
+	for i, header := range *c.headers {
+		if header.Key == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, len(*c.headers))
+	for i, header := range *c.headers {
+		keys[i] = header.Key
+	}
+	return keys
+}
+
+var _ propagation.TextMapCarrier = headerCarrier{}
+
+// startProducerSpan starts the span of a produced message and writes its W3C trace context to the headers
+func startProducerSpan(ctx context.Context, topic string, event *EventMessage, headers *[]kafka.Header) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, topic+" publish", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(
+		semconv.MessagingSystemKafka,
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingOperationTypePublish,
+		attribute.String("event.type", event.EventType),
+	))
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{headers: headers})
+	return ctx, span
+}
+
+// startConsumerSpan starts the span of a consumed message as a child of the trace context in its headers
+func startConsumerSpan(ctx context.Context, msg *kafka.Message) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier{headers: &msg.Headers})
+	return tracer.Start(ctx, *msg.TopicPartition.Topic+" process", trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(
+		semconv.MessagingSystemKafka,
+		semconv.MessagingDestinationName(*msg.TopicPartition.Topic),
+		semconv.MessagingOperationTypeDeliver,
+		semconv.MessagingDestinationPartitionID(strconv.Itoa(int(msg.TopicPartition.Partition))),
+		semconv.MessagingKafkaMessageOffset(int(msg.TopicPartition.Offset)),
+	))
+}
+
+// recordError marks the span as failed when err is not nil
+func recordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
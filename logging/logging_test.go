@@ -0,0 +1,48 @@
+package logging_test
+
+import (
+	"bytes"
+	"company-service/logging"
+	"company-service/models"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := logging.New(&buffer, slog.LevelInfo, "json")
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+
+	company := models.Company{ID: uuid.New(), Name: "Acme", Description: "Confidential plans", Type: "NonProfit"}
+	logger.InfoContext(ctx, "company created",
+		slog.Any("company", company),
+		slog.String("password", "hunter2"),
+		slog.Group("request", slog.String("Authorization", "Bearer abc")),
+	)
+	logger.DebugContext(ctx, "not written")
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &record))
+	assert.Equal(t, "company created", record["msg"])
+	assert.Equal(t, "req-1", record["request_id"])
+	assert.Equal(t, logging.RedactedValue, record["password"])
+	assert.Equal(t, map[string]interface{}{"Authorization": logging.RedactedValue}, record["request"])
+	assert.Equal(t, map[string]interface{}{"id": company.ID.String(), "name": "Acme", "type": "NonProfit"}, record["company"])
+	assert.NotContains(t, buffer.String(), "Confidential")
+	assert.NotContains(t, buffer.String(), "not written")
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := logging.ParseLevel("debug")
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelDebug, level)
+
+	_, err = logging.ParseLevel("verbose")
+	assert.Error(t, err)
+}
@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"company-service/config"
+	"company-service/database"
+	"company-service/models"
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Database is a database.Database decorator that records the duration of every call
+type Database struct {
+	database.Database
+	metrics *Metrics
+}
+
+// NewDatabase wraps the database to record its call timings
+func NewDatabase(db database.Database, metrics *Metrics) *Database {
+	return &Database{Database: db, metrics: metrics}
+}
+
+// observe records the duration of a call, a missing record is not counted as an error
+func (d *Database) observe(method string, start time.Time, err error) {
+	outcome := "success"
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		outcome = "not_found"
+	case err != nil:
+		outcome = "error"
+	}
+	d.metrics.dbDuration.WithLabelValues(method, outcome).Observe(time.Since(start).Seconds())
+}
+
+func (d *Database) GetUserByUsername(ctx context.Context, username string) (user *models.User, err error) {
+	defer func(start time.Time) { d.observe("GetUserByUsername", start, err) }(time.Now())
+	return d.Database.GetUserByUsername(ctx, username)
+}
+
+func (d *Database) CreateCompany(ctx context.Context, company *models.Company) (err error) {
+	defer func(start time.Time) { d.observe("CreateCompany", start, err) }(time.Now())
+	return d.Database.CreateCompany(ctx, company)
+}
+
+func (d *Database) CreateCompanies(ctx context.Context, companies []*models.Company) (err error) {
+	defer func(start time.Time) { d.observe("CreateCompanies", start, err) }(time.Now())
+	return d.Database.CreateCompanies(ctx, companies)
+}
+
+func (d *Database) GetCompany(ctx context.Context, id string) (company *models.Company, err error) {
+	defer func(start time.Time) { d.observe("GetCompany", start, err) }(time.Now())
+	return d.Database.GetCompany(ctx, id)
+}
+
+func (d *Database) GetCompanies(ctx context.Context, ids []string) (companies []models.Company, err error) {
+	defer func(start time.Time) { d.observe("GetCompanies", start, err) }(time.Now())
+	return d.Database.GetCompanies(ctx, ids)
+}
+
+func (d *Database) GetCompanyForUpdate(ctx context.Context, id string) (company *models.Company, err error) {
+	defer func(start time.Time) { d.observe("GetCompanyForUpdate", start, err) }(time.Now())
+	return d.Database.GetCompanyForUpdate(ctx, id)
+}
+
+func (d *Database) UpdateCompany(ctx context.Context, id string, fields map[string]interface{}) (company *models.Company, err error) {
+	defer func(start time.Time) { d.observe("UpdateCompany", start, err) }(time.Now())
+	return d.Database.UpdateCompany(ctx, id, fields)
+}
+
+func (d *Database) DeleteCompany(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { d.observe("DeleteCompany", start, err) }(time.Now())
+	return d.Database.DeleteCompany(ctx, id)
+}
+
+// Transaction records the duration of the whole transaction, the calls made by fn are recorded too
+func (d *Database) Transaction(ctx context.Context, fn func(tx database.Database) error) (err error) {
+	defer func(start time.Time) { d.observe("Transaction", start, err) }(time.Now())
+	return d.Database.Transaction(ctx, func(tx database.Database) error {
+		return fn(&Database{Database: tx, metrics: d.metrics})
+	})
+}
+
+func (d *Database) CreateDefaultUser(ctx context.Context, conf *config.Config) (err error) {
+	defer func(start time.Time) { d.observe("CreateDefaultUser", start, err) }(time.Now())
+	return d.Database.CreateDefaultUser(ctx, conf)
+}
+
+func (d *Database) GetIfExistsByID(ctx context.Context, id string) (company *models.Company, err error) {
+	defer func(start time.Time) { d.observe("GetIfExistsByID", start, err) }(time.Now())
+	return d.Database.GetIfExistsByID(ctx, id)
+}
+
+func (d *Database) CheckIfExistsByName(ctx context.Context, name string) bool {
+	defer d.observe("CheckIfExistsByName", time.Now(), nil)
+	return d.Database.CheckIfExistsByName(ctx, name)
+}
+
+func (d *Database) GetCompanyFields(ctx context.Context, id string, columns []string) (company *models.Company, err error) {
+	defer func(start time.Time) { d.observe("GetCompanyFields", start, err) }(time.Now())
+	return d.Database.GetCompanyFields(ctx, id, columns)
+}
+
+func (d *Database) GetCompanyByName(ctx context.Context, name string) (company *models.Company, err error) {
+	defer func(start time.Time) { d.observe("GetCompanyByName", start, err) }(time.Now())
+	return d.Database.GetCompanyByName(ctx, name)
+}
+
+func (d *Database) ListCompanies(ctx context.Context, after string, limit int) (companies []models.Company, err error) {
+	defer func(start time.Time) { d.observe("ListCompanies", start, err) }(time.Now())
+	return d.Database.ListCompanies(ctx, after, limit)
+}
+
+func (d *Database) StreamCompanies(ctx context.Context, filter database.CompanyFilter, fn func(company *models.Company) error) (err error) {
+	defer func(start time.Time) { d.observe("StreamCompanies", start, err) }(time.Now())
+	return d.Database.StreamCompanies(ctx, filter, fn)
+}
+
+func (d *Database) CreateJob(ctx context.Context, job *models.Job) (err error) {
+	defer func(start time.Time) { d.observe("CreateJob", start, err) }(time.Now())
+	return d.Database.CreateJob(ctx, job)
+}
+
+func (d *Database) GetJob(ctx context.Context, id string) (job *models.Job, err error) {
+	defer func(start time.Time) { d.observe("GetJob", start, err) }(time.Now())
+	return d.Database.GetJob(ctx, id)
+}
+
+func (d *Database) UpdateJob(ctx context.Context, id string, from []string, fields map[string]interface{}) (job *models.Job, err error) {
+	defer func(start time.Time) { d.observe("UpdateJob", start, err) }(time.Now())
+	return d.Database.UpdateJob(ctx, id, from, fields)
+}
+
+func (d *Database) UpdateRunningJob(ctx context.Context, id, owner string, fields map[string]interface{}) (job *models.Job, err error) {
+	defer func(start time.Time) { d.observe("UpdateRunningJob", start, err) }(time.Now())
+	return d.Database.UpdateRunningJob(ctx, id, owner, fields)
+}
+
+func (d *Database) RequeueExpiredJobs(ctx context.Context, now time.Time) (ids []string, err error) {
+	defer func(start time.Time) { d.observe("RequeueExpiredJobs", start, err) }(time.Now())
+	return d.Database.RequeueExpiredJobs(ctx, now)
+}
+
+func (d *Database) ListJobs(ctx context.Context, statuses ...string) (jobs []models.Job, err error) {
+	defer func(start time.Time) { d.observe("ListJobs", start, err) }(time.Now())
+	return d.Database.ListJobs(ctx, statuses...)
+}
+
+func (d *Database) WriteJobOutput(ctx context.Context, id string, r io.Reader) (written int64, err error) {
+	defer func(start time.Time) { d.observe("WriteJobOutput", start, err) }(time.Now())
+	return d.Database.WriteJobOutput(ctx, id, r)
+}
+
+func (d *Database) ReadJobOutput(ctx context.Context, id string, w io.Writer) (written int64, err error) {
+	defer func(start time.Time) { d.observe("ReadJobOutput", start, err) }(time.Now())
+	return d.Database.ReadJobOutput(ctx, id, w)
+}
+
+func (d *Database) ReindexCompanyName(ctx context.Context, id string) (changed bool, err error) {
+	defer func(start time.Time) { d.observe("ReindexCompanyName", start, err) }(time.Now())
+	return d.Database.ReindexCompanyName(ctx, id)
+}
+
+func (d *Database) CreateUser(ctx context.Context, user *models.User) (err error) {
+	defer func(start time.Time) { d.observe("CreateUser", start, err) }(time.Now())
+	return d.Database.CreateUser(ctx, user)
+}
+
+func (d *Database) UpdateUser(ctx context.Context, username string, fields map[string]interface{}) (user *models.User, err error) {
+	defer func(start time.Time) { d.observe("UpdateUser", start, err) }(time.Now())
+	return d.Database.UpdateUser(ctx, username, fields)
+}
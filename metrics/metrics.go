@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"company-service/database"
+	"company-service/middleware"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the Prometheus collectors of the service in their own registry
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests      *prometheus.CounterVec
+	httpDuration      *prometheus.HistogramVec
+	dbDuration        *prometheus.HistogramVec
+	kafkaDeliveries   *prometheus.CounterVec
+	kafkaConsumerLags *prometheus.GaugeVec
+	grpcCalls         *prometheus.CounterVec
+	grpcDuration      *prometheus.HistogramVec
+}
+
+// New creates the collectors and registers them along with the Go runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by method, route template and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latencies by method, route template and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_call_duration_seconds",
+			Help:    "Duration of the Database calls by method and outcome.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"method", "outcome"}),
+		kafkaDeliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_producer_deliveries_total",
+			Help: "Produced events by type and outcome of queuing them for delivery (success, failure or timeout).",
+		}, []string{"event_type", "outcome"}),
+		kafkaConsumerLags: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_consumer_lag_messages",
+			Help: "Messages left to consume by topic and partition.",
+		}, []string{"topic", "partition"}),
+		grpcCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "gRPC calls by method and status code.",
+		}, []string{"method", "code"}),
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "gRPC call latencies by method and status code, streams last until they end.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "code"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration, m.dbDuration, m.kafkaDeliveries, m.kafkaConsumerLags,
+		m.grpcCalls, m.grpcDuration,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware records the count and latency of the requests. The route is the mux path
+// template, e.g. /api/companies/{id}, so IDs do not create new series.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := middleware.NewStatusRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		status := strconv.Itoa(recorder.Status)
+		m.httpRequests.WithLabelValues(r.Method, route, status).Inc()
+		m.httpDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObserveConsumerLag implements kafka.LagObserver
+func (m *Metrics) ObserveConsumerLag(topic string, partition int32, lag int64) {
+	m.kafkaConsumerLags.WithLabelValues(topic, strconv.Itoa(int(partition))).Set(float64(lag))
+}
+
+// RegisterQueueLength exports the length of the producer queue
+func (m *Metrics) RegisterQueueLength(queueLength func() int) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "kafka_producer_queue_length",
+		Help: "Messages waiting to be sent to the broker.",
+	}, func() float64 { return float64(queueLength()) }))
+}
+
+// RegisterCache exports the counters of the GetCompany cache
+func (m *Metrics) RegisterCache(cache *database.CachedDatabase) {
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "company_cache_hits_total",
+			Help: "GetCompany calls served from the cache.",
+		}, func() float64 { return float64(cache.Stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "company_cache_misses_total",
+			Help: "GetCompany calls that went to the database.",
+		}, func() float64 { return float64(cache.Stats().Misses) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "company_cache_evictions_total",
+			Help: "Entries evicted from the cache because it was full.",
+		}, func() float64 { return float64(cache.Stats().Evictions) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "company_cache_entries",
+			Help: "Entries currently in the cache.",
+		}, func() float64 { return float64(cache.Stats().Entries) }),
+	)
+}
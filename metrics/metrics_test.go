@@ -0,0 +1,94 @@
+package metrics_test
+
+import (
+	"company-service/config"
+	"company-service/database"
+	"company-service/kafka"
+	"company-service/metrics"
+	"company-service/middleware"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// scrape returns the metrics in the Prometheus text format
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	return rec.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	m := metrics.New()
+	ctx := context.Background()
+
+	// HTTP requests are labelled with the route template, not the raw path
+	router := mux.NewRouter()
+	router.Use(m.Middleware)
+	router.HandleFunc("/api/companies/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}).Methods(http.MethodGet)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/companies/"+uuid.NewString(), nil))
+
+	// Database calls are timed per method and outcome
+	memory, err := database.NewMemoryDatabase(&config.Config{User: "admin", Password: "admin-password"})
+	require.NoError(t, err)
+	db := metrics.NewDatabase(memory, m)
+	_, err = db.GetCompany(ctx, uuid.NewString())
+	require.Error(t, err)
+
+	// Deliveries are counted per event type and outcome
+	memoryProducer := kafka.NewMemoryProducer()
+	producer := metrics.NewProducer(memoryProducer, m)
+	require.NoError(t, producer.ProduceEvent(ctx, &kafka.EventMessage{EventType: "company_created"}))
+	memoryProducer.Close()
+	require.Error(t, producer.ProduceEvent(ctx, &kafka.EventMessage{EventType: "company_deleted"}))
+
+	m.ObserveConsumerLag("company_events", 0, 7)
+	m.RegisterQueueLength(func() int { return 3 })
+
+	output := scrape(t, m)
+	assert.Contains(t, output, `http_requests_total{method="GET",route="/api/companies/{id}",status="404"} 1`)
+	assert.Contains(t, output, `http_request_duration_seconds_count{method="GET",route="/api/companies/{id}",status="404"} 1`)
+	assert.Contains(t, output, `db_call_duration_seconds_count{method="GetCompany",outcome="not_found"} 1`)
+	assert.Contains(t, output, `kafka_producer_deliveries_total{event_type="company_created",outcome="success"} 1`)
+	assert.Contains(t, output, `kafka_producer_deliveries_total{event_type="company_deleted",outcome="failure"} 1`)
+	assert.Contains(t, output, `kafka_consumer_lag_messages{partition="0",topic="company_events"} 7`)
+	assert.Contains(t, output, `kafka_producer_queue_length 3`)
+}
+
+func TestGRPCInterceptors(t *testing.T) {
+	m := metrics.New()
+	metricsInterceptor, recovery := m.UnaryServerInterceptor(), middleware.UnaryRecoveryInterceptor()
+	call := func(method string, handler grpc.UnaryHandler) error {
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		_, err := metricsInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return recovery(ctx, req, info, handler)
+		})
+		return err
+	}
+
+	require.NoError(t, call("/company.v1.CompanyService/GetCompany", func(context.Context, interface{}) (interface{}, error) {
+		return nil, nil
+	}))
+	// A panic is answered with INTERNAL, and recorded as such
+	err := call("/company.v1.CompanyService/GetCompany", func(context.Context, interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	output := scrape(t, m)
+	assert.Contains(t, output, `grpc_server_handled_total{code="OK",method="/company.v1.CompanyService/GetCompany"} 1`)
+	assert.Contains(t, output, `grpc_server_handled_total{code="Internal",method="/company.v1.CompanyService/GetCompany"} 1`)
+	assert.Contains(t, output, `grpc_server_handling_seconds_count{code="Internal",method="/company.v1.CompanyService/GetCompany"} 1`)
+}
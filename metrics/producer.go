@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"company-service/kafka"
+	"context"
+	"errors"
+)
+
+// Producer is a kafka.Producer decorator that counts the outcome of producing every event
+type Producer struct {
+	kafka.Producer
+	metrics *Metrics
+}
+
+// NewProducer wraps the producer to count its deliveries
+func NewProducer(producer kafka.Producer, metrics *Metrics) *Producer {
+	return &Producer{Producer: producer, metrics: metrics}
+}
+
+// ProduceEvent produces the event and records whether it was queued for delivery
+func (p *Producer) ProduceEvent(ctx context.Context, event *kafka.EventMessage) error {
+	err := p.Producer.ProduceEvent(ctx, event)
+	outcome := "success"
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		outcome = "timeout"
+	case err != nil:
+		outcome = "failure"
+	}
+	p.metrics.kafkaDeliveries.WithLabelValues(event.EventType, outcome).Inc()
+	return err
+}
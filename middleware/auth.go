@@ -2,39 +2,102 @@ package middleware
 
 import (
 	"company-service/config"
+	"company-service/models"
+	"company-service/utils"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
+	"gorm.io/gorm"
+	"log/slog"
 	"net/http"
 	"time"
 )
 
-// JwtMiddleware is a middleware that checks if the request has a valid JWT token
-func JwtMiddleware(next http.HandlerFunc, conf *config.Config) http.HandlerFunc {
+// UserStore finds the user of a token, it is implemented by database.Database
+type UserStore interface {
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+}
+
+// errInactiveUser is returned for the token of a user that was disabled or deleted since its login
+var errInactiveUser = errors.New("Unauthorized: The user is disabled")
+
+// JwtMiddleware is a middleware that checks if the request has a valid JWT token of an
+// active user, and a CSRF token when the request is not safe
+func JwtMiddleware(next http.HandlerFunc, conf *config.Config, users UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get the token from the cookie
 		cookie, err := r.Cookie("auth_token")
 		if err != nil {
-			http.Error(w, "Unauthorized: No token found", http.StatusUnauthorized)
+			utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized: No token found")
 			return
 		}
 
 		// Parse the token
-		tokenString := cookie.Value
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Check if the signing method is valid
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method")
-			}
-			return []byte(conf.JWTSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		claims, err := parseToken(cookie.Value, conf.JWTSecret)
+		if err != nil {
+			utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized: Invalid token")
 			return
 		}
+		if err = checkUser(r.Context(), users, claims.Subject); errors.Is(err, errInactiveUser) {
+			utils.SendErrorResponse(w, http.StatusUnauthorized, err.Error())
+			return
+		} else if err != nil {
+			utils.SendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// The browser sends the cookie on cross-site requests too, so they must prove they can read the CSRF token
+		if conf.CSRFProtection && !validCSRF(r) {
+			utils.SendErrorResponse(w, http.StatusForbidden, "Forbidden: Missing or invalid CSRF token")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), usernameKey{}, claims.Subject)))
+	}
+}
+
+type usernameKey struct{}
 
-		next.ServeHTTP(w, r)
+// Username returns the user authenticated by JwtMiddleware, or an empty string
+func Username(ctx context.Context) string {
+	username, _ := ctx.Value(usernameKey{}).(string)
+	return username
+}
+
+// checkUser returns errInactiveUser when the user of a token is disabled or no longer exists,
+// so that disabling a user revokes the tokens issued before
+func checkUser(ctx context.Context, users UserStore, username string) error {
+	user, err := users.GetUserByUsername(ctx, username)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return errInactiveUser
+	case err != nil:
+		slog.ErrorContext(ctx, "Failed to read the user of a token", slog.String("username", username), slog.Any("error", err))
+		return err
+	case user.Disabled:
+		return errInactiveUser
+	}
+	return nil
+}
+
+// parseToken verifies the signature and expiry of the token and returns its claims
+func parseToken(tokenString, secret string) (*jwt.StandardClaims, error) {
+	claims := &jwt.StandardClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		// Check if the signing method is valid
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
 	}
+	return claims, nil
 }
 
 // GenerateJWT generates a JWT token with the given username and secret
@@ -0,0 +1,62 @@
+package middleware_test
+
+import (
+	"company-service/config"
+	"company-service/middleware"
+	"company-service/models"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// users is a UserStore of the users by name, a nil user makes the lookup fail
+type users map[string]*models.User
+
+func (u users) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	user, ok := u[username]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if user == nil {
+		return nil, errors.New("connection refused")
+	}
+	return user, nil
+}
+
+func TestJwtMiddlewareUsers(t *testing.T) {
+	conf := &config.Config{JWTSecret: "secret"}
+	store := users{
+		"admin":  {Username: "admin"},
+		"alice":  {Username: "alice", Disabled: true},
+		"broken": nil,
+	}
+	handler := middleware.JwtMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "admin", middleware.Username(r.Context()))
+		w.WriteHeader(http.StatusOK)
+	}, conf, store)
+
+	for _, tt := range []struct {
+		username string
+		code     int
+	}{
+		{"admin", http.StatusOK},
+		// The tokens issued before the user was disabled, or deleted, are refused
+		{"alice", http.StatusUnauthorized},
+		{"bob", http.StatusUnauthorized},
+		{"broken", http.StatusInternalServerError},
+	} {
+		token, err := middleware.GenerateJWT(tt.username, conf.JWTSecret)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/api/companies", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		assert.Equal(t, tt.code, rec.Code, tt.username)
+	}
+}
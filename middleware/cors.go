@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"company-service/config"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// corsAllowedMethods and corsAllowedHeaders are what the API accepts from browsers
+const (
+	corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE"
+	corsAllowedHeaders = "Content-Type, If-Match, If-None-Match, " + CSRFHeader + ", " + RequestIDHeader + ", " + APIKeyHeader
+	corsExposedHeaders = RequestIDHeader + ", " + CSRFHeader + ", ETag, Location, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy, Retry-After"
+)
+
+// CORSMiddleware lets the browsers of conf.CORSAllowedOrigins call the API and answers
+// their preflight requests. Requests from other origins get no CORS header, so the
+// browser blocks the response. The router must have a route matching OPTIONS requests
+// for the preflight requests to reach the middleware.
+func CORSMiddleware(conf *config.Config) mux.MiddlewareFunc {
+	allowed := make(map[string]bool, len(conf.CORSAllowedOrigins))
+	for _, origin := range conf.CORSAllowedOrigins {
+		allowed[strings.TrimSuffix(origin, "/")] = true
+	}
+	// Browsers refuse credentials with a wildcard origin, so "*" never allows them
+	credentials := conf.CORSAllowCredentials && !allowed["*"]
+	maxAge := strconv.Itoa(int(conf.CORSMaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			header := w.Header()
+			header.Add("Vary", "Origin")
+			if origin == "" || !(allowed[origin] || allowed["*"]) {
+				if preflight {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if allowed["*"] && !credentials {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+			if credentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				header.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+				next.ServeHTTP(w, r)
+				return
+			}
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			header.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			header.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
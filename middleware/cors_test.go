@@ -0,0 +1,102 @@
+package middleware_test
+
+import (
+	"company-service/config"
+	"company-service/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	handler := func(conf *config.Config) http.Handler {
+		return middleware.CORSMiddleware(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+	send := func(h http.Handler, method, origin string, preflight bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/companies", nil)
+		req.Header.Set("Origin", origin)
+		if preflight {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	conf := &config.Config{
+		CORSAllowedOrigins:   []string{"https://admin.example.com"},
+		CORSAllowCredentials: true,
+		CORSMaxAge:           10 * time.Minute,
+	}
+
+	t.Run("Preflight from an allowed origin", func(t *testing.T) {
+		rec := send(handler(conf), http.MethodOptions, "https://admin.example.com", true)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, "https://admin.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "600", rec.Header().Get("Access-Control-Max-Age"))
+		assert.Contains(t, rec.Header().Get("Access-Control-Allow-Headers"), middleware.CSRFHeader)
+	})
+
+	t.Run("Requests from other origins get no CORS header", func(t *testing.T) {
+		rec := send(handler(conf), http.MethodOptions, "https://evil.example.com", true)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = send(handler(conf), http.MethodGet, "https://evil.example.com", false)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("Actual requests expose the response headers", func(t *testing.T) {
+		rec := send(handler(conf), http.MethodGet, "https://admin.example.com", false)
+		assert.Equal(t, "https://admin.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, rec.Header().Get("Access-Control-Expose-Headers"), middleware.CSRFHeader)
+		assert.Contains(t, rec.Header().Values("Vary"), "Origin")
+	})
+
+	t.Run("Wildcard origins never allow credentials", func(t *testing.T) {
+		wildcard := &config.Config{CORSAllowedOrigins: []string{"*"}, CORSAllowCredentials: true}
+		rec := send(handler(wildcard), http.MethodGet, "https://any.example.com", false)
+		assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Credentials"))
+	})
+}
+
+func TestJwtMiddlewareCSRF(t *testing.T) {
+	conf := &config.Config{JWTSecret: "secret", CSRFProtection: true}
+	token, err := middleware.GenerateJWT("admin", conf.JWTSecret)
+	assert.NoError(t, err)
+	handler := middleware.JwtMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, conf, users{"admin": {Username: "admin"}})
+	send := func(method, cookieToken, headerToken string) int {
+		req := httptest.NewRequest(method, "/api/companies", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		if cookieToken != "" {
+			req.AddCookie(&http.Cookie{Name: middleware.CSRFCookieName, Value: cookieToken})
+		}
+		if headerToken != "" {
+			req.Header.Set(middleware.CSRFHeader, headerToken)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "csrf", "csrf"))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "csrf", ""))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPatch, "csrf", "other"))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodDelete, "", "csrf"))
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "", ""))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/companies", nil)
+	req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error":"Forbidden: Missing or invalid CSRF token"}`, rec.Body.String())
+}
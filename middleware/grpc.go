@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"company-service/config"
+	"context"
+	"errors"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The gRPC clients send the JWT of the login in the authorization metadata, as "Bearer <token>".
+// There is no cookie, so no CSRF check either.
+const authorizationKey = "authorization"
+
+// UnaryAuthInterceptor is the JwtMiddleware of the unary gRPC calls
+func UnaryAuthInterceptor(conf *config.Config, users UserStore) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, conf, users)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthInterceptor is the JwtMiddleware of the streaming gRPC calls
+func StreamAuthInterceptor(conf *config.Config, users UserStore) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(stream.Context(), conf, users)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authenticate verifies the token of the call and its user, and returns a context carrying the user
+func authenticate(ctx context.Context, conf *config.Config, users UserStore) (context.Context, error) {
+	values := metadata.ValueFromIncomingContext(ctx, authorizationKey)
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized: No token found")
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized: The authorization must be a Bearer token")
+	}
+	claims, err := parseToken(token, conf.JWTSecret)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Unauthorized: Invalid token")
+	}
+	if err = checkUser(ctx, users, claims.Subject); errors.Is(err, errInactiveUser) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, "Internal server error")
+	}
+	return context.WithValue(ctx, usernameKey{}, claims.Subject), nil
+}
+
+// authenticatedStream replaces the context of a stream with the authenticated one
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// UnaryRecoveryInterceptor answers INTERNAL to a unary call whose handler panics, instead of
+// crashing the server
+func UnaryRecoveryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = recoveredError(ctx, info.FullMethod, recovered)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecoveryInterceptor ends a streaming call whose handler panics with INTERNAL
+func StreamRecoveryInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = recoveredError(stream.Context(), info.FullMethod, recovered)
+			}
+		}()
+		return handler(srv, stream)
+	}
+}
+
+// recoveredError logs the panic of a call with its stack and returns the error of the call
+func recoveredError(ctx context.Context, method string, recovered interface{}) error {
+	slog.ErrorContext(ctx, "gRPC handler panicked", slog.String("method", method),
+		slog.Any("panic", recovered), slog.String("stack", string(debug.Stack())))
+	return status.Error(codes.Internal, "Internal server error")
+}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"company-service/config"
+	"company-service/ratelimit"
+	"company-service/utils"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// APIKeyHeader is the header carrying the API key of a client
+const APIKeyHeader = "X-API-Key"
+
+// RateLimitMiddleware enforces a token bucket per client and route, configured by conf.RateLimit
+// and conf.RouteRateLimits. A client is identified by its API key when it is one of
+// conf.RateLimitAPIKeys, else by the common name of its verified client certificate, else by
+// the subject of a valid JWT, else by its IP address.
+// Every response carries the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers,
+// rejected requests get a 429 with Retry-After. If the store fails the request is let through.
+func RateLimitMiddleware(conf *config.Config, store ratelimit.Store) mux.MiddlewareFunc {
+	apiKeys := make(map[string]bool, len(conf.RateLimitAPIKeys))
+	for _, key := range conf.RateLimitAPIKeys {
+		apiKeys[key] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, limit := routeRateLimit(conf, r)
+			if limit.Requests <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			bucketLimit := ratelimit.Limit{Rate: float64(limit.Requests) / limit.Period.Seconds(), Burst: limit.Burst}
+			key := route + " " + clientKey(conf, apiKeys, r)
+			decision, err := store.Take(r.Context(), key, bucketLimit, time.Now())
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Rate limit store failed, the request is not limited", slog.Any("error", err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.ResetAfter)))
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", limit.Requests, ceilSeconds(limit.Period), limit.Burst))
+			if !decision.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+				utils.SendErrorResponse(w, http.StatusTooManyRequests, "Too many requests, retry later")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// routeRateLimit returns the route template matched by the request and its limit
+func routeRateLimit(conf *config.Config, r *http.Request) (string, config.RateLimit) {
+	route := routeKey(r)
+	if route == "" {
+		return r.Method + " " + r.URL.Path, conf.RateLimit
+	}
+	if limit, ok := conf.RouteRateLimits[route]; ok {
+		return route, limit
+	}
+	return route, conf.RateLimit
+}
+
+// clientKey identifies the client owning the bucket
+func clientKey(conf *config.Config, apiKeys map[string]bool, r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" && apiKeys[key] {
+		return "key:" + key
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		// Internal callers authenticated with a client certificate
+		return "cert:" + r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	if cookie, err := r.Cookie("auth_token"); err == nil {
+		if claims, err := parseToken(cookie.Value, conf.JWTSecret); err == nil && claims.Subject != "" {
+			return "user:" + claims.Subject
+		}
+	}
+	return "ip:" + ClientIP(r, conf.TrustedProxies)
+}
+
+// ClientIP returns the IP address of the client. X-Forwarded-For is only read when the
+// request comes from a trusted proxy, and then the right-most address that is not a trusted
+// proxy is the client, since anything left of it can be forged by the client.
+func ClientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !trusted(remote.Unmap(), trustedProxies) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			// Everything left of a malformed entry is untrustworthy
+			return remote.String()
+		}
+		if !trusted(addr.Unmap(), trustedProxies) {
+			return addr.Unmap().String()
+		}
+		remote = addr
+	}
+	return remote.Unmap().String()
+}
+
+// trusted reports whether the address belongs to one of the trusted proxy networks
+func trusted(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ceilSeconds rounds the duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
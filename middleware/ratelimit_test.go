@@ -0,0 +1,91 @@
+package middleware_test
+
+import (
+	"company-service/config"
+	"company-service/middleware"
+	"company-service/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newLimitedRouter serves GET and POST /api/companies/{id} behind the rate limiter
+func newLimitedRouter(conf *config.Config) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(middleware.RateLimitMiddleware(conf, ratelimit.NewMemoryStore()))
+	router.HandleFunc("/api/companies/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}).Methods(http.MethodGet, http.MethodPost)
+	return router
+}
+
+// send serves a request from the given remote address with optional headers
+func send(router http.Handler, method, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/companies/1", nil)
+	req.RemoteAddr = remoteAddr
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	conf := &config.Config{
+		JWTSecret:        "secret",
+		RateLimit:        config.RateLimit{Requests: 1, Period: time.Minute, Burst: 1},
+		RouteRateLimits:  map[string]config.RateLimit{"POST /api/companies/{id}": {Requests: 2, Period: time.Minute, Burst: 2}},
+		TrustedProxies:   []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		RateLimitAPIKeys: []string{"known-key"},
+	}
+
+	t.Run("Requests over the limit are rejected", func(t *testing.T) {
+		router := newLimitedRouter(conf)
+		rec := send(router, http.MethodGet, "192.0.2.1:1234", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "1", rec.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+
+		rec = send(router, http.MethodGet, "192.0.2.1:1234", nil)
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+		// Another client and another route are not affected
+		assert.Equal(t, http.StatusOK, send(router, http.MethodGet, "192.0.2.2:1234", nil).Code)
+		assert.Equal(t, http.StatusOK, send(router, http.MethodPost, "192.0.2.1:1234", nil).Code)
+		assert.Equal(t, http.StatusOK, send(router, http.MethodPost, "192.0.2.1:1234", nil).Code)
+	})
+
+	t.Run("X-Forwarded-For is only trusted from proxies", func(t *testing.T) {
+		router := newLimitedRouter(conf)
+		forwarded := map[string]string{"X-Forwarded-For": "192.0.2.1, 10.0.0.2"}
+		assert.Equal(t, http.StatusOK, send(router, http.MethodGet, "10.0.0.1:1234", forwarded).Code)
+		assert.Equal(t, http.StatusTooManyRequests, send(router, http.MethodGet, "10.0.0.3:1234", forwarded).Code)
+
+		// A direct client cannot pick its bucket with the header
+		forwarded = map[string]string{"X-Forwarded-For": "192.0.2.9"}
+		assert.Equal(t, http.StatusOK, send(router, http.MethodGet, "192.0.2.5:1234", forwarded).Code)
+		assert.Equal(t, http.StatusTooManyRequests, send(router, http.MethodGet, "192.0.2.5:1234", nil).Code)
+	})
+
+	t.Run("API keys and JWT subjects get their own quota", func(t *testing.T) {
+		router := newLimitedRouter(conf)
+		require.Equal(t, http.StatusOK, send(router, http.MethodGet, "192.0.2.1:1234", nil).Code)
+
+		assert.Equal(t, http.StatusOK, send(router, http.MethodGet, "192.0.2.1:1234", map[string]string{middleware.APIKeyHeader: "known-key"}).Code)
+		assert.Equal(t, http.StatusTooManyRequests, send(router, http.MethodGet, "192.0.2.1:1234", map[string]string{middleware.APIKeyHeader: "unknown-key"}).Code)
+
+		token, err := middleware.GenerateJWT("alice", conf.JWTSecret)
+		require.NoError(t, err)
+		cookie := map[string]string{"Cookie": "auth_token=" + token}
+		assert.Equal(t, http.StatusOK, send(router, http.MethodGet, "192.0.2.1:1234", cookie).Code)
+		assert.Equal(t, http.StatusTooManyRequests, send(router, http.MethodGet, "192.0.2.7:1234", cookie).Code)
+	})
+}
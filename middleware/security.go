@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"company-service/config"
+	"company-service/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// BodyLimitMiddleware caps the size of the request body of the matched route with
+// conf.RouteMaxBodyBytes, falling back to conf.MaxBodyBytes. Reading past the limit
+// fails with an *http.MaxBytesError.
+func BodyLimitMiddleware(conf *config.Config) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit, ok := conf.RouteMaxBodyBytes[routeKey(r)]
+			if !ok {
+				limit = conf.MaxBodyBytes
+			}
+			if limit > 0 && r.Body != nil {
+				if r.ContentLength > limit {
+					utils.SendErrorResponse(w, http.StatusRequestEntityTooLarge, "Request body too large")
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SecurityHeadersMiddleware sets the browser security headers on every response.
+// Strict-Transport-Security is only sent over HTTPS, browsers ignore it otherwise.
+func SecurityHeadersMiddleware(conf *config.Config) mux.MiddlewareFunc {
+	hsts := "max-age=" + strconv.Itoa(int(conf.HSTSMaxAge.Seconds())) + "; includeSubDomains"
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			header.Set("X-Content-Type-Options", "nosniff")
+			header.Set("X-Frame-Options", "DENY")
+			header.Set("Referrer-Policy", "no-referrer")
+			if conf.ContentSecurityPolicy != "" {
+				header.Set("Content-Security-Policy", conf.ContentSecurityPolicy)
+			}
+			if r.TLS != nil && conf.HSTSMaxAge > 0 {
+				header.Set("Strict-Transport-Security", hsts)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package middleware_test
+
+import (
+	"company-service/config"
+	"company-service/middleware"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	conf := &config.Config{
+		MaxBodyBytes:      10,
+		RouteMaxBodyBytes: map[string]int64{"POST /large": 100},
+	}
+	router := mux.NewRouter()
+	router.Use(middleware.BodyLimitMiddleware(conf))
+	read := func(w http.ResponseWriter, r *http.Request) {
+		var maxBytesError *http.MaxBytesError
+		if _, err := io.ReadAll(r.Body); errors.As(err, &maxBytesError) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	}
+	router.HandleFunc("/small", read).Methods(http.MethodPost)
+	router.HandleFunc("/large", read).Methods(http.MethodPost)
+
+	send := func(path string, body string, chunked bool) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, send("/small", "0123456789", false))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send("/small", "0123456789+", false))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send("/small", "0123456789+", true))
+	assert.Equal(t, http.StatusOK, send("/large", strings.Repeat("x", 100), true))
+
+	// A declared length over the limit is refused before the handler runs, with the JSON error of the API
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/small", strings.NewReader("0123456789+")))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error":"Request body too large"}`, rec.Body.String())
+}
+
+func TestSecurityHeadersMiddleware(t *testing.T) {
+	conf := &config.Config{HSTSMaxAge: time.Hour, ContentSecurityPolicy: "default-src 'none'"}
+	handler := middleware.SecurityHeadersMiddleware(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", rec.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "default-src 'none'", rec.Header().Get("Content-Security-Policy"))
+	assert.Empty(t, rec.Header().Get("Strict-Transport-Security"))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "max-age=3600; includeSubDomains", rec.Header().Get("Strict-Transport-Security"))
+}
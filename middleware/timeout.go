@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"company-service/config"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TimeoutMiddleware bounds the request context with the time budget of the matched route.
+// The budget comes from conf.RouteTimeouts, falling back to conf.RequestTimeout.
+func TimeoutMiddleware(conf *config.Config) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := routeTimeout(conf, r)
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// routeTimeout returns the time budget configured for the route matched by the request
+func routeTimeout(conf *config.Config, r *http.Request) time.Duration {
+	if timeout, ok := conf.RouteTimeouts[routeKey(r)]; ok {
+		return timeout
+	}
+	return conf.RequestTimeout
+}
+
+// routeKey returns "METHOD /path/template" for the route matched by the request,
+// the key of the per route settings, or an empty string outside of a route
+func routeKey(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return r.Method + " " + template
+		}
+	}
+	return ""
+}
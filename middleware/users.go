@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"company-service/models"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserCache is a UserStore remembering the users read by the authentication for a short TTL,
+// so a token does not cost a database read per request. A user disabled or deleted in the
+// meantime is refused once its entry expires.
+type UserCache struct {
+	users UserStore
+	ttl   time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]cachedUser
+	lastSweep time.Time
+}
+
+// cachedUser is a lookup of a user, a nil user remembers that it does not exist
+type cachedUser struct {
+	user    *models.User
+	expires time.Time
+}
+
+// NewUserCache wraps the store, a TTL of zero or less reads every user from the store
+func NewUserCache(users UserStore, ttl time.Duration) *UserCache {
+	return &UserCache{users: users, ttl: ttl, entries: make(map[string]cachedUser)}
+}
+
+// GetUserByUsername returns the remembered user, reading it from the store when it expired.
+// Only the users found and those that do not exist are remembered, not the errors.
+func (c *UserCache) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	if c.ttl <= 0 {
+		return c.users.GetUserByUsername(ctx, username)
+	}
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[username]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		if entry.user == nil {
+			return nil, gorm.ErrRecordNotFound
+		}
+		user := *entry.user
+		return &user, nil
+	}
+
+	user, err := c.users.GetUserByUsername(ctx, username)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	entry = cachedUser{expires: now.Add(c.ttl)}
+	if user != nil {
+		// The password hash is not needed to check a token
+		cached := *user
+		cached.Password = ""
+		entry.user = &cached
+	}
+	c.mu.Lock()
+	c.entries[username] = entry
+	c.sweep(now)
+	c.mu.Unlock()
+	return user, err
+}
+
+// sweep drops the expired entries once per TTL. The caller must hold the lock.
+func (c *UserCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+	for username, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, username)
+		}
+	}
+}
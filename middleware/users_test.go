@@ -0,0 +1,65 @@
+package middleware_test
+
+import (
+	"company-service/middleware"
+	"company-service/models"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// countingUsers counts the lookups reaching the wrapped store
+type countingUsers struct {
+	users
+	calls int
+}
+
+func (c *countingUsers) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	c.calls++
+	return c.users.GetUserByUsername(ctx, username)
+}
+
+func TestUserCache(t *testing.T) {
+	ctx := context.Background()
+	store := &countingUsers{users: users{"alice": {Username: "alice", Password: "hash"}, "broken": nil}}
+	cache := middleware.NewUserCache(store, 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		user, err := cache.GetUserByUsername(ctx, "alice")
+		require.NoError(t, err)
+		assert.False(t, user.Disabled)
+	}
+	_, err := cache.GetUserByUsername(ctx, "bob")
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	_, err = cache.GetUserByUsername(ctx, "bob")
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	assert.Equal(t, 2, store.calls)
+
+	// The errors are not remembered
+	for i := 0; i < 2; i++ {
+		_, err = cache.GetUserByUsername(ctx, "broken")
+		assert.EqualError(t, err, "connection refused")
+	}
+	assert.Equal(t, 4, store.calls)
+
+	// A disabled user is seen once the entry expires
+	store.users["alice"].Disabled = true
+	time.Sleep(60 * time.Millisecond)
+	user, err := cache.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.True(t, user.Disabled)
+	assert.Equal(t, 5, store.calls)
+
+	// Without a TTL every lookup reads the store
+	uncached := middleware.NewUserCache(store, 0)
+	_, err = uncached.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	_, err = uncached.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 7, store.calls)
+}
@@ -2,8 +2,12 @@ package mocks
 
 import (
 	"company-service/config"
+	"company-service/database"
 	"company-service/models"
+	"context"
 	"github.com/stretchr/testify/mock"
+	"io"
+	"time"
 )
 
 // MockDatabase is a mock implementation of the Database interface
@@ -11,9 +15,10 @@ type MockDatabase struct {
 	mock.Mock
 }
 
-// Implement the methods of the Database interface
+// Implement the methods of the Database interface. The context is not recorded so that
+// expectations only need to match the business arguments.
 
-func (m *MockDatabase) GetUserByUsername(username string) (*models.User, error) {
+func (m *MockDatabase) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
 	args := m.Called(username)
 	if user, ok := args.Get(0).(*models.User); ok {
 		return user, args.Error(1)
@@ -22,12 +27,17 @@ func (m *MockDatabase) GetUserByUsername(username string) (*models.User, error)
 	return nil, args.Error(1)
 }
 
-func (m *MockDatabase) CreateCompany(company *models.Company) error {
+func (m *MockDatabase) CreateCompany(ctx context.Context, company *models.Company) error {
 	args := m.Called(company)
 	return args.Error(0)
 }
 
-func (m *MockDatabase) GetCompany(id string) (*models.Company, error) {
+func (m *MockDatabase) CreateCompanies(ctx context.Context, companies []*models.Company) error {
+	args := m.Called(companies)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) GetCompany(ctx context.Context, id string) (*models.Company, error) {
 	args := m.Called(id)
 	if company, ok := args.Get(0).(*models.Company); ok {
 		return company, args.Error(1) // Return the company if it's correctly asserted
@@ -37,7 +47,14 @@ func (m *MockDatabase) GetCompany(id string) (*models.Company, error) {
 	return nil, args.Error(1)
 }
 
-func (m *MockDatabase) UpdateCompany(id string, fields map[string]interface{}) (*models.Company, error) {
+func (m *MockDatabase) GetCompanyForUpdate(ctx context.Context, id string) (*models.Company, error) {
+	args := m.Called(id)
+	if company, ok := args.Get(0).(*models.Company); ok {
+		return company, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+func (m *MockDatabase) UpdateCompany(ctx context.Context, id string, fields map[string]interface{}) (*models.Company, error) {
 	args := m.Called(id, fields)
 	if company, ok := args.Get(0).(*models.Company); ok {
 		return company, args.Error(1)
@@ -45,22 +62,139 @@ func (m *MockDatabase) UpdateCompany(id string, fields map[string]interface{}) (
 	return nil, args.Error(1)
 }
 
-func (m *MockDatabase) DeleteCompany(id string) error {
+func (m *MockDatabase) DeleteCompany(ctx context.Context, id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
-func (m *MockDatabase) GetIfExistsByID(id string) (*models.Company, error) {
+func (m *MockDatabase) GetIfExistsByID(ctx context.Context, id string) (*models.Company, error) {
 	args := m.Called(id)
-	return args.Get(0).(*models.Company), args.Error(1)
+	if company, ok := args.Get(0).(*models.Company); ok {
+		return company, args.Error(1)
+	}
+	return nil, args.Error(1)
 }
-func (m *MockDatabase) CreateDefaultUser(conf *config.Config) error {
+func (m *MockDatabase) CreateDefaultUser(ctx context.Context, conf *config.Config) error {
 	args := m.Called(conf)
 	return args.Error(0)
 }
-func (m *MockDatabase) CheckIfExistsByName(name string) bool {
+func (m *MockDatabase) CheckIfExistsByName(ctx context.Context, name string) bool {
 	args := m.Called(name)
 	return args.Bool(0)
 }
+func (m *MockDatabase) GetCompanyFields(ctx context.Context, id string, columns []string) (*models.Company, error) {
+	args := m.Called(id, columns)
+	if company, ok := args.Get(0).(*models.Company); ok {
+		return company, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+func (m *MockDatabase) GetCompanyByName(ctx context.Context, name string) (*models.Company, error) {
+	args := m.Called(name)
+	if company, ok := args.Get(0).(*models.Company); ok {
+		return company, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+func (m *MockDatabase) GetCompanies(ctx context.Context, ids []string) ([]models.Company, error) {
+	args := m.Called(ids)
+	if companies, ok := args.Get(0).([]models.Company); ok {
+		return companies, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+func (m *MockDatabase) ListCompanies(ctx context.Context, after string, limit int) ([]models.Company, error) {
+	args := m.Called(after, limit)
+	if companies, ok := args.Get(0).([]models.Company); ok {
+		return companies, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+func (m *MockDatabase) StreamCompanies(ctx context.Context, filter database.CompanyFilter, fn func(company *models.Company) error) error {
+	args := m.Called(filter)
+	if companies, ok := args.Get(0).([]models.Company); ok {
+		for i := range companies {
+			if err := fn(&companies[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return args.Error(1)
+}
+func (m *MockDatabase) CreateJob(ctx context.Context, job *models.Job) error {
+	args := m.Called(job)
+	return args.Error(0)
+}
+func (m *MockDatabase) GetJob(ctx context.Context, id string) (*models.Job, error) {
+	args := m.Called(id)
+	if job, ok := args.Get(0).(*models.Job); ok {
+		return job, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+func (m *MockDatabase) UpdateJob(ctx context.Context, id string, from []string, fields map[string]interface{}) (*models.Job, error) {
+	args := m.Called(id, from, fields)
+	if job, ok := args.Get(0).(*models.Job); ok {
+		return job, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+func (m *MockDatabase) UpdateRunningJob(ctx context.Context, id, owner string, fields map[string]interface{}) (*models.Job, error) {
+	args := m.Called(id, owner, fields)
+	if job, ok := args.Get(0).(*models.Job); ok {
+		return job, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+func (m *MockDatabase) RequeueExpiredJobs(ctx context.Context, now time.Time) ([]string, error) {
+	args := m.Called(now)
+	if ids, ok := args.Get(0).([]string); ok {
+		return ids, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+func (m *MockDatabase) ListJobs(ctx context.Context, statuses ...string) ([]models.Job, error) {
+	args := m.Called(statuses)
+	if jobs, ok := args.Get(0).([]models.Job); ok {
+		return jobs, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+func (m *MockDatabase) WriteJobOutput(ctx context.Context, id string, r io.Reader) (int64, error) {
+	args := m.Called(id, r)
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockDatabase) ReadJobOutput(ctx context.Context, id string, w io.Writer) (int64, error) {
+	args := m.Called(id, w)
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockDatabase) ReindexCompanyName(ctx context.Context, id string) (bool, error) {
+	args := m.Called(id)
+	return args.Bool(0), args.Error(1)
+}
+func (m *MockDatabase) CreateUser(ctx context.Context, user *models.User) error {
+	args := m.Called(user)
+	return args.Error(0)
+}
+func (m *MockDatabase) UpdateUser(ctx context.Context, username string, fields map[string]interface{}) (*models.User, error) {
+	args := m.Called(username, fields)
+	if user, ok := args.Get(0).(*models.User); ok {
+		return user, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// Transaction returns the error of the expectation, or calls fn with the mock itself
+func (m *MockDatabase) Transaction(ctx context.Context, fn func(tx database.Database) error) error {
+	args := m.Called()
+	if err := args.Error(0); err != nil {
+		return err
+	}
+	return fn(m)
+}
+func (m *MockDatabase) Ping(ctx context.Context) error {
+	args := m.Called()
+	return args.Error(0)
+}
 func (m *MockDatabase) Close() error {
 	m.Called()
 	return nil
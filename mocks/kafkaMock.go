@@ -2,6 +2,7 @@ package mocks
 
 import (
 	"company-service/kafka"
+	"context"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -9,10 +10,14 @@ type MockKafkaProducer struct {
 	mock.Mock
 }
 
-func (m *MockKafkaProducer) ProduceEvent(event *kafka.EventMessage) error {
+func (m *MockKafkaProducer) ProduceEvent(ctx context.Context, event *kafka.EventMessage) error {
 	args := m.Called(event)
 	return args.Error(0)
 }
+func (m *MockKafkaProducer) Ping(ctx context.Context) error {
+	args := m.Called()
+	return args.Error(0)
+}
 func (m *MockKafkaProducer) Close() {
 	m.Called()
 }
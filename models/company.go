@@ -2,19 +2,33 @@ package models
 
 import (
 	"github.com/google/uuid"
+	"log/slog"
 	"time"
 )
 
 type CompanyType string
 
+// Company is a company record. NormalizedName is the unique key derived from Name with the
+// configured normalisation policy, it is maintained by the database layer. Its column fits the
+// longest NFKC expansion of a full name: a single character such as U+FDFA becomes 18.
 type Company struct {
-	ID          uuid.UUID  `json:"id" gorm:"primary_key"`
-	Name        string     `json:"name" gorm:"size:15;unique;not null"`
-	Description string     `json:"description" gorm:"size:3000"`
-	Employees   int        `json:"employees" gorm:"not null"`
-	Registered  bool       `json:"registered" gorm:"not null"`
-	Type        string     `json:"type" gorm:"type:enum('Corporations','NonProfit','Cooperative','Sole Proprietorship');not null"`
-	CreatedAt   time.Time  `json:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt   time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
-	DeletedAt   *time.Time `json:"deletedAt" gorm:"index"`
+	ID             uuid.UUID  `json:"id" gorm:"type:char(36);primary_key"`
+	Name           string     `json:"name" gorm:"size:15;unique;not null"`
+	NormalizedName string     `json:"-" gorm:"size:270;uniqueIndex"`
+	Description    string     `json:"description" gorm:"size:3000"`
+	Employees      int        `json:"employees" gorm:"not null"`
+	Registered     bool       `json:"registered" gorm:"not null"`
+	Type           string     `json:"type" gorm:"size:20;not null;check:chk_companies_type,type IN ('Corporations','NonProfit','Cooperative','Sole Proprietorship')"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+	DeletedAt      *time.Time `json:"deletedAt" gorm:"index"`
+}
+
+// LogValue keeps the logs short and free of the description when a company is logged
+func (c Company) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", c.ID.String()),
+		slog.String("name", c.Name),
+		slog.String("type", c.Type),
+	)
 }
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"log/slog"
+	"time"
+)
+
+// Statuses of a job. A job is queued until a worker claims it, and ends succeeded, failed or cancelled.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a long-running operation executed in the background by a worker
+type Job struct {
+	ID     uuid.UUID `json:"id" gorm:"type:char(36);primary_key"`
+	Type   string    `json:"type" gorm:"size:50;not null"`
+	Status string    `json:"status" gorm:"size:20;not null;index"`
+	// Params holds the input of the job, it is not returned by the API as it may be large.
+	// The size maps to LONGTEXT on MySQL and TEXT on PostgreSQL.
+	Params RawJSON `json:"-" gorm:"size:1073741824"`
+	// Progress counts the items processed so far, out of Total when it is known
+	Progress   int        `json:"progress" gorm:"not null;default:0"`
+	Total      int        `json:"total,omitempty" gorm:"not null;default:0"`
+	Result     RawJSON    `json:"result,omitempty" gorm:"size:1073741824"`
+	Error      string     `json:"error,omitempty" gorm:"size:1000"`
+	CreatedBy  string     `json:"created_by,omitempty" gorm:"size:255"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt  time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Owner is the runner executing the job, which renews its lease until the job is over
+	Owner          string     `json:"-" gorm:"size:100"`
+	LeaseExpiresAt *time.Time `json:"-" gorm:"index"`
+}
+
+// JobOutput is a chunk of the file written by a job. The file is stored in the database so
+// that every instance can serve it, in chunks so that it is never held in memory.
+type JobOutput struct {
+	JobID uuid.UUID `gorm:"type:char(36);primaryKey"`
+	Seq   int       `gorm:"primaryKey;autoIncrement:false"`
+	Data  []byte    `gorm:"size:1073741824"`
+}
+
+// Finished tells whether the job reached a final status
+func (j *Job) Finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// LogValue leaves the parameters and the result out of the logs
+func (j Job) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", j.ID.String()),
+		slog.String("type", j.Type),
+		slog.String("status", j.Status),
+	)
+}
+
+// RawJSON is a JSON document stored in a text column and embedded as is in the JSON of its model
+type RawJSON string
+
+// MarshalJSON returns the document, or null when it is empty
+func (r RawJSON) MarshalJSON() ([]byte, error) {
+	if r == "" {
+		return []byte("null"), nil
+	}
+	return []byte(r), nil
+}
+
+// UnmarshalJSON keeps the document as is
+func (r *RawJSON) UnmarshalJSON(data []byte) error {
+	*r = RawJSON(data)
+	return nil
+}
@@ -1,9 +1,19 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"log/slog"
+)
 
 type User struct {
 	gorm.Model
 	Username string `json:"username" gorm:"unique;not null"`
 	Password string `json:"password" gorm:"not null"`
+	// Disabled users cannot log in
+	Disabled bool `json:"disabled" gorm:"not null;default:false"`
+}
+
+// LogValue makes sure the password hash never reaches the logs
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(slog.Uint64("id", uint64(u.ID)), slog.String("username", u.Username), slog.Bool("disabled", u.Disabled))
 }
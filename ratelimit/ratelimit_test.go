@@ -0,0 +1,57 @@
+package ratelimit_test
+
+import (
+	"company-service/ratelimit"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	limit := ratelimit.Limit{Rate: 1, Burst: 2}
+	now := time.Now()
+
+	t.Run("Bursts are allowed then refilled over time", func(t *testing.T) {
+		store := ratelimit.NewMemoryStore()
+		for i := 1; i >= 0; i-- {
+			decision, err := store.Take(ctx, "client", limit, now)
+			require.NoError(t, err)
+			assert.True(t, decision.Allowed)
+			assert.Equal(t, i, decision.Remaining)
+		}
+
+		decision, err := store.Take(ctx, "client", limit, now)
+		require.NoError(t, err)
+		assert.False(t, decision.Allowed)
+		assert.Equal(t, time.Second, decision.RetryAfter)
+		assert.Equal(t, 2*time.Second, decision.ResetAfter)
+
+		decision, err = store.Take(ctx, "client", limit, now.Add(time.Second))
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+	})
+
+	t.Run("Keys have their own bucket", func(t *testing.T) {
+		store := ratelimit.NewMemoryStore()
+		for i := 0; i < 2; i++ {
+			_, err := store.Take(ctx, "first", limit, now)
+			require.NoError(t, err)
+		}
+		decision, err := store.Take(ctx, "second", limit, now)
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+	})
+
+	t.Run("Full buckets are dropped", func(t *testing.T) {
+		store := ratelimit.NewMemoryStore()
+		_, err := store.Take(ctx, "first", limit, now)
+		require.NoError(t, err)
+		_, err = store.Take(ctx, "second", limit, now.Add(2*time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, 1, store.Len())
+	})
+}
@@ -0,0 +1,128 @@
+package tlsconfig
+
+import (
+	"company-service/config"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// New returns the TLS configuration of the server, or nil when TLS is not configured.
+// The certificate is served by a CertificateReloader so it can be renewed without a restart.
+// With conf.TLSClientCAFile, client certificates signed by these CAs are verified (mutual TLS).
+func New(conf *config.Config) (*tls.Config, error) {
+	if conf.TLSCertFile == "" && conf.TLSKeyFile == "" {
+		if conf.TLSClientCAFile != "" {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	reloader, err := NewCertificateReloader(conf.TLSCertFile, conf.TLSKeyFile, conf.TLSReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if conf.TLSClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	caBytes, err := os.ReadFile(conf.TLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the client CA file: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caBytes) {
+		return nil, fmt.Errorf("no PEM certificate found in %s", conf.TLSClientCAFile)
+	}
+	tlsConfig.ClientCAs = clientCAs
+	switch conf.TLSClientAuth {
+	case "", "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unsupported TLS_CLIENT_AUTH %q: expected optional or require", conf.TLSClientAuth)
+	}
+	return tlsConfig, nil
+}
+
+// CertificateReloader serves a certificate loaded from disk and loads it again when the
+// files change. The files are checked at most once per interval, during a handshake.
+// A certificate that fails to load is logged and the previous one keeps being served.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	modTime     time.Time
+	nextCheck   time.Time
+}
+
+// NewCertificateReloader loads the certificate and its key
+func NewCertificateReloader(certFile, keyFile string, interval time.Duration) (*CertificateReloader, error) {
+	r := &CertificateReloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, it is meant for tls.Config.GetCertificate
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := time.Now(); !now.Before(r.nextCheck) {
+		r.nextCheck = now.Add(r.interval)
+		modTime, err := r.latestModTime()
+		if err == nil && modTime.After(r.modTime) {
+			err = r.load(modTime)
+		}
+		if err != nil {
+			slog.Error("Failed to reload the TLS certificate, keeping the current one", slog.Any("error", err))
+		}
+	}
+	return r.certificate, nil
+}
+
+// load reads the certificate files. The caller must hold the lock or own the reloader.
+func (r *CertificateReloader) load(modTime time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the TLS certificate: %w", err)
+	}
+	if r.certificate != nil {
+		slog.Info("Reloaded the TLS certificate", slog.String("file", r.certFile))
+	}
+	r.certificate = &certificate
+	r.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the last time one of the certificate files was modified
+func (r *CertificateReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to read the TLS certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
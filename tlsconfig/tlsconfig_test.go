@@ -0,0 +1,92 @@
+package tlsconfig_test
+
+import (
+	"company-service/config"
+	"company-service/tlsconfig"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a self-signed certificate and its key with the given common name
+func writeCertificate(t *testing.T, dir, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0o600))
+	return certFile, keyFile
+}
+
+// commonName returns the common name of the certificate served by the reloader
+func commonName(t *testing.T, reloader *tlsconfig.CertificateReloader) string {
+	certificate, err := reloader.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "first")
+	reloader, err := tlsconfig.NewCertificateReloader(certFile, keyFile, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "first", commonName(t, reloader))
+
+	// A renewed certificate is served from the next handshake
+	writeCertificate(t, dir, "second")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	assert.Equal(t, "second", commonName(t, reloader))
+
+	// A broken certificate keeps the previous one
+	require.NoError(t, os.WriteFile(certFile, []byte("broken"), 0o600))
+	future = future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	assert.Equal(t, "second", commonName(t, reloader))
+}
+
+func TestNew(t *testing.T) {
+	tlsConfig, err := tlsconfig.New(&config.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, tlsConfig)
+
+	certFile, keyFile := writeCertificate(t, t.TempDir(), "server")
+	tlsConfig, err = tlsconfig.New(&config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientCAFile: certFile})
+	require.NoError(t, err)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+
+	tlsConfig, err = tlsconfig.New(&config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientCAFile: certFile, TLSClientAuth: "require"})
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+
+	_, err = tlsconfig.New(&config.Config{TLSClientCAFile: certFile})
+	assert.Error(t, err)
+}
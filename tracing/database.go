@@ -0,0 +1,214 @@
+package tracing
+
+import (
+	"company-service/config"
+	"company-service/database"
+	"company-service/models"
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// Database is a database.Database decorator that records a client span for every call
+type Database struct {
+	database.Database
+	tracer trace.Tracer
+	system string
+}
+
+// NewDatabase wraps the database to trace its calls, system is the database driver name
+func NewDatabase(db database.Database, system string) *Database {
+	return &Database{
+		Database: db,
+		tracer:   otel.Tracer(ServiceName + "/database"),
+		system:   system,
+	}
+}
+
+// start starts the span of a call
+func (d *Database) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, semconv.DBSystemKey.String(d.system), semconv.DBOperationName(method))
+	return d.tracer.Start(ctx, "db."+method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// end records the error of a call and ends its span, a missing record is not an error
+func end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (d *Database) GetUserByUsername(ctx context.Context, username string) (user *models.User, err error) {
+	ctx, span := d.start(ctx, "GetUserByUsername")
+	defer func() { end(span, err) }()
+	return d.Database.GetUserByUsername(ctx, username)
+}
+
+func (d *Database) CreateCompany(ctx context.Context, company *models.Company) (err error) {
+	ctx, span := d.start(ctx, "CreateCompany", attribute.String("company.id", company.ID.String()))
+	defer func() { end(span, err) }()
+	return d.Database.CreateCompany(ctx, company)
+}
+
+func (d *Database) CreateCompanies(ctx context.Context, companies []*models.Company) (err error) {
+	ctx, span := d.start(ctx, "CreateCompanies", attribute.Int("company.count", len(companies)))
+	defer func() { end(span, err) }()
+	return d.Database.CreateCompanies(ctx, companies)
+}
+
+func (d *Database) GetCompany(ctx context.Context, id string) (company *models.Company, err error) {
+	ctx, span := d.start(ctx, "GetCompany", attribute.String("company.id", id))
+	defer func() { end(span, err) }()
+	return d.Database.GetCompany(ctx, id)
+}
+
+func (d *Database) GetCompanies(ctx context.Context, ids []string) (companies []models.Company, err error) {
+	ctx, span := d.start(ctx, "GetCompanies", attribute.Int("company.count", len(ids)))
+	defer func() { end(span, err) }()
+	return d.Database.GetCompanies(ctx, ids)
+}
+
+func (d *Database) GetCompanyForUpdate(ctx context.Context, id string) (company *models.Company, err error) {
+	ctx, span := d.start(ctx, "GetCompanyForUpdate", attribute.String("company.id", id))
+	defer func() { end(span, err) }()
+	return d.Database.GetCompanyForUpdate(ctx, id)
+}
+
+func (d *Database) UpdateCompany(ctx context.Context, id string, fields map[string]interface{}) (company *models.Company, err error) {
+	ctx, span := d.start(ctx, "UpdateCompany", attribute.String("company.id", id))
+	defer func() { end(span, err) }()
+	return d.Database.UpdateCompany(ctx, id, fields)
+}
+
+func (d *Database) DeleteCompany(ctx context.Context, id string) (err error) {
+	ctx, span := d.start(ctx, "DeleteCompany", attribute.String("company.id", id))
+	defer func() { end(span, err) }()
+	return d.Database.DeleteCompany(ctx, id)
+}
+
+// Transaction records a span around the transaction, the spans of the calls made by fn are its children
+func (d *Database) Transaction(ctx context.Context, fn func(tx database.Database) error) (err error) {
+	ctx, span := d.start(ctx, "Transaction")
+	defer func() { end(span, err) }()
+	return d.Database.Transaction(ctx, func(tx database.Database) error {
+		return fn(&Database{Database: tx, tracer: d.tracer, system: d.system})
+	})
+}
+
+func (d *Database) CreateDefaultUser(ctx context.Context, conf *config.Config) (err error) {
+	ctx, span := d.start(ctx, "CreateDefaultUser")
+	defer func() { end(span, err) }()
+	return d.Database.CreateDefaultUser(ctx, conf)
+}
+
+func (d *Database) GetIfExistsByID(ctx context.Context, id string) (company *models.Company, err error) {
+	ctx, span := d.start(ctx, "GetIfExistsByID", attribute.String("company.id", id))
+	defer func() { end(span, err) }()
+	return d.Database.GetIfExistsByID(ctx, id)
+}
+
+func (d *Database) CheckIfExistsByName(ctx context.Context, name string) bool {
+	ctx, span := d.start(ctx, "CheckIfExistsByName")
+	defer span.End()
+	return d.Database.CheckIfExistsByName(ctx, name)
+}
+
+func (d *Database) GetCompanyFields(ctx context.Context, id string, columns []string) (company *models.Company, err error) {
+	ctx, span := d.start(ctx, "GetCompanyFields", attribute.String("company.id", id), attribute.StringSlice("db.columns", columns))
+	defer func() { end(span, err) }()
+	return d.Database.GetCompanyFields(ctx, id, columns)
+}
+
+func (d *Database) GetCompanyByName(ctx context.Context, name string) (company *models.Company, err error) {
+	ctx, span := d.start(ctx, "GetCompanyByName")
+	defer func() { end(span, err) }()
+	return d.Database.GetCompanyByName(ctx, name)
+}
+
+func (d *Database) ListCompanies(ctx context.Context, after string, limit int) (companies []models.Company, err error) {
+	ctx, span := d.start(ctx, "ListCompanies", attribute.String("page.after", after), attribute.Int("page.limit", limit))
+	defer func() { end(span, err) }()
+	return d.Database.ListCompanies(ctx, after, limit)
+}
+
+func (d *Database) StreamCompanies(ctx context.Context, filter database.CompanyFilter, fn func(company *models.Company) error) (err error) {
+	ctx, span := d.start(ctx, "StreamCompanies")
+	defer func() { end(span, err) }()
+	return d.Database.StreamCompanies(ctx, filter, fn)
+}
+
+func (d *Database) CreateJob(ctx context.Context, job *models.Job) (err error) {
+	ctx, span := d.start(ctx, "CreateJob", attribute.String("job.id", job.ID.String()))
+	defer func() { end(span, err) }()
+	return d.Database.CreateJob(ctx, job)
+}
+
+func (d *Database) GetJob(ctx context.Context, id string) (job *models.Job, err error) {
+	ctx, span := d.start(ctx, "GetJob", attribute.String("job.id", id))
+	defer func() { end(span, err) }()
+	return d.Database.GetJob(ctx, id)
+}
+
+func (d *Database) UpdateJob(ctx context.Context, id string, from []string, fields map[string]interface{}) (job *models.Job, err error) {
+	ctx, span := d.start(ctx, "UpdateJob", attribute.String("job.id", id))
+	defer func() { end(span, err) }()
+	return d.Database.UpdateJob(ctx, id, from, fields)
+}
+
+func (d *Database) UpdateRunningJob(ctx context.Context, id, owner string, fields map[string]interface{}) (job *models.Job, err error) {
+	ctx, span := d.start(ctx, "UpdateRunningJob", attribute.String("job.id", id))
+	defer func() { end(span, err) }()
+	return d.Database.UpdateRunningJob(ctx, id, owner, fields)
+}
+
+func (d *Database) RequeueExpiredJobs(ctx context.Context, now time.Time) (ids []string, err error) {
+	ctx, span := d.start(ctx, "RequeueExpiredJobs")
+	defer func() { end(span, err) }()
+	return d.Database.RequeueExpiredJobs(ctx, now)
+}
+
+func (d *Database) ListJobs(ctx context.Context, statuses ...string) (jobs []models.Job, err error) {
+	ctx, span := d.start(ctx, "ListJobs")
+	defer func() { end(span, err) }()
+	return d.Database.ListJobs(ctx, statuses...)
+}
+
+func (d *Database) WriteJobOutput(ctx context.Context, id string, r io.Reader) (written int64, err error) {
+	ctx, span := d.start(ctx, "WriteJobOutput", attribute.String("job.id", id))
+	defer func() { end(span, err) }()
+	return d.Database.WriteJobOutput(ctx, id, r)
+}
+
+func (d *Database) ReadJobOutput(ctx context.Context, id string, w io.Writer) (written int64, err error) {
+	ctx, span := d.start(ctx, "ReadJobOutput", attribute.String("job.id", id))
+	defer func() { end(span, err) }()
+	return d.Database.ReadJobOutput(ctx, id, w)
+}
+
+func (d *Database) ReindexCompanyName(ctx context.Context, id string) (changed bool, err error) {
+	ctx, span := d.start(ctx, "ReindexCompanyName", attribute.String("company.id", id))
+	defer func() { end(span, err) }()
+	return d.Database.ReindexCompanyName(ctx, id)
+}
+
+func (d *Database) CreateUser(ctx context.Context, user *models.User) (err error) {
+	ctx, span := d.start(ctx, "CreateUser")
+	defer func() { end(span, err) }()
+	return d.Database.CreateUser(ctx, user)
+}
+
+func (d *Database) UpdateUser(ctx context.Context, username string, fields map[string]interface{}) (user *models.User, err error) {
+	ctx, span := d.start(ctx, "UpdateUser")
+	defer func() { end(span, err) }()
+	return d.Database.UpdateUser(ctx, username, fields)
+}
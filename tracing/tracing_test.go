@@ -0,0 +1,97 @@
+package tracing_test
+
+import (
+	"company-service/config"
+	"company-service/controllers"
+	"company-service/database"
+	"company-service/kafka"
+	"company-service/models"
+	"company-service/tracing"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spanNamed returns the recorded span with the given name
+func spanNamed(t *testing.T, recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	t.Fatalf("no span named %q", name)
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	conf := &config.Config{User: "admin", Password: "admin-password"}
+
+	t.Run("Requests continue the incoming trace down to the database", func(t *testing.T) {
+		memory, err := database.NewMemoryDatabase(conf)
+		require.NoError(t, err)
+		company := &models.Company{ID: uuid.New(), Name: "Traced", Employees: 1, Type: "NonProfit"}
+		require.NoError(t, memory.CreateCompany(context.Background(), company))
+		app := controllers.NewApp(tracing.NewDatabase(memory, "memory"), kafka.NewMemoryProducer(), conf)
+
+		traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+		req := httptest.NewRequest(http.MethodGet, "/api/companies/"+company.ID.String(), nil)
+		req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		rec := httptest.NewRecorder()
+		app.Router().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		server := spanNamed(t, recorder, "/api/companies/{id}")
+		assert.Equal(t, traceID, server.SpanContext().TraceID().String())
+		dbSpan := spanNamed(t, recorder, "db.GetCompany")
+		assert.Equal(t, server.SpanContext().SpanID(), dbSpan.Parent().SpanID())
+		assert.Equal(t, trace.SpanKindClient, dbSpan.SpanKind())
+	})
+
+	t.Run("Consumed events carry the trace of the producer", func(t *testing.T) {
+		producer := kafka.NewMemoryProducer()
+		events := producer.Subscribe(1)
+		ctx, span := otel.Tracer("test").Start(context.Background(), "request")
+		require.NoError(t, producer.ProduceEvent(ctx, &kafka.EventMessage{EventType: "company_created"}))
+		span.End()
+
+		event := <-events
+		consumed := trace.SpanContextFromContext(event.ContextWithTrace(context.Background()))
+		assert.Equal(t, span.SpanContext().TraceID(), consumed.TraceID())
+	})
+}
+
+func TestSetup(t *testing.T) {
+	ctx := context.Background()
+
+	shutdown, err := tracing.Setup(ctx, &config.Config{TracingExporter: "none"})
+	require.NoError(t, err)
+	require.NoError(t, shutdown(ctx))
+
+	_, err = tracing.Setup(ctx, &config.Config{TracingExporter: "zipkin"})
+	assert.Error(t, err)
+
+	file := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err = tracing.Setup(ctx, &config.Config{TracingExporter: "file", TracingFile: file, TracingSampleRatio: 1})
+	require.NoError(t, err)
+	_, span := otel.Tracer("test").Start(ctx, "offline")
+	span.End()
+	require.NoError(t, shutdown(ctx))
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"Name":"offline"`)
+}
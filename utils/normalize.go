@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NameNormalizer turns a company name into the key used to enforce name uniqueness
+type NameNormalizer struct {
+	// NFKC applies Unicode compatibility normalisation, e.g. the full-width "Ａｃｍｅ" and "Acme" compare equal
+	NFKC bool
+	// CaseFold ignores the letter case
+	CaseFold bool
+	// Whitespace trims the name and collapses inner runs of whitespace into a single space
+	Whitespace bool
+}
+
+// NewNameNormalizer parses a comma separated policy made of "nfkc", "casefold" and "whitespace".
+// An empty policy only compares the names byte by byte.
+func NewNameNormalizer(policy string) (NameNormalizer, error) {
+	var normalizer NameNormalizer
+	for _, option := range strings.Split(policy, ",") {
+		switch strings.ToLower(strings.TrimSpace(option)) {
+		case "":
+		case "nfkc":
+			normalizer.NFKC = true
+		case "casefold":
+			normalizer.CaseFold = true
+		case "whitespace":
+			normalizer.Whitespace = true
+		default:
+			return NameNormalizer{}, fmt.Errorf("unknown name normalisation option %q", option)
+		}
+	}
+	return normalizer, nil
+}
+
+// Normalize returns the normalised form of the name
+func (n NameNormalizer) Normalize(name string) string {
+	if n.NFKC {
+		name = norm.NFKC.String(name)
+	}
+	if n.CaseFold {
+		name = cases.Fold().String(name)
+	}
+	if n.Whitespace {
+		name = strings.Join(strings.Fields(name), " ")
+	}
+	return name
+}
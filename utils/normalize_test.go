@@ -0,0 +1,34 @@
+package utils_test
+
+import (
+	"company-service/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNameNormalizer(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		input    string
+		expected string
+	}{
+		{name: "Empty policy keeps the name", policy: "", input: " Acme  Ltd ", expected: " Acme  Ltd "},
+		{name: "Case folding", policy: "casefold", input: "ACME Straße", expected: "acme strasse"},
+		{name: "Whitespace", policy: "whitespace", input: " Acme \t Ltd ", expected: "Acme Ltd"},
+		{name: "NFKC", policy: "nfkc", input: "Ａｃｍｅ ①", expected: "Acme 1"},
+		{name: "Full policy", policy: "nfkc, casefold, whitespace", input: " ＡＣＭＥ   ltd", expected: "acme ltd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalizer, err := utils.NewNameNormalizer(tt.policy)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, normalizer.Normalize(tt.input))
+		})
+	}
+
+	_, err := utils.NewNameNormalizer("nfkc,soundex")
+	assert.EqualError(t, err, `unknown name normalisation option "soundex"`)
+}
@@ -3,8 +3,19 @@ package utils
 import (
 	"company-service/models"
 	"fmt"
+	"slices"
+	"strings"
 )
 
+// Limits of the company fields, shared by the validation and the API documentation
+const (
+	MaxNameLength        = 15
+	MaxDescriptionLength = 3000
+)
+
+// CompanyTypes are the allowed values of the type of a company
+var CompanyTypes = []string{"Corporations", "NonProfit", "Cooperative", "Sole Proprietorship"}
+
 // ValidateCompanyUpdate validates the updated fields of a company.
 func ValidateCompanyUpdate(updatedFields map[string]interface{}) error {
 	// Iterate over the updated fields and validate each one
@@ -13,10 +24,10 @@ func ValidateCompanyUpdate(updatedFields map[string]interface{}) error {
 		case "name":
 			// Validate 'name' field: Ensure it is not empty and has a reasonable length
 			if name, ok := value.(string); ok {
-				if len(name) > 15 {
-					return fmt.Errorf("name cannot be longer than 15 characters")
+				if len(name) > MaxNameLength {
+					return fmt.Errorf("name cannot be longer than %d characters", MaxNameLength)
 				}
-				if len(name) == 0 {
+				if len(strings.TrimSpace(name)) == 0 {
 					return fmt.Errorf("name cannot be empty")
 				}
 			} else {
@@ -25,8 +36,8 @@ func ValidateCompanyUpdate(updatedFields map[string]interface{}) error {
 		case "description":
 			// Validate 'description' field: Ensure it does not exceed the max length of 3000 characters
 			if description, ok := value.(string); ok {
-				if len(description) > 3000 {
-					return fmt.Errorf("description cannot be longer than 3000 characters")
+				if len(description) > MaxDescriptionLength {
+					return fmt.Errorf("description cannot be longer than %d characters", MaxDescriptionLength)
 				}
 			} else {
 				return fmt.Errorf("invalid type for 'description'. It should be a string")
@@ -43,8 +54,7 @@ func ValidateCompanyUpdate(updatedFields map[string]interface{}) error {
 		case "type":
 			// Validate 'type' field: Ensure it matches one of the allowed values
 			if companyType, ok := value.(string); ok {
-				validTypes := map[string]bool{"Corporations": true, "NonProfit": true, "Cooperative": true, "Sole Proprietorship": true}
-				if !validTypes[companyType] {
+				if !slices.Contains(CompanyTypes, companyType) {
 					return fmt.Errorf("invalid 'type'. Allowed values are 'Corporations', 'NonProfit', 'Cooperative', 'Sole Proprietorship'")
 				}
 			} else {
@@ -58,8 +68,8 @@ func ValidateCompanyUpdate(updatedFields map[string]interface{}) error {
 // ValidateCompanyInput validates the input fields of a company.
 func ValidateCompanyInput(company *models.Company) error {
 	// Validate Name
-	if company.Name == "" || len(company.Name) > 15 {
-		return fmt.Errorf("invalid 'Name': it is required and must be at most 15 characters")
+	if strings.TrimSpace(company.Name) == "" || len(company.Name) > MaxNameLength {
+		return fmt.Errorf("invalid 'Name': it is required and must be at most %d characters", MaxNameLength)
 	}
 
 	// Validate Employees
@@ -75,13 +85,7 @@ func ValidateCompanyInput(company *models.Company) error {
 	if company.Type == "" {
 		return fmt.Errorf("invalid 'Type': it is required")
 	}
-	validTypes := map[string]bool{
-		"Corporations":        true,
-		"NonProfit":           true,
-		"Cooperative":         true,
-		"Sole Proprietorship": true,
-	}
-	if !validTypes[company.Type] {
+	if !slices.Contains(CompanyTypes, company.Type) {
 		return fmt.Errorf("invalid 'Type': must be one of 'Corporations', 'NonProfit', 'Cooperative', 'Sole Proprietorship'")
 	}
 